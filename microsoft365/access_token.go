@@ -0,0 +1,89 @@
+package microsoft365
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+)
+
+// accessTokenClaims holds the claims of a Microsoft Entra access token that
+// describe who the token was issued to.
+// https://learn.microsoft.com/en-us/entra/identity-platform/access-token-claims-reference
+type accessTokenClaims struct {
+	Audience          string   `json:"aud"`
+	TenantID          string   `json:"tid"`
+	AppID             string   `json:"appid"`
+	AuthorizedParty   string   `json:"azp"`
+	ObjectID          string   `json:"oid"`
+	UserPrincipalName string   `json:"upn"`
+	PreferredUsername string   `json:"preferred_username"`
+	IDType            string   `json:"idtyp"`
+	Roles             []string `json:"roles"`
+	Scope             string   `json:"scp"`
+	ExpiresOn         int64    `json:"exp"`
+}
+
+// IsDelegated reports whether the token was issued to a signed-in user rather
+// than to an application. Only delegated tokens carry scopes.
+func (c *accessTokenClaims) IsDelegated() bool {
+	if c.IDType != "" {
+		return c.IDType == "user"
+	}
+	return c.Scope != ""
+}
+
+// GetAppID returns the client ID of the application the token was issued to;
+// v1.0 tokens use appid and v2.0 tokens azp.
+func (c *accessTokenClaims) GetAppID() string {
+	if c.AppID != "" {
+		return c.AppID
+	}
+	return c.AuthorizedParty
+}
+
+// GetScopes returns the delegated permissions granted to the token.
+func (c *accessTokenClaims) GetScopes() []string {
+	return strings.Fields(c.Scope)
+}
+
+// GetExpiresOn returns the expiry time of the token.
+func (c *accessTokenClaims) GetExpiresOn() time.Time {
+	return time.Unix(c.ExpiresOn, 0)
+}
+
+// decodeAccessToken reads the claims of a JWT access token. The signature is
+// not verified; the token was just received from the identity provider and
+// the claims are only used to describe it.
+func decodeAccessToken(token string) (*accessTokenClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("access token is not a JWT")
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return nil, fmt.Errorf("error decoding access token: %v", err)
+	}
+
+	var claims accessTokenClaims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, fmt.Errorf("error decoding access token claims: %v", err)
+	}
+
+	return &claims, nil
+}
+
+// getAccessTokenClaims returns the claims of the access token the client
+// sends to Graph.
+func (c *graphClient) getAccessTokenClaims(ctx context.Context) (*accessTokenClaims, error) {
+	token, err := c.cred.GetToken(ctx, policy.TokenRequestOptions{Scopes: c.scopes})
+	if err != nil {
+		return nil, err
+	}
+	return decodeAccessToken(token.Token)
+}
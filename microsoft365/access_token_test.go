@@ -0,0 +1,65 @@
+package microsoft365
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"testing"
+)
+
+// testAccessToken builds an unsigned JWT with the given claims.
+func testAccessToken(t *testing.T, claims map[string]interface{}) string {
+	t.Helper()
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none","typ":"JWT"}`))
+	return header + "." + base64.RawURLEncoding.EncodeToString(payload) + ".signature"
+}
+
+func TestDecodeAccessToken(t *testing.T) {
+	tests := []struct {
+		name      string
+		claims    map[string]interface{}
+		delegated bool
+		appID     string
+	}{
+		{
+			name:      "delegated v1.0 token",
+			claims:    map[string]interface{}{"appid": "cli-app", "scp": "User.Read Mail.Read", "upn": "user@example.com"},
+			delegated: true,
+			appID:     "cli-app",
+		},
+		{
+			name:      "app-only v1.0 token",
+			claims:    map[string]interface{}{"appid": "daemon-app", "roles": []string{"User.Read.All"}, "idtyp": "app"},
+			delegated: false,
+			appID:     "daemon-app",
+		},
+		{
+			name:      "app-only v2.0 token without idtyp",
+			claims:    map[string]interface{}{"azp": "daemon-app", "roles": []string{"Mail.Read"}},
+			delegated: false,
+			appID:     "daemon-app",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims, err := decodeAccessToken(testAccessToken(t, tt.claims))
+			if err != nil {
+				t.Fatalf("decodeAccessToken() error = %v", err)
+			}
+			if claims.IsDelegated() != tt.delegated {
+				t.Errorf("IsDelegated() = %t, want %t", claims.IsDelegated(), tt.delegated)
+			}
+			if claims.GetAppID() != tt.appID {
+				t.Errorf("GetAppID() = %q, want %q", claims.GetAppID(), tt.appID)
+			}
+		})
+	}
+
+	if _, err := decodeAccessToken("not-a-jwt"); err == nil {
+		t.Error("decodeAccessToken() of an opaque token returned no error")
+	}
+}
@@ -0,0 +1,281 @@
+package microsoft365
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	abstractions "github.com/microsoft/kiota-abstractions-go"
+	"github.com/microsoft/kiota-abstractions-go/serialization"
+	msgraphsdkgo "github.com/microsoftgraph/msgraph-sdk-go"
+	msgraphcore "github.com/microsoftgraph/msgraph-sdk-go-core"
+	"github.com/microsoftgraph/msgraph-sdk-go/models/odataerrors"
+)
+
+const (
+	// batchSize is the most requests Graph accepts in one $batch request
+	batchSize = 20
+	// batchWindow is how long a request waits for the hydrate calls of other
+	// rows to join its batch
+	batchWindow = 20 * time.Millisecond
+	// batchMaxResponseSize is the most content, in bytes, the responses of one
+	// $batch request are expected to hold, e.g. of attachments, so a batch of
+	// large responses isn't held in memory at once. A larger response is sent
+	// in a batch of its own.
+	batchMaxResponseSize = 4 * 1024 * 1024
+)
+
+// batchCall is a request waiting for its response from a $batch request.
+type batchCall struct {
+	// ctx is that of the hydrate call waiting for the response
+	ctx      context.Context
+	request  *abstractions.RequestInformation
+	workload string
+	attempt  int
+	// size is the expected size of the response, counted against
+	// batchMaxResponseSize
+	size int64
+
+	done   chan struct{}
+	status int
+	body   []byte
+	err    error
+}
+
+func (c *batchCall) finish(status int, body []byte, err error) {
+	c.status, c.body, c.err = status, body, err
+	close(c.done)
+}
+
+// pendingBatch is the calls of a workload waiting for the next $batch
+// request.
+type pendingBatch struct {
+	calls []*batchCall
+	// size is the expected size of the responses of the calls
+	size  int64
+	timer *time.Timer
+}
+
+// graphBatcher sends the per-row requests of concurrent hydrate calls, e.g.
+// the mailbox settings of each user, to Graph in $batch requests of up to 20
+// requests, rather than one round trip per row. Each $batch request holds the
+// requests of one workload, and is throttled as that workload.
+// https://learn.microsoft.com/en-us/graph/json-batching
+type graphBatcher struct {
+	adapter    *msgraphsdkgo.GraphRequestAdapter
+	throttling *throttlingHandler
+	logger     hclog.Logger
+
+	mu      sync.Mutex
+	pending map[string]*pendingBatch
+}
+
+func newGraphBatcher(adapter *msgraphsdkgo.GraphRequestAdapter, throttling *throttlingHandler, logger hclog.Logger) *graphBatcher {
+	return &graphBatcher{
+		adapter:    adapter,
+		throttling: throttling,
+		logger:     logger,
+		pending:    map[string]*pendingBatch{},
+	}
+}
+
+// do queues the request, whose response is expected to be about size bytes,
+// for the next $batch request of its workload and waits for its response.
+func (b *graphBatcher) do(ctx context.Context, request *abstractions.RequestInformation, size int64) (*batchCall, error) {
+	uri, err := request.GetUri()
+	if err != nil {
+		return nil, err
+	}
+	call := &batchCall{ctx: ctx, request: request, workload: getWorkload(uri.Path), size: size, done: make(chan struct{})}
+	b.enqueue(call)
+
+	select {
+	case <-call.done:
+		return call, call.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// enqueue adds the call to the pending batch of its workload, which is sent
+// once it's full or batchWindow after its first call was queued. A batch is
+// full when it has batchSize requests or batchMaxResponseSize of expected
+// responses.
+func (b *graphBatcher) enqueue(call *batchCall) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	batch := b.pending[call.workload]
+	if batch != nil && batch.size+call.size > batchMaxResponseSize {
+		b.flushLocked(call.workload)
+		batch = nil
+	}
+	if batch == nil {
+		batch = &pendingBatch{}
+		b.pending[call.workload] = batch
+	}
+	batch.calls = append(batch.calls, call)
+	batch.size += call.size
+	if len(batch.calls) >= batchSize || batch.size >= batchMaxResponseSize {
+		b.flushLocked(call.workload)
+		return
+	}
+	if batch.timer == nil {
+		batch.timer = time.AfterFunc(batchWindow, func() { b.flush(call.workload) })
+	}
+}
+
+func (b *graphBatcher) flush(workload string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.flushLocked(workload)
+}
+
+func (b *graphBatcher) flushLocked(workload string) {
+	batch := b.pending[workload]
+	if batch == nil {
+		return
+	}
+	delete(b.pending, workload)
+	if batch.timer != nil {
+		batch.timer.Stop()
+	}
+	go b.send(workload, batch.calls)
+}
+
+// send sends the calls in one $batch request and hands each call its own
+// response. A failed request only fails its own call; throttled requests are
+// queued again for a later batch. The $batch request is cancelled once every
+// hydrate call waiting for it was.
+func (b *graphBatcher) send(workload string, calls []*batchCall) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ctx = withWorkload(ctx, workload)
+
+	batch := msgraphcore.NewBatchRequest(b.adapter)
+	items := make(map[string]*batchCall, len(calls))
+	for _, call := range calls {
+		// The hydrate call is no longer waiting for the response
+		if call.ctx.Err() != nil {
+			continue
+		}
+		item, err := batch.AddBatchRequestStep(*call.request)
+		if err != nil {
+			call.finish(0, nil, err)
+			continue
+		}
+		items[*item.GetId()] = call
+	}
+	if len(items) == 0 {
+		return
+	}
+
+	var waiting atomic.Int32
+	waiting.Store(int32(len(items)))
+	for _, call := range items {
+		stop := context.AfterFunc(call.ctx, func() {
+			if waiting.Add(-1) == 0 {
+				cancel()
+			}
+		})
+		defer stop()
+	}
+
+	response, err := batch.Send(ctx, b.adapter)
+	if err != nil {
+		b.logger.Error("graphBatcher.send", "batch_error", err, "requests", len(items))
+		for _, call := range items {
+			call.finish(0, nil, err)
+		}
+		return
+	}
+
+	for id, call := range items {
+		item := response.GetResponseById(id)
+		if item == nil || item.GetStatus() == nil {
+			call.finish(0, nil, fmt.Errorf("the $batch response has no response for request %s", id))
+			continue
+		}
+
+		resp := &http.Response{StatusCode: int(*item.GetStatus()), Header: http.Header{}}
+		for name, value := range item.GetHeaders() {
+			resp.Header.Set(name, value)
+		}
+		if isRetriableResponse(resp) {
+			if delay, ok := b.throttling.shouldRetry(call.workload, resp, call.attempt); ok {
+				call.attempt++
+				time.AfterFunc(delay, func() {
+					if call.ctx.Err() == nil {
+						b.enqueue(call)
+					}
+				})
+				continue
+			}
+		}
+
+		body, err := json.Marshal(item.GetBody())
+		call.finish(resp.StatusCode, body, err)
+	}
+}
+
+// batchGet sends the GET request in a $batch request shared with the hydrate
+// calls of other rows, and parses the response with the factory. A failed
+// request returns an *odataerrors.ODataError, as it would if it had been sent
+// on its own.
+func batchGet[T serialization.Parsable](ctx context.Context, c *graphClient, request *abstractions.RequestInformation, factory serialization.ParsableFactory) (T, error) {
+	return batchGetSized[T](ctx, c, request, factory, 0)
+}
+
+// batchGetSized is batchGet for a request whose response is expected to be
+// about size bytes, e.g. the content of an attachment, so that a $batch
+// request holds at most batchMaxResponseSize of them.
+func batchGetSized[T serialization.Parsable](ctx context.Context, c *graphClient, request *abstractions.RequestInformation, factory serialization.ParsableFactory, size int64) (T, error) {
+	var result T
+
+	call, err := c.batcher.do(ctx, request, size)
+	if err != nil {
+		return result, err
+	}
+	if call.status >= 400 {
+		return result, parseBatchError(call.status, call.body)
+	}
+
+	parseNode, err := serialization.DefaultParseNodeFactoryInstance.GetRootParseNode("application/json", call.body)
+	if err != nil {
+		return result, err
+	}
+	value, err := parseNode.GetObjectValue(factory)
+	if err != nil || value == nil {
+		return result, err
+	}
+
+	typed, ok := value.(T)
+	if !ok {
+		return result, fmt.Errorf("unexpected $batch response type %T", value)
+	}
+	return typed, nil
+}
+
+// parseBatchError returns the Graph error in the body of a failed $batch
+// response.
+func parseBatchError(status int, body []byte) error {
+	parseNode, err := serialization.DefaultParseNodeFactoryInstance.GetRootParseNode("application/json", body)
+	if err == nil {
+		var value serialization.Parsable
+		value, err = parseNode.GetObjectValue(odataerrors.CreateODataErrorFromDiscriminatorValue)
+		// Only a body with a code is a Graph error, rather than e.g. a proxy's
+		// error page
+		if odataErr, ok := value.(*odataerrors.ODataError); ok && err == nil {
+			if main := odataErr.GetErrorEscaped(); main != nil && main.GetCode() != nil && main.GetMessage() != nil {
+				odataErr.ResponseStatusCode = status
+				return odataErr
+			}
+		}
+	}
+	return fmt.Errorf("the server returned status %d: %s", status, body)
+}
@@ -0,0 +1,290 @@
+package microsoft365
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	msgraphsdkgo "github.com/microsoftgraph/msgraph-sdk-go"
+	"github.com/microsoftgraph/msgraph-sdk-go/models"
+	"github.com/microsoftgraph/msgraph-sdk-go/models/odataerrors"
+)
+
+type testBatchRequest struct {
+	ID     string `json:"id"`
+	Method string `json:"method"`
+	URL    string `json:"url"`
+}
+
+type testBatchResponse struct {
+	ID      string            `json:"id"`
+	Status  int               `json:"status"`
+	Headers map[string]string `json:"headers"`
+	Body    interface{}       `json:"body,omitempty"`
+}
+
+// testBatchServer serves $batch requests, answering each request in a batch
+// with respond. It records the number of requests in each batch.
+func testBatchServer(t *testing.T, respond func(url string) testBatchResponse) (*graphClient, func() []int) {
+	var mu sync.Mutex
+	var sizes []int
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1.0/$batch" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		var batch struct {
+			Requests []testBatchRequest `json:"requests"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&batch); err != nil {
+			t.Errorf("error decoding $batch request: %v", err)
+		}
+		mu.Lock()
+		sizes = append(sizes, len(batch.Requests))
+		mu.Unlock()
+
+		var responses []testBatchResponse
+		for _, request := range batch.Requests {
+			response := respond(request.URL)
+			response.ID = request.ID
+			if response.Headers == nil {
+				response.Headers = map[string]string{"Content-Type": "application/json"}
+			}
+			responses = append(responses, response)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"responses": responses})
+	}))
+	t.Cleanup(server.Close)
+
+	throttling := testThrottlingHandler(throttlingOptions{MaxRetries: 2})
+	adapter, err := newGraphRequestAdapter(&countingCredential{expires: time.Hour}, cloudEndpoints{GraphEndpoint: server.URL}, testThrottlingClient(throttling))
+	if err != nil {
+		t.Fatalf("newGraphRequestAdapter() error = %v", err)
+	}
+	client := &graphClient{
+		client:     msgraphsdkgo.NewGraphServiceClient(adapter),
+		adapter:    adapter,
+		throttling: throttling,
+		batcher:    newGraphBatcher(adapter, throttling, hclog.NewNullLogger()),
+	}
+
+	return client, func() []int {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]int(nil), sizes...)
+	}
+}
+
+func getTestMailboxSettings(t *testing.T, client *graphClient, userID string) (models.MailboxSettingsable, error) {
+	ctx := testContext()
+	request, err := client.client.Users().ByUserId(userID).MailboxSettings().ToGetRequestInformation(ctx, nil)
+	if err != nil {
+		t.Fatalf("ToGetRequestInformation() error = %v", err)
+	}
+	return batchGet[models.MailboxSettingsable](ctx, client, request, models.CreateMailboxSettingsFromDiscriminatorValue)
+}
+
+func TestBatchGetIsolatesErrors(t *testing.T) {
+	client, batchSizes := testBatchServer(t, func(url string) testBatchResponse {
+		if strings.Contains(url, "/users/b/") {
+			return testBatchResponse{Status: http.StatusNotFound, Body: map[string]interface{}{
+				"error": map[string]string{"code": "MailboxNotEnabledForRESTAPI", "message": "The mailbox is either inactive, soft-deleted, or is hosted on-premise."},
+			}}
+		}
+		userID := strings.Split(url, "/")[2]
+		return testBatchResponse{Status: http.StatusOK, Body: map[string]string{"timeZone": "zone-" + userID}}
+	})
+
+	var wg sync.WaitGroup
+	results := make(map[string]string)
+	errs := make(map[string]error)
+	var mu sync.Mutex
+	for _, userID := range []string{"a", "b", "c"} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			settings, err := getTestMailboxSettings(t, client, userID)
+			mu.Lock()
+			defer mu.Unlock()
+			errs[userID] = err
+			if settings != nil && settings.GetTimeZone() != nil {
+				results[userID] = *settings.GetTimeZone()
+			}
+		}()
+	}
+	wg.Wait()
+
+	for _, userID := range []string{"a", "c"} {
+		if errs[userID] != nil || results[userID] != "zone-"+userID {
+			t.Errorf("user %s: timeZone = %q, error = %v, want %q", userID, results[userID], errs[userID], "zone-"+userID)
+		}
+	}
+
+	odataErr, ok := errs["b"].(*odataerrors.ODataError)
+	if !ok {
+		t.Fatalf("user b: error = %v (%T), want an *odataerrors.ODataError", errs["b"], errs["b"])
+	}
+	if code := getErrorObject(odataErr).Code; code != "MailboxNotEnabledForRESTAPI" || odataErr.ResponseStatusCode != http.StatusNotFound {
+		t.Errorf("user b: code = %q, status = %d", code, odataErr.ResponseStatusCode)
+	}
+
+	if sizes := batchSizes(); len(sizes) != 1 || sizes[0] != 3 {
+		t.Errorf("batch sizes = %v, want [3]", sizes)
+	}
+}
+
+func TestBatchGetSplitsBatches(t *testing.T) {
+	client, batchSizes := testBatchServer(t, func(url string) testBatchResponse {
+		return testBatchResponse{Status: http.StatusOK, Body: map[string]string{"timeZone": "UTC"}}
+	})
+
+	var wg sync.WaitGroup
+	var failed atomic.Int32
+	for i := 0; i < 45; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := getTestMailboxSettings(t, client, fmt.Sprintf("user%d", i)); err != nil {
+				failed.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if failed.Load() != 0 {
+		t.Errorf("%d requests failed", failed.Load())
+	}
+	total := 0
+	for _, size := range batchSizes() {
+		if size > batchSize {
+			t.Errorf("batch of %d requests, want at most %d", size, batchSize)
+		}
+		total += size
+	}
+	if total != 45 {
+		t.Errorf("batched %d requests, want 45", total)
+	}
+}
+
+func TestBatchGetSplitsBatchesBySize(t *testing.T) {
+	client, batchSizes := testBatchServer(t, func(url string) testBatchResponse {
+		return testBatchResponse{Status: http.StatusOK, Body: map[string]string{"timeZone": "UTC"}}
+	})
+
+	// Two of these fit in batchMaxResponseSize, three don't
+	size := int64(batchMaxResponseSize * 2 / 5)
+	ctx := testContext()
+	var wg sync.WaitGroup
+	var failed atomic.Int32
+	for i := 0; i < 6; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			request, err := client.client.Users().ByUserId(fmt.Sprintf("user%d", i)).MailboxSettings().ToGetRequestInformation(ctx, nil)
+			if err != nil {
+				t.Errorf("ToGetRequestInformation() error = %v", err)
+				return
+			}
+			if _, err := batchGetSized[models.MailboxSettingsable](ctx, client, request, models.CreateMailboxSettingsFromDiscriminatorValue, size); err != nil {
+				failed.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if failed.Load() != 0 {
+		t.Errorf("%d requests failed", failed.Load())
+	}
+	total := 0
+	for _, n := range batchSizes() {
+		if n > 2 {
+			t.Errorf("batch of %d requests, want at most 2", n)
+		}
+		total += n
+	}
+	if total != 6 {
+		t.Errorf("batched %d requests, want 6", total)
+	}
+}
+
+func TestBatchGetRetriesThrottledRequests(t *testing.T) {
+	var calls atomic.Int32
+	client, batchSizes := testBatchServer(t, func(url string) testBatchResponse {
+		if calls.Add(1) == 1 {
+			return testBatchResponse{Status: http.StatusTooManyRequests, Headers: map[string]string{"Retry-After": "0"}}
+		}
+		return testBatchResponse{Status: http.StatusOK, Body: map[string]string{"timeZone": "UTC"}}
+	})
+
+	settings, err := getTestMailboxSettings(t, client, "a")
+	if err != nil {
+		t.Fatalf("batchGet() error = %v", err)
+	}
+	if settings.GetTimeZone() == nil || *settings.GetTimeZone() != "UTC" {
+		t.Errorf("timeZone = %v, want UTC", settings.GetTimeZone())
+	}
+	if sizes := batchSizes(); len(sizes) != 2 {
+		t.Errorf("batch sizes = %v, want 2 batches", sizes)
+	}
+
+	// The $batch requests are throttled as the workload of their requests
+	metrics := client.throttling.getMetrics()
+	if exchange := metrics[workloadExchange]; exchange.Requests != 2 || exchange.Throttled != 1 || exchange.Retries != 1 {
+		t.Errorf("exchange metrics = %+v, want 2 requests, 1 throttled and 1 retry", exchange)
+	}
+	if directory := metrics[workloadDirectory]; directory.Requests != 0 {
+		t.Errorf("directory metrics = %+v, want no requests", directory)
+	}
+}
+
+func TestBatchCancelledWithCallers(t *testing.T) {
+	release := make(chan struct{})
+	client, _ := testBatchServer(t, func(url string) testBatchResponse {
+		<-release
+		return testBatchResponse{Status: http.StatusOK, Body: map[string]string{"timeZone": "UTC"}}
+	})
+	t.Cleanup(func() { close(release) })
+
+	ctx, cancel := context.WithCancel(testContext())
+	request, err := client.client.Users().ByUserId("a").MailboxSettings().ToGetRequestInformation(ctx, nil)
+	if err != nil {
+		t.Fatalf("ToGetRequestInformation() error = %v", err)
+	}
+	done := make(chan error)
+	go func() {
+		_, err := batchGet[models.MailboxSettingsable](ctx, client, request, models.CreateMailboxSettingsFromDiscriminatorValue)
+		done <- err
+	}()
+
+	// Wait for the $batch request to be sent, then cancel its only caller
+	waitFor(t, func() bool { return client.throttling.getMetrics()[workloadExchange].InFlight == 1 })
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Errorf("batchGet() error = %v, want %v", err, context.Canceled)
+	}
+	waitFor(t, func() bool { return client.throttling.getMetrics()[workloadExchange].InFlight == 0 })
+}
+
+// waitFor waits up to 5 seconds for the condition to hold.
+func waitFor(t *testing.T, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for the condition")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
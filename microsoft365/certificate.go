@@ -0,0 +1,113 @@
+package microsoft365
+
+import (
+	"crypto"
+	"crypto/sha1"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+)
+
+// loadClientCertificate reads the client certificate from certificate_path or
+// certificate_content, and checks that it can be used to sign in.
+func loadClientCertificate(credentials microsoft365Credentials) ([]*x509.Certificate, crypto.PrivateKey, error) {
+	source := "certificate_content"
+	var data []byte
+	if credentials.CertificatePath != "" {
+		source = credentials.CertificatePath
+
+		var err error
+		data, err = os.ReadFile(credentials.CertificatePath)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error reading certificate from %s: %v", credentials.CertificatePath, err)
+		}
+	} else {
+		var err error
+		data, err = decodeCertificateContent(credentials.CertificateContent)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	var password []byte
+	if credentials.CertificatePassword != "" {
+		password = []byte(credentials.CertificatePassword)
+	}
+	certs, key, err := azidentity.ParseCertificates(data, password)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error parsing certificate from %s: %v", source, err)
+	}
+
+	certs, err = validateClientCertificate(certs, key, time.Now())
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid certificate in %s: %v", source, err)
+	}
+
+	return certs, key, nil
+}
+
+// decodeCertificateContent returns the PEM or PKCS#12 (PFX) data of
+// certificate_content. PEM is used as is, anything else is read as base64
+// encoded PKCS#12.
+func decodeCertificateContent(content string) ([]byte, error) {
+	content = strings.TrimSpace(content)
+	if strings.Contains(content, "-----BEGIN") {
+		return []byte(content), nil
+	}
+
+	// Secrets managers often wrap long base64 values
+	content = strings.Join(strings.Fields(content), "")
+	data, err := base64.StdEncoding.DecodeString(content)
+	if err != nil {
+		return nil, fmt.Errorf("certificate_content must be PEM or base64 encoded PKCS#12 (PFX): %v", err)
+	}
+	return data, nil
+}
+
+// validateClientCertificate checks that the private key belongs to one of the
+// certificates and that the certificate is valid now. It returns the
+// certificates with that certificate first, as the leaf of the x5c chain.
+func validateClientCertificate(certs []*x509.Certificate, key crypto.PrivateKey, now time.Time) ([]*x509.Certificate, error) {
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, errors.New("the private key type is not supported")
+	}
+	publicKey, ok := signer.Public().(interface{ Equal(crypto.PublicKey) bool })
+	if !ok {
+		return nil, errors.New("the private key type is not supported")
+	}
+
+	leaf := -1
+	for i, cert := range certs {
+		if publicKey.Equal(cert.PublicKey) {
+			leaf = i
+			break
+		}
+	}
+	if leaf == -1 {
+		return nil, errors.New("the private key does not match any of the certificates")
+	}
+	cert := certs[leaf]
+
+	if now.After(cert.NotAfter) {
+		return nil, fmt.Errorf("certificate %q (thumbprint %s) expired on %s", cert.Subject.CommonName, certificateThumbprint(cert), cert.NotAfter.Format(time.RFC3339))
+	}
+	if now.Before(cert.NotBefore) {
+		return nil, fmt.Errorf("certificate %q (thumbprint %s) is not valid until %s", cert.Subject.CommonName, certificateThumbprint(cert), cert.NotBefore.Format(time.RFC3339))
+	}
+
+	ordered := append([]*x509.Certificate{cert}, certs[:leaf]...)
+	return append(ordered, certs[leaf+1:]...), nil
+}
+
+// certificateThumbprint returns the SHA-1 thumbprint Microsoft Entra shows
+// for the certificate of an app registration.
+func certificateThumbprint(cert *x509.Certificate) string {
+	return fmt.Sprintf("%X", sha1.Sum(cert.Raw))
+}
@@ -0,0 +1,94 @@
+package microsoft365
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testCertificatePEM returns a self-signed certificate and its private key
+// as PEM, valid between notBefore and notAfter.
+func testCertificatePEM(t *testing.T, notBefore, notAfter time.Time) (string, string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "steampipe-test"},
+		NotBefore:    notBefore,
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})
+	return string(certPEM), string(keyPEM)
+}
+
+func TestLoadClientCertificateContent(t *testing.T) {
+	now := time.Now()
+	cert, key := testCertificatePEM(t, now.Add(-time.Hour), now.Add(time.Hour))
+
+	for name, content := range map[string]string{
+		"PEM":    cert + key,
+		"base64": base64.StdEncoding.EncodeToString([]byte(key + cert)),
+	} {
+		certs, _, err := loadClientCertificate(microsoft365Credentials{CertificateContent: content})
+		if err != nil {
+			t.Errorf("loadClientCertificate() with %s content error = %v", name, err)
+			continue
+		}
+		if len(certs) != 1 || certs[0].Subject.CommonName != "steampipe-test" {
+			t.Errorf("loadClientCertificate() with %s content returned %v", name, certs)
+		}
+	}
+}
+
+func TestLoadClientCertificateValidation(t *testing.T) {
+	now := time.Now()
+	cert, key := testCertificatePEM(t, now.Add(-time.Hour), now.Add(time.Hour))
+	otherCert, _ := testCertificatePEM(t, now.Add(-time.Hour), now.Add(time.Hour))
+	expiredCert, expiredKey := testCertificatePEM(t, now.Add(-2*time.Hour), now.Add(-time.Hour))
+
+	for name, test := range map[string]struct {
+		content string
+		want    string
+	}{
+		"key mismatch": {otherCert + key, "does not match"},
+		"expired":      {expiredCert + expiredKey, "expired"},
+		"invalid":      {"not a certificate", "PEM or base64"},
+	} {
+		_, _, err := loadClientCertificate(microsoft365Credentials{CertificateContent: test.content})
+		if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("loadClientCertificate() with %s certificate error = %v, want an error containing %q", name, err, test.want)
+		}
+	}
+
+	// The certificate matching the key is sent as the leaf of the x5c chain
+	certs, _, err := loadClientCertificate(microsoft365Credentials{CertificateContent: otherCert + cert + key})
+	if err != nil {
+		t.Fatalf("loadClientCertificate() error = %v", err)
+	}
+	leaf, _ := pem.Decode([]byte(cert))
+	if len(certs) != 2 || string(certs[0].Raw) != string(leaf.Bytes) {
+		t.Error("loadClientCertificate() did not put the certificate matching the key first")
+	}
+}
@@ -1,19 +1,69 @@
 package microsoft365
 
 import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
 	"github.com/turbot/steampipe-plugin-sdk/v5/plugin"
 )
 
+// Supported values for the auth_method connection argument
+const (
+	AuthMethodCLI               = "cli"
+	AuthMethodClientSecret      = "client_secret"
+	AuthMethodClientCertificate = "client_certificate"
+	AuthMethodMSI               = "msi"
+	AuthMethodWorkloadIdentity  = "workload_identity"
+	AuthMethodDeviceCode        = "device_code"
+	AuthMethodChained           = "chained"
+)
+
+var authMethods = []string{
+	AuthMethodCLI,
+	AuthMethodClientSecret,
+	AuthMethodClientCertificate,
+	AuthMethodMSI,
+	AuthMethodWorkloadIdentity,
+	AuthMethodDeviceCode,
+	AuthMethodChained,
+}
+
 type microsoft365Config struct {
-	TenantID            *string `hcl:"tenant_id"`
-	ClientID            *string `hcl:"client_id"`
-	ClientSecret        *string `hcl:"client_secret"`
-	CertificatePath     *string `hcl:"certificate_path"`
-	CertificatePassword *string `hcl:"certificate_password"`
-	EnableMSI           *bool   `hcl:"enable_msi"`
-	MSIEndpoint         *string `hcl:"msi_endpoint"`
-	Environment         *string `hcl:"environment"`
-	UserID              *string `hcl:"user_id"`
+	AuthMethod           *string `hcl:"auth_method"`
+	TenantID             *string `hcl:"tenant_id"`
+	ClientID             *string `hcl:"client_id"`
+	ClientSecret         *string `hcl:"client_secret"`
+	CertificatePath      *string `hcl:"certificate_path"`
+	CertificateContent   *string `hcl:"certificate_content"`
+	CertificatePassword  *string `hcl:"certificate_password"`
+	SendCertificateChain *bool   `hcl:"send_certificate_chain"`
+	EnableMSI            *bool   `hcl:"enable_msi"`
+	MSIEndpoint          *string `hcl:"msi_endpoint"`
+	FederatedTokenFile   *string `hcl:"federated_token_file"`
+	Environment          *string `hcl:"environment"`
+	GraphEndpoint        *string `hcl:"graph_endpoint"`
+	AuthorityHost        *string `hcl:"authority_host"`
+	UserID               *string `hcl:"user_id"`
+	ProxyURL             *string `hcl:"proxy_url"`
+	CABundlePath         *string `hcl:"ca_bundle_path"`
+	RequestTimeout       *int    `hcl:"request_timeout"`
+	TLSMinVersion        *string `hcl:"tls_min_version"`
+	MaxRetries           *int    `hcl:"max_retries"`
+	MaxConcurrency       *int    `hcl:"max_concurrency"`
+	MinRetryDelay        *int    `hcl:"min_retry_delay"`
+	ReplayMode           *string `hcl:"replay_mode"`
+	ReplayDir            *string `hcl:"replay_dir"`
+
+	// MaxAttachmentContentSize is the size, in bytes, of the largest mail
+	// attachment whose content is downloaded
+	MaxAttachmentContentSize *int `hcl:"max_attachment_content_size"`
+
+	// Tenants turns the connection into a multi-tenant connection. Each entry
+	// sets the tenant_id and the credentials for one tenant.
+	Tenants []map[string]string `hcl:"tenants"`
 }
 
 func ConfigInstance() interface{} {
@@ -28,3 +78,233 @@ func GetConfig(connection *plugin.Connection) microsoft365Config {
 	config, _ := connection.Config.(microsoft365Config)
 	return config
 }
+
+// connectionConfigChanged clears the caches of a connection whose config
+// changed, like the SDK does by default, and validates the new config, so a
+// mistake in it is logged when Steampipe loads it rather than on the next
+// query.
+func connectionConfigChanged(ctx context.Context, p *plugin.Plugin, old, new *plugin.Connection) error {
+	if err := p.ClearConnectionCache(ctx, new.Name); err != nil {
+		return err
+	}
+	if err := p.ClearQueryCache(ctx, new.Name); err != nil {
+		return err
+	}
+
+	// Steampipe doesn't report the error, so it's logged too
+	if err := validateConfig(GetConfig(new)); err != nil {
+		plugin.Logger(ctx).Error("connectionConfigChanged", "connection", new.Name, "config_error", err)
+		return fmt.Errorf("connection %s: %v", new.Name, err)
+	}
+	return nil
+}
+
+// validateConfig checks the settings of a connection that don't need a
+// request to Graph, and the credentials of each tenant of a multi-tenant
+// connection. Queries run the same checks when they build their Graph client.
+func validateConfig(config microsoft365Config) error {
+	if _, err := getCloudEndpointsFromConfig(config); err != nil {
+		return err
+	}
+	if _, err := newHTTPTransport(config); err != nil {
+		return err
+	}
+	if _, err := getThrottlingOptions(config); err != nil {
+		return err
+	}
+	if _, err := getMaxAttachmentContentSize(config); err != nil {
+		return err
+	}
+	replay, err := getReplayOptions(config)
+	if err != nil {
+		return err
+	}
+	// In replay mode no token is requested, so credentials aren't needed
+	if replay.Mode == ReplayModeReplay {
+		return nil
+	}
+
+	if len(config.Tenants) == 0 {
+		credentials := getCredentials(config)
+		return credentials.validate()
+	}
+	configs, err := getTenantConfigs(config)
+	if err != nil {
+		return err
+	}
+	tenantIDs := make([]string, 0, len(configs))
+	for tenantID := range configs {
+		tenantIDs = append(tenantIDs, tenantID)
+	}
+	sort.Strings(tenantIDs)
+	for _, tenantID := range tenantIDs {
+		credentials := getCredentials(configs[tenantID])
+		if err := credentials.validate(); err != nil {
+			return fmt.Errorf("tenant %s: %v", tenantID, err)
+		}
+	}
+	return nil
+}
+
+// microsoft365Credentials holds the credential settings of a connection after
+// falling back to the standard Azure environment variables.
+type microsoft365Credentials struct {
+	AuthMethod           string
+	TenantID             string
+	ClientID             string
+	ClientSecret         string
+	CertificatePath      string
+	CertificateContent   string
+	CertificatePassword  string
+	SendCertificateChain bool
+	EnableMSI            bool
+	MSIEndpoint          string
+	FederatedTokenFile   string
+}
+
+// getCredentials resolves the credential settings of the connection. Values
+// set in the connection config take precedence over environment variables.
+func getCredentials(config microsoft365Config) microsoft365Credentials {
+	credentials := microsoft365Credentials{
+		AuthMethod:          stringValueOrEnv(config.AuthMethod, ""),
+		TenantID:            stringValueOrEnv(config.TenantID, "AZURE_TENANT_ID"),
+		ClientID:            stringValueOrEnv(config.ClientID, "AZURE_CLIENT_ID"),
+		ClientSecret:        stringValueOrEnv(config.ClientSecret, "AZURE_CLIENT_SECRET"),
+		CertificatePath:     stringValueOrEnv(config.CertificatePath, "AZURE_CERTIFICATE_PATH"),
+		CertificateContent:  stringValueOrEnv(config.CertificateContent, "AZURE_CERTIFICATE_CONTENT"),
+		CertificatePassword: stringValueOrEnv(config.CertificatePassword, "AZURE_CERTIFICATE_PASSWORD"),
+		MSIEndpoint:         stringValueOrEnv(config.MSIEndpoint, ""),
+		FederatedTokenFile:  stringValueOrEnv(config.FederatedTokenFile, "AZURE_FEDERATED_TOKEN_FILE"),
+	}
+	if config.EnableMSI != nil {
+		credentials.EnableMSI = *config.EnableMSI
+	}
+	if config.SendCertificateChain != nil {
+		credentials.SendCertificateChain = *config.SendCertificateChain
+	}
+
+	// A certificate set in the connection config replaces the one from the
+	// environment, whether it is given as a path or as content
+	if config.CertificatePath != nil && config.CertificateContent == nil {
+		credentials.CertificateContent = ""
+	}
+	if config.CertificateContent != nil && config.CertificatePath == nil {
+		credentials.CertificatePath = ""
+	}
+
+	return credentials
+}
+
+// validate checks that the settings required by the auth method are present.
+// If no auth_method is set, it is inferred in the order client secret, client
+// certificate, managed identity and Azure CLI.
+func (c *microsoft365Credentials) validate() error {
+	c.AuthMethod = strings.ToLower(strings.TrimSpace(c.AuthMethod))
+
+	if c.AuthMethod == "" {
+		switch {
+		case c.TenantID == "":
+			c.AuthMethod = AuthMethodCLI
+		case c.ClientID != "" && c.ClientSecret != "":
+			c.AuthMethod = AuthMethodClientSecret
+		case c.ClientID != "" && c.hasCertificate():
+			c.AuthMethod = AuthMethodClientCertificate
+		case c.EnableMSI:
+			c.AuthMethod = AuthMethodMSI
+		default:
+			return fmt.Errorf("tenant_id is set but no credentials were found: set client_id with client_secret, certificate_path or certificate_content, set enable_msi = true, or set auth_method to one of %s", strings.Join(authMethods, ", "))
+		}
+	}
+
+	switch c.AuthMethod {
+	case AuthMethodCLI, AuthMethodChained:
+		return nil
+	case AuthMethodClientSecret:
+		return c.requireSettings(map[string]string{
+			"tenant_id":     c.TenantID,
+			"client_id":     c.ClientID,
+			"client_secret": c.ClientSecret,
+		})
+	case AuthMethodClientCertificate:
+		if err := c.requireSettings(map[string]string{
+			"tenant_id": c.TenantID,
+			"client_id": c.ClientID,
+		}); err != nil {
+			return err
+		}
+		if c.CertificatePath != "" && c.CertificateContent != "" {
+			return fmt.Errorf("auth_method %q: set either certificate_path or certificate_content, not both", c.AuthMethod)
+		}
+		if !c.hasCertificate() {
+			return fmt.Errorf("auth_method %q requires certificate_path (or the AZURE_CERTIFICATE_PATH environment variable) or certificate_content (or the AZURE_CERTIFICATE_CONTENT environment variable) to be set", c.AuthMethod)
+		}
+		return nil
+	case AuthMethodMSI:
+		if c.MSIEndpoint != "" && !strings.HasPrefix(c.MSIEndpoint, "http://") && !strings.HasPrefix(c.MSIEndpoint, "https://") {
+			return fmt.Errorf("auth_method %q: msi_endpoint %q must be an http or https URL", c.AuthMethod, c.MSIEndpoint)
+		}
+		return nil
+	case AuthMethodWorkloadIdentity:
+		if err := c.requireSettings(map[string]string{
+			"tenant_id":            c.TenantID,
+			"client_id":            c.ClientID,
+			"federated_token_file": c.FederatedTokenFile,
+		}); err != nil {
+			return err
+		}
+		if _, err := os.Stat(c.FederatedTokenFile); err != nil {
+			return fmt.Errorf("auth_method %q: cannot read federated_token_file: %v", c.AuthMethod, err)
+		}
+		return nil
+	case AuthMethodDeviceCode:
+		return nil
+	default:
+		return fmt.Errorf("invalid auth_method %q, valid values are %s", c.AuthMethod, strings.Join(authMethods, ", "))
+	}
+}
+
+// hasCertificate reports whether a client certificate is set, as a path or as
+// content.
+func (c *microsoft365Credentials) hasCertificate() bool {
+	return c.CertificatePath != "" || c.CertificateContent != ""
+}
+
+// settingEnvVars maps connection arguments to the environment variables they
+// can be read from, for use in error messages.
+var settingEnvVars = map[string]string{
+	"tenant_id":            "AZURE_TENANT_ID",
+	"client_id":            "AZURE_CLIENT_ID",
+	"client_secret":        "AZURE_CLIENT_SECRET",
+	"certificate_path":     "AZURE_CERTIFICATE_PATH",
+	"federated_token_file": "AZURE_FEDERATED_TOKEN_FILE",
+}
+
+func (c *microsoft365Credentials) requireSettings(settings map[string]string) error {
+	var missing []string
+	for name, value := range settings {
+		if value != "" {
+			continue
+		}
+		if envVar, ok := settingEnvVars[name]; ok {
+			missing = append(missing, fmt.Sprintf("%s (or the %s environment variable)", name, envVar))
+		} else {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	sort.Strings(missing)
+
+	return fmt.Errorf("auth_method %q requires %s to be set", c.AuthMethod, strings.Join(missing, ", "))
+}
+
+func stringValueOrEnv(value *string, envVar string) string {
+	if value != nil {
+		return *value
+	}
+	if envVar != "" {
+		return os.Getenv(envVar)
+	}
+	return ""
+}
@@ -0,0 +1,67 @@
+package microsoft365
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateConfig(t *testing.T) {
+	for _, envVar := range []string{"AZURE_TENANT_ID", "AZURE_CLIENT_ID", "AZURE_CLIENT_SECRET", "AZURE_CERTIFICATE_PATH", "AZURE_CERTIFICATE_CONTENT", "AZURE_FEDERATED_TOKEN_FILE", "AZURE_ENVIRONMENT"} {
+		t.Setenv(envVar, "")
+	}
+
+	negative := -1
+	tests := []struct {
+		name    string
+		config  microsoft365Config
+		wantErr string
+	}{
+		{
+			name:   "azure cli",
+			config: microsoft365Config{},
+		},
+		{
+			name:   "client secret",
+			config: microsoft365Config{TenantID: StringPtr("tenant"), ClientID: StringPtr("client"), ClientSecret: StringPtr("secret")},
+		},
+		{
+			name:    "unknown auth method",
+			config:  microsoft365Config{AuthMethod: StringPtr("password")},
+			wantErr: `invalid auth_method "password"`,
+		},
+		{
+			name:    "missing credentials",
+			config:  microsoft365Config{AuthMethod: StringPtr(AuthMethodClientSecret), TenantID: StringPtr("tenant")},
+			wantErr: "requires client_id",
+		},
+		{
+			name:    "invalid setting",
+			config:  microsoft365Config{MaxRetries: &negative},
+			wantErr: "invalid max_retries -1",
+		},
+		{
+			name: "credentials of a tenant",
+			config: microsoft365Config{Tenants: []map[string]string{
+				{"tenant_id": "tenant-a", "client_id": "client", "client_secret": "secret"},
+				{"tenant_id": "tenant-b", "auth_method": AuthMethodClientSecret, "client_id": "client"},
+			}},
+			wantErr: "tenant tenant-b: auth_method \"client_secret\" requires client_secret",
+		},
+		{
+			name:   "replay needs no credentials",
+			config: microsoft365Config{AuthMethod: StringPtr(AuthMethodClientSecret), ReplayMode: StringPtr(ReplayModeReplay), ReplayDir: StringPtr(t.TempDir())},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateConfig(tt.config)
+			if tt.wantErr == "" && err != nil {
+				t.Errorf("validateConfig() error = %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("validateConfig() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,329 @@
+package microsoft365
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"github.com/turbot/steampipe-plugin-sdk/v5/plugin"
+)
+
+// newTokenCredential creates the credential for the auth method of the
+// connection. The credentials must have been validated first.
+func newTokenCredential(ctx context.Context, credentials microsoft365Credentials, endpoints cloudEndpoints, transport *httpTransport) (azcore.TokenCredential, error) {
+	switch credentials.AuthMethod {
+	case AuthMethodCLI:
+		return newCLICredential(credentials)
+	case AuthMethodClientSecret:
+		return newClientSecretCredential(credentials, endpoints, transport)
+	case AuthMethodClientCertificate:
+		return newClientCertificateCredential(credentials, endpoints, transport)
+	case AuthMethodMSI:
+		return newManagedIdentityCredential(credentials, endpoints, transport)
+	case AuthMethodWorkloadIdentity:
+		return newWorkloadIdentityCredential(credentials, endpoints, transport)
+	case AuthMethodDeviceCode:
+		return newDeviceCodeCredential(ctx, credentials, endpoints, transport)
+	case AuthMethodChained:
+		return newChainedCredential(credentials, endpoints, transport)
+	}
+	return nil, fmt.Errorf("invalid auth_method %q, valid values are %s", credentials.AuthMethod, strings.Join(authMethods, ", "))
+}
+
+func newCLICredential(credentials microsoft365Credentials) (azcore.TokenCredential, error) {
+	// The Azure CLI authenticates against the cloud selected with
+	// "az cloud set"; the Graph resource is picked from the token scope
+	cred, err := azidentity.NewAzureCLICredential(
+		&azidentity.AzureCLICredentialOptions{
+			TenantID: credentials.TenantID,
+		},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error creating Azure CLI credentials: %w", err)
+	}
+	return cred, nil
+}
+
+func newClientSecretCredential(credentials microsoft365Credentials, endpoints cloudEndpoints, transport *httpTransport) (azcore.TokenCredential, error) {
+	cred, err := azidentity.NewClientSecretCredential(
+		credentials.TenantID,
+		credentials.ClientID,
+		credentials.ClientSecret,
+		&azidentity.ClientSecretCredentialOptions{
+			ClientOptions: policy.ClientOptions{
+				Cloud:     endpoints.Cloud,
+				Transport: transport.tokenClient(),
+			},
+			DisableInstanceDiscovery: endpoints.CustomAuthority,
+		},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error creating client secret credentials: %w", err)
+	}
+	return cred, nil
+}
+
+func newClientCertificateCredential(credentials microsoft365Credentials, endpoints cloudEndpoints, transport *httpTransport) (azcore.TokenCredential, error) {
+	certs, key, err := loadClientCertificate(credentials)
+	if err != nil {
+		return nil, err
+	}
+
+	cred, err := azidentity.NewClientCertificateCredential(
+		credentials.TenantID,
+		credentials.ClientID,
+		certs,
+		key,
+		&azidentity.ClientCertificateCredentialOptions{
+			ClientOptions: policy.ClientOptions{
+				Cloud:     endpoints.Cloud,
+				Transport: transport.tokenClient(),
+			},
+			DisableInstanceDiscovery: endpoints.CustomAuthority,
+			SendCertificateChain:     credentials.SendCertificateChain,
+		},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error creating client certificate credentials: %w", err)
+	}
+	return cred, nil
+}
+
+func newManagedIdentityCredential(credentials microsoft365Credentials, endpoints cloudEndpoints, transport *httpTransport) (azcore.TokenCredential, error) {
+	if credentials.MSIEndpoint != "" {
+		return newMSIEndpointCredential(credentials.MSIEndpoint, credentials.ClientID, transport.localClient()), nil
+	}
+
+	options := &azidentity.ManagedIdentityCredentialOptions{
+		ClientOptions: policy.ClientOptions{
+			Cloud:     endpoints.Cloud,
+			Transport: transport.localClient(),
+		},
+	}
+	// A client ID selects a user-assigned identity, otherwise the system-assigned
+	// identity is used
+	if credentials.ClientID != "" {
+		options.ID = azidentity.ClientID(credentials.ClientID)
+	}
+
+	cred, err := azidentity.NewManagedIdentityCredential(options)
+	if err != nil {
+		return nil, fmt.Errorf("error creating managed identity credentials: %w", err)
+	}
+	return cred, nil
+}
+
+// newWorkloadIdentityCredential exchanges a federated OIDC token, e.g. a
+// Kubernetes service account or GitHub Actions token, for a Graph token.
+func newWorkloadIdentityCredential(credentials microsoft365Credentials, endpoints cloudEndpoints, transport *httpTransport) (azcore.TokenCredential, error) {
+	tokenFile := newFederatedTokenFile(credentials.FederatedTokenFile)
+
+	cred, err := azidentity.NewClientAssertionCredential(
+		credentials.TenantID,
+		credentials.ClientID,
+		tokenFile.getAssertion,
+		&azidentity.ClientAssertionCredentialOptions{
+			ClientOptions: policy.ClientOptions{
+				Cloud:     endpoints.Cloud,
+				Transport: transport.tokenClient(),
+			},
+			DisableInstanceDiscovery: endpoints.CustomAuthority,
+		},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error creating workload identity credentials: %w", err)
+	}
+	return cred, nil
+}
+
+// federatedTokenFile reads the client assertion from a projected token file.
+// The file is rotated by the platform (Kubernetes refreshes it hourly), so it
+// is read again whenever it has changed since the last read.
+type federatedTokenFile struct {
+	path string
+
+	mu        sync.Mutex
+	assertion string
+	modTime   time.Time
+	size      int64
+}
+
+func newFederatedTokenFile(path string) *federatedTokenFile {
+	return &federatedTokenFile{path: path}
+}
+
+func (f *federatedTokenFile) getAssertion(ctx context.Context) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	info, err := os.Stat(f.path)
+	if err != nil {
+		return "", fmt.Errorf("error reading federated token file %s: %v", f.path, err)
+	}
+	if f.assertion != "" && info.ModTime().Equal(f.modTime) && info.Size() == f.size {
+		return f.assertion, nil
+	}
+
+	content, err := os.ReadFile(f.path)
+	if err != nil {
+		return "", fmt.Errorf("error reading federated token file %s: %v", f.path, err)
+	}
+	assertion := strings.TrimSpace(string(content))
+	if assertion == "" {
+		return "", fmt.Errorf("federated token file %s is empty", f.path)
+	}
+
+	f.assertion = assertion
+	f.modTime = info.ModTime()
+	f.size = info.Size()
+
+	return f.assertion, nil
+}
+
+func newDeviceCodeCredential(ctx context.Context, credentials microsoft365Credentials, endpoints cloudEndpoints, transport *httpTransport) (azcore.TokenCredential, error) {
+	cred, err := newPersistentDeviceCodeCredential(plugin.Logger(ctx), credentials, endpoints, transport.tokenClient())
+	if err != nil {
+		return nil, fmt.Errorf("error creating device code credentials: %w", err)
+	}
+	return cred, nil
+}
+
+// newChainedCredential tries each credential that has settings in the
+// connection, in the same order auth_method is inferred, followed by the
+// Azure CLI.
+func newChainedCredential(credentials microsoft365Credentials, endpoints cloudEndpoints, transport *httpTransport) (azcore.TokenCredential, error) {
+	var sources []azcore.TokenCredential
+
+	hasApp := credentials.TenantID != "" && credentials.ClientID != ""
+	if hasApp && credentials.ClientSecret != "" {
+		cred, err := newClientSecretCredential(credentials, endpoints, transport)
+		if err != nil {
+			return nil, err
+		}
+		sources = append(sources, cred)
+	}
+	if hasApp && credentials.hasCertificate() {
+		cred, err := newClientCertificateCredential(credentials, endpoints, transport)
+		if err != nil {
+			return nil, err
+		}
+		sources = append(sources, cred)
+	}
+	if hasApp && credentials.FederatedTokenFile != "" {
+		cred, err := newWorkloadIdentityCredential(credentials, endpoints, transport)
+		if err != nil {
+			return nil, err
+		}
+		sources = append(sources, cred)
+	}
+	// Managed identity requests time out slowly outside of Azure, so it is only
+	// tried when explicitly enabled
+	if credentials.EnableMSI || credentials.MSIEndpoint != "" {
+		cred, err := newManagedIdentityCredential(credentials, endpoints, transport)
+		if err != nil {
+			return nil, err
+		}
+		sources = append(sources, cred)
+	}
+
+	cred, err := newCLICredential(credentials)
+	if err != nil {
+		return nil, err
+	}
+	sources = append(sources, cred)
+
+	chain, err := azidentity.NewChainedTokenCredential(sources, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error creating chained credentials: %w", err)
+	}
+	return chain, nil
+}
+
+// msiEndpointCredential requests managed identity tokens from a custom
+// endpoint that implements the Azure Instance Metadata Service (IMDS) token
+// API, e.g. http://169.254.169.254/metadata/identity/oauth2/token.
+type msiEndpointCredential struct {
+	endpoint   string
+	clientID   string
+	httpClient *http.Client
+}
+
+func newMSIEndpointCredential(endpoint, clientID string, httpClient *http.Client) *msiEndpointCredential {
+	return &msiEndpointCredential{
+		endpoint:   endpoint,
+		clientID:   clientID,
+		httpClient: httpClient,
+	}
+}
+
+func (c *msiEndpointCredential) GetToken(ctx context.Context, opts policy.TokenRequestOptions) (azcore.AccessToken, error) {
+	if len(opts.Scopes) != 1 {
+		return azcore.AccessToken{}, fmt.Errorf("managed identity token requests support exactly one scope, got %d", len(opts.Scopes))
+	}
+
+	endpoint, err := url.Parse(c.endpoint)
+	if err != nil {
+		return azcore.AccessToken{}, fmt.Errorf("invalid msi_endpoint %s: %v", c.endpoint, err)
+	}
+	query := endpoint.Query()
+	if query.Get("api-version") == "" {
+		query.Set("api-version", "2018-02-01")
+	}
+	query.Set("resource", strings.TrimSuffix(opts.Scopes[0], "/.default"))
+	if c.clientID != "" {
+		query.Set("client_id", c.clientID)
+	}
+	endpoint.RawQuery = query.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint.String(), nil)
+	if err != nil {
+		return azcore.AccessToken{}, err
+	}
+	req.Header.Set("Metadata", "true")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return azcore.AccessToken{}, fmt.Errorf("managed identity token request to %s failed: %v", endpoint.Host, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return azcore.AccessToken{}, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return azcore.AccessToken{}, fmt.Errorf("managed identity token request to %s failed with status %d: %s", endpoint.Host, resp.StatusCode, string(body))
+	}
+
+	// IMDS returns the expiry as a string, other implementations as a number
+	var tokenResponse struct {
+		AccessToken string      `json:"access_token"`
+		ExpiresOn   json.Number `json:"expires_on"`
+		ExpiresIn   json.Number `json:"expires_in"`
+	}
+	if err := json.Unmarshal(body, &tokenResponse); err != nil {
+		return azcore.AccessToken{}, fmt.Errorf("error parsing managed identity token response: %v", err)
+	}
+
+	token := azcore.AccessToken{Token: tokenResponse.AccessToken}
+	if expiresOn, err := strconv.ParseInt(tokenResponse.ExpiresOn.String(), 10, 64); err == nil {
+		token.ExpiresOn = time.Unix(expiresOn, 0)
+	} else if expiresIn, err := strconv.ParseInt(tokenResponse.ExpiresIn.String(), 10, 64); err == nil {
+		token.ExpiresOn = time.Now().Add(time.Duration(expiresIn) * time.Second)
+	} else {
+		return azcore.AccessToken{}, fmt.Errorf("managed identity token response has no expiry")
+	}
+
+	return token, nil
+}
@@ -0,0 +1,233 @@
+package microsoft365
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/AzureAD/microsoft-authentication-library-for-go/apps/cache"
+	"github.com/hashicorp/go-hclog"
+)
+
+func TestFederatedTokenFileRotation(t *testing.T) {
+	ctx := testContext()
+	path := filepath.Join(t.TempDir(), "azure-identity-token")
+
+	if err := os.WriteFile(path, []byte("first-assertion\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	tokenFile := newFederatedTokenFile(path)
+
+	assertion, err := tokenFile.getAssertion(ctx)
+	if err != nil {
+		t.Fatalf("getAssertion() error = %v", err)
+	}
+	if assertion != "first-assertion" {
+		t.Errorf("getAssertion() = %q, want %q", assertion, "first-assertion")
+	}
+
+	// Simulate the platform rotating the projected token
+	if err := os.WriteFile(path, []byte("second-assertion"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(path, later, later); err != nil {
+		t.Fatal(err)
+	}
+
+	assertion, err = tokenFile.getAssertion(ctx)
+	if err != nil {
+		t.Fatalf("getAssertion() error = %v", err)
+	}
+	if assertion != "second-assertion" {
+		t.Errorf("getAssertion() after rotation = %q, want %q", assertion, "second-assertion")
+	}
+
+	if err := os.WriteFile(path, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tokenFile.getAssertion(ctx); err == nil {
+		t.Error("getAssertion() with an empty token file returned no error")
+	}
+}
+
+type testCacheData struct {
+	data []byte
+}
+
+func (c *testCacheData) Marshal() ([]byte, error) {
+	return c.data, nil
+}
+
+func (c *testCacheData) Unmarshal(data []byte) error {
+	c.data = data
+	return nil
+}
+
+func TestTokenCacheFileRoundTrip(t *testing.T) {
+	ctx := testContext()
+	t.Setenv("STEAMPIPE_INSTALL_DIR", t.TempDir())
+
+	cacheFile, err := newTokenCacheFile("https://login.microsoftonline.com/organizations|client")
+	if err != nil {
+		t.Fatalf("newTokenCacheFile() error = %v", err)
+	}
+
+	// A missing cache file is an empty cache
+	loaded := &testCacheData{}
+	if err := cacheFile.Replace(ctx, loaded, cache.ReplaceHints{}); err != nil {
+		t.Fatalf("Replace() error = %v", err)
+	}
+	if loaded.data != nil {
+		t.Errorf("Replace() without a cache file loaded %q", loaded.data)
+	}
+
+	secret := []byte(`{"RefreshToken":{"secret":"refresh-token"}}`)
+	if err := cacheFile.Export(ctx, &testCacheData{data: secret}, cache.ExportHints{}); err != nil {
+		t.Fatalf("Export() error = %v", err)
+	}
+
+	onDisk, err := os.ReadFile(cacheFile.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(onDisk), "refresh-token") {
+		t.Error("token cache was written to disk unencrypted")
+	}
+
+	if err := cacheFile.Replace(ctx, loaded, cache.ReplaceHints{}); err != nil {
+		t.Fatalf("Replace() error = %v", err)
+	}
+	if string(loaded.data) != string(secret) {
+		t.Errorf("Replace() loaded %q, want %q", loaded.data, secret)
+	}
+
+	// Plugin processes sharing the cache write it concurrently, each through a
+	// temporary file of its own
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			other := &tokenCacheFile{path: cacheFile.path, keyPath: cacheFile.keyPath}
+			if err := other.Export(ctx, &testCacheData{data: secret}, cache.ExportHints{}); err != nil {
+				t.Errorf("Export() error = %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if err := cacheFile.Replace(ctx, loaded, cache.ReplaceHints{}); err != nil || string(loaded.data) != string(secret) {
+		t.Errorf("Replace() loaded %q, %v, want %q", loaded.data, err, secret)
+	}
+	if tmp, _ := filepath.Glob(cacheFile.path + ".*"); len(tmp) != 0 {
+		t.Errorf("temporary files %v were left behind", tmp)
+	}
+}
+
+// testDeviceCodeAuthority is a stand-in Microsoft Entra authority for the
+// device code flow, whose code is entered by setting approved.
+type testDeviceCodeAuthority struct {
+	server   *httptest.Server
+	approved atomic.Bool
+	codes    atomic.Int32
+}
+
+func newTestDeviceCodeAuthority(t *testing.T) *testDeviceCodeAuthority {
+	a := &testDeviceCodeAuthority{}
+	a.server = httptest.NewTLSServer(a)
+	t.Cleanup(a.server.Close)
+	return a
+}
+
+func (a *testDeviceCodeAuthority) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	authority := a.server.URL + "/tenant"
+	switch r.URL.Path {
+	case "/tenant/v2.0/.well-known/openid-configuration":
+		writeFakeGraphJSON(w, http.StatusOK, map[string]string{
+			"issuer":                        authority + "/v2.0",
+			"authorization_endpoint":        authority + "/oauth2/v2.0/authorize",
+			"token_endpoint":                authority + "/oauth2/v2.0/token",
+			"device_authorization_endpoint": authority + "/oauth2/v2.0/devicecode",
+		})
+	case "/tenant/oauth2/v2.0/devicecode":
+		code := a.codes.Add(1)
+		writeFakeGraphJSON(w, http.StatusOK, map[string]interface{}{
+			"user_code":        fmt.Sprintf("CODE-%d", code),
+			"device_code":      "device-code",
+			"verification_uri": "https://microsoft.com/devicelogin",
+			"expires_in":       900,
+			"interval":         1,
+			"message":          "To sign in, use a web browser to open the page https://microsoft.com/devicelogin.",
+		})
+	case "/tenant/oauth2/v2.0/token":
+		if !a.approved.Load() {
+			writeFakeGraphJSON(w, http.StatusBadRequest, map[string]string{"error": "authorization_pending", "error_description": "The user hasn't entered the code yet."})
+			return
+		}
+		encode := func(claims string) string { return base64.RawURLEncoding.EncodeToString([]byte(claims)) }
+		idToken := encode(`{"alg":"none"}`) + "." + encode(fmt.Sprintf(`{"aud":"client","iss":"%s/v2.0","tid":"tenant","oid":"user","preferred_username":"user@example.com","exp":%d}`, authority, time.Now().Add(time.Hour).Unix())) + "."
+		writeFakeGraphJSON(w, http.StatusOK, map[string]interface{}{
+			"token_type":    "Bearer",
+			"access_token":  "access-token",
+			"refresh_token": "refresh-token",
+			"expires_in":    3600,
+			"scope":         "https://graph.microsoft.com/.default",
+			"id_token":      idToken,
+			"client_info":   encode(`{"uid":"user","utid":"tenant"}`),
+		})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func TestDeviceCodeCredentialSignIn(t *testing.T) {
+	ctx := testContext()
+	t.Setenv("STEAMPIPE_INSTALL_DIR", t.TempDir())
+	authority := newTestDeviceCodeAuthority(t)
+
+	endpoints := cloudEndpoints{CustomAuthority: true}
+	endpoints.Cloud.ActiveDirectoryAuthorityHost = authority.server.URL
+	cred, err := newPersistentDeviceCodeCredential(hclog.NewNullLogger(), microsoft365Credentials{ClientID: "client", TenantID: "tenant"}, endpoints, authority.server.Client())
+	if err != nil {
+		t.Fatalf("newPersistentDeviceCodeCredential() error = %v", err)
+	}
+	opts := policy.TokenRequestOptions{Scopes: []string{"https://graph.microsoft.com/.default"}}
+
+	// The query fails with the URL and code to sign in with
+	_, err = cred.GetToken(ctx, opts)
+	var signInErr *DeviceCodeSignInError
+	if !errors.As(err, &signInErr) || !strings.Contains(err.Error(), "https://microsoft.com/devicelogin") || !strings.Contains(err.Error(), "CODE-1") {
+		t.Fatalf("GetToken() error = %v, want the device code sign-in instructions", err)
+	}
+
+	// Queries run before the code is entered get the same code
+	if _, err := cred.GetToken(ctx, opts); err == nil || !strings.Contains(err.Error(), "CODE-1") {
+		t.Errorf("GetToken() error = %v, want the pending sign-in", err)
+	}
+
+	// Once the code is entered, the pending sign-in completes and a query
+	// run again gets a token
+	authority.approved.Store(true)
+	select {
+	case <-cred.pending.done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("the sign-in didn't complete")
+	}
+	token, err := cred.GetToken(ctx, opts)
+	if err != nil || token.Token != "access-token" {
+		t.Errorf("GetToken() = %q, %v, want the signed-in token", token.Token, err)
+	}
+	if got := authority.codes.Load(); got != 1 {
+		t.Errorf("device codes requested = %d, want 1", got)
+	}
+}
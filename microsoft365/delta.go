@@ -0,0 +1,272 @@
+package microsoft365
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/microsoftgraph/msgraph-sdk-go/models"
+	"github.com/microsoftgraph/msgraph-sdk-go/models/odataerrors"
+	"github.com/turbot/steampipe-plugin-sdk/v5/grpc/proto"
+	"github.com/turbot/steampipe-plugin-sdk/v5/plugin"
+	"github.com/turbot/steampipe-plugin-sdk/v5/plugin/transform"
+)
+
+// The change types of the rows of the delta tables
+const (
+	// deltaChangeInitial rows are returned by the first sync of a scope, which
+	// lists every item
+	deltaChangeInitial = "initial"
+	// deltaChangeUpdated rows were created or updated since the last sync
+	deltaChangeUpdated = "updated"
+	// deltaChangeDeleted rows were deleted since the last sync
+	deltaChangeDeleted = "deleted"
+)
+
+// deltaPage is a page of a Graph delta query, e.g. users.DeltaGetResponseable.
+// The last page has a deltaLink instead of a nextLink.
+// https://learn.microsoft.com/en-us/graph/delta-query-overview
+type deltaPage[T any] interface {
+	GetValue() []T
+	GetOdataNextLink() *string
+	GetOdataDeltaLink() *string
+}
+
+// deltaQuery is an incremental sync of a collection with Graph delta queries.
+type deltaQuery[T any] struct {
+	// Scope identifies the collection, e.g. users or the messages of a mail
+	// folder, which keeps its own deltaLink
+	Scope string
+	// Start is the URL of the first request of a sync from scratch
+	Start string
+	// Get requests a page from the URL, which is Start, a nextLink or a
+	// deltaLink
+	Get func(ctx context.Context, url string) (deltaPage[T], error)
+}
+
+// listDelta streams the items of the query changed since the last sync of its
+// scope by the connection, or every item on the first sync.
+//
+// Every query that pages to the last page advances the stored deltaLink, not
+// only those whose rows reach the user: the plugin can't tell whether Postgres
+// discards the rows, e.g. for a count(*), a join or a limit it applies itself,
+// or whether Steampipe only fills its query cache.
+func listDelta[T any](ctx context.Context, d *plugin.QueryData, q deltaQuery[T], stream func(item T, changeType string)) error {
+	// The start URL carries the $select, so a sync with other properties, e.g.
+	// after an upgrade adds columns, doesn't continue from a deltaLink that
+	// omits them
+	key := strings.Join([]string{d.Connection.Name, getMatrixTenantID(ctx), q.Scope, q.Start}, "/")
+	return syncDelta(ctx, key, q, func(item T, changeType string) bool {
+		stream(item, changeType)
+
+		// Context can be cancelled due to manual cancellation or the limit has been hit
+		return d.RowsRemaining(ctx) != 0
+	})
+}
+
+// syncDelta pages the query from the deltaLink stored for the key. The
+// deltaLink of the last page is only stored once every item was streamed and
+// the query is still running, so that a sync cut short by a limit, an error or
+// a cancellation returns the same changes again.
+func syncDelta[T any](ctx context.Context, key string, q deltaQuery[T], stream func(item T, changeType string) bool) error {
+	logger := plugin.Logger(ctx)
+
+	link, err := loadDeltaLink(key)
+	if err != nil {
+		return err
+	}
+	initial := link == ""
+	if initial {
+		link = q.Start
+	}
+
+	for first := true; ; first = false {
+		page, err := q.Get(ctx, link)
+		if err != nil {
+			// Graph expires deltaLinks that are too old, which requires a sync
+			// from scratch
+			if first && !initial && isDeltaResyncError(err) {
+				logger.Warn("syncDelta", "scope", q.Scope, "resync_required", err)
+				initial, link = true, q.Start
+				if err := deleteDeltaLink(key); err != nil {
+					return err
+				}
+				continue
+			}
+			return err
+		}
+
+		for _, item := range page.GetValue() {
+			if !stream(item, getDeltaChangeType(item, initial)) {
+				return nil
+			}
+		}
+
+		if next := page.GetOdataNextLink(); next != nil && *next != "" {
+			link = *next
+			continue
+		}
+		if delta := page.GetOdataDeltaLink(); delta != nil && *delta != "" {
+			// A query cancelled while the last page was streamed may not have
+			// returned its rows
+			if ctx.Err() != nil {
+				return nil
+			}
+			return saveDeltaLink(key, q.Scope, *delta)
+		}
+		return fmt.Errorf("the delta query of %s returned neither a nextLink nor a deltaLink", q.Scope)
+	}
+}
+
+// getDeltaChangeType returns whether the item of a delta page was deleted,
+// which Graph marks with @removed, or drive items with a deleted facet.
+func getDeltaChangeType(item interface{}, initial bool) string {
+	if holder, ok := item.(interface{ GetAdditionalData() map[string]any }); ok {
+		if _, ok := holder.GetAdditionalData()["@removed"]; ok {
+			return deltaChangeDeleted
+		}
+	}
+	if driveItem, ok := item.(models.DriveItemable); ok && driveItem.GetDeleted() != nil {
+		return deltaChangeDeleted
+	}
+	if initial {
+		return deltaChangeInitial
+	}
+	return deltaChangeUpdated
+}
+
+// isDeltaResyncError reports whether Graph rejected an expired or invalid
+// deltaLink.
+func isDeltaResyncError(err error) bool {
+	var odataErr *odataerrors.ODataError
+	if !errors.As(err, &odataErr) {
+		return false
+	}
+	if odataErr.ResponseStatusCode == http.StatusGone {
+		return true
+	}
+	if terr := odataErr.GetErrorEscaped(); terr != nil && terr.GetCode() != nil {
+		switch strings.ToLower(*terr.GetCode()) {
+		case "resyncrequired", "syncstatenotfound", "syncstateinvalid":
+			return true
+		}
+	}
+	return false
+}
+
+// deltaColumns returns the columns of a delta table from those of the table
+// it tracks, without the excluded columns and those filled by a separate API
+// call, which delta queries don't return.
+func deltaColumns(columns []*plugin.Column, exclude ...string) []*plugin.Column {
+	result := []*plugin.Column{}
+	for _, column := range columns {
+		if column.Hydrate != nil && column.Name != "tenant_id" || slices.Contains(exclude, column.Name) {
+			continue
+		}
+		result = append(result, column)
+	}
+	return append(result, &plugin.Column{Name: "change_type", Type: proto.ColumnType_STRING, Description: "The change since the last sync: initial on the first sync, which returns every item, otherwise updated or deleted.", Transform: transform.FromField("ChangeType")})
+}
+
+//// DELTA LINK STORE
+
+// deltaLinkFile is the deltaLink of the last complete sync of a scope
+type deltaLinkFile struct {
+	Scope     string    `json:"scope"`
+	DeltaLink string    `json:"delta_link"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// getDeltaLinkPath returns the file the deltaLink of the key is stored in,
+// next to the token cache.
+func getDeltaLinkPath(key string) (string, error) {
+	dir, err := getTokenCacheDir()
+	if err != nil {
+		return "", err
+	}
+	hash := sha256.Sum256([]byte(key))
+	return filepath.Join(dir, "delta", fmt.Sprintf("%x.json", hash[:8])), nil
+}
+
+// loadDeltaLink returns the stored deltaLink of the key, or an empty string if
+// the scope was never synced.
+func loadDeltaLink(key string) (string, error) {
+	path, err := getDeltaLinkPath(key)
+	if err != nil {
+		return "", err
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("error reading the delta link %s: %v", path, err)
+	}
+
+	var file deltaLinkFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		// A corrupt file only costs a sync from scratch
+		return "", nil
+	}
+	return file.DeltaLink, nil
+}
+
+// saveDeltaLink stores the deltaLink of the key. The file is written to a
+// temporary file first, so that a concurrent sync never reads a partial one.
+func saveDeltaLink(key, scope, deltaLink string) error {
+	path, err := getDeltaLinkPath(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("error creating the delta link directory: %v", err)
+	}
+
+	data, err := json.Marshal(deltaLinkFile{Scope: scope, DeltaLink: deltaLink, UpdatedAt: time.Now().UTC()})
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("error writing the delta link: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("error writing the delta link: %v", err)
+	}
+	if err := tmp.Chmod(0600); err != nil {
+		tmp.Close()
+		return fmt.Errorf("error writing the delta link: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("error writing the delta link: %v", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("error writing the delta link: %v", err)
+	}
+	return nil
+}
+
+// deleteDeltaLink removes the stored deltaLink of the key.
+func deleteDeltaLink(key string) error {
+	path, err := getDeltaLinkPath(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("error deleting the delta link %s: %v", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,206 @@
+package microsoft365
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/microsoftgraph/msgraph-sdk-go/models"
+	"github.com/microsoftgraph/msgraph-sdk-go/models/odataerrors"
+)
+
+type testDeltaPage struct {
+	value     []models.Userable
+	nextLink  *string
+	deltaLink *string
+}
+
+func (p testDeltaPage) GetValue() []models.Userable { return p.value }
+func (p testDeltaPage) GetOdataNextLink() *string   { return p.nextLink }
+func (p testDeltaPage) GetOdataDeltaLink() *string  { return p.deltaLink }
+
+func testDeltaUser(id string, removed bool) models.Userable {
+	user := models.NewUser()
+	user.SetId(StringPtr(id))
+	if removed {
+		user.SetAdditionalData(map[string]any{"@removed": map[string]any{"reason": "deleted"}})
+	}
+	return user
+}
+
+// testDeltaQuery serves the pages by URL, and records the requested URLs
+func testDeltaQuery(pages map[string]testDeltaPage, errs map[string]error, requested *[]string) deltaQuery[models.Userable] {
+	return deltaQuery[models.Userable]{
+		Scope: "users",
+		Start: "start",
+		Get: func(ctx context.Context, url string) (deltaPage[models.Userable], error) {
+			*requested = append(*requested, url)
+			if err := errs[url]; err != nil {
+				return nil, err
+			}
+			return pages[url], nil
+		},
+	}
+}
+
+type testDeltaRow struct {
+	ID         string
+	ChangeType string
+}
+
+func collectDelta(t *testing.T, q deltaQuery[models.Userable], limit int) []testDeltaRow {
+	t.Helper()
+	rows := []testDeltaRow{}
+	err := syncDelta(testContext(), "connection//users", q, func(user models.Userable, changeType string) bool {
+		rows = append(rows, testDeltaRow{*user.GetId(), changeType})
+		return limit == 0 || len(rows) < limit
+	})
+	if err != nil {
+		t.Fatalf("syncDelta() error = %v", err)
+	}
+	return rows
+}
+
+func TestSyncDelta(t *testing.T) {
+	t.Setenv("STEAMPIPE_INSTALL_DIR", t.TempDir())
+
+	pages := map[string]testDeltaPage{
+		"start": {value: []models.Userable{testDeltaUser("a", false)}, nextLink: StringPtr("page2")},
+		"page2": {value: []models.Userable{testDeltaUser("b", false)}, deltaLink: StringPtr("delta1")},
+		"delta1": {value: []models.Userable{
+			testDeltaUser("a", false),
+			testDeltaUser("b", true),
+		}, deltaLink: StringPtr("delta2")},
+	}
+	var requested []string
+	q := testDeltaQuery(pages, nil, &requested)
+
+	// The first sync lists every item
+	rows := collectDelta(t, q, 0)
+	want := []testDeltaRow{{"a", deltaChangeInitial}, {"b", deltaChangeInitial}}
+	if !reflect.DeepEqual(rows, want) {
+		t.Errorf("first sync = %v, want %v", rows, want)
+	}
+
+	// A sync cut short by a limit doesn't store the deltaLink
+	requested = nil
+	rows = collectDelta(t, q, 1)
+	if len(rows) != 1 || !reflect.DeepEqual(requested, []string{"delta1"}) {
+		t.Errorf("limited sync = %v from %v, want 1 row from [delta1]", rows, requested)
+	}
+
+	// Nor does a sync whose query was cancelled while the last page was streamed
+	ctx, cancel := context.WithCancel(testContext())
+	err := syncDelta(ctx, "connection//users", q, func(models.Userable, string) bool {
+		cancel()
+		return true
+	})
+	if err != nil {
+		t.Fatalf("syncDelta() error = %v", err)
+	}
+
+	// The next sync returns the changes since the first one
+	rows = collectDelta(t, q, 0)
+	want = []testDeltaRow{{"a", deltaChangeUpdated}, {"b", deltaChangeDeleted}}
+	if !reflect.DeepEqual(rows, want) {
+		t.Errorf("second sync = %v, want %v", rows, want)
+	}
+	if link, _ := loadDeltaLink("connection//users"); link != "delta2" {
+		t.Errorf("stored deltaLink = %q, want delta2", link)
+	}
+}
+
+func TestSyncDeltaResync(t *testing.T) {
+	t.Setenv("STEAMPIPE_INSTALL_DIR", t.TempDir())
+
+	if err := saveDeltaLink("connection//users", "users", "expired"); err != nil {
+		t.Fatal(err)
+	}
+
+	gone := odataerrors.NewODataError()
+	gone.ResponseStatusCode = http.StatusGone
+	pages := map[string]testDeltaPage{
+		"start": {value: []models.Userable{testDeltaUser("a", false)}, deltaLink: StringPtr("delta1")},
+	}
+	var requested []string
+	rows := collectDelta(t, testDeltaQuery(pages, map[string]error{"expired": gone}, &requested), 0)
+
+	want := []testDeltaRow{{"a", deltaChangeInitial}}
+	if !reflect.DeepEqual(rows, want) || !reflect.DeepEqual(requested, []string{"expired", "start"}) {
+		t.Errorf("resync = %v from %v, want %v from [expired start]", rows, requested, want)
+	}
+	if link, _ := loadDeltaLink("connection//users"); link != "delta1" {
+		t.Errorf("stored deltaLink = %q, want delta1", link)
+	}
+
+	// Other errors fail the query, and keep the stored deltaLink
+	requested = nil
+	err := syncDelta(testContext(), "connection//users", testDeltaQuery(nil, map[string]error{"delta1": errors.New("throttled")}, &requested), func(models.Userable, string) bool { return true })
+	if err == nil {
+		t.Error("syncDelta() error = nil, want throttled")
+	}
+	if link, _ := loadDeltaLink("connection//users"); link != "delta1" {
+		t.Errorf("stored deltaLink = %q, want delta1", link)
+	}
+}
+
+func TestDeltaLinkStore(t *testing.T) {
+	t.Setenv("STEAMPIPE_INSTALL_DIR", t.TempDir())
+
+	if link, err := loadDeltaLink("a"); link != "" || err != nil {
+		t.Errorf("loadDeltaLink() = %q, %v, want no link", link, err)
+	}
+	if err := saveDeltaLink("a", "users", "https://graph.microsoft.com/v1.0/users/delta?$deltatoken=1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := saveDeltaLink("b", "users", "other"); err != nil {
+		t.Fatal(err)
+	}
+	if link, _ := loadDeltaLink("a"); link != "https://graph.microsoft.com/v1.0/users/delta?$deltatoken=1" {
+		t.Errorf("loadDeltaLink() = %q", link)
+	}
+
+	path, _ := getDeltaLinkPath("a")
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("delta link file mode = %v, want 0600", info.Mode().Perm())
+	}
+
+	if err := deleteDeltaLink("a"); err != nil {
+		t.Fatal(err)
+	}
+	if link, _ := loadDeltaLink("a"); link != "" {
+		t.Errorf("loadDeltaLink() after delete = %q", link)
+	}
+	if link, _ := loadDeltaLink("b"); link != "other" {
+		t.Errorf("loadDeltaLink() of another key = %q", link)
+	}
+}
+
+func TestDeltaChangeType(t *testing.T) {
+	deleted := models.NewDriveItem()
+	deleted.SetDeleted(models.NewDeleted())
+
+	tests := []struct {
+		item    interface{}
+		initial bool
+		want    string
+	}{
+		{testDeltaUser("a", false), true, deltaChangeInitial},
+		{testDeltaUser("a", false), false, deltaChangeUpdated},
+		{testDeltaUser("a", true), false, deltaChangeDeleted},
+		{deleted, false, deltaChangeDeleted},
+		{models.NewDriveItem(), false, deltaChangeUpdated},
+	}
+	for _, tt := range tests {
+		if got := getDeltaChangeType(tt.item, tt.initial); got != tt.want {
+			t.Errorf("getDeltaChangeType(%T, %t) = %s, want %s", tt.item, tt.initial, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,352 @@
+package microsoft365
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/AzureAD/microsoft-authentication-library-for-go/apps/cache"
+	"github.com/AzureAD/microsoft-authentication-library-for-go/apps/public"
+	"github.com/hashicorp/go-hclog"
+)
+
+// The Azure CLI's public client ID, used for device code sign-in when no
+// client_id is configured
+const azureCLIClientID = "04b07795-8ddb-461a-bbee-02f9e1bf7b46"
+
+// deviceCodeCredential signs a user in with the device code flow and keeps
+// the resulting refresh token in an on-disk cache, so later queries,
+// and later Steampipe sessions, refresh their access tokens silently.
+//
+// The plugin can't prompt the user, so a query that needs a sign-in starts
+// one and fails with the URL and code to enter. The sign-in completes in the
+// background once the code is entered, and the query succeeds when run again.
+type deviceCodeCredential struct {
+	client public.Client
+	logger hclog.Logger
+
+	// mu guards pending, so concurrent hydrates share a single device code
+	// instead of each prompting the user
+	mu sync.Mutex
+	// pending is the sign-in waiting for its code to be entered, if any
+	pending *deviceCodeSignIn
+}
+
+// deviceCodeSignIn is a device code sign-in in progress.
+type deviceCodeSignIn struct {
+	err *DeviceCodeSignInError
+	// done is closed when the sign-in completes, fails or expires
+	done chan struct{}
+}
+
+func newPersistentDeviceCodeCredential(logger hclog.Logger, credentials microsoft365Credentials, endpoints cloudEndpoints, httpClient *http.Client) (*deviceCodeCredential, error) {
+	clientID := credentials.ClientID
+	if clientID == "" {
+		clientID = azureCLIClientID
+	}
+	tenantID := credentials.TenantID
+	if tenantID == "" {
+		tenantID = "organizations"
+	}
+	authority := strings.TrimSuffix(endpoints.Cloud.ActiveDirectoryAuthorityHost, "/") + "/" + tenantID
+
+	cacheFile, err := newTokenCacheFile(authority + "|" + clientID)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := public.New(
+		clientID,
+		public.WithAuthority(authority),
+		public.WithCache(cacheFile),
+		public.WithInstanceDiscovery(!endpoints.CustomAuthority),
+		public.WithHTTPClient(httpClient),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error creating device code client: %v", err)
+	}
+
+	return &deviceCodeCredential{client: client, logger: logger}, nil
+}
+
+func (c *deviceCodeCredential) GetToken(ctx context.Context, opts policy.TokenRequestOptions) (azcore.AccessToken, error) {
+	if token, err := c.getTokenSilent(ctx, opts); err == nil {
+		return token, nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// Another caller's sign-in may have completed while this one was waiting
+	if token, err := c.getTokenSilent(ctx, opts); err == nil {
+		return token, nil
+	}
+
+	// A sign-in that ended without a token, e.g. because its code expired, is
+	// replaced with a new one
+	if c.pending != nil {
+		select {
+		case <-c.pending.done:
+			c.pending = nil
+		default:
+		}
+	}
+	if c.pending == nil {
+		signIn, err := c.startSignIn(ctx, opts)
+		if err != nil {
+			return azcore.AccessToken{}, err
+		}
+		c.pending = signIn
+	}
+
+	return azcore.AccessToken{}, c.pending.err
+}
+
+// startSignIn requests a device code and waits for it to be entered in the
+// background, storing the tokens in the cache when it is.
+func (c *deviceCodeCredential) startSignIn(ctx context.Context, opts policy.TokenRequestOptions) (*deviceCodeSignIn, error) {
+	var deviceCodeOptions []public.AcquireByDeviceCodeOption
+	if opts.Claims != "" {
+		deviceCodeOptions = append(deviceCodeOptions, public.WithClaims(opts.Claims))
+	}
+
+	// The sign-in outlives the query that started it, until its code expires
+	signInCtx := context.WithoutCancel(ctx)
+	deviceCode, err := c.client.AcquireTokenByDeviceCode(signInCtx, opts.Scopes, deviceCodeOptions...)
+	if err != nil {
+		return nil, fmt.Errorf("device code sign-in failed: %v", err)
+	}
+
+	// Also written to the plugin log, for queries whose errors aren't shown,
+	// e.g. those of a skipped tenant
+	c.logger.Warn("device_code_sign_in", "message", deviceCode.Result.Message)
+
+	signIn := &deviceCodeSignIn{
+		err: &DeviceCodeSignInError{
+			VerificationURL: deviceCode.Result.VerificationURL,
+			UserCode:        deviceCode.Result.UserCode,
+			ExpiresOn:       deviceCode.Result.ExpiresOn,
+		},
+		done: make(chan struct{}),
+	}
+	go func() {
+		defer close(signIn.done)
+		if _, err := deviceCode.AuthenticationResult(signInCtx); err != nil {
+			c.logger.Warn("device_code_sign_in", "error", err)
+		}
+	}()
+
+	return signIn, nil
+}
+
+// getTokenSilent returns a cached access token, or redeems the cached refresh
+// token for a new one.
+func (c *deviceCodeCredential) getTokenSilent(ctx context.Context, opts policy.TokenRequestOptions) (azcore.AccessToken, error) {
+	accounts, err := c.client.Accounts(ctx)
+	if err != nil {
+		return azcore.AccessToken{}, err
+	}
+	if len(accounts) == 0 {
+		return azcore.AccessToken{}, errors.New("no cached account")
+	}
+
+	silentOptions := []public.AcquireSilentOption{public.WithSilentAccount(accounts[0])}
+	if opts.Claims != "" {
+		silentOptions = append(silentOptions, public.WithClaims(opts.Claims))
+	}
+	result, err := c.client.AcquireTokenSilent(ctx, opts.Scopes, silentOptions...)
+	if err != nil {
+		return azcore.AccessToken{}, err
+	}
+
+	return azcore.AccessToken{Token: result.AccessToken, ExpiresOn: result.ExpiresOn}, nil
+}
+
+// tokenCacheFile persists the MSAL token cache to a file encrypted with
+// AES-256-GCM. The key is kept in a separate file next to it, readable only
+// by the current user, so a copied cache file can't be used on its own. It
+// doesn't protect the cache from anyone who can read both files, e.g. the
+// same user or a backup of the directory.
+type tokenCacheFile struct {
+	path    string
+	keyPath string
+
+	mu sync.Mutex
+}
+
+// newTokenCacheFile returns the cache file for the given partition, e.g. an
+// authority and client ID, under the Steampipe install directory.
+func newTokenCacheFile(partition string) (*tokenCacheFile, error) {
+	dir, err := getTokenCacheDir()
+	if err != nil {
+		return nil, err
+	}
+
+	hash := sha256.Sum256([]byte(partition))
+	return &tokenCacheFile{
+		path:    filepath.Join(dir, fmt.Sprintf("device_code_%x.cache", hash[:8])),
+		keyPath: filepath.Join(dir, "token_cache.key"),
+	}, nil
+}
+
+// getTokenCacheDir returns the plugin's directory for token caches inside the
+// Steampipe install directory.
+func getTokenCacheDir() (string, error) {
+	installDir := os.Getenv("STEAMPIPE_INSTALL_DIR")
+	if installDir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", fmt.Errorf("error locating the Steampipe install directory: %v", err)
+		}
+		installDir = filepath.Join(home, ".steampipe")
+	}
+	return filepath.Join(installDir, "internal", "microsoft365"), nil
+}
+
+// Replace loads the cache from disk. A missing or unreadable cache is treated
+// as empty, which triggers a new sign-in.
+func (f *tokenCacheFile) Replace(ctx context.Context, unmarshaler cache.Unmarshaler, hints cache.ReplaceHints) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	ciphertext, err := os.ReadFile(f.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error reading token cache %s: %v", f.path, err)
+	}
+
+	key, err := f.getKey()
+	if err != nil {
+		return err
+	}
+	plaintext, err := decryptTokenCache(key, ciphertext)
+	if err != nil {
+		// e.g. the key was replaced; start over with an empty cache
+		return nil
+	}
+
+	return unmarshaler.Unmarshal(plaintext)
+}
+
+// Export encrypts the cache and writes it to disk.
+func (f *tokenCacheFile) Export(ctx context.Context, marshaler cache.Marshaler, hints cache.ExportHints) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	plaintext, err := marshaler.Marshal()
+	if err != nil {
+		return err
+	}
+
+	key, err := f.getKey()
+	if err != nil {
+		return err
+	}
+	ciphertext, err := encryptTokenCache(key, plaintext)
+	if err != nil {
+		return err
+	}
+
+	// Write to a temporary file of its own first, so a concurrent reader never
+	// sees a partially written cache, nor two writers each other's file
+	tmp, err := os.CreateTemp(filepath.Dir(f.path), filepath.Base(f.path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("error writing token cache %s: %v", f.path, err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(ciphertext); err != nil {
+		tmp.Close()
+		return fmt.Errorf("error writing token cache %s: %v", f.path, err)
+	}
+	if err := tmp.Chmod(0600); err != nil {
+		tmp.Close()
+		return fmt.Errorf("error writing token cache %s: %v", f.path, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("error writing token cache %s: %v", f.path, err)
+	}
+	if err := os.Rename(tmp.Name(), f.path); err != nil {
+		return fmt.Errorf("error writing token cache %s: %v", f.path, err)
+	}
+	return nil
+}
+
+// tokenCacheKeyLock guards creation of the key, which is shared by the cache
+// files of all connections.
+var tokenCacheKeyLock sync.Mutex
+
+// getKey reads the cache encryption key, creating it on first use.
+func (f *tokenCacheFile) getKey() ([]byte, error) {
+	tokenCacheKeyLock.Lock()
+	defer tokenCacheKeyLock.Unlock()
+
+	key, err := os.ReadFile(f.keyPath)
+	if err == nil && len(key) == 32 {
+		return key, nil
+	}
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("error reading token cache key %s: %v", f.keyPath, err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(f.keyPath), 0700); err != nil {
+		return nil, fmt.Errorf("error creating token cache directory: %v", err)
+	}
+	key = make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(f.keyPath, key, 0600); err != nil {
+		return nil, fmt.Errorf("error writing token cache key %s: %v", f.keyPath, err)
+	}
+
+	return key, nil
+}
+
+func encryptTokenCache(key, plaintext []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+func decryptTokenCache(key, ciphertext []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(ciphertext) < gcm.NonceSize() {
+		return nil, errors.New("token cache is too short")
+	}
+	nonce, ciphertext := ciphertext[:gcm.NonceSize()], ciphertext[gcm.NonceSize():]
+	return gcm.Open(nil, nonce, ciphertext, nil)
+}
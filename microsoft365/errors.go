@@ -3,59 +3,312 @@ package microsoft365
 import (
 	"context"
 	"encoding/json"
-	"strconv"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"slices"
 	"strings"
+	"time"
 
 	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	abstractions "github.com/microsoft/kiota-abstractions-go"
 	"github.com/microsoftgraph/msgraph-sdk-go/models/odataerrors"
 	"github.com/turbot/steampipe-plugin-sdk/v5/plugin"
 )
 
+// ErrorCategory groups errors by what can be done about them, for the ignore
+// and retry decisions.
+type ErrorCategory string
+
+const (
+	// ErrorCategoryAuth errors mean the connection couldn't authenticate
+	ErrorCategoryAuth ErrorCategory = "auth"
+	// ErrorCategoryPermission errors mean the credentials lack a permission
+	ErrorCategoryPermission ErrorCategory = "permission"
+	// ErrorCategoryNotFound errors mean the requested resource doesn't exist,
+	// e.g. a user without a mailbox
+	ErrorCategoryNotFound ErrorCategory = "not_found"
+	// ErrorCategoryThrottled errors mean Graph throttled the request
+	ErrorCategoryThrottled ErrorCategory = "throttled"
+	// ErrorCategoryTransient errors are server or network failures that may
+	// succeed on retry
+	ErrorCategoryTransient ErrorCategory = "transient"
+	// ErrorCategoryBadRequest errors mean Graph rejected the request, e.g. an
+	// unsupported filter
+	ErrorCategoryBadRequest ErrorCategory = "bad_request"
+	// ErrorCategoryUnknown errors couldn't be classified
+	ErrorCategoryUnknown ErrorCategory = "unknown"
+)
+
+// RequestError is the error of a Graph request, with what Microsoft support
+// needs to trace it.
 type RequestError struct {
 	Code    string
 	Message string
+	// InnerCode is the more specific code some workloads nest in the error,
+	// e.g. the Exchange code of a 404
+	InnerCode string `json:",omitempty"`
+	// StatusCode is the HTTP status of the response, if there was one
+	StatusCode int           `json:",omitempty"`
+	Category   ErrorCategory `json:",omitempty"`
+	// RequestID and ClientRequestID identify the request for Microsoft support
+	RequestID       string `json:",omitempty"`
+	ClientRequestID string `json:",omitempty"`
+	// RetryAfter is how long Graph asked to wait, for throttled requests
+	RetryAfter time.Duration `json:"-"`
+
+	// Err is the error the RequestError was built from
+	Err error `json:"-"`
 }
 
 func (m *RequestError) Error() string {
-	errStr, err := json.Marshal(m)
+	type requestError RequestError
+	errStr, err := json.Marshal(struct {
+		*requestError
+		RetryAfterSeconds float64 `json:",omitempty"`
+	}{(*requestError)(m), m.RetryAfter.Seconds()})
 	if err != nil {
 		return ""
 	}
 	return string(errStr)
 }
 
+func (m *RequestError) Unwrap() error {
+	return m.Err
+}
+
+// UserIDRequiredError is returned by the microsoft365_my_* tables when the
+// connection authenticates as an application, which has no signed-in user.
+type UserIDRequiredError struct {
+	AuthMethod string
+	AppID      string
+}
+
+func (e *UserIDRequiredError) Error() string {
+	return fmt.Sprintf("the microsoft365_my_* tables need a signed-in user, but auth_method %q authenticates as application %s: set user_id in the connection config to the ID or user principal name of the user to query", e.AuthMethod, e.AppID)
+}
+
+// DeviceCodeSignInError is returned by a device_code connection that has no
+// signed-in user yet. The sign-in completes once the code is entered at the
+// URL, and queries run after that succeed.
+type DeviceCodeSignInError struct {
+	VerificationURL string
+	UserCode        string
+	ExpiresOn       time.Time
+}
+
+func (e *DeviceCodeSignInError) Error() string {
+	return fmt.Sprintf("device code sign-in required: open %s in a web browser and enter the code %s before %s, then run the query again", e.VerificationURL, e.UserCode, e.ExpiresOn.Local().Format(time.Kitchen))
+}
+
+// tenantErrorCode is the RequestError code of a TenantError
+const tenantErrorCode = "TenantUnavailable"
+
+// TenantError is returned when a tenant of a multi-tenant connection can't be
+// queried at all, e.g. because its credentials are invalid.
+type TenantError struct {
+	TenantID string
+	Err      error
+}
+
+func (e *TenantError) Error() string {
+	return fmt.Sprintf("tenant %s: %v", e.TenantID, e.Err)
+}
+
+func (e *TenantError) Unwrap() error {
+	return e.Err
+}
+
 // Returns the error object
 func getErrorObject(err error) *RequestError {
 	switch err := err.(type) {
-	case *odataerrors.ODataError:
-		terr := err.GetErrorEscaped()
+	case *RequestError:
+		return err
+	case *TenantError:
 		return &RequestError{
-			Code:    *terr.GetCode(),
-			Message: *terr.GetMessage(),
+			Code:     tenantErrorCode,
+			Message:  err.Error(),
+			Category: ErrorCategoryAuth,
+			Err:      err,
 		}
+	case *odataerrors.ODataError:
+		return getODataErrorObject(err)
 	case *azidentity.AuthenticationFailedError:
+		requestErr := &RequestError{
+			Message:  err.Error(),
+			Category: ErrorCategoryAuth,
+			Err:      err,
+		}
+		if err.RawResponse != nil {
+			requestErr.StatusCode = err.RawResponse.StatusCode
+			requestErr.Code = http.StatusText(err.RawResponse.StatusCode)
+			requestErr.RequestID = err.RawResponse.Header.Get("x-ms-request-id")
+			requestErr.ClientRequestID = err.RawResponse.Header.Get("client-request-id")
+		}
+		return requestErr
+	case *azidentity.AuthenticationRequiredError, *DeviceCodeSignInError:
 		return &RequestError{
-			Code:    strconv.Itoa(err.RawResponse.StatusCode),
-			Message: err.Error(),
+			Message:  err.Error(),
+			Category: ErrorCategoryAuth,
+			Err:      err,
 		}
 	default:
 		// If the error type is unknown
 		// return the exact error
 		return &RequestError{
-			Message: err.Error(),
+			Message:  err.Error(),
+			Category: getNetworkErrorCategory(err),
+			Err:      err,
 		}
 	}
 }
 
-func isIgnorableErrorPredicate(ignoreErrorCodes []string) plugin.ErrorPredicateWithContext {
+// getODataErrorObject reads the codes and request IDs of a Graph error, any of
+// which may be missing, e.g. from a proxy's error page.
+func getODataErrorObject(err *odataerrors.ODataError) *RequestError {
+	requestErr := &RequestError{
+		StatusCode: err.ResponseStatusCode,
+		Message:    err.Message,
+		Err:        err,
+	}
+
+	if main := err.GetErrorEscaped(); main != nil {
+		if main.GetCode() != nil {
+			requestErr.Code = *main.GetCode()
+		}
+		if main.GetMessage() != nil {
+			requestErr.Message = *main.GetMessage()
+		}
+		if inner := main.GetInnerError(); inner != nil {
+			if inner.GetRequestId() != nil {
+				requestErr.RequestID = *inner.GetRequestId()
+			}
+			if inner.GetClientRequestId() != nil {
+				requestErr.ClientRequestID = *inner.GetClientRequestId()
+			}
+			if code, ok := inner.GetAdditionalData()["code"].(*string); ok && code != nil {
+				requestErr.InnerCode = *code
+			}
+		}
+		if details := main.GetDetails(); requestErr.InnerCode == "" && len(details) > 0 && details[0].GetCode() != nil {
+			requestErr.InnerCode = *details[0].GetCode()
+		}
+	}
+
+	// The response headers are set on errors of most workloads, even those
+	// without an innerError
+	if headers := err.GetResponseHeaders(); headers != nil {
+		if requestErr.RequestID == "" {
+			requestErr.RequestID = getResponseHeader(headers, "request-id")
+		}
+		if requestErr.ClientRequestID == "" {
+			requestErr.ClientRequestID = getResponseHeader(headers, "client-request-id")
+		}
+		if retryAfter, ok := parseRetryAfter(getResponseHeader(headers, "Retry-After")); ok {
+			requestErr.RetryAfter = retryAfter
+		}
+	}
+
+	if requestErr.Message == "" {
+		requestErr.Message = http.StatusText(requestErr.StatusCode)
+	}
+	requestErr.Category = getErrorCategory(requestErr.StatusCode, requestErr.Code, requestErr.InnerCode)
+	return requestErr
+}
+
+func getResponseHeader(headers *abstractions.ResponseHeaders, key string) string {
+	if values := headers.Get(key); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// Graph error codes of each category, for the errors whose HTTP status is
+// missing or less specific, e.g. a 400 for a malformed item ID
+var categoryErrorCodes = map[ErrorCategory][]string{
+	ErrorCategoryAuth:       {"InvalidAuthenticationToken", "Authorization_IdentityNotFound", "unauthenticated", tenantErrorCode},
+	ErrorCategoryPermission: {"Authorization_RequestDenied", "ErrorAccessDenied", "accessDenied", "Forbidden"},
+	ErrorCategoryNotFound:   {"itemNotFound", "ErrorItemNotFound", "ResourceNotFound", "Request_ResourceNotFound", "NotFound", "MailboxNotEnabledForRESTAPI", "ErrorInvalidUser"},
+	ErrorCategoryThrottled:  {"TooManyRequests", "activityLimitReached", "ApplicationThrottled", "ErrorServerBusy"},
+	ErrorCategoryTransient:  {"serviceNotAvailable", "generalException", "ErrorInternalServerTransientError", "ErrorTimeoutExpired"},
+}
+
+// getErrorCategory classifies a Graph error by its codes, or by its HTTP status
+// if the codes are unknown.
+func getErrorCategory(status int, codes ...string) ErrorCategory {
+	for _, code := range codes {
+		if code == "" {
+			continue
+		}
+		for category, categoryCodes := range categoryErrorCodes {
+			if slices.ContainsFunc(categoryCodes, func(c string) bool { return strings.EqualFold(c, code) }) {
+				return category
+			}
+		}
+	}
+	return getStatusCategory(status)
+}
+
+// getStatusCategory classifies an HTTP status. Graph sends 503 when a
+// workload is too busy, so it counts as throttling.
+func getStatusCategory(status int) ErrorCategory {
+	switch {
+	case status == http.StatusUnauthorized:
+		return ErrorCategoryAuth
+	case status == http.StatusForbidden:
+		return ErrorCategoryPermission
+	case status == http.StatusNotFound:
+		return ErrorCategoryNotFound
+	case status == http.StatusTooManyRequests, status == http.StatusServiceUnavailable:
+		return ErrorCategoryThrottled
+	case status == http.StatusInternalServerError, status == http.StatusBadGateway, status == http.StatusGatewayTimeout:
+		return ErrorCategoryTransient
+	case status >= 400 && status < 500:
+		return ErrorCategoryBadRequest
+	}
+	return ErrorCategoryUnknown
+}
+
+// getNetworkErrorCategory returns transient for the network errors of a
+// request that got no response, e.g. a timeout or a reset connection.
+func getNetworkErrorCategory(err error) ErrorCategory {
+	// A cancelled query isn't retried
+	if errors.Is(err, context.Canceled) {
+		return ErrorCategoryUnknown
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) || errors.Is(err, context.DeadlineExceeded) {
+		return ErrorCategoryTransient
+	}
+	// The transport returns an EOF when the connection closes before the
+	// response
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return ErrorCategoryTransient
+	}
+	return ErrorCategoryUnknown
+}
+
+// isIgnorableErrorPredicate ignores the errors of the categories, e.g. not
+// found errors of rows that were deleted during the query, and those with one
+// of the codes.
+func isIgnorableErrorPredicate(categories []ErrorCategory, codes ...string) plugin.ErrorPredicateWithContext {
 	return func(ctx context.Context, d *plugin.QueryData, h *plugin.HydrateData, err error) bool {
-		if err != nil {
-			if terr, ok := err.(*RequestError); ok {
-				for _, item := range ignoreErrorCodes {
-					if terr != nil && (terr.Code == item || strings.Contains(terr.Message, item)) {
-						return true
-					}
-				}
+		if shouldIgnoreTenantError(ctx, err) {
+			return true
+		}
+		if err == nil {
+			return false
+		}
+
+		requestErr := getErrorObject(err)
+		if slices.Contains(categories, requestErr.Category) {
+			return true
+		}
+		for _, code := range codes {
+			if requestErr.Code == code || requestErr.InnerCode == code {
+				return true
 			}
 		}
 		return false
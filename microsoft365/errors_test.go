@@ -0,0 +1,124 @@
+package microsoft365
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	abstractions "github.com/microsoft/kiota-abstractions-go"
+	"github.com/microsoftgraph/msgraph-sdk-go/models/odataerrors"
+)
+
+func testODataError(status int, code string) *odataerrors.ODataError {
+	odataErr := odataerrors.NewODataError()
+	odataErr.ResponseStatusCode = status
+	if code != "" {
+		main := odataerrors.NewMainError()
+		main.SetCode(StringPtr(code))
+		main.SetMessage(StringPtr(code + " message"))
+		odataErr.SetErrorEscaped(main)
+	}
+	return odataErr
+}
+
+func TestGetErrorObject(t *testing.T) {
+	// Exchange nests its code and the request IDs in the innerError
+	inner := odataerrors.NewInnerError()
+	inner.SetRequestId(StringPtr("request-1"))
+	inner.SetClientRequestId(StringPtr("client-1"))
+	inner.SetAdditionalData(map[string]any{"code": StringPtr("ErrorInvalidUser")})
+	notFound := testODataError(http.StatusNotFound, "ResourceNotFound")
+	notFound.GetErrorEscaped().SetInnerError(inner)
+
+	throttled := testODataError(http.StatusTooManyRequests, "TooManyRequests")
+	headers := abstractions.NewResponseHeaders()
+	headers.Add("request-id", "request-2")
+	headers.Add("Retry-After", "30")
+	throttled.SetResponseHeaders(headers)
+
+	tests := []struct {
+		name string
+		err  error
+		want RequestError
+	}{
+		{
+			name: "inner error",
+			err:  notFound,
+			want: RequestError{Code: "ResourceNotFound", Message: "ResourceNotFound message", InnerCode: "ErrorInvalidUser", StatusCode: 404, Category: ErrorCategoryNotFound, RequestID: "request-1", ClientRequestID: "client-1"},
+		},
+		{
+			name: "throttled",
+			err:  throttled,
+			want: RequestError{Code: "TooManyRequests", Message: "TooManyRequests message", StatusCode: 429, Category: ErrorCategoryThrottled, RequestID: "request-2", RetryAfter: 30 * time.Second},
+		},
+		{
+			name: "no error body",
+			err:  testODataError(http.StatusBadGateway, ""),
+			want: RequestError{Message: "Bad Gateway", StatusCode: 502, Category: ErrorCategoryTransient},
+		},
+		{
+			name: "code without a specific status",
+			err:  testODataError(http.StatusBadRequest, "ErrorAccessDenied"),
+			want: RequestError{Code: "ErrorAccessDenied", Message: "ErrorAccessDenied message", StatusCode: 400, Category: ErrorCategoryPermission},
+		},
+		{
+			name: "tenant",
+			err:  &TenantError{TenantID: "tenant-a", Err: errors.New("AADSTS7000215")},
+			want: RequestError{Code: tenantErrorCode, Message: "tenant tenant-a: AADSTS7000215", Category: ErrorCategoryAuth},
+		},
+		{
+			name: "network",
+			err:  &net.OpError{Op: "read", Err: errors.New("connection reset by peer")},
+			want: RequestError{Message: "read: connection reset by peer", Category: ErrorCategoryTransient},
+		},
+		{
+			name: "cancelled",
+			err:  context.Canceled,
+			want: RequestError{Message: "context canceled", Category: ErrorCategoryUnknown},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := getErrorObject(tt.err)
+			if !errors.Is(got, tt.err) {
+				t.Errorf("getErrorObject() doesn't wrap %v", tt.err)
+			}
+			got.Err = nil
+			if *got != tt.want {
+				t.Errorf("getErrorObject() = %+v, want %+v", *got, tt.want)
+			}
+		})
+	}
+
+	// Microsoft support traces errors by their request IDs
+	if message := getErrorObject(notFound).Error(); !strings.Contains(message, "request-1") || !strings.Contains(message, "client-1") {
+		t.Errorf("Error() = %s, want the request IDs", message)
+	}
+}
+
+func TestIsIgnorableErrorPredicate(t *testing.T) {
+	ctx := testContext()
+	predicate := isIgnorableErrorPredicate([]ErrorCategory{ErrorCategoryNotFound}, "UnsupportedQueryOption")
+
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{testODataError(http.StatusNotFound, "itemNotFound"), true},
+		{getErrorObject(testODataError(http.StatusNotFound, "ErrorItemNotFound")), true},
+		{testODataError(http.StatusBadRequest, "UnsupportedQueryOption"), true},
+		{testODataError(http.StatusBadRequest, "BadRequest"), false},
+		{testODataError(http.StatusForbidden, "Authorization_RequestDenied"), false},
+		{nil, false},
+	}
+	for _, tt := range tests {
+		if got := predicate(ctx, nil, nil, tt.err); got != tt.want {
+			t.Errorf("isIgnorableErrorPredicate()(%v) = %t, want %t", tt.err, got, tt.want)
+		}
+	}
+}
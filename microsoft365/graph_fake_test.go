@@ -0,0 +1,557 @@
+package microsoft365
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+	"unsafe"
+
+	"github.com/turbot/steampipe-plugin-sdk/v5/connection"
+	"github.com/turbot/steampipe-plugin-sdk/v5/grpc/proto"
+	"github.com/turbot/steampipe-plugin-sdk/v5/plugin"
+	"github.com/turbot/steampipe-plugin-sdk/v5/plugin/context_key"
+	"github.com/turbot/steampipe-plugin-sdk/v5/plugin/quals"
+	"github.com/turbot/steampipe-plugin-sdk/v5/plugin/transform"
+)
+
+// fakeGraphDir holds the fixtures of the fake Graph. The path of a fixture
+// mirrors the request path under /v1.0, e.g. users/{id}/messages.json or
+// users/delta.json for users/delta().
+const fakeGraphDir = "testdata/graph"
+
+// fakeGraphTenantID is the tenant of the fake's connection and access token
+const fakeGraphTenantID = "00000000-0000-0000-0000-000000000000"
+
+// fakeGraphRoles are the application permissions of the fake's access token.
+// Sites.Read.All is left out, for the connection diagnostics.
+var fakeGraphRoles = []string{"User.Read.All", "Group.Read.All", "Mail.Read", "MailboxSettings.Read", "Calendars.Read", "Contacts.Read", "Files.Read.All", "OrgContact.Read.All", "AuditLog.Read.All", "Organization.Read.All", "TeamMember.Read.All"}
+
+// fakeGraphUserID is the user of the fixtures with a mailbox, calendar and
+// drive, and the user_id of the fake's connection
+const fakeGraphUserID = "87d349ed-44d7-43e1-9a83-5f2406dee5bd"
+
+// fakeGraph is an in-process stand-in for Microsoft Graph that serves the
+// fixtures in testdata/graph:
+//   - a fixture with a value array is a collection, served in pages linked by
+//     @odata.nextLink, the last page of a delta query ending with an
+//     @odata.deltaLink
+//   - any other fixture is a single object
+//   - a request for an item of a collection fixture, e.g. users/{id}, is
+//     served the item with that ID
+//   - $batch requests are answered from the same fixtures
+//
+// It also serves managed identity tokens, so a connection reaches it through
+// its regular auth and transport settings; see config.
+type fakeGraph struct {
+	server *httptest.Server
+	// pageSize is the number of items in each page of a collection
+	pageSize int
+	// caBundlePath is the PEM file of the server's certificate
+	caBundlePath string
+	// token is the app-only access token the fake issues and accepts
+	token string
+	// configure, if set, changes the connection config of the queries
+	configure func(*microsoft365Config)
+	// fail maps paths, e.g. /users/{id}, to the status of the error the fake
+	// answers their requests with, e.g. 403 for a missing permission
+	fail map[string]int
+	// failSelect maps properties, e.g. signInActivity, to the status of the
+	// error the fake answers requests that $select them with, like Graph does
+	// without the property's permission or license
+	failSelect map[string]int
+
+	// connectionName and cache are those of the connection the queries run
+	// on, shared like in a plugin
+	connectionName string
+	cache          *connection.ConnectionCache
+
+	mu       sync.Mutex
+	requests []*url.URL
+	// batched are the paths of the requests received in $batch requests
+	batched []string
+}
+
+var fakeGraphConnections atomic.Int64
+
+func newFakeGraph(t *testing.T) *fakeGraph {
+	t.Helper()
+
+	f := &fakeGraph{pageSize: 2}
+	f.token = testAccessToken(t, map[string]interface{}{
+		"aud":   "https://graph.microsoft.com",
+		"tid":   fakeGraphTenantID,
+		"appid": "11111111-1111-1111-1111-111111111111",
+		"idtyp": "app",
+		"roles": fakeGraphRoles,
+		"exp":   time.Now().Add(time.Hour).Unix(),
+	})
+	f.server = httptest.NewTLSServer(f)
+	t.Cleanup(f.server.Close)
+
+	// The certificate is trusted through ca_bundle_path, like a TLS
+	// inspecting proxy
+	f.caBundlePath = filepath.Join(t.TempDir(), "fake-graph.pem")
+	certificate := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: f.server.Certificate().Raw})
+	if err := os.WriteFile(f.caBundlePath, certificate, 0600); err != nil {
+		t.Fatalf("error writing the CA bundle: %v", err)
+	}
+
+	// Delta tables save their deltaLinks in the install directory
+	t.Setenv("STEAMPIPE_INSTALL_DIR", t.TempDir())
+
+	// Each fake gets a connection of its own, so clients and memoized
+	// hydrates aren't shared between tests
+	f.connectionName = fmt.Sprintf("fake_graph_%d", fakeGraphConnections.Add(1))
+	cache, err := connection.NewConnectionCache(f.connectionName, 1<<20)
+	if err != nil {
+		t.Fatalf("NewConnectionCache() error = %v", err)
+	}
+	f.cache = cache
+
+	return f
+}
+
+// config returns a connection config that queries the fake Graph, with a
+// user_id for the microsoft365_my_* tables.
+func (f *fakeGraph) config() microsoft365Config {
+	config := microsoft365Config{
+		TenantID:      StringPtr(fakeGraphTenantID),
+		AuthMethod:    StringPtr(AuthMethodMSI),
+		MSIEndpoint:   StringPtr(f.server.URL + "/msi/token"),
+		GraphEndpoint: StringPtr(f.server.URL),
+		CABundlePath:  StringPtr(f.caBundlePath),
+		UserID:        StringPtr(fakeGraphUserID),
+	}
+	if f.configure != nil {
+		f.configure(&config)
+	}
+	return config
+}
+
+// requested returns the queries of the requests for the path, e.g. /users or
+// /users/delta(), in the order they were received.
+func (f *fakeGraph) requested(requestPath string) []url.Values {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var queries []url.Values
+	for _, u := range f.requests {
+		if u.Path == "/v1.0"+requestPath {
+			queries = append(queries, u.Query())
+		}
+	}
+	return queries
+}
+
+func (f *fakeGraph) record(u *url.URL, batched bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.requests = append(f.requests, u)
+	if batched {
+		f.batched = append(f.batched, strings.TrimPrefix(u.Path, "/v1.0"))
+	}
+}
+
+// wasBatched reports whether a request for the path was sent in a $batch
+// request.
+func (f *fakeGraph) wasBatched(requestPath string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return slices.Contains(f.batched, requestPath)
+}
+
+func (f *fakeGraph) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.URL.Path == "/msi/token":
+		writeFakeGraphJSON(w, http.StatusOK, map[string]string{"access_token": f.token, "expires_in": "3600"})
+	case r.Header.Get("Authorization") != "Bearer "+f.token:
+		writeFakeGraphJSON(w, http.StatusUnauthorized, fakeGraphError("InvalidAuthenticationToken", "Access token is empty."))
+	case r.URL.Path == "/v1.0/$batch" && r.Method == http.MethodPost:
+		f.serveBatch(w, r)
+	case r.Method == http.MethodGet:
+		f.record(r.URL, false)
+		status, body := f.respond(r.URL)
+		writeFakeGraphJSON(w, status, body)
+	default:
+		writeFakeGraphJSON(w, http.StatusMethodNotAllowed, fakeGraphError("BadRequest", "Unsupported method "+r.Method))
+	}
+}
+
+func (f *fakeGraph) serveBatch(w http.ResponseWriter, r *http.Request) {
+	// The Graph client compresses request bodies
+	body := io.Reader(r.Body)
+	if r.Header.Get("Content-Encoding") == "gzip" {
+		reader, err := gzip.NewReader(r.Body)
+		if err != nil {
+			writeFakeGraphJSON(w, http.StatusBadRequest, fakeGraphError("BadRequest", err.Error()))
+			return
+		}
+		body = reader
+	}
+
+	var batch struct {
+		Requests []testBatchRequest `json:"requests"`
+	}
+	if err := json.NewDecoder(body).Decode(&batch); err != nil {
+		writeFakeGraphJSON(w, http.StatusBadRequest, fakeGraphError("BadRequest", err.Error()))
+		return
+	}
+
+	responses := []testBatchResponse{}
+	for _, request := range batch.Requests {
+		u, err := url.Parse("/v1.0" + request.URL)
+		if err != nil {
+			responses = append(responses, testBatchResponse{ID: request.ID, Status: http.StatusBadRequest, Body: fakeGraphError("BadRequest", err.Error())})
+			continue
+		}
+		f.record(u, true)
+		status, body := f.respond(u)
+		responses = append(responses, testBatchResponse{ID: request.ID, Status: status, Headers: map[string]string{"Content-Type": "application/json"}, Body: body})
+	}
+	writeFakeGraphJSON(w, http.StatusOK, map[string]interface{}{"responses": responses})
+}
+
+// respond returns the status and body of the response to a GET request.
+func (f *fakeGraph) respond(u *url.URL) (int, interface{}) {
+	// The fixture of a function, e.g. users/delta(), is named without the
+	// parentheses
+	if status, ok := f.fail[strings.TrimPrefix(u.Path, "/v1.0")]; ok {
+		return status, fakeGraphError(fakeGraphStatusCodes[status], http.StatusText(status))
+	}
+	for _, property := range strings.Split(u.Query().Get("$select"), ",") {
+		if status, ok := f.failSelect[property]; ok {
+			return status, fakeGraphError(fakeGraphStatusCodes[status], http.StatusText(status))
+		}
+	}
+
+	resource := strings.Trim(strings.TrimPrefix(u.Path, "/v1.0"), "/")
+	resource = strings.ReplaceAll(resource, "()", "")
+
+	fixture, err := readFakeGraphFixture(resource)
+	if errors.Is(err, fs.ErrNotExist) {
+		// An item of a collection
+		return f.getItem(resource)
+	}
+	if err != nil {
+		return http.StatusInternalServerError, fakeGraphError("generalException", err.Error())
+	}
+
+	items, ok := fixture["value"].([]interface{})
+	if !ok {
+		return http.StatusOK, fixture
+	}
+	return http.StatusOK, f.page(u, items)
+}
+
+func (f *fakeGraph) getItem(resource string) (int, interface{}) {
+	parent, id := path.Split(resource)
+	fixture, err := readFakeGraphFixture(strings.TrimSuffix(parent, "/"))
+	if err == nil {
+		items, _ := fixture["value"].([]interface{})
+		for _, item := range items {
+			if item, ok := item.(map[string]interface{}); ok && item["id"] == id {
+				return http.StatusOK, item
+			}
+		}
+	}
+	return http.StatusNotFound, fakeGraphError("itemNotFound", fmt.Sprintf("The resource %s was not found.", resource))
+}
+
+// page returns the page of the collection at the $skiptoken of the request,
+// linked to the next page or, at the end of a delta query, to its deltaLink.
+func (f *fakeGraph) page(u *url.URL, items []interface{}) map[string]interface{} {
+	query := u.Query()
+	start, _ := strconv.Atoi(query.Get("$skiptoken"))
+	start = min(start, len(items))
+	end := min(start+f.pageSize, len(items))
+
+	page := map[string]interface{}{"value": items[start:end]}
+	link := *f.serverURL()
+	link.Path = u.Path
+	switch {
+	case end < len(items):
+		query.Set("$skiptoken", strconv.Itoa(end))
+		link.RawQuery = query.Encode()
+		page["@odata.nextLink"] = link.String()
+	case path.Base(u.Path) == "delta()":
+		link.RawQuery = url.Values{"$deltatoken": {"fake-delta-token"}}.Encode()
+		page["@odata.deltaLink"] = link.String()
+	}
+	return page
+}
+
+func (f *fakeGraph) serverURL() *url.URL {
+	u, _ := url.Parse(f.server.URL)
+	return u
+}
+
+func readFakeGraphFixture(resource string) (map[string]interface{}, error) {
+	data, err := os.ReadFile(filepath.Join(fakeGraphDir, filepath.FromSlash(resource)+".json"))
+	if err != nil {
+		return nil, err
+	}
+	var fixture map[string]interface{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&fixture); err != nil {
+		return nil, fmt.Errorf("error parsing fixture %s: %v", resource, err)
+	}
+	return fixture, nil
+}
+
+// fakeGraphStatusCodes are the Graph error codes of the statuses of fail
+var fakeGraphStatusCodes = map[int]string{
+	http.StatusForbidden:           "Authorization_RequestDenied",
+	http.StatusInternalServerError: "generalException",
+}
+
+func fakeGraphError(code, message string) map[string]interface{} {
+	return map[string]interface{}{"error": map[string]string{"code": code, "message": message}}
+}
+
+func writeFakeGraphJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+// testQuery is a query of a table, run by the fake Graph's list and get.
+type testQuery struct {
+	Table string
+	// Columns are the queried columns, every column of the table by default
+	Columns []string
+	Quals   []*quals.Qual
+	Limit   int64
+}
+
+// list runs the list hydrates of the query's table against the fake Graph,
+// for each tenant of its matrix, then the column hydrates and transforms of
+// each row, the way Steampipe runs a query, and returns the rows.
+func (f *fakeGraph) list(t *testing.T, q testQuery) []map[string]*proto.Column {
+	t.Helper()
+
+	d := f.queryData(t, q)
+	list := d.Table.List
+
+	matrix := d.Table.GetMatrixItemFunc(testContext(), d)
+	if len(matrix) == 0 {
+		matrix = []map[string]interface{}{nil}
+	}
+
+	var rows []map[string]*proto.Column
+	for _, matrixItem := range matrix {
+		ctx := testContext()
+		if matrixItem != nil {
+			ctx = context.WithValue(ctx, context_key.MatrixItem, matrixItem)
+		}
+
+		parents := []interface{}{nil}
+		if list.ParentHydrate != nil {
+			parents = f.stream(t, d, func() (interface{}, error) { return list.ParentHydrate(ctx, d, &plugin.HydrateData{}) })
+		}
+
+		for _, parent := range parents {
+			items := f.stream(t, d, func() (interface{}, error) { return list.Hydrate(ctx, d, &plugin.HydrateData{Item: parent}) })
+			for _, item := range items {
+				rows = append(rows, f.row(ctx, t, d, item, parent))
+			}
+		}
+	}
+	return rows
+}
+
+// get runs the get hydrate of the query's table against the fake Graph and
+// returns its row, or nil if it found no item.
+func (f *fakeGraph) get(t *testing.T, q testQuery) map[string]*proto.Column {
+	t.Helper()
+
+	d := f.queryData(t, q)
+	item, err := d.Table.Get.Hydrate(testContext(), d, &plugin.HydrateData{})
+	if err != nil {
+		t.Fatalf("%s get error = %v", q.Table, err)
+	}
+	if testIsNil(item) {
+		return nil
+	}
+	return f.row(testContext(), t, d, item, nil)
+}
+
+// getError runs the get hydrate of the query's table and returns its error.
+func (f *fakeGraph) getError(t *testing.T, q testQuery) error {
+	t.Helper()
+
+	d := f.queryData(t, q)
+	_, err := d.Table.Get.Hydrate(testContext(), d, &plugin.HydrateData{})
+	return err
+}
+
+// listError runs the list hydrate of the query's table and returns its error.
+func (f *fakeGraph) listError(t *testing.T, q testQuery) error {
+	t.Helper()
+
+	d := f.queryData(t, q)
+	d.StreamListItem = func(context.Context, ...interface{}) {}
+	_, err := d.Table.List.Hydrate(testContext(), d, &plugin.HydrateData{})
+	return err
+}
+
+// stream collects the items a list hydrate streams, stopping at the query's
+// limit.
+func (f *fakeGraph) stream(t *testing.T, d *plugin.QueryData, hydrate func() (interface{}, error)) []interface{} {
+	t.Helper()
+
+	var items []interface{}
+	d.StreamListItem = func(_ context.Context, streamed ...interface{}) {
+		items = append(items, streamed...)
+		testRowsStreamed(d).SetInt(testRowsStreamed(d).Int() + int64(len(streamed)))
+	}
+	if _, err := hydrate(); err != nil {
+		t.Fatalf("%s list error = %v", d.Table.Name, err)
+	}
+	return items
+}
+
+// row runs the column hydrates of an item, each once, and converts the
+// transformed values of the queried columns.
+func (f *fakeGraph) row(ctx context.Context, t *testing.T, d *plugin.QueryData, item, parent interface{}) map[string]*proto.Column {
+	t.Helper()
+
+	columns := map[string]*plugin.Column{}
+	for _, column := range d.Table.Columns {
+		columns[column.Name] = column
+	}
+
+	results := map[string]interface{}{}
+	row := map[string]*proto.Column{}
+	for _, name := range d.QueryContext.Columns {
+		column := columns[name]
+
+		hydrateItem := item
+		if column.Hydrate != nil {
+			hydrateName := runtime.FuncForPC(reflect.ValueOf(column.Hydrate).Pointer()).Name()
+			result, ok := results[hydrateName]
+			if !ok {
+				var err error
+				result, err = column.Hydrate(ctx, d, &plugin.HydrateData{Item: item, ParentItem: parent, HydrateResults: results})
+				if err != nil {
+					t.Fatalf("%s hydrate %s error = %v", d.Table.Name, hydrateName, err)
+				}
+				results[hydrateName] = result
+			}
+			hydrateItem = result
+		}
+
+		var value interface{}
+		if !testIsNil(hydrateItem) {
+			transforms := column.Transform
+			if transforms == nil {
+				transforms = transform.FromGo()
+			}
+			var err error
+			value, err = transforms.Execute(ctx, &transform.TransformData{
+				HydrateItem:    hydrateItem,
+				HydrateResults: results,
+				ColumnName:     name,
+				KeyColumnQuals: d.Quals.ToQualMap(),
+			})
+			if err != nil {
+				t.Fatalf("%s column %s transform error = %v", d.Table.Name, name, err)
+			}
+		}
+		columnValue, err := column.ToColumnValue(value)
+		if err != nil {
+			t.Fatalf("%s column %s error = %v", d.Table.Name, name, err)
+		}
+		row[name] = columnValue
+	}
+	return row
+}
+
+// queryData builds the QueryData of a query on the fake's connection.
+func (f *fakeGraph) queryData(t *testing.T, q testQuery) *plugin.QueryData {
+	t.Helper()
+
+	p := Plugin(testContext())
+	table := p.TableMap[q.Table]
+	if table == nil {
+		t.Fatalf("unknown table %s", q.Table)
+	}
+	table.Plugin = p
+
+	columns := q.Columns
+	if len(columns) == 0 {
+		for _, column := range table.Columns {
+			columns = append(columns, column.Name)
+		}
+	}
+	var limit *int64
+	if q.Limit > 0 {
+		limit = &q.Limit
+	}
+
+	d := &plugin.QueryData{
+		Table:             table,
+		Connection:        &plugin.Connection{Name: f.connectionName, Config: f.config()},
+		QueryContext:      &plugin.QueryContext{Columns: columns, Limit: limit},
+		EqualsQuals:       map[string]*proto.QualValue{},
+		Quals:             testQualMap(q.Quals...),
+		ConnectionCache:   f.cache,
+		ConnectionManager: connection.NewManager(f.cache),
+	}
+	for _, qual := range q.Quals {
+		if qual.Operator == quals.QualOperatorEqual {
+			d.EqualsQuals[qual.Column] = qual.Value
+		}
+	}
+
+	// The SDK sets the query status when it builds the QueryData; the list
+	// hydrates read the rows remaining from it
+	status := testUnexportedField(reflect.ValueOf(d).Elem(), "queryStatus")
+	status.Set(reflect.New(status.Type().Elem()))
+	rowsRequired := int64(math.MaxInt32)
+	if limit != nil {
+		rowsRequired = *limit
+	}
+	testUnexportedField(status.Elem(), "rowsRequired").SetInt(rowsRequired)
+
+	return d
+}
+
+func testRowsStreamed(d *plugin.QueryData) reflect.Value {
+	return testUnexportedField(testUnexportedField(reflect.ValueOf(d).Elem(), "queryStatus").Elem(), "rowsStreamed")
+}
+
+func testUnexportedField(v reflect.Value, name string) reflect.Value {
+	field := v.FieldByName(name)
+	return reflect.NewAt(field.Type(), unsafe.Pointer(field.UnsafeAddr())).Elem()
+}
+
+func testIsNil(v interface{}) bool {
+	if v == nil {
+		return true
+	}
+	value := reflect.ValueOf(v)
+	return value.Kind() == reflect.Pointer && value.IsNil()
+}
@@ -0,0 +1,374 @@
+package microsoft365
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	abstractions "github.com/microsoft/kiota-abstractions-go"
+	"github.com/turbot/steampipe-plugin-sdk/v5/grpc/proto"
+	"github.com/turbot/steampipe-plugin-sdk/v5/plugin"
+	"github.com/turbot/steampipe-plugin-sdk/v5/plugin/quals"
+)
+
+// The types a filterable Graph property can have
+const (
+	filterTypeString     = "string"
+	filterTypeBool       = "bool"
+	filterTypeInt        = "int"
+	filterTypeDateTime   = "datetime"
+	filterTypeCollection = "collection"
+)
+
+// The SQL operators each endpoint can filter properties on. Graph rejects a
+// $filter with an operator the endpoint doesn't support, rather than
+// ignoring it, so a column only lists the operators its endpoint supports.
+// https://learn.microsoft.com/en-us/graph/filter-query-parameter
+var (
+	// Directory objects support eq, in and startsWith without advanced
+	// queries; ne, endsWith and null checks need ConsistencyLevel eventual
+	directoryStringOperators         = []string{quals.QualOperatorEqual, quals.QualOperatorLike}
+	directoryAdvancedStringOperators = []string{quals.QualOperatorNotEqual, quals.QualOperatorLike, quals.QualOperatorIsNull, quals.QualOperatorIsNotNull}
+	// Exchange supports the comparison operators and startswith on most
+	// message properties
+	exchangeStringOperators = []string{quals.QualOperatorEqual, quals.QualOperatorNotEqual, quals.QualOperatorLike}
+	// Any endpoint that filters a boolean on eq also filters on <>, sent as eq
+	// with the negated value
+	boolOperators = []string{quals.QualOperatorEqual, quals.QualOperatorNotEqual}
+	// Endpoints such as drives and sites only support eq
+	equalOperators = []string{quals.QualOperatorEqual}
+	// Timestamps are filtered on ranges
+	rangeOperators = []string{quals.QualOperatorGreater, quals.QualOperatorGreaterOrEqual, quals.QualOperatorLess, quals.QualOperatorLessOrEqual}
+)
+
+var odataComparisons = map[string]string{
+	quals.QualOperatorEqual:          "eq",
+	quals.QualOperatorNotEqual:       "ne",
+	quals.QualOperatorLess:           "lt",
+	quals.QualOperatorLessOrEqual:    "le",
+	quals.QualOperatorGreater:        "gt",
+	quals.QualOperatorGreaterOrEqual: "ge",
+}
+
+// odataFilterColumn describes how Graph filters on the property behind a
+// column.
+type odataFilterColumn struct {
+	// Property is the Graph property, e.g. userPrincipalName
+	Property string
+	Type     string
+	// Operators are the SQL operators the endpoint can filter the property on
+	Operators []string
+	// AdvancedOperators are the SQL operators the endpoint can only filter
+	// the property on in an advanced query. LIKE '%suffix' is only sent, as
+	// endsWith, if they include LIKE.
+	// https://learn.microsoft.com/en-us/graph/aad-advanced-queries
+	AdvancedOperators []string
+}
+
+// odataFilterColumns maps the filterable columns of a table to the Graph
+// properties behind them.
+type odataFilterColumns map[string]odataFilterColumn
+
+// keyColumns returns an optional key column for each filterable column, so
+// Steampipe passes the quals the endpoint supports to the list function.
+func (c odataFilterColumns) keyColumns() plugin.KeyColumnSlice {
+	names := make([]string, 0, len(c))
+	for name := range c {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	keyColumns := make(plugin.KeyColumnSlice, 0, len(names))
+	for _, name := range names {
+		operators := append([]string{}, c[name].Operators...)
+		for _, op := range c[name].AdvancedOperators {
+			if !isSupportedOperator(operators, op) {
+				operators = append(operators, op)
+			}
+		}
+		keyColumns = append(keyColumns, &plugin.KeyColumn{Name: name, Require: plugin.Optional, Operators: operators})
+	}
+	return keyColumns
+}
+
+// buildFilter translates the quals into $filter clauses, to be joined with
+// "and". Quals that can't be expressed in OData, e.g. LIKE '%x%', are left
+// out and filtered by Steampipe instead.
+func (c odataFilterColumns) buildFilter(qualMap plugin.KeyColumnQualMap) []string {
+	filters, _ := c.buildAdvancedFilter(qualMap)
+	return filters
+}
+
+// buildAdvancedFilter is buildFilter for endpoints that support advanced
+// queries. It also translates the quals that need one, and returns whether
+// any did.
+func (c odataFilterColumns) buildAdvancedFilter(qualMap plugin.KeyColumnQualMap) ([]string, bool) {
+	filters := []string{}
+	advanced := false
+
+	// Build the clauses in a stable order, so the same query sends the same
+	// request
+	names := make([]string, 0, len(c))
+	for name := range c {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if qualMap[name] == nil {
+			continue
+		}
+		column := c[name]
+		for _, q := range qualMap[name].Quals {
+			if filter, needsAdvanced, ok := column.translate(q); ok {
+				filters = append(filters, filter)
+				advanced = advanced || needsAdvanced
+			}
+		}
+	}
+	return filters, advanced
+}
+
+func isSupportedOperator(operators []string, operator string) bool {
+	for _, op := range operators {
+		if op == operator {
+			return true
+		}
+	}
+	return false
+}
+
+// translate returns the $filter clause for the qual and whether it needs an
+// advanced query, or false if the endpoint can't filter on it.
+func (column odataFilterColumn) translate(q *quals.Qual) (string, bool, bool) {
+	basic := isSupportedOperator(column.Operators, q.Operator)
+	advanced := isSupportedOperator(column.AdvancedOperators, q.Operator)
+	if !basic && !advanced {
+		return "", false, false
+	}
+
+	if q.Operator == quals.QualOperatorLike {
+		if column.Type != filterTypeString {
+			return "", false, false
+		}
+		text, prefix, ok := likePattern(q.Value.GetStringValue())
+		switch {
+		case !ok:
+			return "", false, false
+		case prefix:
+			return fmt.Sprintf("startswith(%s,%s)", column.Property, odataString(text)), !basic, true
+		case advanced:
+			return fmt.Sprintf("endswith(%s,%s)", column.Property, odataString(text)), true, true
+		}
+		return "", false, false
+	}
+
+	filter, ok := column.translateOperator(q)
+	return filter, !basic, ok
+}
+
+// translateOperator returns the $filter clause for a qual on any operator
+// other than LIKE.
+func (column odataFilterColumn) translateOperator(q *quals.Qual) (string, bool) {
+	switch q.Operator {
+	case quals.QualOperatorIsNull:
+		return fmt.Sprintf("%s eq null", column.Property), true
+	case quals.QualOperatorIsNotNull:
+		return fmt.Sprintf("%s ne null", column.Property), true
+	}
+
+	comparison, ok := odataComparisons[q.Operator]
+	if !ok {
+		return "", false
+	}
+
+	// IN (...) arrives as a list value, which is sent as eq clauses joined by
+	// or, since not every endpoint supports the in operator
+	if list := q.Value.GetListValue(); list != nil {
+		if q.Operator != quals.QualOperatorEqual || len(list.Values) == 0 {
+			return "", false
+		}
+		clauses := make([]string, 0, len(list.Values))
+		for _, value := range list.Values {
+			clause, ok := column.compare("eq", value)
+			if !ok {
+				return "", false
+			}
+			clauses = append(clauses, clause)
+		}
+		if len(clauses) == 1 {
+			return clauses[0], true
+		}
+		return "(" + strings.Join(clauses, " or ") + ")", true
+	}
+
+	return column.compare(comparison, q.Value)
+}
+
+// compare returns the clause comparing the property with the value.
+func (column odataFilterColumn) compare(comparison string, value *proto.QualValue) (string, bool) {
+	switch column.Type {
+	case filterTypeString:
+		if _, ok := value.GetValue().(*proto.QualValue_StringValue); !ok {
+			return "", false
+		}
+		return fmt.Sprintf("%s %s %s", column.Property, comparison, odataString(value.GetStringValue())), true
+
+	case filterTypeBool:
+		if _, ok := value.GetValue().(*proto.QualValue_BoolValue); !ok {
+			return "", false
+		}
+		v := value.GetBoolValue()
+		switch comparison {
+		case "eq":
+		case "ne":
+			// Not every endpoint supports ne, so <> is sent as eq with the
+			// negated value
+			v = !v
+		default:
+			return "", false
+		}
+		return fmt.Sprintf("%s eq %t", column.Property, v), true
+
+	case filterTypeInt:
+		if _, ok := value.GetValue().(*proto.QualValue_Int64Value); !ok {
+			return "", false
+		}
+		return fmt.Sprintf("%s %s %s", column.Property, comparison, strconv.FormatInt(value.GetInt64Value(), 10)), true
+
+	case filterTypeDateTime:
+		if value.GetTimestampValue() == nil {
+			return "", false
+		}
+		t := value.GetTimestampValue().AsTime().UTC()
+		// Only whole seconds are sent, so a fractional second is rounded away
+		// from the range, and Steampipe drops the extra items Graph returns.
+		// An equality can't be widened that way, so it's left to Steampipe.
+		if whole := t.Truncate(time.Second); !whole.Equal(t) {
+			switch comparison {
+			case "gt", "ge":
+				t = whole
+			case "lt", "le":
+				t = whole.Add(time.Second)
+			default:
+				return "", false
+			}
+		}
+		return fmt.Sprintf("%s %s %s", column.Property, comparison, t.Format(time.RFC3339)), true
+
+	case filterTypeCollection:
+		// e.g. groupTypes/any(c:c eq 'Unified')
+		if comparison != "eq" {
+			return "", false
+		}
+		var items []string
+		switch v := value.GetValue().(type) {
+		case *proto.QualValue_StringValue:
+			items = []string{v.StringValue}
+		case *proto.QualValue_JsonbValue:
+			// A JSON column compares with an array, e.g. '["Unified"]'; Graph
+			// returns the collections that contain each item, and Steampipe
+			// drops those that have others too
+			if err := json.Unmarshal([]byte(v.JsonbValue), &items); err != nil || len(items) == 0 {
+				return "", false
+			}
+		default:
+			return "", false
+		}
+		clauses := make([]string, 0, len(items))
+		for _, item := range items {
+			clauses = append(clauses, fmt.Sprintf("%s/any(c:c eq %s)", column.Property, odataString(item)))
+		}
+		return strings.Join(clauses, " and "), true
+	}
+
+	return "", false
+}
+
+// odataString quotes the value as an OData string literal, doubling any
+// single quotes in it.
+func odataString(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", "''") + "'"
+}
+
+// likePattern returns the fixed text of a LIKE pattern of the form 'text%',
+// with true, or '%text', with false. It returns false for ok if the pattern
+// matches anything else, e.g. '%text%'.
+func likePattern(pattern string) (text string, prefix bool, ok bool) {
+	var literal strings.Builder
+	leading, trailing := false, false
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; c {
+		case '\\':
+			// An escaped wildcard is matched literally
+			if i+1 >= len(pattern) {
+				return "", false, false
+			}
+			i++
+			literal.WriteByte(pattern[i])
+		case '_':
+			return "", false, false
+		case '%':
+			switch {
+			case i == 0:
+				leading = true
+			case i == len(pattern)-1:
+				trailing = true
+			default:
+				return "", false, false
+			}
+		default:
+			literal.WriteByte(c)
+		}
+	}
+	if leading == trailing || literal.Len() == 0 {
+		return "", false, false
+	}
+	return literal.String(), trailing, true
+}
+
+// advancedFilterPattern matches the operators of a $filter, from the filter
+// column, that directory objects only support in advanced queries.
+var (
+	advancedFilterPattern = regexp.MustCompile(`(?i)\bendswith\(|\bne\b|\bnot\(|/\$count\b`)
+	odataStringPattern    = regexp.MustCompile(`'(?:[^']|'')*'`)
+)
+
+// isAdvancedFilter returns whether the $filter needs an advanced query.
+func isAdvancedFilter(filter string) bool {
+	// Operators in string literals, e.g. 'ne', don't count
+	return advancedFilterPattern.MatchString(odataStringPattern.ReplaceAllString(filter, "''"))
+}
+
+// advancedQueryHeaders returns the headers of an advanced directory query,
+// needed for $search, $count and the filters in directoryAdvancedStringOperators.
+func advancedQueryHeaders() *abstractions.RequestHeaders {
+	headers := abstractions.NewRequestHeaders()
+	headers.Add("ConsistencyLevel", "eventual")
+	return headers
+}
+
+// searchQuery returns the $search query for the search column. Graph requires
+// each clause to be quoted, e.g. "displayName:wa" OR "mail:wa", so a single
+// unquoted clause is quoted for the user.
+func searchQuery(search string) string {
+	search = strings.TrimSpace(search)
+	if strings.Contains(search, `"`) {
+		return search
+	}
+	return `"` + search + `"`
+}
+
+// mailSearchQuery returns the $search query for the query column of the mail
+// tables. Exchange takes the whole KQL query as one quoted string, e.g.
+// "from:alex AND subject:\"q1 report\"", so quotes in it are escaped.
+func mailSearchQuery(query string) string {
+	query = strings.TrimSpace(query)
+	query = strings.ReplaceAll(query, `\`, `\\`)
+	query = strings.ReplaceAll(query, `"`, `\"`)
+	return `"` + query + `"`
+}
@@ -0,0 +1,261 @@
+package microsoft365
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/turbot/steampipe-plugin-sdk/v5/grpc/proto"
+	"github.com/turbot/steampipe-plugin-sdk/v5/plugin"
+	"github.com/turbot/steampipe-plugin-sdk/v5/plugin/quals"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+var testFilterColumns = odataFilterColumns{
+	"display_name":       {Property: "displayName", Type: filterTypeString, Operators: []string{"=", "<>", "~~", "is null", "is not null"}},
+	"account_enabled":    {Property: "accountEnabled", Type: filterTypeBool, Operators: boolOperators},
+	"size":               {Property: "size", Type: filterTypeInt, Operators: []string{"=", "<", "<=", ">", ">="}},
+	"created_date_time":  {Property: "createdDateTime", Type: filterTypeDateTime, Operators: []string{">=", "<"}},
+	"modified_date_time": {Property: "lastModifiedDateTime", Type: filterTypeDateTime, Operators: []string{"=", "<>", "<", "<=", ">", ">="}},
+	"group_types":        {Property: "groupTypes", Type: filterTypeCollection, Operators: equalOperators},
+}
+
+func testQualMap(qs ...*quals.Qual) plugin.KeyColumnQualMap {
+	qualMap := plugin.KeyColumnQualMap{}
+	for _, q := range qs {
+		if qualMap[q.Column] == nil {
+			qualMap[q.Column] = &plugin.KeyColumnQuals{Name: q.Column}
+		}
+		qualMap[q.Column].Quals = append(qualMap[q.Column].Quals, q)
+	}
+	return qualMap
+}
+
+func stringQual(column, operator, value string) *quals.Qual {
+	return &quals.Qual{Column: column, Operator: operator, Value: &proto.QualValue{Value: &proto.QualValue_StringValue{StringValue: value}}}
+}
+
+func TestBuildFilter(t *testing.T) {
+	created := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	tests := []struct {
+		name  string
+		quals []*quals.Qual
+		want  []string
+	}{
+		{
+			name:  "equal escapes quotes",
+			quals: []*quals.Qual{stringQual("display_name", "=", "O'Brien")},
+			want:  []string{"displayName eq 'O''Brien'"},
+		},
+		{
+			name:  "not equal",
+			quals: []*quals.Qual{stringQual("display_name", "<>", "x")},
+			want:  []string{"displayName ne 'x'"},
+		},
+		{
+			name:  "like prefix",
+			quals: []*quals.Qual{stringQual("display_name", "~~", `100\%%`)},
+			want:  []string{"startswith(displayName,'100%')"},
+		},
+		{
+			name: "like with other wildcards is left to steampipe",
+			quals: []*quals.Qual{
+				stringQual("display_name", "~~", "%x"),
+				stringQual("display_name", "~~", "a_b%"),
+				stringQual("display_name", "~~", "a%b%"),
+				stringQual("display_name", "~~", "abc"),
+			},
+			want: []string{},
+		},
+		{
+			name: "in",
+			quals: []*quals.Qual{{Column: "display_name", Operator: "=", Value: &proto.QualValue{Value: &proto.QualValue_ListValue{ListValue: &proto.QualValueList{Values: []*proto.QualValue{
+				{Value: &proto.QualValue_StringValue{StringValue: "a"}},
+				{Value: &proto.QualValue_StringValue{StringValue: "b"}},
+			}}}}}},
+			want: []string{"(displayName eq 'a' or displayName eq 'b')"},
+		},
+		{
+			name: "null checks",
+			quals: []*quals.Qual{
+				{Column: "display_name", Operator: "is null"},
+				{Column: "display_name", Operator: "is not null"},
+			},
+			want: []string{"displayName eq null", "displayName ne null"},
+		},
+		{
+			name: "bool not equal is negated",
+			quals: []*quals.Qual{
+				{Column: "account_enabled", Operator: "<>", Value: &proto.QualValue{Value: &proto.QualValue_BoolValue{BoolValue: true}}},
+			},
+			want: []string{"accountEnabled eq false"},
+		},
+		{
+			name: "ranges",
+			quals: []*quals.Qual{
+				{Column: "size", Operator: ">", Value: &proto.QualValue{Value: &proto.QualValue_Int64Value{Int64Value: 10}}},
+				{Column: "created_date_time", Operator: ">=", Value: &proto.QualValue{Value: &proto.QualValue_TimestampValue{TimestampValue: timestamppb.New(created)}}},
+			},
+			want: []string{"createdDateTime ge 2024-01-02T03:04:05Z", "size gt 10"},
+		},
+		{
+			name: "fractional seconds are rounded away from the range",
+			quals: []*quals.Qual{
+				{Column: "created_date_time", Operator: ">=", Value: &proto.QualValue{Value: &proto.QualValue_TimestampValue{TimestampValue: timestamppb.New(created.Add(250 * time.Millisecond))}}},
+				{Column: "modified_date_time", Operator: "<=", Value: &proto.QualValue{Value: &proto.QualValue_TimestampValue{TimestampValue: timestamppb.New(created.Add(time.Microsecond))}}},
+			},
+			want: []string{"createdDateTime ge 2024-01-02T03:04:05Z", "lastModifiedDateTime le 2024-01-02T03:04:06Z"},
+		},
+		{
+			name: "fractional seconds of the other bounds",
+			quals: []*quals.Qual{
+				{Column: "modified_date_time", Operator: ">", Value: &proto.QualValue{Value: &proto.QualValue_TimestampValue{TimestampValue: timestamppb.New(created.Add(999 * time.Millisecond))}}},
+				{Column: "modified_date_time", Operator: "<", Value: &proto.QualValue{Value: &proto.QualValue_TimestampValue{TimestampValue: timestamppb.New(created.Add(time.Hour + time.Millisecond))}}},
+			},
+			want: []string{"lastModifiedDateTime gt 2024-01-02T03:04:05Z", "lastModifiedDateTime lt 2024-01-02T04:04:06Z"},
+		},
+		{
+			name: "equality with fractional seconds is left to steampipe",
+			quals: []*quals.Qual{
+				{Column: "modified_date_time", Operator: "=", Value: &proto.QualValue{Value: &proto.QualValue_TimestampValue{TimestampValue: timestamppb.New(created.Add(time.Millisecond))}}},
+			},
+			want: []string{},
+		},
+		{
+			name: "unsupported operator is left to steampipe",
+			quals: []*quals.Qual{
+				{Column: "created_date_time", Operator: "<=", Value: &proto.QualValue{Value: &proto.QualValue_TimestampValue{TimestampValue: timestamppb.New(created)}}},
+				stringQual("display_name", "~~*", "a%"),
+				stringQual("mail", "=", "a@example.com"),
+			},
+			want: []string{},
+		},
+		{
+			name: "collection",
+			quals: []*quals.Qual{
+				{Column: "group_types", Operator: "=", Value: &proto.QualValue{Value: &proto.QualValue_JsonbValue{JsonbValue: `["Unified","DynamicMembership"]`}}},
+			},
+			want: []string{"groupTypes/any(c:c eq 'Unified') and groupTypes/any(c:c eq 'DynamicMembership')"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := testFilterColumns.buildFilter(testQualMap(tt.quals...)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("buildFilter() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBuildAdvancedFilter(t *testing.T) {
+	columns := odataFilterColumns{
+		"mail":      {Property: "mail", Type: filterTypeString, Operators: directoryStringOperators, AdvancedOperators: directoryAdvancedStringOperators},
+		"user_type": {Property: "userType", Type: filterTypeString, Operators: equalOperators},
+	}
+
+	tests := []struct {
+		name         string
+		quals        []*quals.Qual
+		want         []string
+		wantAdvanced bool
+	}{
+		{
+			name:  "basic",
+			quals: []*quals.Qual{stringQual("mail", "~~", "admin@%"), stringQual("user_type", "=", "Guest")},
+			want:  []string{"startswith(mail,'admin@')", "userType eq 'Guest'"},
+		},
+		{
+			name:         "ends with",
+			quals:        []*quals.Qual{stringQual("mail", "~~", "%@contractor.com")},
+			want:         []string{"endswith(mail,'@contractor.com')"},
+			wantAdvanced: true,
+		},
+		{
+			name:         "not equal and null checks",
+			quals:        []*quals.Qual{stringQual("mail", "<>", "a@example.com"), {Column: "mail", Operator: "is null"}},
+			want:         []string{"mail ne 'a@example.com'", "mail eq null"},
+			wantAdvanced: true,
+		},
+		{
+			name:  "unsupported operator is left to steampipe",
+			quals: []*quals.Qual{stringQual("mail", "~~", "%contractor%"), stringQual("user_type", "<>", "Guest")},
+			want:  []string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, advanced := columns.buildAdvancedFilter(testQualMap(tt.quals...))
+			if !reflect.DeepEqual(got, tt.want) || advanced != tt.wantAdvanced {
+				t.Errorf("buildAdvancedFilter() = %q, %t, want %q, %t", got, advanced, tt.want, tt.wantAdvanced)
+			}
+		})
+	}
+
+	// Endpoints without advanced queries don't get the filters that need one
+	if got := columns.buildFilter(testQualMap(stringQual("mail", "~~", "%@contractor.com"))); len(got) != 1 {
+		t.Errorf("buildFilter() = %q", got)
+	}
+}
+
+func TestIsAdvancedFilter(t *testing.T) {
+	tests := map[string]bool{
+		"endsWith(mail,'@contractor.com')": true,
+		"userType ne 'Member'":             true,
+		"not(startswith(mail,'a'))":        true,
+		"memberOf/$count eq 0":             true,
+		"displayName eq 'Anne'":            false,
+		"startswith(displayName,'ne')":     false,
+	}
+	for filter, want := range tests {
+		if got := isAdvancedFilter(filter); got != want {
+			t.Errorf("isAdvancedFilter(%q) = %t, want %t", filter, got, want)
+		}
+	}
+}
+
+func TestSearchQuery(t *testing.T) {
+	tests := map[string]string{
+		"displayName:wa":                     `"displayName:wa"`,
+		` "displayName:wa" OR "mail:wa" `:    `"displayName:wa" OR "mail:wa"`,
+		`"description:one" AND "mail:admin"`: `"description:one" AND "mail:admin"`,
+	}
+	for search, want := range tests {
+		if got := searchQuery(search); got != want {
+			t.Errorf("searchQuery(%q) = %q, want %q", search, got, want)
+		}
+	}
+}
+
+func TestMailSearchQuery(t *testing.T) {
+	tests := map[string]string{
+		"invoice":                                `"invoice"`,
+		` from:alex AND hasattachments:true `:    `"from:alex AND hasattachments:true"`,
+		`subject:"q1 report" AND NOT from:megan`: `"subject:\"q1 report\" AND NOT from:megan"`,
+		`path\to`:                                `"path\\to"`,
+	}
+	for query, want := range tests {
+		if got := mailSearchQuery(query); got != want {
+			t.Errorf("mailSearchQuery(%q) = %q, want %q", query, got, want)
+		}
+	}
+}
+
+func TestFilterKeyColumns(t *testing.T) {
+	keyColumns := userFilterColumns.keyColumns()
+	if len(keyColumns) != len(userFilterColumns) {
+		t.Errorf("%d key columns, want %d", len(keyColumns), len(userFilterColumns))
+	}
+	for _, keyColumn := range keyColumns {
+		if keyColumn.Require != plugin.Optional {
+			t.Errorf("key column %s is %s, want optional", keyColumn.Name, keyColumn.Require)
+		}
+		column := userFilterColumns[keyColumn.Name]
+		for _, op := range append(column.Operators, column.AdvancedOperators...) {
+			if !isSupportedOperator(keyColumn.Operators, op) {
+				t.Errorf("key column %s operators = %v, missing %s", keyColumn.Name, keyColumn.Operators, op)
+			}
+		}
+	}
+}
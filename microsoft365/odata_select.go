@@ -0,0 +1,133 @@
+package microsoft365
+
+import (
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/iancoleman/strcase"
+	"github.com/turbot/steampipe-plugin-sdk/v5/plugin"
+)
+
+// odataSelect maps the columns of a table to the Graph properties they're
+// read from, so that a request only asks for the properties of the queried
+// columns. Graph doesn't return some properties, e.g. signInActivity,
+// unless they're selected.
+// https://learn.microsoft.com/en-us/graph/query-parameters#select-parameter
+type odataSelect struct {
+	// Properties maps the columns that aren't read from the lower camel case
+	// of their name. Columns read from quals, e.g. filter, or from navigation
+	// properties that can't be expanded map to no properties. Columns with a
+	// hydrate function are never selected.
+	Properties map[string][]string
+	// Expand maps the columns read from a navigation property to it. They're
+	// expanded even if they have a hydrate function, which can fall back to
+	// fetching the property when the request couldn't expand it.
+	Expand map[string]string
+	// GetOnly are the properties Graph only returns for a single item, and
+	// rejects in a $select when listing
+	GetOnly []string
+	// Gated maps the columns read from a property that needs its own
+	// permission or license to it. They're selected even if they have a
+	// hydrate function, which fetches the property for each item when Graph
+	// rejected a request that selected it.
+	Gated map[string]string
+	// Required are always selected, e.g. the id the hydrate functions use
+	Required []string
+}
+
+// buildListSelect returns the $select and $expand lists of a list request
+// for the queried columns.
+func (s odataSelect) buildListSelect(d *plugin.QueryData) ([]string, []string) {
+	return s.build(d.QueryContext.Columns, d.Table, true)
+}
+
+// buildGetSelect returns the $select and $expand lists of a get request for
+// the queried columns.
+func (s odataSelect) buildGetSelect(d *plugin.QueryData) ([]string, []string) {
+	return s.build(d.QueryContext.Columns, d.Table, false)
+}
+
+// buildDeltaSelect returns the $select and $expand lists of a delta query for
+// every column of the table. Graph keeps the properties of the first request
+// in the deltaLink, so they don't depend on the queried columns.
+func (s odataSelect) buildDeltaSelect(table *plugin.Table) ([]string, []string) {
+	var columns []string
+	for _, column := range table.Columns {
+		// The change type isn't a property of the item
+		if column.Name != "change_type" {
+			columns = append(columns, column.Name)
+		}
+	}
+	return s.build(columns, table, true)
+}
+
+func (s odataSelect) build(columns []string, table *plugin.Table, list bool) ([]string, []string) {
+	hydrated := map[string]bool{}
+	if table != nil {
+		for _, column := range table.Columns {
+			if column.Hydrate != nil {
+				hydrated[column.Name] = true
+			}
+		}
+	}
+
+	selects := map[string]bool{}
+	expands := map[string]bool{}
+	for _, property := range s.Required {
+		selects[property] = true
+	}
+	for _, column := range columns {
+		if expand, ok := s.Expand[column]; ok {
+			expands[expand] = true
+			continue
+		}
+		if property, ok := s.Gated[column]; ok {
+			selects[property] = true
+			continue
+		}
+		if hydrated[column] || strings.HasPrefix(column, "_") {
+			continue
+		}
+		properties, ok := s.Properties[column]
+		if !ok {
+			properties = []string{strcase.ToLowerCamel(column)}
+		}
+		for _, property := range properties {
+			if list && slices.Contains(s.GetOnly, property) {
+				continue
+			}
+			selects[property] = true
+		}
+	}
+
+	return sortedKeys(selects), sortedKeys(expands)
+}
+
+// withoutGated returns the $select list without the gated properties, and
+// whether it had any.
+func (s odataSelect) withoutGated(selects []string) ([]string, bool) {
+	var result []string
+	for _, property := range selects {
+		gated := false
+		for _, gatedProperty := range s.Gated {
+			gated = gated || property == gatedProperty
+		}
+		if !gated {
+			result = append(result, property)
+		}
+	}
+	return result, len(result) < len(selects)
+}
+
+func sortedKeys(set map[string]bool) []string {
+	if len(set) == 0 {
+		return nil
+	}
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
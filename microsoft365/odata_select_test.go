@@ -0,0 +1,143 @@
+package microsoft365
+
+import (
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/microsoftgraph/msgraph-sdk-go/models"
+)
+
+func TestBuildSelect(t *testing.T) {
+	table := Plugin(testContext()).TableMap["microsoft365_user"]
+
+	tests := []struct {
+		name       string
+		columns    []string
+		list       bool
+		wantSelect []string
+		wantExpand []string
+	}{
+		{
+			name:       "queried columns",
+			columns:    []string{"display_name", "title", "mail"},
+			list:       true,
+			wantSelect: []string{"displayName", "id", "mail"},
+		},
+		{
+			name:       "hydrated and qual columns aren't selected",
+			columns:    []string{"tenant_id", "time_zone", "is_mfa_registered", "filter", "_ctx"},
+			list:       true,
+			wantSelect: []string{"id"},
+		},
+		{
+			name:       "gated properties are selected",
+			columns:    []string{"sign_in_activity", "employee_leave_date_time"},
+			list:       true,
+			wantSelect: []string{"employeeLeaveDateTime", "id", "signInActivity"},
+		},
+		{
+			name:       "get only properties when listing",
+			columns:    []string{"about_me", "skills", "mail"},
+			list:       true,
+			wantSelect: []string{"id", "mail"},
+		},
+		{
+			name:       "get only properties of a single item",
+			columns:    []string{"about_me", "skills", "mail"},
+			wantSelect: []string{"aboutMe", "id", "mail", "skills"},
+		},
+		{
+			name:       "navigation properties are expanded",
+			columns:    []string{"license_details"},
+			list:       true,
+			wantSelect: []string{"id"},
+			wantExpand: []string{"licenseDetails"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			selects, expands := userSelect.build(tt.columns, table, tt.list)
+			if !reflect.DeepEqual(selects, tt.wantSelect) || !reflect.DeepEqual(expands, tt.wantExpand) {
+				t.Errorf("build() = %v, %v, want %v, %v", selects, expands, tt.wantSelect, tt.wantExpand)
+			}
+		})
+	}
+}
+
+func TestSelectWithoutGated(t *testing.T) {
+	selects, ok := userSelect.withoutGated([]string{"displayName", "employeeLeaveDateTime", "id", "signInActivity"})
+	if want := []string{"displayName", "id"}; !ok || !reflect.DeepEqual(selects, want) {
+		t.Errorf("withoutGated() = %v, %t, want %v, true", selects, ok, want)
+	}
+	if _, ok := userSelect.withoutGated([]string{"displayName", "id"}); ok {
+		t.Error("withoutGated() = true, want false without gated properties")
+	}
+}
+
+// Every column of a table must either map to a property of the Graph model
+// or be excluded, since Graph rejects a $select with an unknown property.
+func TestSelectCoversTableColumns(t *testing.T) {
+	tables := map[string]struct {
+		select_ odataSelect
+		model   interface{}
+	}{
+		"microsoft365_user":                 {userSelect, models.NewUser()},
+		"microsoft365_group":                {groupSelect, models.NewGroup()},
+		"microsoft365_site":                 {siteSelect, models.NewSite()},
+		"microsoft365_drive":                {driveSelect, models.NewDrive()},
+		"microsoft365_my_drive":             {driveSelect, models.NewDrive()},
+		"microsoft365_contact":              {contactSelect, models.NewContact()},
+		"microsoft365_my_contact":           {contactSelect, models.NewContact()},
+		"microsoft365_calendar_event":       {calendarEventSelect, models.NewEvent()},
+		"microsoft365_my_calendar_event":    {calendarEventSelect, models.NewEvent()},
+		"microsoft365_organization_contact": {orgContactSelect, models.NewOrgContact()},
+		"microsoft365_mail_attachment":      {mailAttachmentSelect, models.NewAttachment()},
+		"microsoft365_mail_folder":          {mailFolderSelect, models.NewMailFolder()},
+		"microsoft365_my_mail_folder":       {mailFolderSelect, models.NewMailFolder()},
+		"microsoft365_mail_message":         {mailMessageSelect, models.NewMessage()},
+		"microsoft365_my_mail_message":      {mailMessageSelect, models.NewMessage()},
+		"microsoft365_user_delta":           {userSelect, models.NewUser()},
+		"microsoft365_group_delta":          {groupSelect, models.NewGroup()},
+		"microsoft365_mail_message_delta":   {mailMessageSelect, models.NewMessage()},
+	}
+
+	derivedModels := map[string]interface{}{
+		"microsoft.graph.fileAttachment": models.NewFileAttachment(),
+	}
+
+	tableMap := Plugin(testContext()).TableMap
+	for name, tt := range tables {
+		table := tableMap[name]
+		var columns []string
+		for _, column := range table.Columns {
+			columns = append(columns, column.Name)
+		}
+
+		for _, list := range []bool{true, false} {
+			selects, expands := tt.select_.build(columns, table, list)
+			if strings.HasSuffix(name, "_delta") {
+				selects, expands = tt.select_.buildDeltaSelect(table)
+			}
+			for _, property := range append(selects, expands...) {
+				// e.g. singleValueExtendedProperties($filter=...)
+				property, _, _ = strings.Cut(property, "(")
+				// e.g. microsoft.graph.fileAttachment/contentId, a property of a
+				// derived type
+				model := tt.model
+				if cast, derived, ok := strings.Cut(property, "/"); ok {
+					model, property = derivedModels[cast], derived
+				}
+				getter := "Get" + strings.ToUpper(property[:1]) + property[1:]
+				if model == nil || !reflect.ValueOf(model).MethodByName(getter).IsValid() {
+					t.Errorf("%s selects %s, which %T has no %s for", name, property, model, getter)
+				}
+			}
+			if !slices.Contains(selects, "id") {
+				t.Errorf("%s doesn't select the id", name)
+			}
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package microsoft365
+
+import (
+	"sort"
+	"strings"
+)
+
+// tablePermission lists the least privileged Microsoft Graph permissions a
+// table needs, for application (app-only) and delegated tokens.
+// https://learn.microsoft.com/en-us/graph/permissions-reference
+type tablePermission struct {
+	Application []string
+	Delegated   []string
+
+	// Optional permissions are only needed by some columns, e.g. those filled
+	// from a separate API call
+	OptionalApplication []string
+	OptionalDelegated   []string
+
+	// Me is set for the microsoft365_my_* tables, which query the signed-in
+	// user, or the user_id from config
+	Me bool
+}
+
+// getRequired returns the permissions the table needs for the token type.
+func (p tablePermission) getRequired(delegated bool) []string {
+	if delegated {
+		return p.Delegated
+	}
+	return p.Application
+}
+
+// getOptional returns the permissions some columns of the table need for the
+// token type.
+func (p tablePermission) getOptional(delegated bool) []string {
+	if delegated {
+		return p.OptionalDelegated
+	}
+	return p.OptionalApplication
+}
+
+var tablePermissions = map[string]tablePermission{
+	"microsoft365_calendar":              {Application: []string{"Calendars.Read"}, Delegated: []string{"Calendars.Read.Shared"}},
+	"microsoft365_calendar_event":        {Application: []string{"Calendars.Read"}, Delegated: []string{"Calendars.Read.Shared"}},
+	"microsoft365_calendar_group":        {Application: []string{"Calendars.Read"}, Delegated: []string{"Calendars.Read.Shared"}},
+	"microsoft365_connection_diagnostic": {},
+	"microsoft365_contact":               {Application: []string{"Contacts.Read"}, Delegated: []string{"Contacts.Read.Shared"}},
+	"microsoft365_drive":                 {Application: []string{"Files.Read.All"}, Delegated: []string{"Files.Read.All"}},
+	"microsoft365_drive_file":            {Application: []string{"Files.Read.All"}, Delegated: []string{"Files.Read.All"}},
+	"microsoft365_drive_file_delta":      {Application: []string{"Files.Read.All"}, Delegated: []string{"Files.Read.All"}},
+	"microsoft365_group":                 {Application: []string{"Group.Read.All"}, Delegated: []string{"Group.Read.All"}},
+	"microsoft365_group_delta":           {Application: []string{"Group.Read.All"}, Delegated: []string{"Group.Read.All"}},
+	"microsoft365_list":                  {Application: []string{"Sites.Read.All"}, Delegated: []string{"Sites.Read.All"}},
+	"microsoft365_mail_attachment":       {Application: []string{"Mail.Read"}, Delegated: []string{"Mail.Read.Shared"}},
+	"microsoft365_mail_folder":           {Application: []string{"Mail.ReadBasic.All"}, Delegated: []string{"Mail.Read.Shared"}},
+	"microsoft365_mail_message":          {Application: []string{"Mail.Read"}, Delegated: []string{"Mail.Read.Shared"}},
+	"microsoft365_mail_message_delta":    {Application: []string{"Mail.Read"}, Delegated: []string{"Mail.Read.Shared"}},
+	"microsoft365_my_calendar":           {Application: []string{"Calendars.Read"}, Delegated: []string{"Calendars.Read"}, Me: true},
+	"microsoft365_my_calendar_event":     {Application: []string{"Calendars.Read"}, Delegated: []string{"Calendars.Read"}, Me: true},
+	"microsoft365_my_calendar_group":     {Application: []string{"Calendars.Read"}, Delegated: []string{"Calendars.Read"}, Me: true},
+	"microsoft365_my_contact":            {Application: []string{"Contacts.Read"}, Delegated: []string{"Contacts.Read"}, Me: true},
+	"microsoft365_my_drive":              {Application: []string{"Files.Read.All"}, Delegated: []string{"Files.Read"}, Me: true},
+	"microsoft365_my_drive_file":         {Application: []string{"Files.Read.All"}, Delegated: []string{"Files.Read"}, Me: true},
+	"microsoft365_my_mail_folder":        {Application: []string{"Mail.ReadBasic.All"}, Delegated: []string{"Mail.ReadBasic"}, Me: true},
+	"microsoft365_my_mail_message":       {Application: []string{"Mail.Read"}, Delegated: []string{"Mail.Read"}, Me: true},
+	"microsoft365_organization": {
+		Application:         []string{"Organization.Read.All"},
+		Delegated:           []string{"Organization.Read.All"},
+		OptionalApplication: []string{"SharePointTenantSettings.Read.All", "Policy.Read.All", "SecurityEvents.Read.All"},
+		OptionalDelegated:   []string{"SharePointTenantSettings.Read.All", "Policy.Read.All", "SecurityEvents.Read.All"},
+	},
+	"microsoft365_organization_contact": {Application: []string{"OrgContact.Read.All"}, Delegated: []string{"OrgContact.Read.All"}},
+	"microsoft365_site":                 {Application: []string{"Sites.Read.All"}, Delegated: []string{"Sites.Read.All"}},
+	"microsoft365_team":                 {Application: []string{"Group.Read.All"}, Delegated: []string{"Group.Read.All"}},
+	"microsoft365_team_member":          {Application: []string{"TeamMember.Read.All"}, Delegated: []string{"TeamMember.Read.All"}},
+	"microsoft365_user": {
+		Application:         []string{"User.Read.All"},
+		Delegated:           []string{"User.Read.All"},
+		OptionalApplication: []string{"MailboxSettings.Read", "AuditLog.Read.All"},
+		OptionalDelegated:   []string{"MailboxSettings.Read", "AuditLog.Read.All"},
+	},
+	"microsoft365_user_delta":               {Application: []string{"User.Read.All"}, Delegated: []string{"User.Read.All"}},
+	"microsoft365_user_registration_detail": {Application: []string{"AuditLog.Read.All"}, Delegated: []string{"AuditLog.Read.All"}},
+}
+
+// higherPermissions lists broader permissions that also grant a permission,
+// besides its ReadWrite variant
+var higherPermissions = map[string][]string{
+	"Calendars.Read.Shared": {"Calendars.Read", "Calendars.ReadWrite"},
+	"Contacts.Read.Shared":  {"Contacts.Read", "Contacts.ReadWrite"},
+	"Files.Read":            {"Files.Read.All", "Files.ReadWrite.All", "Sites.Read.All", "Sites.ReadWrite.All"},
+	"Files.Read.All":        {"Sites.Read.All", "Sites.ReadWrite.All"},
+	"Group.Read.All":        {"Directory.Read.All", "Directory.ReadWrite.All"},
+	"Mail.Read.Shared":      {"Mail.Read", "Mail.ReadWrite"},
+	"Mail.ReadBasic":        {"Mail.Read"},
+	"Mail.ReadBasic.All":    {"Mail.Read"},
+	"Organization.Read.All": {"Directory.Read.All", "Directory.ReadWrite.All"},
+	"OrgContact.Read.All":   {"Directory.Read.All", "Directory.ReadWrite.All"},
+	"Sites.Read.All":        {"Sites.Manage.All", "Sites.FullControl.All"},
+	"TeamMember.Read.All":   {"Group.Read.All", "Group.ReadWrite.All"},
+	"User.Read.All":         {"Directory.Read.All", "Directory.ReadWrite.All"},
+}
+
+// isPermissionGranted reports whether the permission, or a broader one, is
+// among the granted permissions.
+func isPermissionGranted(permission string, granted map[string]bool) bool {
+	if granted[permission] {
+		return true
+	}
+
+	// e.g. Mail.ReadWrite grants Mail.Read
+	if readWrite := strings.Replace(permission, ".Read", ".ReadWrite", 1); readWrite != permission && granted[readWrite] {
+		return true
+	}
+
+	for _, higher := range higherPermissions[permission] {
+		if isPermissionGranted(higher, granted) {
+			return true
+		}
+	}
+	return false
+}
+
+// getMissingPermissions returns the permissions that aren't granted, sorted.
+func getMissingPermissions(required []string, granted map[string]bool) []string {
+	missing := []string{}
+	for _, permission := range required {
+		if !isPermissionGranted(permission, granted) {
+			missing = append(missing, permission)
+		}
+	}
+	sort.Strings(missing)
+	return missing
+}
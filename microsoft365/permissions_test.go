@@ -0,0 +1,28 @@
+package microsoft365
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTablePermissionsCoverTableMap(t *testing.T) {
+	for name := range Plugin(testContext()).TableMap {
+		if _, ok := tablePermissions[name]; !ok {
+			t.Errorf("table %s has no entry in tablePermissions", name)
+		}
+	}
+}
+
+func TestGetMissingPermissions(t *testing.T) {
+	granted := map[string]bool{
+		"Mail.ReadWrite":     true,
+		"Directory.Read.All": true,
+		"Sites.Read.All":     true,
+	}
+
+	required := []string{"User.Read.All", "Mail.Read", "Mail.Read.Shared", "Files.Read", "Calendars.Read", "TeamMember.Read.All"}
+	want := []string{"Calendars.Read"}
+	if got := getMissingPermissions(required, granted); !reflect.DeepEqual(got, want) {
+		t.Errorf("getMissingPermissions() = %v, want %v", got, want)
+	}
+}
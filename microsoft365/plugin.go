@@ -14,40 +14,55 @@ func Plugin(ctx context.Context) *plugin.Plugin {
 	p := &plugin.Plugin{
 		Name:             pluginName,
 		DefaultTransform: transform.FromGo(),
-		ConnectionKeyColumns: []plugin.ConnectionKeyColumn{
-			{
-				Name:    "tenant_id",
-				Hydrate: getTenant,
-			},
+		DefaultIgnoreConfig: &plugin.IgnoreConfig{
+			ShouldIgnoreErrorFunc: isIgnorableErrorPredicate(nil),
 		},
 		ConnectionConfigSchema: &plugin.ConnectionConfigSchema{
 			NewInstance: ConfigInstance,
 		},
+		ConnectionConfigChangedFunc: connectionConfigChanged,
 		TableMap: map[string]*plugin.Table{
-			"microsoft365_calendar":             tableMicrosoft365Calendar(ctx),
-			"microsoft365_calendar_event":       tableMicrosoft365CalendarEvent(ctx),
-			"microsoft365_calendar_group":       tableMicrosoft365CalendarGroup(ctx),
-			"microsoft365_contact":              tableMicrosoft365Contact(ctx),
-			"microsoft365_drive":                tableMicrosoft365Drive(ctx),
-			"microsoft365_drive_file":           tableMicrosoft365DriveFile(ctx),
-			"microsoft365_group":                tableMicrosoft365Group(ctx),
-			"microsoft365_list":                 tableMicrosoft365List(ctx),
-			"microsoft365_mail_message":         tableMicrosoft365MailMessage(ctx),
-			"microsoft365_my_calendar":          tableMicrosoft365MyCalendar(ctx),
-			"microsoft365_my_calendar_event":    tableMicrosoft365MyCalendarEvent(ctx),
-			"microsoft365_my_calendar_group":    tableMicrosoft365MyCalendarGroup(ctx),
-			"microsoft365_my_contact":           tableMicrosoft365MyContact(ctx),
-			"microsoft365_my_drive":             tableMicrosoft365MyDrive(ctx),
-			"microsoft365_my_drive_file":        tableMicrosoft365MyDriveFile(ctx),
-			"microsoft365_my_mail_message":      tableMicrosoft365MyMailMessage(ctx),
-			"microsoft365_organization":         tableMicrosoft365Organization(ctx),
-			"microsoft365_organization_contact": tableMicrosoft365OrganizationContact(ctx),
-			"microsoft365_site":                 tableMicrosoft365Site(ctx),
-			"microsoft365_team":                 tableMicrosoft365Team(ctx),
-			"microsoft365_team_member":          tableMicrosoft365TeamMember(ctx),
-			"microsoft365_user":                 tableMicrosoft365User(ctx),
+			"microsoft365_calendar":                 tableMicrosoft365Calendar(ctx),
+			"microsoft365_calendar_event":           tableMicrosoft365CalendarEvent(ctx),
+			"microsoft365_calendar_group":           tableMicrosoft365CalendarGroup(ctx),
+			"microsoft365_connection_diagnostic":    tableMicrosoft365ConnectionDiagnostic(ctx),
+			"microsoft365_contact":                  tableMicrosoft365Contact(ctx),
+			"microsoft365_drive":                    tableMicrosoft365Drive(ctx),
+			"microsoft365_drive_file":               tableMicrosoft365DriveFile(ctx),
+			"microsoft365_drive_file_delta":         tableMicrosoft365DriveFileDelta(ctx),
+			"microsoft365_group":                    tableMicrosoft365Group(ctx),
+			"microsoft365_group_delta":              tableMicrosoft365GroupDelta(ctx),
+			"microsoft365_list":                     tableMicrosoft365List(ctx),
+			"microsoft365_mail_attachment":          tableMicrosoft365MailAttachment(ctx),
+			"microsoft365_mail_folder":              tableMicrosoft365MailFolder(ctx),
+			"microsoft365_mail_message":             tableMicrosoft365MailMessage(ctx),
+			"microsoft365_mail_message_delta":       tableMicrosoft365MailMessageDelta(ctx),
+			"microsoft365_my_calendar":              tableMicrosoft365MyCalendar(ctx),
+			"microsoft365_my_calendar_event":        tableMicrosoft365MyCalendarEvent(ctx),
+			"microsoft365_my_calendar_group":        tableMicrosoft365MyCalendarGroup(ctx),
+			"microsoft365_my_contact":               tableMicrosoft365MyContact(ctx),
+			"microsoft365_my_drive":                 tableMicrosoft365MyDrive(ctx),
+			"microsoft365_my_drive_file":            tableMicrosoft365MyDriveFile(ctx),
+			"microsoft365_my_mail_folder":           tableMicrosoft365MyMailFolder(ctx),
+			"microsoft365_my_mail_message":          tableMicrosoft365MyMailMessage(ctx),
+			"microsoft365_organization":             tableMicrosoft365Organization(ctx),
+			"microsoft365_organization_contact":     tableMicrosoft365OrganizationContact(ctx),
+			"microsoft365_site":                     tableMicrosoft365Site(ctx),
+			"microsoft365_team":                     tableMicrosoft365Team(ctx),
+			"microsoft365_team_member":              tableMicrosoft365TeamMember(ctx),
+			"microsoft365_user":                     tableMicrosoft365User(ctx),
+			"microsoft365_user_delta":               tableMicrosoft365UserDelta(ctx),
+			"microsoft365_user_registration_detail": tableMicrosoft365UserRegistrationDetail(ctx),
 		},
 	}
 
+	// tenant_id is an optional key column of every table, so tenantMatrix can
+	// query only the tenants of a multi-tenant connection in its quals
+	for _, table := range p.TableMap {
+		if table.List != nil {
+			table.List.KeyColumns = append(table.List.KeyColumns, &plugin.KeyColumn{Name: matrixKeyTenant, Require: plugin.Optional})
+		}
+	}
+
 	return p
 }
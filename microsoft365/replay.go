@@ -0,0 +1,504 @@
+package microsoft365
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+)
+
+// Supported values for the replay_mode connection argument
+const (
+	ReplayModeRecord = "record"
+	ReplayModeReplay = "replay"
+)
+
+// replayClaimsFile is the file of a recording that holds the claims of the
+// access token it was recorded with, but not the token itself
+const replayClaimsFile = "access_token_claims.json"
+
+// replayHeaders are the response headers kept in a recording; the others,
+// e.g. cookies, are dropped
+var replayHeaders = []string{"Content-Type", "Location", "Retry-After", "Request-Id", "Client-Request-Id"}
+
+// Secrets and tokens are scrubbed from the URLs and bodies of a recording:
+// access tokens and other JWTs, the signatures and temporary auth tokens of
+// download URLs, and secret properties of JSON bodies. Paging tokens such as
+// $skiptoken are kept, since replaying the next page needs them.
+var (
+	replayJWTPattern        = regexp.MustCompile(`eyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*`)
+	replayQueryParamPattern = regexp.MustCompile(`(?i)([?&](?:tempauth|sig|signature|access_token|code|client_secret|password|token)=)[^&"'\s\\]+`)
+	replayJSONSecretPattern = regexp.MustCompile(`(?i)("(?:access_token|refresh_token|id_token|client_secret|password)"\s*:\s*)"(?:[^"\\]|\\.)*"`)
+)
+
+const replayScrubbedValue = "REDACTED"
+
+// replayOptions holds the replay_mode and replay_dir settings of a
+// connection.
+type replayOptions struct {
+	Mode string
+	Dir  string
+}
+
+func getReplayOptions(config microsoft365Config) (replayOptions, error) {
+	var options replayOptions
+	if config.ReplayMode != nil {
+		options.Mode = strings.ToLower(strings.TrimSpace(*config.ReplayMode))
+	}
+	if config.ReplayDir != nil {
+		options.Dir = *config.ReplayDir
+	}
+
+	switch options.Mode {
+	case "":
+		return options, nil
+	case ReplayModeRecord, ReplayModeReplay:
+	default:
+		return options, fmt.Errorf("invalid replay_mode %q, valid values are %q and %q", options.Mode, ReplayModeRecord, ReplayModeReplay)
+	}
+
+	if options.Dir == "" {
+		return options, fmt.Errorf("replay_mode %q requires replay_dir to be set", options.Mode)
+	}
+	if options.Mode == ReplayModeReplay {
+		if info, err := os.Stat(options.Dir); err != nil || !info.IsDir() {
+			return options, fmt.Errorf("replay_mode %q: replay_dir %s is not a directory of recorded responses", options.Mode, options.Dir)
+		}
+	}
+
+	return options, nil
+}
+
+// replayInteraction is a recorded Graph response, saved as a JSON file in the
+// replay_dir.
+type replayInteraction struct {
+	Method string            `json:"method"`
+	URL    string            `json:"url"`
+	Status int               `json:"status"`
+	Header map[string]string `json:"headers,omitempty"`
+	// Body is the response body if it is JSON, otherwise it is kept as Text
+	Body json.RawMessage `json:"body,omitempty"`
+	Text string          `json:"text,omitempty"`
+}
+
+// replayTransport records the Graph responses of a connection to the
+// replay_dir, or serves them back from it without sending any request. It is
+// the parent transport of the Graph middleware, so retries and redirects are
+// handled as they would be for the live responses.
+//
+// The sub-requests of a $batch request are recorded and replayed one by one,
+// since which rows share a batch varies from query to query.
+type replayTransport struct {
+	mode string
+	dir  string
+	next http.RoundTripper
+
+	claimsOnce sync.Once
+}
+
+func newReplayTransport(options replayOptions, next http.RoundTripper) (*replayTransport, error) {
+	if options.Mode == ReplayModeRecord {
+		if err := os.MkdirAll(options.Dir, 0o700); err != nil {
+			return nil, fmt.Errorf("error creating replay_dir %s: %v", options.Dir, err)
+		}
+	}
+	return &replayTransport{mode: options.Mode, dir: options.Dir, next: next}, nil
+}
+
+func (t *replayTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	body, err := readReplayRequestBody(req)
+	if err != nil {
+		return nil, err
+	}
+	if t.mode == ReplayModeRecord {
+		if err := t.recordClaims(req); err != nil {
+			return nil, err
+		}
+	}
+
+	if req.Method == http.MethodPost && strings.HasSuffix(req.URL.Path, "/$batch") {
+		if t.mode == ReplayModeRecord {
+			return t.recordBatch(req, body)
+		}
+		return t.replayBatch(req, body)
+	}
+
+	if t.mode == ReplayModeRecord {
+		resp, err := t.next.RoundTrip(req)
+		if err != nil {
+			return nil, err
+		}
+		respBody, err := readReplayResponseBody(resp)
+		if err != nil {
+			return nil, err
+		}
+		if err := t.save(newReplayInteraction(req.Method, req.URL.String(), resp.StatusCode, resp.Header, respBody), body); err != nil {
+			return nil, err
+		}
+		return resp, nil
+	}
+
+	interaction, err := t.load(req.Method, req.URL.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	return interaction.response(req), nil
+}
+
+// recordClaims saves the claims of the access token of the first recorded
+// request, so that replaying the recording sees the same identity, e.g. the
+// signed-in user of the microsoft365_my_* tables.
+func (t *replayTransport) recordClaims(req *http.Request) error {
+	token, ok := strings.CutPrefix(req.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		return nil
+	}
+
+	var err error
+	t.claimsOnce.Do(func() {
+		claims, decodeErr := decodeAccessToken(token)
+		if decodeErr != nil {
+			// Opaque tokens have no claims to record
+			return
+		}
+		var data []byte
+		data, err = json.MarshalIndent(claims, "", "  ")
+		if err == nil {
+			err = os.WriteFile(filepath.Join(t.dir, replayClaimsFile), data, 0o600)
+		}
+		if err != nil {
+			err = fmt.Errorf("error recording access token claims: %v", err)
+		}
+	})
+	return err
+}
+
+// replayBatchRequest and replayBatchResponse are the parts of the $batch
+// payloads that are recorded and replayed
+// https://learn.microsoft.com/en-us/graph/json-batching
+type replayBatchRequest struct {
+	Requests []struct {
+		ID     string          `json:"id"`
+		Method string          `json:"method"`
+		URL    string          `json:"url"`
+		Body   json.RawMessage `json:"body,omitempty"`
+	} `json:"requests"`
+}
+
+type replayBatchResponseItem struct {
+	ID     string            `json:"id"`
+	Status int               `json:"status"`
+	Header map[string]string `json:"headers,omitempty"`
+	Body   json.RawMessage   `json:"body,omitempty"`
+}
+
+type replayBatchResponse struct {
+	Responses []replayBatchResponseItem `json:"responses"`
+}
+
+// recordBatch sends the $batch request and records the response of each of
+// its sub-requests.
+func (t *replayTransport) recordBatch(req *http.Request, body []byte) (*http.Response, error) {
+	var batch replayBatchRequest
+	if err := json.Unmarshal(body, &batch); err != nil {
+		return nil, fmt.Errorf("error reading $batch request to record: %v", err)
+	}
+
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	respBody, err := readReplayResponseBody(resp)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		return resp, err
+	}
+
+	var batchResp replayBatchResponse
+	if err := json.Unmarshal(respBody, &batchResp); err != nil {
+		return nil, fmt.Errorf("error reading $batch response to record: %v", err)
+	}
+	responses := make(map[string]replayBatchResponseItem, len(batchResp.Responses))
+	for _, item := range batchResp.Responses {
+		responses[item.ID] = item
+	}
+
+	base := strings.TrimSuffix(req.URL.String(), "/$batch")
+	for _, request := range batch.Requests {
+		item, ok := responses[request.ID]
+		if !ok {
+			continue
+		}
+		interaction := &replayInteraction{
+			Method: request.Method,
+			URL:    scrubReplayText(base + request.URL),
+			Status: item.Status,
+			Header: scrubReplayHeaders(item.Header),
+			Body:   json.RawMessage(scrubReplayText(string(item.Body))),
+		}
+		if err := t.save(interaction, request.Body); err != nil {
+			return nil, err
+		}
+	}
+
+	return resp, nil
+}
+
+// replayBatch answers the $batch request from the recorded responses of its
+// sub-requests.
+func (t *replayTransport) replayBatch(req *http.Request, body []byte) (*http.Response, error) {
+	var batch replayBatchRequest
+	if err := json.Unmarshal(body, &batch); err != nil {
+		return nil, fmt.Errorf("error reading $batch request to replay: %v", err)
+	}
+	if len(batch.Requests) == 0 {
+		return nil, errors.New("error reading $batch request to replay: it has no requests")
+	}
+
+	base := strings.TrimSuffix(req.URL.String(), "/$batch")
+	var batchResp replayBatchResponse
+	for _, request := range batch.Requests {
+		interaction, err := t.load(request.Method, base+request.URL, request.Body)
+		if err != nil {
+			return nil, err
+		}
+		item := replayBatchResponseItem{ID: request.ID, Status: interaction.Status, Header: interaction.Header, Body: interaction.Body}
+		if item.Body == nil && interaction.Text != "" {
+			item.Body, _ = json.Marshal(interaction.Text)
+		}
+		batchResp.Responses = append(batchResp.Responses, item)
+	}
+
+	respBody, err := json.Marshal(batchResp)
+	if err != nil {
+		return nil, err
+	}
+	interaction := &replayInteraction{Status: http.StatusOK, Header: map[string]string{"Content-Type": "application/json"}, Body: respBody}
+	return interaction.response(req), nil
+}
+
+// save writes the interaction to its file in the replay_dir. A response
+// recorded again, e.g. the retry of a throttled request, replaces the earlier
+// one.
+func (t *replayTransport) save(interaction *replayInteraction, requestBody []byte) error {
+	data, err := json.MarshalIndent(interaction, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error recording response for %s %s: %v", interaction.Method, interaction.URL, err)
+	}
+
+	path := filepath.Join(t.dir, replayFileName(interaction.Method, interaction.URL, requestBody))
+	tmp, err := os.CreateTemp(t.dir, ".recording-*")
+	if err != nil {
+		return fmt.Errorf("error recording response for %s %s: %v", interaction.Method, interaction.URL, err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("error recording response for %s %s: %v", interaction.Method, interaction.URL, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("error recording response for %s %s: %v", interaction.Method, interaction.URL, err)
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// load reads the recorded response to the request from the replay_dir.
+func (t *replayTransport) load(method, rawURL string, requestBody []byte) (*replayInteraction, error) {
+	path := filepath.Join(t.dir, replayFileName(method, rawURL, requestBody))
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("replay_mode %q: no response to %s %s was recorded in %s", ReplayModeReplay, method, scrubReplayText(rawURL), t.dir)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading recorded response %s: %v", path, err)
+	}
+
+	var interaction replayInteraction
+	if err := json.Unmarshal(data, &interaction); err != nil {
+		return nil, fmt.Errorf("error reading recorded response %s: %v", path, err)
+	}
+	return &interaction, nil
+}
+
+func newReplayInteraction(method, rawURL string, status int, header http.Header, body []byte) *replayInteraction {
+	headers := map[string]string{}
+	for _, name := range replayHeaders {
+		if value := header.Get(name); value != "" {
+			headers[name] = value
+		}
+	}
+
+	interaction := &replayInteraction{
+		Method: method,
+		URL:    scrubReplayText(rawURL),
+		Status: status,
+		Header: scrubReplayHeaders(headers),
+	}
+	scrubbed := scrubReplayText(string(body))
+	if json.Valid([]byte(scrubbed)) {
+		interaction.Body = json.RawMessage(scrubbed)
+	} else {
+		interaction.Text = scrubbed
+	}
+	return interaction
+}
+
+// response builds the HTTP response of the recorded interaction.
+func (i *replayInteraction) response(req *http.Request) *http.Response {
+	body := []byte(i.Text)
+	if i.Body != nil {
+		body = i.Body
+	}
+
+	header := http.Header{}
+	for name, value := range i.Header {
+		header.Set(name, value)
+	}
+	header.Set("Content-Length", strconv.Itoa(len(body)))
+
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", i.Status, http.StatusText(i.Status)),
+		StatusCode:    i.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}
+}
+
+// replayFileName returns the file a request's response is recorded in. The
+// request is identified by its method, its path and sorted query parameters,
+// and its body, after scrubbing, so a recording can be replayed against
+// another graph_endpoint.
+func replayFileName(method, rawURL string, requestBody []byte) string {
+	target := scrubReplayText(rawURL)
+	if u, err := url.Parse(target); err == nil {
+		target = u.Path + "?" + u.Query().Encode()
+	}
+	key := method + " " + target
+	if len(requestBody) > 0 && string(requestBody) != "null" {
+		key += "\n" + scrubReplayText(string(requestBody))
+	}
+	hash := sha256.Sum256([]byte(key))
+	return fmt.Sprintf("%s-%x.json", strings.ToLower(method), hash[:12])
+}
+
+func scrubReplayText(text string) string {
+	text = replayJWTPattern.ReplaceAllString(text, replayScrubbedValue)
+	text = replayQueryParamPattern.ReplaceAllString(text, "${1}"+replayScrubbedValue)
+	return replayJSONSecretPattern.ReplaceAllString(text, `${1}"`+replayScrubbedValue+`"`)
+}
+
+func scrubReplayHeaders(headers map[string]string) map[string]string {
+	for name, value := range headers {
+		headers[name] = scrubReplayText(value)
+	}
+	return headers
+}
+
+// readReplayRequestBody returns the request body, uncompressed, and leaves it
+// in place to be sent.
+func readReplayRequestBody(req *http.Request) ([]byte, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil, nil
+	}
+	data, err := io.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	req.Body = io.NopCloser(bytes.NewReader(data))
+
+	if req.Header.Get("Content-Encoding") == "gzip" {
+		return gunzipReplayBody(data)
+	}
+	return data, nil
+}
+
+// readReplayResponseBody returns the response body, uncompressed, and leaves
+// it in place to be read by the Graph client.
+func readReplayResponseBody(resp *http.Response) ([]byte, error) {
+	data, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.Header.Get("Content-Encoding") == "gzip" {
+		if data, err = gunzipReplayBody(data); err != nil {
+			return nil, err
+		}
+		resp.Header.Del("Content-Encoding")
+		resp.Header.Set("Content-Length", strconv.Itoa(len(data)))
+		resp.ContentLength = int64(len(data))
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(data))
+	return data, nil
+}
+
+func gunzipReplayBody(data []byte) ([]byte, error) {
+	reader, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return io.ReadAll(reader)
+}
+
+// replayCredential stands in for the connection's credential in replay mode,
+// so queries neither need credentials nor send token requests. Its token is
+// an unsigned JWT with the claims of the recording's token, so the signed-in
+// user is looked up from the recorded /me response as it was when recording.
+type replayCredential struct {
+	claims accessTokenClaims
+}
+
+// newReplayCredential returns the credential of the recording in the dir.
+// Recordings without claims get those of a signed-in user.
+func newReplayCredential(dir string) (replayCredential, error) {
+	cred := replayCredential{claims: accessTokenClaims{IDType: "user"}}
+
+	data, err := os.ReadFile(filepath.Join(dir, replayClaimsFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return cred, nil
+	}
+	if err == nil {
+		err = json.Unmarshal(data, &cred.claims)
+	}
+	if err != nil {
+		return cred, fmt.Errorf("error reading recorded access token claims: %v", err)
+	}
+	return cred, nil
+}
+
+func (c replayCredential) GetToken(ctx context.Context, options policy.TokenRequestOptions) (azcore.AccessToken, error) {
+	expiresOn := time.Now().Add(time.Hour)
+	claims := c.claims
+	claims.ExpiresOn = expiresOn.Unix()
+
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return azcore.AccessToken{}, err
+	}
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none","typ":"JWT"}`))
+	return azcore.AccessToken{Token: header + "." + base64.RawURLEncoding.EncodeToString(payload) + ".", ExpiresOn: expiresOn}, nil
+}
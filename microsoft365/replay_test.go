@@ -0,0 +1,174 @@
+package microsoft365
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/turbot/steampipe-plugin-sdk/v5/plugin/quals"
+)
+
+func TestGetReplayOptions(t *testing.T) {
+	dir := t.TempDir()
+
+	tests := []struct {
+		name    string
+		config  microsoft365Config
+		want    replayOptions
+		wantErr string
+	}{
+		{name: "off", config: microsoft365Config{}, want: replayOptions{}},
+		{name: "record", config: microsoft365Config{ReplayMode: StringPtr("Record"), ReplayDir: StringPtr(filepath.Join(dir, "new"))}, want: replayOptions{Mode: ReplayModeRecord, Dir: filepath.Join(dir, "new")}},
+		{name: "replay", config: microsoft365Config{ReplayMode: StringPtr("replay"), ReplayDir: StringPtr(dir)}, want: replayOptions{Mode: ReplayModeReplay, Dir: dir}},
+		{name: "invalid mode", config: microsoft365Config{ReplayMode: StringPtr("playback"), ReplayDir: StringPtr(dir)}, wantErr: "invalid replay_mode"},
+		{name: "no dir", config: microsoft365Config{ReplayMode: StringPtr("record")}, wantErr: "requires replay_dir"},
+		{name: "missing dir", config: microsoft365Config{ReplayMode: StringPtr("replay"), ReplayDir: StringPtr(filepath.Join(dir, "missing"))}, wantErr: "not a directory"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := getReplayOptions(tt.config)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("getReplayOptions() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("getReplayOptions() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("getReplayOptions() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestScrubReplayText(t *testing.T) {
+	tests := map[string]string{
+		`{"access_token":"abc","expires_in":3600}`:  `{"access_token":"REDACTED","expires_in":3600}`,
+		`Bearer eyJhbGciOi.eyJhdWQiOi.c2lnbmF0dXJl`: `Bearer REDACTED`,
+		`{"@microsoft.graph.downloadUrl":"https://contoso.sharepoint.com/download.aspx?UniqueId=1&tempauth=v1.abc"}`: `{"@microsoft.graph.downloadUrl":"https://contoso.sharepoint.com/download.aspx?UniqueId=1&tempauth=REDACTED"}`,
+		`https://graph.microsoft.com/v1.0/users?$skiptoken=X1&$top=2`:                                                `https://graph.microsoft.com/v1.0/users?$skiptoken=X1&$top=2`,
+	}
+	for text, want := range tests {
+		if got := scrubReplayText(text); got != want {
+			t.Errorf("scrubReplayText(%s) = %s, want %s", text, got, want)
+		}
+	}
+}
+
+func TestReplayTransport(t *testing.T) {
+	f := newFakeGraph(t)
+	dir := filepath.Join(t.TempDir(), "recording")
+	query := testQuery{Table: "microsoft365_user", Columns: []string{"id", "display_name", "time_zone"}}
+
+	f.configure = func(config *microsoft365Config) {
+		config.ReplayMode = StringPtr(ReplayModeRecord)
+		config.ReplayDir = StringPtr(dir)
+	}
+	recorded := f.list(t, query)
+	mail := f.list(t, testQuery{Table: "microsoft365_mail_message", Quals: []*quals.Qual{stringQual("user_id", "=", fakeGraphUserID)}})
+
+	// The recording holds neither the access token nor the Authorization header
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil || len(files) == 0 {
+		t.Fatalf("recorded files = %v, %v", files, err)
+	}
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(string(data), f.token) || strings.Contains(string(data), "Bearer") {
+			t.Errorf("%s contains the access token", file)
+		}
+	}
+
+	// Replaying sends no requests, not even for a token
+	f.server.Close()
+	f.configure = func(config *microsoft365Config) {
+		config.ReplayMode = StringPtr(ReplayModeReplay)
+		config.ReplayDir = StringPtr(dir)
+		config.AuthMethod = nil
+		config.MSIEndpoint = nil
+	}
+	replayed := f.list(t, query)
+	for _, column := range query.Columns {
+		if got, want := testColumn(replayed, column), testColumn(recorded, column); !slices.Equal(got, want) {
+			t.Errorf("replayed column %s = %q, want %q", column, got, want)
+		}
+	}
+	assertColumn(t, recorded, "time_zone", "Pacific Standard Time", "", "")
+	replayedMail := f.list(t, testQuery{Table: "microsoft365_mail_message", Quals: []*quals.Qual{stringQual("user_id", "=", fakeGraphUserID)}})
+	if got, want := testColumn(replayedMail, "subject"), testColumn(mail, "subject"); !slices.Equal(got, want) {
+		t.Errorf("replayed subjects = %q, want %q", got, want)
+	}
+
+	// A request that wasn't recorded fails rather than going to the network
+	err = f.getError(t, testQuery{Table: "microsoft365_user", Quals: []*quals.Qual{stringQual("id", "=", "missing")}})
+	if err == nil || !strings.Contains(err.Error(), "no response to GET") {
+		t.Errorf("get error = %v, want no recorded response", err)
+	}
+}
+
+func TestReplayTransportSignedInUser(t *testing.T) {
+	f := newFakeGraph(t)
+	dir := filepath.Join(t.TempDir(), "recording")
+	query := testQuery{Table: "microsoft365_my_mail_message", Columns: []string{"id", "subject", "user_id"}}
+
+	// A delegated token, whose user is looked up from /me
+	f.token = testAccessToken(t, map[string]interface{}{
+		"aud":   "https://graph.microsoft.com",
+		"tid":   fakeGraphTenantID,
+		"appid": "11111111-1111-1111-1111-111111111111",
+		"idtyp": "user",
+		"scp":   "User.Read Mail.Read",
+		"upn":   "AdeleV@contoso.com",
+		"exp":   time.Now().Add(time.Hour).Unix(),
+	})
+	f.configure = func(config *microsoft365Config) {
+		config.UserID = nil
+		config.ReplayMode = StringPtr(ReplayModeRecord)
+		config.ReplayDir = StringPtr(dir)
+	}
+	recorded := f.list(t, query)
+	if len(recorded) == 0 || len(f.requested("/me")) != 1 {
+		t.Fatalf("recorded %d rows with %d /me requests, want the messages of the signed-in user", len(recorded), len(f.requested("/me")))
+	}
+
+	// The claims are recorded without the token's signature
+	data, err := os.ReadFile(filepath.Join(dir, replayClaimsFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "signature") || !strings.Contains(string(data), "AdeleV@contoso.com") {
+		t.Errorf("recorded claims = %s", data)
+	}
+
+	f.server.Close()
+	f.configure = func(config *microsoft365Config) {
+		config.UserID = nil
+		config.ReplayMode = StringPtr(ReplayModeReplay)
+		config.ReplayDir = StringPtr(dir)
+		config.AuthMethod = nil
+		config.MSIEndpoint = nil
+	}
+	replayed := f.list(t, query)
+	for _, column := range query.Columns {
+		if got, want := testColumn(replayed, column), testColumn(recorded, column); !slices.Equal(got, want) {
+			t.Errorf("replayed column %s = %q, want %q", column, got, want)
+		}
+	}
+
+	// The connection diagnostic sees the recorded token
+	diagnostics := f.list(t, testQuery{Table: "microsoft365_connection_diagnostic", Columns: []string{"table_name", "token_type", "user_principal_name", "error"}})
+	if len(diagnostics) == 0 {
+		t.Fatal("no connection diagnostic rows")
+	}
+	assertColumn(t, diagnostics[:1], "token_type", "delegated")
+	assertColumn(t, diagnostics[:1], "user_principal_name", "AdeleV@contoso.com")
+	assertColumn(t, diagnostics[:1], "error", "")
+}
@@ -3,20 +3,23 @@ package microsoft365
 import (
 	"bytes"
 	"context"
-	"crypto"
-	"crypto/x509"
+	"crypto/sha256"
 	"encoding/json"
 	"fmt"
+	"net/http"
+	"net/url"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"runtime"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/turbot/steampipe-plugin-sdk/v5/plugin"
 
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore/cloud"
-	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
-	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
 	a "github.com/microsoft/kiota-authentication-azure-go"
 	msgraphsdkgo "github.com/microsoftgraph/msgraph-sdk-go"
 )
@@ -69,159 +72,355 @@ func getTenantFromCLI() (string, error) {
 	return tokenResponse.Tenant, nil
 }
 
+// graphClient bundles a Graph client with the request adapter and credential
+// it was built from. They are always cached and handed out together; caching
+// the client alone left the page iterators of the mail tables with a nil
+// adapter.
+type graphClient struct {
+	client  *msgraphsdkgo.GraphServiceClient
+	adapter *msgraphsdkgo.GraphRequestAdapter
+	cred    azcore.TokenCredential
+
+	// throttling holds the request metrics of the connection
+	throttling *throttlingHandler
+	// batcher groups the per-row requests of hydrate calls into $batch requests
+	batcher *graphBatcher
+
+	// authMethod is the auth method the credential was created for
+	authMethod string
+	// scopes requests tokens for the Graph endpoint of the connection's cloud
+	scopes []string
+}
+
+// graphClientPool holds one graphClient per connection. The key includes a
+// hash of the connection config, so a config change builds a new client, and
+// the client of the old config is dropped.
+var graphClientPool = struct {
+	sync.Mutex
+	clients map[string]*graphClientEntry
+	// keys maps each connection, and tenant of a multi-tenant connection, to
+	// the key of its current client
+	keys map[string]string
+}{clients: map[string]*graphClientEntry{}, keys: map[string]string{}}
+
+// graphClientErrorTTL is how long an error building a client is returned to
+// the hydrate calls of the connection before the client is built again, so
+// e.g. an unreadable certificate isn't loaded again for every row.
+const graphClientErrorTTL = 30 * time.Second
+
+// graphClientEntry is the pooled client of a key. It's built once, by the
+// first caller, while the others wait on ready.
+type graphClientEntry struct {
+	ready  chan struct{}
+	client *graphClient
+	err    error
+	// retryAt is when a client that failed to build is built again
+	retryAt time.Time
+}
+
+// expired reports whether the entry failed to build long enough ago to be
+// built again.
+func (e *graphClientEntry) expired() bool {
+	select {
+	case <-e.ready:
+		return e.err != nil && !time.Now().Before(e.retryAt)
+	default:
+		return false
+	}
+}
+
 func GetGraphClient(ctx context.Context, d *plugin.QueryData) (*msgraphsdkgo.GraphServiceClient, *msgraphsdkgo.GraphRequestAdapter, error) {
-	logger := plugin.Logger(ctx)
+	c, err := getGraphClient(ctx, d)
+	if err != nil {
+		return nil, nil, err
+	}
+	return c.client, c.adapter, nil
+}
 
-	// Disable caching since it only saves ~.25ms and results in an SDK error
-	// when running consecutive queries for the mail_message and my_mail_message
-	// tables:
-	// Error: rpc error: code = Internal desc = hydrate function listMicrosoft365MyMailMessages failed with panic runtime error: invalid memory address or nil pointer dereference (SQLSTATE HV000)
-	// Have we already created and cached the session?
-	/*
-		sessionCacheKey := "GetGraphClient"
-		if cachedData, ok := d.ConnectionManager.Cache.Get(sessionCacheKey); ok {
-			return cachedData.(*msgraphsdkgo.GraphServiceClient), nil, nil
-		}
-	*/
-	var tenantID, environment, clientID, clientSecret, certificatePath, certificatePassword string
+// getGraphClient returns the pooled client for the connection, creating it on
+// first use. Concurrent hydrates wait for a single client, so they share its
+// credential and token cache, but the pool is only locked to look the entry
+// up, so other connections and tenants aren't held up while it's built.
+func getGraphClient(ctx context.Context, d *plugin.QueryData) (*graphClient, error) {
+	microsoft365Config, err := getConnectionConfig(ctx, d)
+	if err != nil {
+		return nil, err
+	}
 
-	microsoft365Config := GetConfig(d.Connection)
-	if microsoft365Config.TenantID != nil {
-		tenantID = *microsoft365Config.TenantID
-	} else {
-		tenantID = os.Getenv("AZURE_TENANT_ID")
+	key, err := graphClientCacheKey(d.Connection, microsoft365Config)
+	if err != nil {
+		return nil, err
 	}
 
-	if microsoft365Config.Environment != nil {
-		environment = *microsoft365Config.Environment
-	} else {
-		environment = os.Getenv("AZURE_ENVIRONMENT")
+	var connectionName string
+	if d.Connection != nil {
+		connectionName = d.Connection.Name
 	}
+	slot := connectionName + "/" + getMatrixTenantID(ctx)
 
-	var enableMSI bool
-	if microsoft365Config.EnableMSI != nil {
-		enableMSI = *microsoft365Config.EnableMSI
+	graphClientPool.Lock()
+	if previous, ok := graphClientPool.keys[slot]; ok && previous != key {
+		delete(graphClientPool.clients, previous)
+	}
+	graphClientPool.keys[slot] = key
+	entry, ok := graphClientPool.clients[key]
+	if ok && entry.expired() {
+		ok = false
+	}
+	if !ok {
+		entry = &graphClientEntry{ready: make(chan struct{})}
+		graphClientPool.clients[key] = entry
 	}
+	graphClientPool.Unlock()
 
-	// 1. Client secret credentials
-	if microsoft365Config.ClientID != nil {
-		clientID = *microsoft365Config.ClientID
+	if ok {
+		select {
+		case <-entry.ready:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
 	} else {
-		clientID = os.Getenv("AZURE_CLIENT_ID")
+		entry.client, entry.err = newGraphClient(ctx, microsoft365Config)
+		// A build cut short by the query ending isn't a reason to fail others
+		if entry.err != nil && ctx.Err() == nil {
+			entry.retryAt = time.Now().Add(graphClientErrorTTL)
+		}
+		close(entry.ready)
 	}
 
-	if microsoft365Config.ClientSecret != nil {
-		clientSecret = *microsoft365Config.ClientSecret
-	} else {
-		clientSecret = os.Getenv("AZURE_CLIENT_SECRET")
+	if entry.err != nil {
+		if tenantID := getMatrixTenantID(ctx); tenantID != "" {
+			return nil, &TenantError{TenantID: tenantID, Err: entry.err}
+		}
+		return nil, entry.err
 	}
+	return entry.client, nil
+}
 
-	// 2. Client certificate credentials
-	if microsoft365Config.CertificatePath != nil {
-		certificatePath = *microsoft365Config.CertificatePath
-	} else {
-		certificatePath = os.Getenv("AZURE_CERTIFICATE_PATH")
+func graphClientCacheKey(connection *plugin.Connection, microsoft365Config microsoft365Config) (string, error) {
+	var connectionName string
+	if connection != nil {
+		connectionName = connection.Name
 	}
 
-	if microsoft365Config.CertificatePassword != nil {
-		certificatePassword = *microsoft365Config.CertificatePassword
-	} else {
-		certificatePassword = os.Getenv("AZURE_CERTIFICATE_PASSWORD")
+	configJSON, err := json.Marshal(microsoft365Config)
+	if err != nil {
+		return "", fmt.Errorf("error building client cache key: %v", err)
 	}
+	hash := sha256.Sum256(configJSON)
 
-	var cloudConfiguration cloud.Configuration
-	switch environment {
-	case "AZURECHINACLOUD":
-		cloudConfiguration = cloud.AzureChina
-	case "AZUREUSGOVERNMENTCLOUD":
-		cloudConfiguration = cloud.AzureGovernment
-	default:
-		cloudConfiguration = cloud.AzurePublic
+	return fmt.Sprintf("%s/%x", connectionName, hash), nil
+}
+
+func newGraphClient(ctx context.Context, microsoft365Config microsoft365Config) (*graphClient, error) {
+	logger := plugin.Logger(ctx)
+
+	replay, err := getReplayOptions(microsoft365Config)
+	if err != nil {
+		logger.Error("newGraphClient", "replay_error", err)
+		return nil, err
+	}
+
+	// In replay mode no token is requested, so credentials aren't needed
+	credentials := getCredentials(microsoft365Config)
+	if replay.Mode == ReplayModeReplay {
+		credentials.AuthMethod = ReplayModeReplay
+	} else if err := credentials.validate(); err != nil {
+		logger.Error("newGraphClient", "config_error", err)
+		return nil, err
+	}
+
+	endpoints, err := getCloudEndpointsFromConfig(microsoft365Config)
+	if err != nil {
+		logger.Error("newGraphClient", "cloud_endpoints_error", err)
+		return nil, err
+	}
+
+	transport, err := newHTTPTransport(microsoft365Config)
+	if err != nil {
+		logger.Error("newGraphClient", "transport_error", err)
+		return nil, err
+	}
+
+	throttlingOptions, err := getThrottlingOptions(microsoft365Config)
+	if err != nil {
+		logger.Error("newGraphClient", "throttling_error", err)
+		return nil, err
+	}
+	throttling := newThrottlingHandler(throttlingOptions, logger)
+
+	// The tenants of a multi-tenant connection answer the same requests
+	// differently, so each is recorded in its own directory
+	tenantID := getMatrixTenantID(ctx)
+	if replay.Mode != "" && tenantID != "" {
+		replay.Dir = filepath.Join(replay.Dir, tenantID)
 	}
 
 	var cred azcore.TokenCredential
-	var err error
-	if tenantID == "" { // CLI authentication
-		cred, err = azidentity.NewAzureCLICredential(
-			&azidentity.AzureCLICredentialOptions{},
-		)
-		if err != nil {
-			logger.Error("GetGraphClient", "credential_error", err)
-			return nil, nil, fmt.Errorf("error creating credentials: %w", err)
-		}
-	} else if tenantID != "" && clientID != "" && clientSecret != "" { // Client secret authentication
-		cred, err = azidentity.NewClientSecretCredential(
-			tenantID,
-			clientID,
-			clientSecret,
-			&azidentity.ClientSecretCredentialOptions{
-				ClientOptions: policy.ClientOptions{
-					Cloud: cloudConfiguration,
-				},
-			},
-		)
+	if replay.Mode == ReplayModeReplay {
+		cred, err = newReplayCredential(replay.Dir)
 		if err != nil {
-			logger.Error("GetGraphClient", "credential_error", err)
-			return nil, nil, fmt.Errorf("error creating credentials: %w", err)
+			logger.Error("newGraphClient", "replay_error", err)
+			return nil, err
 		}
-	} else if tenantID != "" && clientID != "" && certificatePath != "" { // Client certificate authentication
-		// Load certificate from given path
-		loadFile, err := os.ReadFile(certificatePath)
+	} else {
+		cred, err = newTokenCredential(ctx, credentials, endpoints, transport)
 		if err != nil {
-			return nil, nil, fmt.Errorf("error reading certificate from %s: %v", certificatePath, err)
+			logger.Error("newGraphClient", "credential_error", err, "auth_method", credentials.AuthMethod)
+			return nil, err
 		}
 
-		var certs []*x509.Certificate
-		var key crypto.PrivateKey
-		if certificatePassword == "" {
-			certs, key, err = azidentity.ParseCertificates(loadFile, nil)
-		} else {
-			certs, key, err = azidentity.ParseCertificates(loadFile, []byte(certificatePassword))
-		}
+		// Tokens are reused across hydrate calls until shortly before they expire
+		cred = newCachedTokenCredential(cred)
+	}
 
+	// In a multi-tenant connection, failing to get a token only skips the tenant
+	if tenantID != "" {
+		cred = &tenantCredential{TokenCredential: cred, tenantID: tenantID}
+	}
+
+	if replay.Mode != "" {
+		transport.replay, err = newReplayTransport(replay, transport.transport)
 		if err != nil {
-			return nil, nil, fmt.Errorf("error parsing certificate from %s: %v", certificatePath, err)
+			logger.Error("newGraphClient", "replay_error", err)
+			return nil, err
 		}
+	}
 
-		cred, err = azidentity.NewClientCertificateCredential(
-			tenantID,
-			clientID,
-			certs,
-			key,
-			&azidentity.ClientCertificateCredentialOptions{
-				ClientOptions: policy.ClientOptions{
-					Cloud: cloudConfiguration,
-				},
-			},
-		)
-		if err != nil {
-			logger.Error("GetGraphClient", "client_certificate_credential_error", err)
-			return nil, nil, err
+	adapter, err := newGraphRequestAdapter(cred, endpoints, transport.graphClient(throttling))
+	if err != nil {
+		return nil, err
+	}
+	client := msgraphsdkgo.NewGraphServiceClient(adapter)
+
+	return &graphClient{
+		client:     client,
+		adapter:    adapter,
+		cred:       cred,
+		throttling: throttling,
+		batcher:    newGraphBatcher(adapter, throttling, logger),
+		authMethod: credentials.AuthMethod,
+		scopes:     endpoints.scopes(),
+	}, nil
+}
+
+// cloudEndpoints holds the authority host and Microsoft Graph endpoint of a
+// national cloud.
+type cloudEndpoints struct {
+	Cloud         cloud.Configuration
+	GraphEndpoint string
+
+	// CustomAuthority is set when authority_host overrides the cloud default.
+	// Instance discovery only knows the Microsoft hosted authorities, so it is
+	// disabled for custom ones such as a local stand-in token endpoint.
+	CustomAuthority bool
+}
+
+// https://learn.microsoft.com/en-us/graph/deployments#microsoft-graph-and-graph-explorer-service-root-endpoints
+var graphEndpoints = map[string]string{
+	"AZUREPUBLICCLOUD":          "https://graph.microsoft.com",
+	"AZURECHINACLOUD":           "https://microsoftgraph.chinacloudapi.cn",
+	"AZUREUSGOVERNMENTCLOUD":    "https://graph.microsoft.us",
+	"AZUREUSGOVERNMENTCLOUDDOD": "https://dod-graph.microsoft.us",
+}
+
+// scopes returns the token scopes for the Graph endpoint.
+func (e cloudEndpoints) scopes() []string {
+	return []string{e.GraphEndpoint + "/.default"}
+}
+
+// getCloudEndpointsFromConfig resolves the cloud endpoints from the connection
+// config, falling back to the standard Azure environment variables.
+func getCloudEndpointsFromConfig(microsoft365Config microsoft365Config) (cloudEndpoints, error) {
+	var environment, graphEndpoint, authorityHost string
+	if microsoft365Config.Environment != nil {
+		environment = *microsoft365Config.Environment
+	} else {
+		environment = os.Getenv("AZURE_ENVIRONMENT")
+	}
+
+	if microsoft365Config.GraphEndpoint != nil {
+		graphEndpoint = *microsoft365Config.GraphEndpoint
+	}
+
+	if microsoft365Config.AuthorityHost != nil {
+		authorityHost = *microsoft365Config.AuthorityHost
+	} else {
+		authorityHost = os.Getenv("AZURE_AUTHORITY_HOST")
+	}
+
+	return getCloudEndpoints(environment, graphEndpoint, authorityHost)
+}
+
+// getCloudEndpoints resolves the authority host and Graph endpoint for the
+// given environment. The graph_endpoint and authority_host overrides take
+// precedence over the environment defaults, e.g. to target a local stand-in.
+func getCloudEndpoints(environment, graphEndpoint, authorityHost string) (cloudEndpoints, error) {
+	environment = strings.ToUpper(environment)
+	if environment == "" {
+		environment = "AZUREPUBLICCLOUD"
+	}
+
+	var endpoints cloudEndpoints
+	switch environment {
+	case "AZURECHINACLOUD":
+		endpoints.Cloud = cloud.AzureChina
+	case "AZUREUSGOVERNMENTCLOUD", "AZUREUSGOVERNMENTCLOUDDOD":
+		endpoints.Cloud = cloud.AzureGovernment
+	case "AZUREPUBLICCLOUD":
+		endpoints.Cloud = cloud.AzurePublic
+	default:
+		return endpoints, fmt.Errorf("invalid environment %q, valid environments are \"AZUREPUBLICCLOUD\", \"AZURECHINACLOUD\", \"AZUREUSGOVERNMENTCLOUD\" and \"AZUREUSGOVERNMENTCLOUDDOD\"", environment)
+	}
+	endpoints.GraphEndpoint = graphEndpoints[environment]
+
+	if authorityHost != "" {
+		if _, err := url.ParseRequestURI(authorityHost); err != nil {
+			return endpoints, fmt.Errorf("invalid authority_host %q: %v", authorityHost, err)
 		}
-	} else if enableMSI { // Managed identity authentication
-		cred, err = azidentity.NewManagedIdentityCredential(
-			&azidentity.ManagedIdentityCredentialOptions{},
-		)
-		if err != nil {
-			logger.Error("GetGraphClient", "managed_identity_credential_error", err)
-			return nil, nil, err
+		endpoints.Cloud = cloud.Configuration{
+			ActiveDirectoryAuthorityHost: authorityHost,
+			Services:                     map[cloud.ServiceName]cloud.ServiceConfiguration{},
 		}
+		endpoints.CustomAuthority = true
 	}
 
-	auth, err := a.NewAzureIdentityAuthenticationProvider(cred)
+	if graphEndpoint != "" {
+		if _, err := url.ParseRequestURI(graphEndpoint); err != nil {
+			return endpoints, fmt.Errorf("invalid graph_endpoint %q: %v", graphEndpoint, err)
+		}
+		endpoints.GraphEndpoint = graphEndpoint
+	}
+	endpoints.GraphEndpoint = strings.TrimSuffix(endpoints.GraphEndpoint, "/")
+
+	return endpoints, nil
+}
+
+// newGraphRequestAdapter creates a request adapter that sends requests, and
+// requests tokens, for the Graph endpoint of the given cloud through the HTTP
+// client.
+func newGraphRequestAdapter(cred azcore.TokenCredential, endpoints cloudEndpoints, httpClient *http.Client) (*msgraphsdkgo.GraphRequestAdapter, error) {
+	graphURL, err := url.Parse(endpoints.GraphEndpoint)
 	if err != nil {
-		return nil, nil, fmt.Errorf("error creating authentication provider: %v", err)
+		return nil, fmt.Errorf("error parsing graph endpoint %s: %v", endpoints.GraphEndpoint, err)
 	}
 
-	adapter, err := msgraphsdkgo.NewGraphRequestAdapter(auth)
+	// The valid hosts are compared without the port, so a graph_endpoint with
+	// one, e.g. a local stand-in, still gets tokens
+	auth, err := a.NewAzureIdentityAuthenticationProviderWithScopesAndValidHosts(
+		cred,
+		endpoints.scopes(),
+		[]string{graphURL.Hostname()},
+	)
 	if err != nil {
-		return nil, nil, fmt.Errorf("error creating graph adapter: %v", err)
+		return nil, fmt.Errorf("error creating authentication provider: %v", err)
 	}
-	client := msgraphsdkgo.NewGraphServiceClient(adapter)
 
-	// See comment above as to why caching is disabled
-	// Save session into cache
-	//d.ConnectionManager.Cache.Set(sessionCacheKey, client)
+	adapter, err := msgraphsdkgo.NewGraphRequestAdapterWithParseNodeFactoryAndSerializationWriterFactoryAndHttpClient(auth, nil, nil, httpClient)
+	if err != nil {
+		return nil, fmt.Errorf("error creating graph adapter: %v", err)
+	}
+	adapter.SetBaseUrl(endpoints.GraphEndpoint + "/v1.0")
 
-	return client, adapter, nil
+	return adapter, nil
 }
@@ -0,0 +1,252 @@
+package microsoft365
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/hashicorp/go-hclog"
+	msgraphcore "github.com/microsoftgraph/msgraph-sdk-go-core"
+	"github.com/microsoftgraph/msgraph-sdk-go/models"
+	"github.com/turbot/steampipe-plugin-sdk/v5/plugin"
+	"github.com/turbot/steampipe-plugin-sdk/v5/plugin/context_key"
+)
+
+func testContext() context.Context {
+	return context.WithValue(context.Background(), context_key.Logger, hclog.NewNullLogger())
+}
+
+func testQueryData(connectionName string, config microsoft365Config) *plugin.QueryData {
+	return &plugin.QueryData{
+		Connection: &plugin.Connection{Name: connectionName, Config: config},
+	}
+}
+
+func testClientSecretConfig() microsoft365Config {
+	return microsoft365Config{
+		TenantID:     StringPtr("00000000-0000-0000-0000-000000000000"),
+		ClientID:     StringPtr("11111111-1111-1111-1111-111111111111"),
+		ClientSecret: StringPtr("secret"),
+	}
+}
+
+// Consecutive queries against microsoft365_mail_message and
+// microsoft365_my_mail_message used to panic when the cached client was
+// returned without its request adapter.
+func TestGetGraphClientConsecutiveMailQueries(t *testing.T) {
+	ctx := testContext()
+	d := testQueryData("test_consecutive_mail", testClientSecretConfig())
+
+	// microsoft365_mail_message
+	client, adapter, err := GetGraphClient(ctx, d)
+	if err != nil {
+		t.Fatalf("GetGraphClient() error = %v", err)
+	}
+	if client == nil || adapter == nil {
+		t.Fatalf("GetGraphClient() returned client %v, adapter %v", client, adapter)
+	}
+
+	// microsoft365_my_mail_message
+	cachedClient, cachedAdapter, err := GetGraphClient(ctx, d)
+	if err != nil {
+		t.Fatalf("GetGraphClient() error = %v", err)
+	}
+	if cachedAdapter == nil {
+		t.Fatal("GetGraphClient() returned a cached client without its adapter")
+	}
+	if cachedClient != client || cachedAdapter != adapter {
+		t.Error("GetGraphClient() did not reuse the pooled client")
+	}
+
+	messages := models.NewMessageCollectionResponse()
+	messages.SetValue([]models.Messageable{models.NewMessage(), models.NewMessage()})
+
+	pageIterator, err := msgraphcore.NewPageIterator[models.Messageable](messages, cachedAdapter, models.CreateMessageCollectionResponseFromDiscriminatorValue)
+	if err != nil {
+		t.Fatalf("NewPageIterator() error = %v", err)
+	}
+
+	var count int
+	err = pageIterator.Iterate(ctx, func(pageItem models.Messageable) bool {
+		count++
+		return true
+	})
+	if err != nil {
+		t.Fatalf("Iterate() error = %v", err)
+	}
+	if count != 2 {
+		t.Errorf("Iterate() returned %d messages, want 2", count)
+	}
+}
+
+func TestGetGraphClientConcurrent(t *testing.T) {
+	ctx := testContext()
+	d := testQueryData("test_concurrent", testClientSecretConfig())
+
+	const workers = 50
+	clients := make([]*graphClient, workers)
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			c, err := getGraphClient(ctx, d)
+			if err != nil {
+				t.Errorf("getGraphClient() error = %v", err)
+				return
+			}
+			clients[i] = c
+		}(i)
+	}
+	wg.Wait()
+
+	for i := 1; i < workers; i++ {
+		if clients[i] != clients[0] {
+			t.Fatal("concurrent getGraphClient() calls created more than one client")
+		}
+	}
+}
+
+// A client being built holds up only the hydrate calls of its own
+// connection.
+func TestGetGraphClientBuildsPerConnection(t *testing.T) {
+	building := testQueryData("test_building", testClientSecretConfig())
+	key, err := graphClientCacheKey(building.Connection, testClientSecretConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry := &graphClientEntry{ready: make(chan struct{})}
+	graphClientPool.Lock()
+	graphClientPool.clients[key] = entry
+	graphClientPool.Unlock()
+
+	ctx, cancel := context.WithTimeout(testContext(), 5*time.Second)
+	defer cancel()
+	if _, err := getGraphClient(ctx, testQueryData("test_not_building", testClientSecretConfig())); err != nil {
+		t.Fatalf("getGraphClient() error = %v", err)
+	}
+
+	waitCtx, waitCancel := context.WithTimeout(testContext(), 50*time.Millisecond)
+	defer waitCancel()
+	if _, err := getGraphClient(waitCtx, building); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("getGraphClient() error = %v, want it to wait for the client being built", err)
+	}
+
+	entry.client = &graphClient{}
+	close(entry.ready)
+	if c, err := getGraphClient(ctx, building); err != nil || c != entry.client {
+		t.Errorf("getGraphClient() = %p, %v, want the built client", c, err)
+	}
+}
+
+// An error building a client is returned without building it again, until
+// graphClientErrorTTL has passed.
+func TestGetGraphClientCachesErrors(t *testing.T) {
+	ctx := testContext()
+	config := testClientSecretConfig()
+	config.AuthMethod = StringPtr("unknown")
+	d := testQueryData("test_client_error", config)
+
+	first, err := getGraphClient(ctx, d)
+	if err == nil {
+		t.Fatalf("getGraphClient() = %v, want an error for the invalid auth_method", first)
+	}
+	if _, again := getGraphClient(ctx, d); again != err {
+		t.Errorf("getGraphClient() error = %v, want the cached error %v", again, err)
+	}
+
+	key, _ := graphClientCacheKey(d.Connection, config)
+	graphClientPool.Lock()
+	graphClientPool.clients[key].retryAt = time.Now()
+	graphClientPool.Unlock()
+	if _, rebuilt := getGraphClient(ctx, d); rebuilt == nil || rebuilt == err {
+		t.Errorf("getGraphClient() error = %v, want a new error after the TTL", rebuilt)
+	}
+}
+
+func TestGetGraphClientConfigChange(t *testing.T) {
+	ctx := testContext()
+
+	config := testClientSecretConfig()
+	first, err := getGraphClient(ctx, testQueryData("test_config_change", config))
+	if err != nil {
+		t.Fatalf("getGraphClient() error = %v", err)
+	}
+
+	config.ClientSecret = StringPtr("rotated")
+	second, err := getGraphClient(ctx, testQueryData("test_config_change", config))
+	if err != nil {
+		t.Fatalf("getGraphClient() error = %v", err)
+	}
+
+	if first == second {
+		t.Error("getGraphClient() reused the client after the connection config changed")
+	}
+
+	// The client of the old config is dropped from the pool
+	oldKey, _ := graphClientCacheKey(&plugin.Connection{Name: "test_config_change"}, testClientSecretConfig())
+	graphClientPool.Lock()
+	_, ok := graphClientPool.clients[oldKey]
+	graphClientPool.Unlock()
+	if ok {
+		t.Error("the client of the old connection config is still pooled")
+	}
+}
+
+type countingCredential struct {
+	calls   atomic.Int32
+	expires time.Duration
+}
+
+func (c *countingCredential) GetToken(ctx context.Context, opts policy.TokenRequestOptions) (azcore.AccessToken, error) {
+	c.calls.Add(1)
+	return azcore.AccessToken{Token: "token", ExpiresOn: time.Now().Add(c.expires)}, nil
+}
+
+func TestCachedTokenCredential(t *testing.T) {
+	ctx := testContext()
+	opts := policy.TokenRequestOptions{Scopes: []string{"https://graph.microsoft.com/.default"}}
+
+	inner := &countingCredential{expires: time.Hour}
+	cred := newCachedTokenCredential(inner)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := cred.GetToken(ctx, opts); err != nil {
+				t.Errorf("GetToken() error = %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if got := inner.calls.Load(); got != 1 {
+		t.Errorf("wrapped credential called %d times, want 1", got)
+	}
+
+	if _, err := cred.GetToken(ctx, policy.TokenRequestOptions{Scopes: opts.Scopes, Claims: "challenge"}); err != nil {
+		t.Fatalf("GetToken() error = %v", err)
+	}
+	if got := inner.calls.Load(); got != 2 {
+		t.Errorf("claims challenge was served from the cache")
+	}
+
+	expiring := &countingCredential{expires: time.Minute}
+	cred = newCachedTokenCredential(expiring)
+	for i := 0; i < 2; i++ {
+		if _, err := cred.GetToken(ctx, opts); err != nil {
+			t.Fatalf("GetToken() error = %v", err)
+		}
+	}
+	if got := expiring.calls.Load(); got != 2 {
+		t.Errorf("token about to expire was reused, wrapped credential called %d times, want 2", got)
+	}
+}
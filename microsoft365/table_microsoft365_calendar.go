@@ -46,8 +46,9 @@ func calendarColumns() []*plugin.Column {
 
 func tableMicrosoft365Calendar(_ context.Context) *plugin.Table {
 	return &plugin.Table{
-		Name:        "microsoft365_calendar",
-		Description: "Metadata of the specified user's calendar.",
+		Name:              "microsoft365_calendar",
+		Description:       "Metadata of the specified user's calendar.",
+		GetMatrixItemFunc: tenantMatrix,
 		List: &plugin.ListConfig{
 			ParentHydrate: listMicrosoft365CalendarGroups,
 			Hydrate:       listMicrosoft365Calendars,
@@ -58,14 +59,14 @@ func tableMicrosoft365Calendar(_ context.Context) *plugin.Table {
 				},
 			},
 			IgnoreConfig: &plugin.IgnoreConfig{
-				ShouldIgnoreErrorFunc: isIgnorableErrorPredicate([]string{"ErrorItemNotFound"}),
+				ShouldIgnoreErrorFunc: isIgnorableErrorPredicate([]ErrorCategory{ErrorCategoryNotFound}),
 			},
 		},
 		Get: &plugin.GetConfig{
 			Hydrate:    getMicrosoft365Calendar,
 			KeyColumns: plugin.AllColumns([]string{"user_id", "calendar_group_id", "id"}),
 			IgnoreConfig: &plugin.IgnoreConfig{
-				ShouldIgnoreErrorFunc: isIgnorableErrorPredicate([]string{"ErrorItemNotFound"}),
+				ShouldIgnoreErrorFunc: isIgnorableErrorPredicate([]ErrorCategory{ErrorCategoryNotFound}),
 			},
 		},
 		Columns: calendarColumns(),
@@ -164,14 +165,21 @@ func listMicrosoft365CalendarPermissions(ctx context.Context, d *plugin.QueryDat
 	calendarData := h.Item.(*Microsoft365CalendarInfo)
 
 	// Create client
-	client, adapter, err := GetGraphClient(ctx, d)
+	client, err := getGraphClient(ctx, d)
 	if err != nil {
 		logger.Error("microsoft365_calendar.listMicrosoft365CalendarPermissions", "connection_error", err)
 		return nil, err
 	}
+	adapter := client.adapter
 
+	// The first page is batched with those of other calendars
 	var permissions []map[string]interface{}
-	result, err := client.Users().ByUserId(calendarData.UserID).CalendarGroups().ByCalendarGroupId(calendarData.CalendarGroupID).Calendars().ByCalendarId(*calendarData.GetId()).CalendarPermissions().Get(ctx, nil)
+	request, err := client.client.Users().ByUserId(calendarData.UserID).CalendarGroups().ByCalendarGroupId(calendarData.CalendarGroupID).Calendars().ByCalendarId(*calendarData.GetId()).CalendarPermissions().ToGetRequestInformation(ctx, nil)
+	if err != nil {
+		logger.Error("microsoft365_calendar.listMicrosoft365CalendarPermissions", "request_error", err)
+		return nil, err
+	}
+	result, err := batchGet[models.CalendarPermissionCollectionResponseable](ctx, client, request, models.CreateCalendarPermissionCollectionResponseFromDiscriminatorValue)
 	if err != nil {
 		errObj := getErrorObject(err)
 		return nil, errObj
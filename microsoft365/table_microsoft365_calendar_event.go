@@ -15,6 +15,18 @@ import (
 
 //// TABLE DEFINITION
 
+// calendarEventSelect maps the event columns to the properties to $select
+var calendarEventSelect = odataSelect{
+	Properties: map[string][]string{
+		"title":      {"subject"},
+		"start_time": {"start"},
+		"end_time":   {"end"},
+		"ical_uid":   {"iCalUId"},
+		"user_id":    nil,
+	},
+	Required: []string{"id"},
+}
+
 func calendarEventColumns() []*plugin.Column {
 	return commonColumns([]*plugin.Column{
 		{Name: "subject", Type: proto.ColumnType_STRING, Description: "The text of the event's subject line.", Transform: transform.FromMethod("GetSubject")},
@@ -71,8 +83,9 @@ func calendarEventColumns() []*plugin.Column {
 
 func tableMicrosoft365CalendarEvent(_ context.Context) *plugin.Table {
 	return &plugin.Table{
-		Name:        "microsoft365_calendar_event",
-		Description: "Events scheduled on the specified calendar.",
+		Name:              "microsoft365_calendar_event",
+		Description:       "Events scheduled on the specified calendar.",
+		GetMatrixItemFunc: tenantMatrix,
 		List: &plugin.ListConfig{
 			Hydrate: listMicrosoft365CalendarEvents,
 			KeyColumns: []*plugin.KeyColumn{
@@ -92,14 +105,14 @@ func tableMicrosoft365CalendarEvent(_ context.Context) *plugin.Table {
 				},
 			},
 			IgnoreConfig: &plugin.IgnoreConfig{
-				ShouldIgnoreErrorFunc: isIgnorableErrorPredicate([]string{"ErrorItemNotFound", "UnsupportedQueryOption"}),
+				ShouldIgnoreErrorFunc: isIgnorableErrorPredicate([]ErrorCategory{ErrorCategoryNotFound}, "UnsupportedQueryOption"),
 			},
 		},
 		Get: &plugin.GetConfig{
 			Hydrate:    getMicrosoft365CalendarEvent,
 			KeyColumns: plugin.AllColumns([]string{"user_id", "id"}),
 			IgnoreConfig: &plugin.IgnoreConfig{
-				ShouldIgnoreErrorFunc: isIgnorableErrorPredicate([]string{"ErrorItemNotFound"}),
+				ShouldIgnoreErrorFunc: isIgnorableErrorPredicate([]ErrorCategory{ErrorCategoryNotFound}),
 			},
 		},
 		Columns: calendarEventColumns(),
@@ -130,6 +143,9 @@ func listMicrosoft365CalendarEvents(ctx context.Context, d *plugin.QueryData, _
 	}
 	input.Top = Int32(int32(pageSize))
 
+	// Request only the properties of the queried columns
+	input.Select, input.Expand = calendarEventSelect.buildListSelect(d)
+
 	var result models.EventCollectionResponseable
 
 	// Filter event using timestamp
@@ -198,6 +214,9 @@ func listMicrosoft365CalendarEvents(ctx context.Context, d *plugin.QueryData, _
 		}
 		input.Top = Int32(int32(pageSize))
 
+		// Request only the properties of the queried columns
+		input.Select, input.Expand = calendarEventSelect.buildListSelect(d)
+
 		options := &users.ItemEventsRequestBuilderGetRequestConfiguration{
 			QueryParameters: input,
 		}
@@ -249,7 +268,16 @@ func getMicrosoft365CalendarEvent(ctx context.Context, d *plugin.QueryData, _ *p
 	}
 	userID := d.EqualsQuals["user_id"].GetStringValue()
 
-	result, err := client.Users().ByUserId(userID).Events().ByEventId(eventID).Get(ctx, nil)
+	input := &users.ItemEventsEventItemRequestBuilderGetQueryParameters{}
+
+	// Request only the properties of the queried columns
+	input.Select, input.Expand = calendarEventSelect.buildGetSelect(d)
+
+	options := &users.ItemEventsEventItemRequestBuilderGetRequestConfiguration{
+		QueryParameters: input,
+	}
+
+	result, err := client.Users().ByUserId(userID).Events().ByEventId(eventID).Get(ctx, options)
 	if err != nil {
 		errObj := getErrorObject(err)
 		return nil, errObj
@@ -29,8 +29,9 @@ func calendarGroupColumns() []*plugin.Column {
 
 func tableMicrosoft365CalendarGroup(_ context.Context) *plugin.Table {
 	return &plugin.Table{
-		Name:        "microsoft365_calendar_group",
-		Description: "List all the calendar groups of specified user.",
+		Name:              "microsoft365_calendar_group",
+		Description:       "List all the calendar groups of specified user.",
+		GetMatrixItemFunc: tenantMatrix,
 		List: &plugin.ListConfig{
 			Hydrate: listMicrosoft365CalendarGroups,
 			KeyColumns: []*plugin.KeyColumn{
@@ -40,14 +41,14 @@ func tableMicrosoft365CalendarGroup(_ context.Context) *plugin.Table {
 				},
 			},
 			IgnoreConfig: &plugin.IgnoreConfig{
-				ShouldIgnoreErrorFunc: isIgnorableErrorPredicate([]string{"ResourceNotFound"}),
+				ShouldIgnoreErrorFunc: isIgnorableErrorPredicate([]ErrorCategory{ErrorCategoryNotFound}),
 			},
 		},
 		Get: &plugin.GetConfig{
 			Hydrate:    getMicrosoft365CalendarGroup,
 			KeyColumns: plugin.AllColumns([]string{"id", "user_id"}),
 			IgnoreConfig: &plugin.IgnoreConfig{
-				ShouldIgnoreErrorFunc: isIgnorableErrorPredicate([]string{"ResourceNotFound"}),
+				ShouldIgnoreErrorFunc: isIgnorableErrorPredicate([]ErrorCategory{ErrorCategoryNotFound}),
 			},
 		},
 		Columns: calendarGroupColumns(),
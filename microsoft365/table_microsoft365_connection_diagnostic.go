@@ -0,0 +1,141 @@
+package microsoft365
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/turbot/steampipe-plugin-sdk/v5/grpc/proto"
+	"github.com/turbot/steampipe-plugin-sdk/v5/plugin"
+	"github.com/turbot/steampipe-plugin-sdk/v5/plugin/transform"
+)
+
+//// TABLE DEFINITION
+
+func tableMicrosoft365ConnectionDiagnostic(_ context.Context) *plugin.Table {
+	return &plugin.Table{
+		Name:              "microsoft365_connection_diagnostic",
+		Description:       "Diagnoses the connection's credentials, and the Microsoft Graph permissions each table needs but isn't granted.",
+		GetMatrixItemFunc: tenantMatrix,
+		List: &plugin.ListConfig{
+			Hydrate: listMicrosoft365ConnectionDiagnostics,
+		},
+		Columns: commonColumns([]*plugin.Column{
+			{Name: "table_name", Type: proto.ColumnType_STRING, Description: "The name of the table."},
+			{Name: "is_usable", Type: proto.ColumnType_BOOL, Description: "True if the access token grants every permission the table requires."},
+			{Name: "auth_method", Type: proto.ColumnType_STRING, Description: "The authentication method the connection uses, e.g. client_secret or cli."},
+			{Name: "token_tenant_id", Type: proto.ColumnType_STRING, Description: "The tenant the access token was issued by.", Transform: transform.FromField("TokenTenantID")},
+			{Name: "app_id", Type: proto.ColumnType_STRING, Description: "The client ID of the application the access token was issued to.", Transform: transform.FromField("AppID")},
+			{Name: "token_type", Type: proto.ColumnType_STRING, Description: "The type of the access token, delegated (signed-in user) or application (app-only)."},
+			{Name: "user_principal_name", Type: proto.ColumnType_STRING, Description: "The signed-in user of a delegated access token."},
+			{Name: "roles", Type: proto.ColumnType_JSON, Description: "The application permissions granted to an app-only access token."},
+			{Name: "scopes", Type: proto.ColumnType_JSON, Description: "The delegated permissions granted to a delegated access token."},
+			{Name: "expires_on", Type: proto.ColumnType_TIMESTAMP, Description: "The time the access token expires."},
+			{Name: "required_permissions", Type: proto.ColumnType_JSON, Description: "The least privileged permissions the table requires for the token type."},
+			{Name: "missing_permissions", Type: proto.ColumnType_JSON, Description: "The required permissions that aren't granted to the access token."},
+			{Name: "optional_permissions", Type: proto.ColumnType_JSON, Description: "The permissions only some columns of the table require."},
+			{Name: "missing_optional_permissions", Type: proto.ColumnType_JSON, Description: "The optional permissions that aren't granted to the access token. The columns that need them are empty."},
+			{Name: "request_metrics", Type: proto.ColumnType_JSON, Description: "The number of requests sent, throttled and retried for each Microsoft Graph workload since the connection's client was created."},
+			{Name: "note", Type: proto.ColumnType_STRING, Description: "Additional information on whether the table is usable."},
+			{Name: "error", Type: proto.ColumnType_STRING, Description: "The error returned when creating the client or getting an access token, if any."},
+		}),
+	}
+}
+
+//// LIST FUNCTION
+
+func listMicrosoft365ConnectionDiagnostics(ctx context.Context, d *plugin.QueryData, h *plugin.HydrateData) (interface{}, error) {
+	logger := plugin.Logger(ctx)
+
+	tableNames := make([]string, 0, len(d.Table.Plugin.TableMap))
+	for name := range d.Table.Plugin.TableMap {
+		tableNames = append(tableNames, name)
+	}
+	sort.Strings(tableNames)
+
+	var diagnostic Microsoft365ConnectionDiagnosticInfo
+	var claims *accessTokenClaims
+
+	// Failures are reported in the error column rather than failing the query,
+	// since diagnosing them is the point of the table
+	client, err := getGraphClient(ctx, d)
+	if err == nil {
+		diagnostic.AuthMethod = client.authMethod
+		diagnostic.RequestMetrics = client.throttling.getMetrics()
+		claims, err = client.getAccessTokenClaims(ctx)
+	}
+	if err != nil {
+		logger.Warn("microsoft365_connection_diagnostic.listMicrosoft365ConnectionDiagnostics", "connection_error", err)
+		diagnostic.Error = err.Error()
+	}
+
+	granted := map[string]bool{}
+	if claims != nil {
+		diagnostic.TokenTenantID = claims.TenantID
+		diagnostic.AppID = claims.GetAppID()
+		diagnostic.Roles = claims.Roles
+		diagnostic.Scopes = claims.GetScopes()
+		expiresOn := claims.GetExpiresOn()
+		diagnostic.ExpiresOn = &expiresOn
+
+		permissions := diagnostic.Roles
+		diagnostic.TokenType = "application"
+		if claims.IsDelegated() {
+			permissions = diagnostic.Scopes
+			diagnostic.TokenType = "delegated"
+			diagnostic.UserPrincipalName = claims.UserPrincipalName
+			if diagnostic.UserPrincipalName == "" {
+				diagnostic.UserPrincipalName = claims.PreferredUsername
+			}
+		}
+		for _, permission := range permissions {
+			granted[permission] = true
+		}
+	}
+
+	for _, tableName := range tableNames {
+		row := diagnostic
+		row.TableName = tableName
+		row.diagnoseTable(ctx, d, claims, granted)
+
+		d.StreamListItem(ctx, &row)
+
+		// Context can be cancelled due to manual cancellation or the limit has been hit
+		if d.RowsRemaining(ctx) == 0 {
+			return nil, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// diagnoseTable compares the permissions the table needs with those granted
+// to the access token.
+func (r *Microsoft365ConnectionDiagnosticInfo) diagnoseTable(ctx context.Context, d *plugin.QueryData, claims *accessTokenClaims, granted map[string]bool) {
+	permission, ok := tablePermissions[r.TableName]
+	if !ok {
+		r.Note = "The permissions this table requires are unknown."
+		return
+	}
+	if claims == nil {
+		r.Note = "No access token could be acquired, see the error column."
+		return
+	}
+
+	delegated := claims.IsDelegated()
+	r.RequiredPermissions = permission.getRequired(delegated)
+	r.MissingPermissions = getMissingPermissions(r.RequiredPermissions, granted)
+	r.OptionalPermissions = permission.getOptional(delegated)
+	r.MissingOptionalPermissions = getMissingPermissions(r.OptionalPermissions, granted)
+	r.IsUsable = len(r.MissingPermissions) == 0
+
+	switch {
+	case permission.Me && !delegated && getUserFromConfig(ctx, d, nil) == "":
+		r.IsUsable = false
+		r.Note = fmt.Sprintf("The access token has no signed-in user; set user_id in the connection config to query this table with auth_method %q.", r.AuthMethod)
+	case len(r.MissingPermissions) > 0:
+		r.Note = "Grant the missing permissions to the application, and admin consent to them, to use this table."
+	case delegated:
+		r.Note = "The signed-in user's own roles may still limit the results."
+	}
+}
@@ -12,6 +12,15 @@ import (
 	"github.com/microsoftgraph/msgraph-sdk-go/users"
 )
 
+// contactSelect maps the contact columns to the properties to $select
+var contactSelect = odataSelect{
+	Properties: map[string][]string{
+		"title":   {"displayName"},
+		"user_id": nil,
+	},
+	Required: []string{"id"},
+}
+
 func contactColumns() []*plugin.Column {
 	return commonColumns([]*plugin.Column{
 		{Name: "display_name", Type: proto.ColumnType_STRING, Description: "The contact's display name.", Transform: transform.FromMethod("GetDisplayName")},
@@ -62,8 +71,9 @@ func contactColumns() []*plugin.Column {
 
 func tableMicrosoft365Contact(_ context.Context) *plugin.Table {
 	return &plugin.Table{
-		Name:        "microsoft365_contact",
-		Description: "Contacts owned by the specified user.",
+		Name:              "microsoft365_contact",
+		Description:       "Contacts owned by the specified user.",
+		GetMatrixItemFunc: tenantMatrix,
 		List: &plugin.ListConfig{
 			Hydrate: listMicrosoft365Contacts,
 			KeyColumns: plugin.KeyColumnSlice{
@@ -71,14 +81,14 @@ func tableMicrosoft365Contact(_ context.Context) *plugin.Table {
 				{Name: "user_id", Require: plugin.Required},
 			},
 			IgnoreConfig: &plugin.IgnoreConfig{
-				ShouldIgnoreErrorFunc: isIgnorableErrorPredicate([]string{"ErrorItemNotFound"}),
+				ShouldIgnoreErrorFunc: isIgnorableErrorPredicate([]ErrorCategory{ErrorCategoryNotFound}),
 			},
 		},
 		Get: &plugin.GetConfig{
 			Hydrate:    getMicrosoft365Contact,
 			KeyColumns: plugin.AllColumns([]string{"user_id", "id"}),
 			IgnoreConfig: &plugin.IgnoreConfig{
-				ShouldIgnoreErrorFunc: isIgnorableErrorPredicate([]string{"ErrorItemNotFound"}),
+				ShouldIgnoreErrorFunc: isIgnorableErrorPredicate([]ErrorCategory{ErrorCategoryNotFound}),
 			},
 		},
 		Columns: contactColumns(),
@@ -109,6 +119,9 @@ func listMicrosoft365Contacts(ctx context.Context, d *plugin.QueryData, _ *plugi
 	}
 	input.Top = Int32(int32(pageSize))
 
+	// Request only the properties of the queried columns
+	input.Select, input.Expand = contactSelect.buildListSelect(d)
+
 	options := &users.ItemContactsRequestBuilderGetRequestConfiguration{
 		QueryParameters: input,
 	}
@@ -160,7 +173,16 @@ func getMicrosoft365Contact(ctx context.Context, d *plugin.QueryData, _ *plugin.
 		return nil, err
 	}
 
-	result, err := client.Users().ByUserId(userID).Contacts().ByContactId(contactID).Get(ctx, nil)
+	input := &users.ItemContactsContactItemRequestBuilderGetQueryParameters{}
+
+	// Request only the properties of the queried columns
+	input.Select, input.Expand = contactSelect.buildGetSelect(d)
+
+	options := &users.ItemContactsContactItemRequestBuilderGetRequestConfiguration{
+		QueryParameters: input,
+	}
+
+	result, err := client.Users().ByUserId(userID).Contacts().ByContactId(contactID).Get(ctx, options)
 	if err != nil {
 		errObj := getErrorObject(err)
 		return nil, errObj
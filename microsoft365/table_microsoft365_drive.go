@@ -2,10 +2,8 @@ package microsoft365
 
 import (
 	"context"
-	"fmt"
 	"strings"
 
-	"github.com/iancoleman/strcase"
 	"github.com/turbot/steampipe-plugin-sdk/v5/grpc/proto"
 	"github.com/turbot/steampipe-plugin-sdk/v5/plugin"
 	"github.com/turbot/steampipe-plugin-sdk/v5/plugin/transform"
@@ -15,6 +13,19 @@ import (
 	"github.com/microsoftgraph/msgraph-sdk-go/users"
 )
 
+// driveSelect maps the drive columns to the properties to $select
+var driveSelect = odataSelect{
+	Properties: map[string][]string{
+		"title":          {"name"},
+		"etag":           {"eTag"},
+		"sharepoint_ids": {"sharePointIds"},
+		"filter":         nil,
+		"user_id":        nil,
+		"list":           nil,
+	},
+	Required: []string{"id"},
+}
+
 func driveColumns() []*plugin.Column {
 	return commonColumns([]*plugin.Column{
 		{Name: "name", Type: proto.ColumnType_STRING, Description: "The name of the item.", Transform: transform.FromMethod("GetName")},
@@ -49,28 +60,33 @@ func driveColumns() []*plugin.Column {
 
 //// TABLE DEFINITION
 
+// driveFilterColumns are the columns Graph filters drives on
+var driveFilterColumns = odataFilterColumns{
+	"id":         {Property: "id", Type: filterTypeString, Operators: equalOperators},
+	"name":       {Property: "name", Type: filterTypeString, Operators: equalOperators},
+	"drive_type": {Property: "driveType", Type: filterTypeString, Operators: equalOperators},
+}
+
 func tableMicrosoft365Drive(_ context.Context) *plugin.Table {
 	return &plugin.Table{
-		Name:        "microsoft365_drive",
-		Description: "Drives defined user's shared drives in the Google Drive.",
+		Name:              "microsoft365_drive",
+		Description:       "Drives defined user's shared drives in the Google Drive.",
+		GetMatrixItemFunc: tenantMatrix,
 		List: &plugin.ListConfig{
 			Hydrate: listMicrosoft365Drives,
-			KeyColumns: []*plugin.KeyColumn{
+			KeyColumns: append(plugin.KeyColumnSlice{
 				{Name: "user_id", Require: plugin.Required},
 				{Name: "filter", Require: plugin.Optional},
-				{Name: "id", Require: plugin.Optional},
-				{Name: "name", Require: plugin.Optional},
-				{Name: "drive_type", Require: plugin.Optional},
-			},
+			}, driveFilterColumns.keyColumns()...),
 			IgnoreConfig: &plugin.IgnoreConfig{
-				ShouldIgnoreErrorFunc: isIgnorableErrorPredicate([]string{"itemNotFound"}),
+				ShouldIgnoreErrorFunc: isIgnorableErrorPredicate([]ErrorCategory{ErrorCategoryNotFound}),
 			},
 		},
 		Get: &plugin.GetConfig{
 			Hydrate:    getMicrosoft365Drive,
 			KeyColumns: plugin.AllColumns([]string{"id", "user_id"}),
 			IgnoreConfig: &plugin.IgnoreConfig{
-				ShouldIgnoreErrorFunc: isIgnorableErrorPredicate([]string{"itemNotFound"}),
+				ShouldIgnoreErrorFunc: isIgnorableErrorPredicate([]ErrorCategory{ErrorCategoryNotFound}),
 			},
 		},
 		Columns: driveColumns(),
@@ -100,9 +116,12 @@ func listMicrosoft365Drives(ctx context.Context, d *plugin.QueryData, _ *plugin.
 	}
 	input.Top = Int32(int32(pageSize))
 
+	// Request only the properties of the queried columns
+	input.Select, input.Expand = driveSelect.buildListSelect(d)
+
 	var queryFilter string
 	equalQuals := d.EqualsQuals
-	filter := buildDriveQueryFilter(equalQuals)
+	filter := driveFilterColumns.buildFilter(d.Quals)
 
 	if equalQuals["filter"] != nil {
 		queryFilter = equalQuals["filter"].GetStringValue()
@@ -168,7 +187,16 @@ func getMicrosoft365Drive(ctx context.Context, d *plugin.QueryData, h *plugin.Hy
 		return nil, err
 	}
 
-	result, err := client.Users().ByUserId(userID).Drives().ByDriveId(driveID).Get(ctx, nil)
+	input := &users.ItemDrivesDriveItemRequestBuilderGetQueryParameters{}
+
+	// Request only the properties of the queried columns
+	input.Select, input.Expand = driveSelect.buildGetSelect(d)
+
+	options := &users.ItemDrivesDriveItemRequestBuilderGetRequestConfiguration{
+		QueryParameters: input,
+	}
+
+	result, err := client.Users().ByUserId(userID).Drives().ByDriveId(driveID).Get(ctx, options)
 	if err != nil {
 		errObj := getErrorObject(err)
 		return nil, errObj
@@ -176,23 +204,3 @@ func getMicrosoft365Drive(ctx context.Context, d *plugin.QueryData, h *plugin.Hy
 
 	return &Microsoft365DriveInfo{result, userID}, nil
 }
-
-func buildDriveQueryFilter(equalQuals plugin.KeyColumnEqualsQualMap) []string {
-	filters := []string{}
-
-	filterQuals := map[string]string{
-		"name":       "string",
-		"id":         "string",
-		"drive_type": "string",
-	}
-
-	for qual, qualType := range filterQuals {
-		switch qualType {
-		case "string":
-			if equalQuals[qual] != nil {
-				filters = append(filters, fmt.Sprintf("%s eq '%s'", strcase.ToCamel(qual), equalQuals[qual].GetStringValue()))
-			}
-		}
-	}
-	return filters
-}
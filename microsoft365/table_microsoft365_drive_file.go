@@ -43,21 +43,22 @@ func driveFileColumns() []*plugin.Column {
 
 func tableMicrosoft365DriveFile(_ context.Context) *plugin.Table {
 	return &plugin.Table{
-		Name:        "microsoft365_drive_file",
-		Description: "Retrieves file's metadata or content owned by an user.",
+		Name:              "microsoft365_drive_file",
+		Description:       "Retrieves file's metadata or content owned by an user.",
+		GetMatrixItemFunc: tenantMatrix,
 		List: &plugin.ListConfig{
 			Hydrate:       listMicrosoft365DriveFiles,
 			ParentHydrate: listMicrosoft365Drives,
 			KeyColumns:    plugin.SingleColumn("user_id"),
 			IgnoreConfig: &plugin.IgnoreConfig{
-				ShouldIgnoreErrorFunc: isIgnorableErrorPredicate([]string{"itemNotFound"}),
+				ShouldIgnoreErrorFunc: isIgnorableErrorPredicate([]ErrorCategory{ErrorCategoryNotFound}),
 			},
 		},
 		Get: &plugin.GetConfig{
 			Hydrate:    getMicrosoft365DriveFile,
 			KeyColumns: plugin.AllColumns([]string{"id", "drive_id", "user_id"}),
 			IgnoreConfig: &plugin.IgnoreConfig{
-				ShouldIgnoreErrorFunc: isIgnorableErrorPredicate([]string{"itemNotFound"}),
+				ShouldIgnoreErrorFunc: isIgnorableErrorPredicate([]ErrorCategory{ErrorCategoryNotFound}),
 			},
 		},
 		Columns: driveFileColumns(),
@@ -0,0 +1,72 @@
+package microsoft365
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/turbot/steampipe-plugin-sdk/v5/plugin"
+
+	"github.com/microsoftgraph/msgraph-sdk-go/drives"
+	"github.com/microsoftgraph/msgraph-sdk-go/models"
+)
+
+//// TABLE DEFINITION
+
+func tableMicrosoft365DriveFileDelta(_ context.Context) *plugin.Table {
+	return &plugin.Table{
+		Name:              "microsoft365_drive_file_delta",
+		Description:       "Files and folders in the drives of the specified user created, updated or deleted since the last query of the table.",
+		GetMatrixItemFunc: tenantMatrix,
+		List: &plugin.ListConfig{
+			Hydrate:       listMicrosoft365DriveFileDeltas,
+			ParentHydrate: listMicrosoft365Drives,
+			KeyColumns:    plugin.SingleColumn("user_id"),
+			IgnoreConfig: &plugin.IgnoreConfig{
+				ShouldIgnoreErrorFunc: isIgnorableErrorPredicate([]ErrorCategory{ErrorCategoryNotFound}),
+			},
+		},
+		Columns: deltaColumns(driveFileColumns()),
+	}
+}
+
+//// LIST FUNCTION
+
+func listMicrosoft365DriveFileDeltas(ctx context.Context, d *plugin.QueryData, h *plugin.HydrateData) (interface{}, error) {
+	driveID := *h.Item.(*Microsoft365DriveInfo).GetId()
+	logger := plugin.Logger(ctx)
+
+	// Create client
+	client, adapter, err := GetGraphClient(ctx, d)
+	if err != nil {
+		logger.Error("microsoft365_drive_file_delta.listMicrosoft365DriveFileDeltas", "connection_error", err)
+		return nil, err
+	}
+
+	userID := d.EqualsQualString("user_id")
+
+	// The delta of the root folder includes every item of the drive
+	info, err := client.Drives().ByDriveId(driveID).Items().ByDriveItemId("root").Delta().ToGetRequestInformation(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	start, err := info.GetUri()
+	if err != nil {
+		return nil, err
+	}
+
+	err = listDelta(ctx, d, deltaQuery[models.DriveItemable]{
+		Scope: fmt.Sprintf("drives/%s/items/root", driveID),
+		Start: start.String(),
+		Get: func(ctx context.Context, url string) (deltaPage[models.DriveItemable], error) {
+			return drives.NewItemItemsItemDeltaRequestBuilder(url, adapter).GetAsDeltaGetResponse(ctx, nil)
+		},
+	}, func(item models.DriveItemable, changeType string) {
+		d.StreamListItem(ctx, &Microsoft365DriveItemDeltaInfo{&Microsoft365DriveItemInfo{item, driveID, userID}, changeType})
+	})
+	if err != nil {
+		logger.Error("listMicrosoft365DriveFileDeltas", "paging_error", err)
+		return nil, getErrorObject(err)
+	}
+
+	return nil, nil
+}
@@ -2,10 +2,8 @@ package microsoft365
 
 import (
 	"context"
-	"fmt"
 	"strings"
 
-	"github.com/iancoleman/strcase"
 	"github.com/turbot/steampipe-plugin-sdk/v5/grpc/proto"
 	"github.com/turbot/steampipe-plugin-sdk/v5/plugin"
 	"github.com/turbot/steampipe-plugin-sdk/v5/plugin/transform"
@@ -15,6 +13,17 @@ import (
 	"github.com/microsoftgraph/msgraph-sdk-go/models"
 )
 
+// groupSelect maps the group columns to the properties to $select
+var groupSelect = odataSelect{
+	Properties: map[string][]string{
+		"title":  {"displayName"},
+		"filter": nil,
+		"search": nil,
+	},
+	GetOnly:  []string{"allowExternalSenders", "autoSubscribeNewMembers", "hideFromAddressLists", "hideFromOutlookClients", "isSubscribedByMail"},
+	Required: []string{"id"},
+}
+
 func groupColumns() []*plugin.Column {
 	return commonColumns([]*plugin.Column{
 		// Basic group information
@@ -79,35 +88,42 @@ func groupColumns() []*plugin.Column {
 		// Standard columns
 		{Name: "title", Type: proto.ColumnType_STRING, Description: ColumnDescriptionTitle, Transform: transform.FromMethod("GetDisplayName")},
 		{Name: "filter", Type: proto.ColumnType_STRING, Transform: transform.FromQual("filter"), Description: "Odata query to search for resources."},
+		{Name: "search", Type: proto.ColumnType_STRING, Transform: transform.FromQual("search"), Description: "Search query for resources, e.g. displayName:wa or \"displayName:wa\" OR \"mail:wa\"."},
 	})
 }
 
 //// TABLE DEFINITION
 
+// groupFilterColumns are the columns Graph filters groups on
+var groupFilterColumns = odataFilterColumns{
+	"id":               {Property: "id", Type: filterTypeString, Operators: equalOperators},
+	"display_name":     {Property: "displayName", Type: filterTypeString, Operators: directoryStringOperators, AdvancedOperators: directoryAdvancedStringOperators},
+	"mail":             {Property: "mail", Type: filterTypeString, Operators: directoryStringOperators, AdvancedOperators: directoryAdvancedStringOperators},
+	"mail_enabled":     {Property: "mailEnabled", Type: filterTypeBool, Operators: boolOperators},
+	"security_enabled": {Property: "securityEnabled", Type: filterTypeBool, Operators: boolOperators},
+	"group_types":      {Property: "groupTypes", Type: filterTypeCollection, Operators: equalOperators},
+}
+
 func tableMicrosoft365Group(_ context.Context) *plugin.Table {
 	return &plugin.Table{
-		Name:        "microsoft365_group",
-		Description: "Groups in Microsoft 365.",
+		Name:              "microsoft365_group",
+		Description:       "Groups in Microsoft 365.",
+		GetMatrixItemFunc: tenantMatrix,
 		List: &plugin.ListConfig{
 			Hydrate: listMicrosoft365Groups,
-			KeyColumns: []*plugin.KeyColumn{
+			KeyColumns: append(plugin.KeyColumnSlice{
 				{Name: "filter", Require: plugin.Optional},
-				{Name: "id", Require: plugin.Optional},
-				{Name: "display_name", Require: plugin.Optional},
-				{Name: "mail", Require: plugin.Optional},
-				{Name: "mail_enabled", Require: plugin.Optional},
-				{Name: "security_enabled", Require: plugin.Optional},
-				{Name: "group_types", Require: plugin.Optional},
-			},
+				{Name: "search", Require: plugin.Optional},
+			}, groupFilterColumns.keyColumns()...),
 			IgnoreConfig: &plugin.IgnoreConfig{
-				ShouldIgnoreErrorFunc: isIgnorableErrorPredicate([]string{"itemNotFound"}),
+				ShouldIgnoreErrorFunc: isIgnorableErrorPredicate([]ErrorCategory{ErrorCategoryNotFound}),
 			},
 		},
 		Get: &plugin.GetConfig{
 			Hydrate:    getMicrosoft365Group,
 			KeyColumns: plugin.AllColumns([]string{"id"}),
 			IgnoreConfig: &plugin.IgnoreConfig{
-				ShouldIgnoreErrorFunc: isIgnorableErrorPredicate([]string{"itemNotFound"}),
+				ShouldIgnoreErrorFunc: isIgnorableErrorPredicate([]ErrorCategory{ErrorCategoryNotFound}),
 			},
 		},
 		Columns: groupColumns(),
@@ -137,9 +153,12 @@ func listMicrosoft365Groups(ctx context.Context, d *plugin.QueryData, _ *plugin.
 	}
 	input.Top = Int32(int32(pageSize))
 
+	// Request only the properties of the queried columns
+	input.Select, input.Expand = groupSelect.buildListSelect(d)
+
 	var queryFilter string
 	equalQuals := d.EqualsQuals
-	filter := buildGroupQueryFilter(equalQuals)
+	filter, advanced := groupFilterColumns.buildAdvancedFilter(d.Quals)
 
 	if equalQuals["filter"] != nil {
 		queryFilter = equalQuals["filter"].GetStringValue()
@@ -147,6 +166,12 @@ func listMicrosoft365Groups(ctx context.Context, d *plugin.QueryData, _ *plugin.
 
 	if queryFilter != "" {
 		filter = append(filter, queryFilter)
+		advanced = advanced || isAdvancedFilter(queryFilter)
+	}
+
+	if equalQuals["search"] != nil {
+		input.Search = StringPtr(searchQuery(equalQuals["search"].GetStringValue()))
+		advanced = true
 	}
 
 	if len(filter) > 0 {
@@ -158,6 +183,12 @@ func listMicrosoft365Groups(ctx context.Context, d *plugin.QueryData, _ *plugin.
 		QueryParameters: input,
 	}
 
+	// $search, $count and some filters are only supported in advanced queries
+	if advanced {
+		input.Count = BoolPtr(true)
+		options.Headers = advancedQueryHeaders()
+	}
+
 	result, err := client.Groups().Get(ctx, options)
 	if err != nil {
 		errObj := getErrorObject(err)
@@ -169,6 +200,9 @@ func listMicrosoft365Groups(ctx context.Context, d *plugin.QueryData, _ *plugin.
 		logger.Error("listMicrosoft365Groups", "create_iterator_instance_error", err)
 		return nil, err
 	}
+	if advanced {
+		pageIterator.SetHeaders(options.Headers)
+	}
 
 	err = pageIterator.Iterate(ctx, func(pageItem models.Groupable) bool {
 		group := pageItem
@@ -203,48 +237,20 @@ func getMicrosoft365Group(ctx context.Context, d *plugin.QueryData, h *plugin.Hy
 		return nil, err
 	}
 
-	result, err := client.Groups().ByGroupId(groupID).Get(ctx, nil)
-	if err != nil {
-		errObj := getErrorObject(err)
-		return nil, errObj
-	}
-
-	return &Microsoft365GroupInfo{Groupable: result}, nil
-}
-
-//// HELPER FUNCTIONS
+	input := &groups.GroupItemRequestBuilderGetQueryParameters{}
 
-func buildGroupQueryFilter(equalQuals plugin.KeyColumnEqualsQualMap) []string {
-	filters := []string{}
+	// Request only the properties of the queried columns
+	input.Select, input.Expand = groupSelect.buildGetSelect(d)
 
-	filterQuals := map[string]string{
-		"display_name":     "string",
-		"id":               "string",
-		"mail":             "string",
-		"mail_enabled":     "bool",
-		"security_enabled": "bool",
-	}
-
-	for qual, qualType := range filterQuals {
-		switch qualType {
-		case "string":
-			if equalQuals[qual] != nil {
-				filters = append(filters, fmt.Sprintf("%s eq '%s'", strcase.ToCamel(qual), equalQuals[qual].GetStringValue()))
-			}
-		case "bool":
-			if equalQuals[qual] != nil {
-				filters = append(filters, fmt.Sprintf("%s eq %t", strcase.ToCamel(qual), equalQuals[qual].GetBoolValue()))
-			}
-		}
+	options := &groups.GroupItemRequestBuilderGetRequestConfiguration{
+		QueryParameters: input,
 	}
 
-	// Handle group_types filter specially
-	if equalQuals["group_types"] != nil {
-		groupType := equalQuals["group_types"].GetStringValue()
-		if groupType == "Unified" {
-			filters = append(filters, "groupTypes/any(c:c eq 'Unified')")
-		}
+	result, err := client.Groups().ByGroupId(groupID).Get(ctx, options)
+	if err != nil {
+		errObj := getErrorObject(err)
+		return nil, errObj
 	}
 
-	return filters
+	return &Microsoft365GroupInfo{Groupable: result}, nil
 }
@@ -0,0 +1,72 @@
+package microsoft365
+
+import (
+	"context"
+
+	"github.com/turbot/steampipe-plugin-sdk/v5/plugin"
+
+	"github.com/microsoftgraph/msgraph-sdk-go/groups"
+	"github.com/microsoftgraph/msgraph-sdk-go/models"
+)
+
+//// TABLE DEFINITION
+
+func tableMicrosoft365GroupDelta(_ context.Context) *plugin.Table {
+	return &plugin.Table{
+		Name:              "microsoft365_group_delta",
+		Description:       "Groups in Microsoft 365 created, updated or deleted since the last query of the table.",
+		GetMatrixItemFunc: tenantMatrix,
+		List: &plugin.ListConfig{
+			Hydrate: listMicrosoft365GroupDeltas,
+		},
+		// Delta queries don't return the properties Graph only returns for a
+		// single group
+		Columns: deltaColumns(groupColumns(), "filter", "search",
+			"allow_external_senders", "auto_subscribe_new_members", "hide_from_address_lists", "hide_from_outlook_clients", "is_subscribed_by_mail"),
+	}
+}
+
+//// LIST FUNCTION
+
+func listMicrosoft365GroupDeltas(ctx context.Context, d *plugin.QueryData, _ *plugin.HydrateData) (interface{}, error) {
+	logger := plugin.Logger(ctx)
+
+	// Create client
+	client, adapter, err := GetGraphClient(ctx, d)
+	if err != nil {
+		logger.Error("microsoft365_group_delta.listMicrosoft365GroupDeltas", "connection_error", err)
+		return nil, err
+	}
+
+	input := &groups.DeltaRequestBuilderGetQueryParameters{}
+
+	// The properties of every column, since the deltaLink keeps those of the first request
+	input.Select, _ = groupSelect.buildDeltaSelect(d.Table)
+
+	info, err := client.Groups().Delta().ToGetRequestInformation(ctx, &groups.DeltaRequestBuilderGetRequestConfiguration{
+		QueryParameters: input,
+	})
+	if err != nil {
+		return nil, err
+	}
+	start, err := info.GetUri()
+	if err != nil {
+		return nil, err
+	}
+
+	err = listDelta(ctx, d, deltaQuery[models.Groupable]{
+		Scope: "groups",
+		Start: start.String(),
+		Get: func(ctx context.Context, url string) (deltaPage[models.Groupable], error) {
+			return groups.NewDeltaRequestBuilder(url, adapter).GetAsDeltaGetResponse(ctx, nil)
+		},
+	}, func(group models.Groupable, changeType string) {
+		d.StreamListItem(ctx, &Microsoft365GroupDeltaInfo{&Microsoft365GroupInfo{group}, changeType})
+	})
+	if err != nil {
+		logger.Error("listMicrosoft365GroupDeltas", "paging_error", err)
+		return nil, getErrorObject(err)
+	}
+
+	return nil, nil
+}
@@ -48,8 +48,9 @@ func listColumns() []*plugin.Column {
 
 func tableMicrosoft365List(_ context.Context) *plugin.Table {
 	return &plugin.Table{
-		Name:        "microsoft365_list",
-		Description: "SharePoint lists in Microsoft 365.",
+		Name:              "microsoft365_list",
+		Description:       "SharePoint lists in Microsoft 365.",
+		GetMatrixItemFunc: tenantMatrix,
 		List: &plugin.ListConfig{
 			Hydrate:       listMicrosoft365Lists,
 			ParentHydrate: listMicrosoft365Sites,
@@ -57,14 +58,14 @@ func tableMicrosoft365List(_ context.Context) *plugin.Table {
 				{Name: "site_id", Require: plugin.Optional},
 			},
 			IgnoreConfig: &plugin.IgnoreConfig{
-				ShouldIgnoreErrorFunc: isIgnorableErrorPredicate([]string{"itemNotFound"}),
+				ShouldIgnoreErrorFunc: isIgnorableErrorPredicate([]ErrorCategory{ErrorCategoryNotFound}),
 			},
 		},
 		Get: &plugin.GetConfig{
 			Hydrate:    getMicrosoft365List,
 			KeyColumns: plugin.AllColumns([]string{"site_id", "id"}),
 			IgnoreConfig: &plugin.IgnoreConfig{
-				ShouldIgnoreErrorFunc: isIgnorableErrorPredicate([]string{"itemNotFound"}),
+				ShouldIgnoreErrorFunc: isIgnorableErrorPredicate([]ErrorCategory{ErrorCategoryNotFound}),
 			},
 		},
 		Columns: listColumns(),
@@ -0,0 +1,254 @@
+package microsoft365
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/turbot/steampipe-plugin-sdk/v5/grpc/proto"
+	"github.com/turbot/steampipe-plugin-sdk/v5/plugin"
+	"github.com/turbot/steampipe-plugin-sdk/v5/plugin/transform"
+
+	abstractions "github.com/microsoft/kiota-abstractions-go"
+	msgraphcore "github.com/microsoftgraph/msgraph-sdk-go-core"
+	"github.com/microsoftgraph/msgraph-sdk-go/models"
+	"github.com/microsoftgraph/msgraph-sdk-go/users"
+)
+
+// defaultMaxAttachmentContentSize is the size, in bytes, of the largest
+// attachment whose content is downloaded, unless max_attachment_content_size
+// is set
+const defaultMaxAttachmentContentSize = 10 * 1024 * 1024
+
+// attachmentResponseOverhead is the size, in bytes, that the other properties
+// of an attachment are expected to add to its base64 encoded content
+const attachmentResponseOverhead = 4 * 1024
+
+// mailAttachmentSelect maps the attachment columns to the properties to
+// $select. Graph returns the content of every file attachment unless the
+// properties are selected, and the size decides whether the content is
+// downloaded. The contentId of file attachments is selected through a cast to
+// their type.
+var mailAttachmentSelect = odataSelect{
+	Properties: map[string][]string{
+		"title":      {"name"},
+		"type":       nil,
+		"content_id": {"microsoft.graph.fileAttachment/contentId"},
+		"user_id":    nil,
+		"message_id": nil,
+	},
+	Required: []string{"id", "size"},
+}
+
+//// TABLE DEFINITION
+
+func tableMicrosoft365MailAttachment(_ context.Context) *plugin.Table {
+	return &plugin.Table{
+		Name:              "microsoft365_mail_attachment",
+		Description:       "Retrieves the file, item and reference attachments of a message in the specified user's mailbox.",
+		GetMatrixItemFunc: tenantMatrix,
+		List: &plugin.ListConfig{
+			Hydrate: listMicrosoft365MailAttachments,
+			KeyColumns: plugin.KeyColumnSlice{
+				// Key fields
+				{Name: "user_id", Require: plugin.Required},
+				{Name: "message_id", Require: plugin.Required},
+			},
+			IgnoreConfig: &plugin.IgnoreConfig{
+				ShouldIgnoreErrorFunc: isIgnorableErrorPredicate([]ErrorCategory{ErrorCategoryNotFound}),
+			},
+		},
+		Get: &plugin.GetConfig{
+			Hydrate:    getMicrosoft365MailAttachment,
+			KeyColumns: plugin.AllColumns([]string{"user_id", "message_id", "id"}),
+			IgnoreConfig: &plugin.IgnoreConfig{
+				ShouldIgnoreErrorFunc: isIgnorableErrorPredicate([]ErrorCategory{ErrorCategoryNotFound}),
+			},
+		},
+		Columns: commonColumns([]*plugin.Column{
+			{Name: "name", Type: proto.ColumnType_STRING, Description: "The display name of the attachment.", Transform: transform.FromMethod("GetName")},
+			{Name: "id", Type: proto.ColumnType_STRING, Description: "The attachment's unique identifier.", Transform: transform.FromMethod("GetId")},
+			{Name: "type", Type: proto.ColumnType_STRING, Description: "The type of the attachment. The possible values are: file, item and reference.", Transform: transform.FromMethod("AttachmentType")},
+			{Name: "content_type", Type: proto.ColumnType_STRING, Description: "The MIME type of the attachment.", Transform: transform.FromMethod("GetContentType")},
+			{Name: "size", Type: proto.ColumnType_INT, Description: "The size of the attachment, in bytes.", Transform: transform.FromMethod("GetSize")},
+			{Name: "is_inline", Type: proto.ColumnType_BOOL, Description: "True if the attachment is an inline attachment, e.g. an image embedded in the body of the message.", Transform: transform.FromMethod("GetIsInline")},
+			{Name: "last_modified_date_time", Type: proto.ColumnType_TIMESTAMP, Description: "The date and time when the attachment was last modified.", Transform: transform.FromMethod("GetLastModifiedDateTime")},
+			{Name: "content_id", Type: proto.ColumnType_STRING, Description: "The ID of a file attachment in the Exchange store, e.g. the cid of an inline image.", Transform: transform.FromMethod("AttachmentContentID")},
+
+			// Content columns, downloaded only when queried
+			{Name: "sha256", Type: proto.ColumnType_STRING, Description: "The hex-encoded SHA-256 hash of the content of a file attachment. Null for attachments larger than max_attachment_content_size.", Hydrate: getMailAttachmentContent, Transform: transform.FromMethod("SHA256")},
+			{Name: "content_base64", Type: proto.ColumnType_STRING, Description: "The base64-encoded content of a file attachment. Null for attachments larger than max_attachment_content_size.", Hydrate: getMailAttachmentContent, Transform: transform.FromMethod("ContentBase64")},
+
+			// Standard columns
+			{Name: "title", Type: proto.ColumnType_STRING, Description: ColumnDescriptionTitle, Transform: transform.FromMethod("GetName")},
+			{Name: "user_id", Type: proto.ColumnType_STRING, Description: ColumnDescriptionUserID},
+			{Name: "message_id", Type: proto.ColumnType_STRING, Description: "The ID of the message the attachment belongs to.", Transform: transform.FromField("MessageID")},
+		}),
+	}
+}
+
+//// LIST FUNCTION
+
+func listMicrosoft365MailAttachments(ctx context.Context, d *plugin.QueryData, _ *plugin.HydrateData) (interface{}, error) {
+	logger := plugin.Logger(ctx)
+
+	// Create client
+	client, adapter, err := GetGraphClient(ctx, d)
+	if err != nil {
+		logger.Error("microsoft365_mail_attachment.listMicrosoft365MailAttachments", "connection_error", err)
+		return nil, err
+	}
+
+	userID := d.EqualsQualString("user_id")
+	messageID := d.EqualsQualString("message_id")
+	if userID == "" || messageID == "" {
+		return nil, nil
+	}
+
+	// Request only the properties of the queried columns
+	input := &users.ItemMessagesItemAttachmentsRequestBuilderGetQueryParameters{}
+	input.Select, _ = mailAttachmentSelect.buildListSelect(d)
+
+	options := &users.ItemMessagesItemAttachmentsRequestBuilderGetRequestConfiguration{
+		QueryParameters: input,
+	}
+
+	result, err := client.Users().ByUserId(userID).Messages().ByMessageId(messageID).Attachments().Get(ctx, options)
+	if err != nil {
+		errObj := getErrorObject(err)
+		logger.Error("microsoft365_mail_attachment.listMicrosoft365MailAttachments", "api_error", errObj)
+		return nil, errObj
+	}
+
+	pageIterator, err := msgraphcore.NewPageIterator[models.Attachmentable](result, adapter, models.CreateAttachmentCollectionResponseFromDiscriminatorValue)
+	if err != nil {
+		logger.Error("microsoft365_mail_attachment.listMicrosoft365MailAttachments", "create_iterator_instance_error", err)
+		return nil, err
+	}
+
+	err = pageIterator.Iterate(ctx, func(attachment models.Attachmentable) bool {
+		d.StreamListItem(ctx, &Microsoft365MailAttachmentInfo{attachment, userID, messageID})
+
+		// Context can be cancelled due to manual cancellation or the limit has been hit
+		return d.RowsRemaining(ctx) != 0
+	})
+	if err != nil {
+		logger.Error("microsoft365_mail_attachment.listMicrosoft365MailAttachments", "paging_error", err)
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+//// HYDRATE FUNCTIONS
+
+func getMicrosoft365MailAttachment(ctx context.Context, d *plugin.QueryData, _ *plugin.HydrateData) (interface{}, error) {
+	logger := plugin.Logger(ctx)
+
+	userID := d.EqualsQualString("user_id")
+	messageID := d.EqualsQualString("message_id")
+	id := d.EqualsQualString("id")
+	if userID == "" || messageID == "" || id == "" {
+		return nil, nil
+	}
+
+	// Create client
+	client, _, err := GetGraphClient(ctx, d)
+	if err != nil {
+		logger.Error("microsoft365_mail_attachment.getMicrosoft365MailAttachment", "connection_error", err)
+		return nil, err
+	}
+
+	// Request only the properties of the queried columns
+	input := &users.ItemMessagesItemAttachmentsAttachmentItemRequestBuilderGetQueryParameters{}
+	input.Select, _ = mailAttachmentSelect.buildGetSelect(d)
+
+	options := &users.ItemMessagesItemAttachmentsAttachmentItemRequestBuilderGetRequestConfiguration{
+		QueryParameters: input,
+	}
+
+	result, err := client.Users().ByUserId(userID).Messages().ByMessageId(messageID).Attachments().ByAttachmentId(id).Get(ctx, options)
+	if err != nil {
+		return nil, getErrorObject(err)
+	}
+
+	return &Microsoft365MailAttachmentInfo{result, userID, messageID}, nil
+}
+
+// getMailAttachmentContent downloads a file attachment, batched with the
+// attachments of the other rows up to batchMaxResponseSize, unless it's
+// larger than max_attachment_content_size. An attachment whose response alone
+// exceeds batchMaxResponseSize is requested on its own. Item and reference
+// attachments have no content.
+func getMailAttachmentContent(ctx context.Context, d *plugin.QueryData, h *plugin.HydrateData) (interface{}, error) {
+	logger := plugin.Logger(ctx)
+
+	attachment := h.Item.(*Microsoft365MailAttachmentInfo)
+	if _, ok := attachment.Attachmentable.(models.FileAttachmentable); !ok || attachment.GetId() == nil {
+		return nil, nil
+	}
+
+	config, err := getConnectionConfig(ctx, d)
+	if err != nil {
+		return nil, err
+	}
+	maxSize, err := getMaxAttachmentContentSize(config)
+	if err != nil {
+		return nil, err
+	}
+	if attachment.GetSize() == nil || int64(*attachment.GetSize()) > maxSize {
+		return nil, nil
+	}
+
+	// Create client
+	client, err := getGraphClient(ctx, d)
+	if err != nil {
+		logger.Error("microsoft365_mail_attachment.getMailAttachmentContent", "connection_error", err)
+		return nil, err
+	}
+
+	builder := client.client.Users().ByUserId(attachment.UserID).Messages().ByMessageId(attachment.MessageID).Attachments().ByAttachmentId(*attachment.GetId())
+	responseSize := getAttachmentResponseSize(int64(*attachment.GetSize()))
+
+	var result models.Attachmentable
+	if responseSize > batchMaxResponseSize {
+		result, err = builder.Get(ctx, nil)
+	} else {
+		var request *abstractions.RequestInformation
+		request, err = builder.ToGetRequestInformation(ctx, nil)
+		if err != nil {
+			logger.Error("microsoft365_mail_attachment.getMailAttachmentContent", "request_error", err)
+			return nil, err
+		}
+		result, err = batchGetSized[models.Attachmentable](ctx, client, request, models.CreateAttachmentFromDiscriminatorValue, responseSize)
+	}
+	if err != nil {
+		errObj := getErrorObject(err)
+		logger.Error("microsoft365_mail_attachment.getMailAttachmentContent", "api_error", errObj)
+		return nil, errObj
+	}
+
+	file, ok := result.(models.FileAttachmentable)
+	if !ok {
+		return nil, nil
+	}
+	return &Microsoft365MailAttachmentContent{Content: file.GetContentBytes()}, nil
+}
+
+// getAttachmentResponseSize returns the expected size, in bytes, of the
+// response to a request for an attachment of size bytes, whose content Graph
+// returns base64 encoded.
+func getAttachmentResponseSize(size int64) int64 {
+	return (size+2)/3*4 + attachmentResponseOverhead
+}
+
+// getMaxAttachmentContentSize returns the size, in bytes, of the largest
+// attachment whose content is downloaded.
+func getMaxAttachmentContentSize(config microsoft365Config) (int64, error) {
+	if config.MaxAttachmentContentSize == nil {
+		return defaultMaxAttachmentContentSize, nil
+	}
+	if *config.MaxAttachmentContentSize < 0 {
+		return 0, fmt.Errorf("invalid max_attachment_content_size %d: must be a number of bytes of at least 0", *config.MaxAttachmentContentSize)
+	}
+	return int64(*config.MaxAttachmentContentSize), nil
+}
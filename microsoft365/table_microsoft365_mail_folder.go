@@ -0,0 +1,434 @@
+package microsoft365
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/turbot/steampipe-plugin-sdk/v5/grpc/proto"
+	"github.com/turbot/steampipe-plugin-sdk/v5/memoize"
+	"github.com/turbot/steampipe-plugin-sdk/v5/plugin"
+	"github.com/turbot/steampipe-plugin-sdk/v5/plugin/transform"
+
+	msgraphsdkgo "github.com/microsoftgraph/msgraph-sdk-go"
+	msgraphcore "github.com/microsoftgraph/msgraph-sdk-go-core"
+	"github.com/microsoftgraph/msgraph-sdk-go/models"
+	"github.com/microsoftgraph/msgraph-sdk-go/users"
+)
+
+// mailFolderSizeProperty is the MAPI property with the total size of the
+// items in a folder, PidTagMessageSizeExtended, which v1.0 mail folders only
+// expose as an extended property
+const mailFolderSizeProperty = "Long 0x0E08"
+
+// maxMailFolderDepth caps the parent folders walked to build the path of a
+// single folder
+const maxMailFolderDepth = 32
+
+// wellKnownMailFolderNames are the names Graph accepts in place of the ID of
+// a mail folder
+// https://learn.microsoft.com/en-us/graph/api/resources/mailfolder#well-known-folder-names
+var wellKnownMailFolderNames = []string{
+	"archive",
+	"clutter",
+	"conflicts",
+	"conversationhistory",
+	"deleteditems",
+	"drafts",
+	"inbox",
+	"junkemail",
+	"localfailures",
+	"msgfolderroot",
+	"outbox",
+	"recoverableitemsdeletions",
+	"scheduled",
+	"searchfolders",
+	"sentitems",
+	"serverfailures",
+	"syncissues",
+}
+
+// mailFolderSelect maps the folder columns to the properties to $select.
+// Walking the hierarchy needs the child folder count, and the path the
+// display name.
+var mailFolderSelect = odataSelect{
+	Properties: map[string][]string{
+		"title":   {"displayName"},
+		"path":    nil,
+		"user_id": nil,
+	},
+	Expand: map[string]string{
+		"size_in_bytes": fmt.Sprintf("singleValueExtendedProperties($filter=id eq '%s')", mailFolderSizeProperty),
+	},
+	Required: []string{"id", "displayName", "childFolderCount", "parentFolderId"},
+}
+
+func mailFolderColumns() []*plugin.Column {
+	return commonColumns([]*plugin.Column{
+		{Name: "display_name", Type: proto.ColumnType_STRING, Description: "The mail folder's display name.", Transform: transform.FromMethod("GetDisplayName")},
+		{Name: "id", Type: proto.ColumnType_STRING, Description: "The mail folder's unique identifier.", Transform: transform.FromMethod("GetId")},
+		{Name: "path", Type: proto.ColumnType_STRING, Description: "The display names of the folder and its parent folders, separated by /, e.g. Inbox/Projects.", Transform: transform.FromField("Path")},
+		{Name: "well_known_name", Type: proto.ColumnType_STRING, Description: "The well-known name of the folder, e.g. inbox, sentitems or deleteditems, if it is a well-known folder.", Hydrate: getMailFolderWellKnownName, Transform: transform.FromValue()},
+		{Name: "parent_folder_id", Type: proto.ColumnType_STRING, Description: "The unique identifier for the mail folder's parent mail folder.", Transform: transform.FromMethod("GetParentFolderId")},
+		{Name: "is_hidden", Type: proto.ColumnType_BOOL, Description: "Indicates whether the mail folder is hidden.", Transform: transform.FromMethod("GetIsHidden")},
+		{Name: "child_folder_count", Type: proto.ColumnType_INT, Description: "The number of immediate child mail folders in the current mail folder.", Transform: transform.FromMethod("GetChildFolderCount")},
+		{Name: "total_item_count", Type: proto.ColumnType_INT, Description: "The number of items in the mail folder.", Transform: transform.FromMethod("GetTotalItemCount")},
+		{Name: "unread_item_count", Type: proto.ColumnType_INT, Description: "The number of items in the mail folder marked as unread.", Transform: transform.FromMethod("GetUnreadItemCount")},
+		{Name: "size_in_bytes", Type: proto.ColumnType_INT, Description: "The total size of the items in the mail folder, in bytes.", Transform: transform.FromMethod("MailFolderSizeInBytes")},
+
+		// Standard columns
+		{Name: "title", Type: proto.ColumnType_STRING, Description: ColumnDescriptionTitle, Transform: transform.FromMethod("GetDisplayName")},
+		{Name: "user_id", Type: proto.ColumnType_STRING, Description: ColumnDescriptionUserID},
+	})
+}
+
+//// TABLE DEFINITION
+
+func tableMicrosoft365MailFolder(_ context.Context) *plugin.Table {
+	return &plugin.Table{
+		Name:              "microsoft365_mail_folder",
+		Description:       "Retrieves the mail folders, including hidden and child folders, in the specified user's mailbox.",
+		GetMatrixItemFunc: tenantMatrix,
+		List: &plugin.ListConfig{
+			Hydrate: listMicrosoft365MailFolders,
+			KeyColumns: plugin.KeyColumnSlice{
+				// Key fields
+				{Name: "user_id", Require: plugin.Required},
+			},
+			IgnoreConfig: &plugin.IgnoreConfig{
+				ShouldIgnoreErrorFunc: isIgnorableErrorPredicate([]ErrorCategory{ErrorCategoryNotFound}),
+			},
+		},
+		Get: &plugin.GetConfig{
+			Hydrate:    getMicrosoft365MailFolder,
+			KeyColumns: plugin.AllColumns([]string{"user_id", "id"}),
+			IgnoreConfig: &plugin.IgnoreConfig{
+				ShouldIgnoreErrorFunc: isIgnorableErrorPredicate([]ErrorCategory{ErrorCategoryNotFound}),
+			},
+		},
+		Columns: mailFolderColumns(),
+	}
+}
+
+//// LIST FUNCTION
+
+func listMicrosoft365MailFolders(ctx context.Context, d *plugin.QueryData, _ *plugin.HydrateData) (interface{}, error) {
+	logger := plugin.Logger(ctx)
+
+	// Create client
+	client, adapter, err := GetGraphClient(ctx, d)
+	if err != nil {
+		logger.Error("microsoft365_mail_folder.listMicrosoft365MailFolders", "connection_error", err)
+		return nil, err
+	}
+
+	userID := d.EqualsQuals["user_id"].GetStringValue()
+
+	err = listMailFolders(ctx, d, client, adapter, userID)
+	if err != nil {
+		logger.Error("microsoft365_mail_folder.listMicrosoft365MailFolders", "api_error", err)
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+// listMailFolders streams the mail folders of the user, depth first, with
+// the child folders of each folder following it.
+func listMailFolders(ctx context.Context, d *plugin.QueryData, client *msgraphsdkgo.GraphServiceClient, adapter *msgraphsdkgo.GraphRequestAdapter, userID string) error {
+	// Minimum value is 1 (this function isn't run if "limit 0" is specified)
+	// Maximum value is unknown (tested up to 999)
+	pageSize := int64(999)
+	limit := d.QueryContext.Limit
+	if limit != nil && *limit < pageSize {
+		pageSize = *limit
+	}
+
+	// Request only the properties of the queried columns
+	selects, expands := mailFolderSelect.buildListSelect(d)
+
+	input := &users.ItemMailFoldersRequestBuilderGetQueryParameters{
+		Top:                  Int32(int32(pageSize)),
+		Select:               selects,
+		Expand:               expands,
+		IncludeHiddenFolders: StringPtr("true"),
+	}
+	options := &users.ItemMailFoldersRequestBuilderGetRequestConfiguration{
+		QueryParameters: input,
+	}
+
+	result, err := client.Users().ByUserId(userID).MailFolders().Get(ctx, options)
+	if err != nil {
+		return getErrorObject(err)
+	}
+
+	walker := &mailFolderWalker{
+		d:       d,
+		client:  client,
+		adapter: adapter,
+		userID:  userID,
+		childOptions: &users.ItemMailFoldersItemChildFoldersRequestBuilderGetRequestConfiguration{
+			QueryParameters: &users.ItemMailFoldersItemChildFoldersRequestBuilderGetQueryParameters{
+				Top:                  input.Top,
+				Select:               selects,
+				Expand:               expands,
+				IncludeHiddenFolders: StringPtr("true"),
+			},
+		},
+	}
+	_, err = walker.walk(ctx, result, "")
+	return err
+}
+
+// mailFolderWalker streams a page of mail folders and, recursively, their
+// child folders.
+type mailFolderWalker struct {
+	d            *plugin.QueryData
+	client       *msgraphsdkgo.GraphServiceClient
+	adapter      *msgraphsdkgo.GraphRequestAdapter
+	userID       string
+	childOptions *users.ItemMailFoldersItemChildFoldersRequestBuilderGetRequestConfiguration
+}
+
+// walk streams the folders of the collection, whose parent folder has the
+// path. It reports whether more rows are wanted.
+func (w *mailFolderWalker) walk(ctx context.Context, result models.MailFolderCollectionResponseable, parentPath string) (bool, error) {
+	pageIterator, err := msgraphcore.NewPageIterator[models.MailFolderable](result, w.adapter, models.CreateMailFolderCollectionResponseFromDiscriminatorValue)
+	if err != nil {
+		return false, err
+	}
+
+	more := true
+	var walkErr error
+	err = pageIterator.Iterate(ctx, func(folder models.MailFolderable) bool {
+		path := joinMailFolderPath(parentPath, folder.GetDisplayName())
+		w.d.StreamListItem(ctx, &Microsoft365MailFolderInfo{folder, w.userID, path})
+
+		// Context can be cancelled due to manual cancellation or the limit has been hit
+		if w.d.RowsRemaining(ctx) == 0 {
+			more = false
+			return false
+		}
+
+		if folder.GetId() == nil || folder.GetChildFolderCount() == nil || *folder.GetChildFolderCount() == 0 {
+			return true
+		}
+		children, err := w.client.Users().ByUserId(w.userID).MailFolders().ByMailFolderId(*folder.GetId()).ChildFolders().Get(ctx, w.childOptions)
+		if err != nil {
+			walkErr = getErrorObject(err)
+			return false
+		}
+		more, walkErr = w.walk(ctx, children, path)
+		return more && walkErr == nil
+	})
+	if err != nil {
+		return false, err
+	}
+
+	return more, walkErr
+}
+
+// listChildMailFolderIDs returns the IDs of the child folders of the folder,
+// including hidden ones, and recursively of their child folders, depth first.
+func listChildMailFolderIDs(ctx context.Context, client *msgraphsdkgo.GraphServiceClient, adapter *msgraphsdkgo.GraphRequestAdapter, userID string, folderID string) ([]string, error) {
+	options := &users.ItemMailFoldersItemChildFoldersRequestBuilderGetRequestConfiguration{
+		QueryParameters: &users.ItemMailFoldersItemChildFoldersRequestBuilderGetQueryParameters{
+			Top:                  Int32(999),
+			Select:               []string{"id", "childFolderCount"},
+			IncludeHiddenFolders: StringPtr("true"),
+		},
+	}
+
+	result, err := client.Users().ByUserId(userID).MailFolders().ByMailFolderId(folderID).ChildFolders().Get(ctx, options)
+	if err != nil {
+		return nil, getErrorObject(err)
+	}
+
+	pageIterator, err := msgraphcore.NewPageIterator[models.MailFolderable](result, adapter, models.CreateMailFolderCollectionResponseFromDiscriminatorValue)
+	if err != nil {
+		return nil, err
+	}
+
+	var ids []string
+	var childErr error
+	err = pageIterator.Iterate(ctx, func(folder models.MailFolderable) bool {
+		if folder.GetId() == nil {
+			return true
+		}
+		ids = append(ids, *folder.GetId())
+
+		if folder.GetChildFolderCount() == nil || *folder.GetChildFolderCount() == 0 {
+			return true
+		}
+		var childIDs []string
+		childIDs, childErr = listChildMailFolderIDs(ctx, client, adapter, userID, *folder.GetId())
+		ids = append(ids, childIDs...)
+		return childErr == nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return ids, childErr
+}
+
+func joinMailFolderPath(parentPath string, displayName *string) string {
+	if parentPath == "" {
+		return stringValue(displayName)
+	}
+	return parentPath + "/" + stringValue(displayName)
+}
+
+//// HYDRATE FUNCTIONS
+
+func getMicrosoft365MailFolder(ctx context.Context, d *plugin.QueryData, _ *plugin.HydrateData) (interface{}, error) {
+	logger := plugin.Logger(ctx)
+
+	userID := d.EqualsQualString("user_id")
+	id := d.EqualsQualString("id")
+	if userID == "" || id == "" {
+		return nil, nil
+	}
+
+	// Create client
+	client, _, err := GetGraphClient(ctx, d)
+	if err != nil {
+		logger.Error("microsoft365_mail_folder.getMicrosoft365MailFolder", "connection_error", err)
+		return nil, err
+	}
+
+	return getMailFolder(ctx, d, client, userID, id)
+}
+
+// getMailFolder gets the mail folder, which may be given by its well-known
+// name, and builds its path from its parent folders.
+func getMailFolder(ctx context.Context, d *plugin.QueryData, client *msgraphsdkgo.GraphServiceClient, userID string, id string) (interface{}, error) {
+	folders := client.Users().ByUserId(userID).MailFolders()
+
+	// Request only the properties of the queried columns
+	input := &users.ItemMailFoldersMailFolderItemRequestBuilderGetQueryParameters{}
+	input.Select, input.Expand = mailFolderSelect.buildGetSelect(d)
+
+	result, err := folders.ByMailFolderId(id).Get(ctx, &users.ItemMailFoldersMailFolderItemRequestBuilderGetRequestConfiguration{QueryParameters: input})
+	if err != nil {
+		return nil, getErrorObject(err)
+	}
+
+	// The path ends at the top of the folder hierarchy, msgfolderroot
+	parentOptions := &users.ItemMailFoldersMailFolderItemRequestBuilderGetRequestConfiguration{
+		QueryParameters: &users.ItemMailFoldersMailFolderItemRequestBuilderGetQueryParameters{
+			Select: []string{"id", "displayName", "parentFolderId"},
+		},
+	}
+	root, err := folders.ByMailFolderId("msgfolderroot").Get(ctx, parentOptions)
+	if err != nil {
+		return nil, getErrorObject(err)
+	}
+
+	var segments []string
+	if result.GetId() == nil || root.GetId() == nil || *result.GetId() != *root.GetId() {
+		segments = append(segments, stringValue(result.GetDisplayName()))
+	}
+	parentID := result.GetParentFolderId()
+	for depth := 0; parentID != nil && root.GetId() != nil && *parentID != *root.GetId() && depth < maxMailFolderDepth; depth++ {
+		parent, err := folders.ByMailFolderId(*parentID).Get(ctx, parentOptions)
+		if err != nil {
+			return nil, getErrorObject(err)
+		}
+		segments = append([]string{stringValue(parent.GetDisplayName())}, segments...)
+		parentID = parent.GetParentFolderId()
+	}
+	path := strings.Join(segments, "/")
+
+	return &Microsoft365MailFolderInfo{result, userID, path}, nil
+}
+
+func getMailFolderWellKnownName(ctx context.Context, d *plugin.QueryData, h *plugin.HydrateData) (interface{}, error) {
+	folder := h.Item.(*Microsoft365MailFolderInfo)
+	if folder.GetId() == nil {
+		return nil, nil
+	}
+
+	names, err := getMailFolderWellKnownNamesMemoized(ctx, d, h)
+	if err != nil {
+		return nil, err
+	}
+
+	if name, ok := names.(map[string]string)[*folder.GetId()]; ok {
+		return name, nil
+	}
+	return nil, nil
+}
+
+// getMailFolderWellKnownNamesMemoized looks up the well-known folders once
+// per mailbox, so the rows of a query share one $batch request.
+var getMailFolderWellKnownNamesMemoized = plugin.HydrateFunc(getMailFolderWellKnownNamesUncached).Memoize(memoize.WithCacheKeyFunction(getMailFolderWellKnownNamesCacheKey))
+
+// Build a cache key for the call to getMailFolderWellKnownNames.
+func getMailFolderWellKnownNamesCacheKey(ctx context.Context, d *plugin.QueryData, h *plugin.HydrateData) (interface{}, error) {
+	key := fmt.Sprintf("getMailFolderWellKnownNames-%s", h.Item.(*Microsoft365MailFolderInfo).UserID)
+	if tenantID := getMatrixTenantID(ctx); tenantID != "" {
+		key = fmt.Sprintf("%s-%s", key, tenantID)
+	}
+	return key, nil
+}
+
+// getMailFolderWellKnownNamesUncached returns the well-known names of the
+// user's folders, keyed by folder ID. Mailboxes don't have every well-known
+// folder, e.g. archive, so missing ones are skipped.
+func getMailFolderWellKnownNamesUncached(ctx context.Context, d *plugin.QueryData, h *plugin.HydrateData) (interface{}, error) {
+	logger := plugin.Logger(ctx)
+	userID := h.Item.(*Microsoft365MailFolderInfo).UserID
+
+	// Create client
+	client, err := getGraphClient(ctx, d)
+	if err != nil {
+		logger.Error("getMailFolderWellKnownNames", "connection_error", err)
+		return nil, err
+	}
+
+	options := &users.ItemMailFoldersMailFolderItemRequestBuilderGetRequestConfiguration{
+		QueryParameters: &users.ItemMailFoldersMailFolderItemRequestBuilderGetQueryParameters{
+			Select: []string{"id"},
+		},
+	}
+
+	// The folders are requested at once, so they share a $batch request
+	names := map[string]string{}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	var firstErr error
+	for _, name := range wellKnownMailFolderNames {
+		request, err := client.client.Users().ByUserId(userID).MailFolders().ByMailFolderId(name).ToGetRequestInformation(ctx, options)
+		if err != nil {
+			logger.Error("getMailFolderWellKnownNames", "request_error", err)
+			return nil, err
+		}
+
+		wg.Add(1)
+		go func(name string) {
+			defer wg.Done()
+			folder, err := batchGet[models.MailFolderable](ctx, client, request, models.CreateMailFolderFromDiscriminatorValue)
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				if errObj := getErrorObject(err); errObj.Category != ErrorCategoryNotFound && firstErr == nil {
+					firstErr = errObj
+				}
+				return
+			}
+			if folder.GetId() != nil {
+				names[*folder.GetId()] = name
+			}
+		}(name)
+	}
+	wg.Wait()
+
+	if firstErr != nil {
+		logger.Error("getMailFolderWellKnownNames", "api_error", firstErr)
+		return nil, firstErr
+	}
+	return names, nil
+}
@@ -5,17 +5,30 @@ import (
 	"fmt"
 	"strings"
 
-	"github.com/iancoleman/strcase"
-
 	"github.com/turbot/steampipe-plugin-sdk/v5/grpc/proto"
 	"github.com/turbot/steampipe-plugin-sdk/v5/plugin"
 	"github.com/turbot/steampipe-plugin-sdk/v5/plugin/transform"
 
+	msgraphsdkgo "github.com/microsoftgraph/msgraph-sdk-go"
 	msgraphcore "github.com/microsoftgraph/msgraph-sdk-go-core"
 	"github.com/microsoftgraph/msgraph-sdk-go/models"
 	"github.com/microsoftgraph/msgraph-sdk-go/users"
 )
 
+// mailMessageSelect maps the message columns to the properties to $select
+var mailMessageSelect = odataSelect{
+	Properties: map[string][]string{
+		"title":                 {"subject"},
+		"from_address":          {"from"},
+		"filter":                nil,
+		"query":                 nil,
+		"user_id":               nil,
+		"folder_id":             nil,
+		"include_child_folders": nil,
+	},
+	Required: []string{"id"},
+}
+
 func mailMessageColumns() []*plugin.Column {
 	return commonColumns([]*plugin.Column{
 		{Name: "subject", Type: proto.ColumnType_STRING, Description: "The subject of the message.", Transform: transform.FromMethod("GetSubject")},
@@ -35,6 +48,7 @@ func mailMessageColumns() []*plugin.Column {
 		{Name: "internet_message_id", Type: proto.ColumnType_STRING, Description: "The message ID in the format specified by RFC2822.", Transform: transform.FromMethod("GetInternetMessageId")},
 		{Name: "is_read_receipt_requested", Type: proto.ColumnType_BOOL, Description: "Indicates whether a read receipt is requested for the message.", Transform: transform.FromMethod("GetIsReadReceiptRequested")},
 		{Name: "is_delivery_receipt_requested", Type: proto.ColumnType_BOOL, Description: "Indicates whether a read receipt is requested for the message.", Transform: transform.FromMethod("GetIsDeliveryReceiptRequested")},
+		{Name: "from_address", Type: proto.ColumnType_STRING, Description: "The email address of the owner of the mailbox from which the message is sent.", Transform: transform.FromMethod("MessageFromAddress")},
 		{Name: "parent_folder_id", Type: proto.ColumnType_STRING, Description: "The unique identifier for the message's parent mailFolder.", Transform: transform.FromMethod("GetParentFolderId")},
 
 		// Other fields
@@ -56,38 +70,61 @@ func mailMessageColumns() []*plugin.Column {
 		// Standard columns
 		{Name: "title", Type: proto.ColumnType_STRING, Description: ColumnDescriptionTitle, Transform: transform.FromMethod("GetSubject")},
 		{Name: "user_id", Type: proto.ColumnType_STRING, Description: ColumnDescriptionUserID},
+		{Name: "folder_id", Type: proto.ColumnType_STRING, Description: "The ID or well-known name, e.g. inbox, sentitems, deleteditems, archive or junkemail, of the mail folder to list the messages of. If not set, the messages of every folder are listed.", Transform: transform.FromField("FolderID")},
+		{Name: "include_child_folders", Type: proto.ColumnType_BOOL, Description: "If true, the messages of the child folders of folder_id are listed as well. The messages are listed folder by folder, so they're only ordered by received_date_time within each folder.", Transform: transform.FromQual("include_child_folders")},
 		{Name: "filter", Type: proto.ColumnType_STRING, Transform: transform.FromQual("filter"), Description: "Odata query to search for resources."},
+		{Name: "query", Type: proto.ColumnType_STRING, Transform: transform.FromQual("query"), Description: "KQL query to search the body, subject, recipients and attachment names of the messages for, e.g. invoice or from:alex@contoso.com AND hasattachments:true. Results are ordered by relevance, capped at 1000 messages, and can't be combined with filter."},
 	})
 }
 
 //// TABLE DEFINITION
 
+// mailSearchResultCap is the most messages Exchange returns for a $search
+// https://learn.microsoft.com/en-us/graph/search-query-parameter#using-search-on-message-collections
+const mailSearchResultCap = 1000
+
+// mailMessageOrderBy lists messages newest first, so a query with a limit
+// doesn't read the whole mailbox
+const mailMessageOrderBy = "receivedDateTime desc"
+
+// mailMessageFilterColumns are the columns Exchange filters messages on
+var mailMessageFilterColumns = odataFilterColumns{
+	"subject":                  {Property: "subject", Type: filterTypeString, Operators: exchangeStringOperators},
+	"has_attachments":          {Property: "hasAttachments", Type: filterTypeBool, Operators: boolOperators},
+	"is_read":                  {Property: "isRead", Type: filterTypeBool, Operators: boolOperators},
+	"is_draft":                 {Property: "isDraft", Type: filterTypeBool, Operators: boolOperators},
+	"received_date_time":       {Property: "receivedDateTime", Type: filterTypeDateTime, Operators: rangeOperators},
+	"sent_date_time":           {Property: "sentDateTime", Type: filterTypeDateTime, Operators: rangeOperators},
+	"from_address":             {Property: "from/emailAddress/address", Type: filterTypeString, Operators: equalOperators},
+	"importance":               {Property: "importance", Type: filterTypeString, Operators: equalOperators},
+	"inference_classification": {Property: "inferenceClassification", Type: filterTypeString, Operators: equalOperators},
+	"conversation_id":          {Property: "conversationId", Type: filterTypeString, Operators: equalOperators},
+}
+
 func tableMicrosoft365MailMessage(_ context.Context) *plugin.Table {
 	return &plugin.Table{
-		Name:        "microsoft365_mail_message",
-		Description: "Retrieves messages in the specified user's mailbox.",
+		Name:              "microsoft365_mail_message",
+		Description:       "Retrieves messages in the specified user's mailbox.",
+		GetMatrixItemFunc: tenantMatrix,
 		List: &plugin.ListConfig{
 			Hydrate: listMicrosoft365MailMessages,
-			KeyColumns: plugin.KeyColumnSlice{
+			KeyColumns: append(plugin.KeyColumnSlice{
 				// Key fields
 				{Name: "user_id", Require: plugin.Required},
+				{Name: "folder_id", Require: plugin.Optional},
+				{Name: "include_child_folders", Require: plugin.Optional},
 				{Name: "filter", Require: plugin.Optional},
-
-				// Other fields for filtering OData
-				{Name: "subject", Require: plugin.Optional},
-				{Name: "has_attachments", Require: plugin.Optional, Operators: []string{"<>", "="}},
-				{Name: "is_read", Require: plugin.Optional, Operators: []string{"<>", "="}},
-				{Name: "is_draft", Require: plugin.Optional, Operators: []string{"<>", "="}},
-			},
+				{Name: "query", Require: plugin.Optional},
+			}, mailMessageFilterColumns.keyColumns()...),
 			IgnoreConfig: &plugin.IgnoreConfig{
-				ShouldIgnoreErrorFunc: isIgnorableErrorPredicate([]string{"ErrorItemNotFound"}),
+				ShouldIgnoreErrorFunc: isIgnorableErrorPredicate([]ErrorCategory{ErrorCategoryNotFound}),
 			},
 		},
 		Get: &plugin.GetConfig{
 			Hydrate:    getMicrosoft365MailMessage,
 			KeyColumns: plugin.AllColumns([]string{"user_id", "id"}),
 			IgnoreConfig: &plugin.IgnoreConfig{
-				ShouldIgnoreErrorFunc: isIgnorableErrorPredicate([]string{"ErrorItemNotFound"}),
+				ShouldIgnoreErrorFunc: isIgnorableErrorPredicate([]ErrorCategory{ErrorCategoryNotFound}),
 			},
 		},
 		Columns: mailMessageColumns(),
@@ -106,6 +143,21 @@ func listMicrosoft365MailMessages(ctx context.Context, d *plugin.QueryData, _ *p
 		return nil, err
 	}
 
+	userID := d.EqualsQuals["user_id"].GetStringValue()
+
+	err = listMailMessages(ctx, d, client, adapter, userID)
+	if err != nil {
+		logger.Error("microsoft365_mail_message.listMicrosoft365MailMessages", "api_error", err)
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+// listMailMessages streams the messages of the user's mailbox, or of the
+// folder_id folder and, with include_child_folders, of its child folders. The
+// folders are listed one after the other, each newest first.
+func listMailMessages(ctx context.Context, d *plugin.QueryData, client *msgraphsdkgo.GraphServiceClient, adapter *msgraphsdkgo.GraphRequestAdapter, userID string) error {
 	// List operations
 	input := &users.ItemMessagesRequestBuilderGetQueryParameters{}
 
@@ -118,62 +170,144 @@ func listMicrosoft365MailMessages(ctx context.Context, d *plugin.QueryData, _ *p
 	}
 	input.Top = Int32(int32(pageSize))
 
-	// Check for query context and requests only for queried columns
-	givenColumns := d.QueryContext.Columns
-	selectColumns := buildMailMessageRequestFields(ctx, givenColumns)
-	input.Select = selectColumns
+	// Request only the properties of the queried columns
+	input.Select, input.Expand = mailMessageSelect.buildListSelect(d)
 
 	equalQuals := d.EqualsQuals
-	quals := d.Quals
 
 	var queryFilter string
-	filter := buildQueryFilter(equalQuals)
-	filter = append(filter, buildBoolNEFilter(quals)...)
-
 	if equalQuals["filter"] != nil {
 		queryFilter = equalQuals["filter"].GetStringValue()
 	}
 
-	if queryFilter != "" {
-		filter = append(filter, queryFilter)
+	// Exchange rejects $filter with $search, so the quals of the filter
+	// columns aren't pushed down, and Postgres filters the search results on
+	// them instead
+	if query := d.EqualsQualString("query"); query != "" {
+		if queryFilter != "" {
+			return fmt.Errorf("filter can't be combined with query, as Microsoft Graph doesn't support $filter with $search on messages")
+		}
+		input.Search = StringPtr(mailSearchQuery(query))
+		input.Top = Int32(int32(min(pageSize, mailSearchResultCap)))
+	} else {
+		// The filter is parenthesized, as it may contain an or that would
+		// otherwise bind looser than the pushed down quals
+		filter := mailMessageFilterColumns.buildFilter(d.Quals)
+		if queryFilter != "" {
+			filter = append(filter, "("+queryFilter+")")
+		}
+
+		input.Orderby = []string{mailMessageOrderBy}
+		filter = orderMailMessageFilter(filter)
+		if len(filter) > 0 {
+			joinStr := strings.Join(filter, " and ")
+			input.Filter = &joinStr
+		}
 	}
 
-	if len(filter) > 0 {
-		joinStr := strings.Join(filter, " and ")
-		input.Filter = &joinStr
+	folderID := d.EqualsQualString("folder_id")
+	if folderID == "" {
+		options := &users.ItemMessagesRequestBuilderGetRequestConfiguration{
+			QueryParameters: input,
+		}
+
+		result, err := client.Users().ByUserId(userID).Messages().Get(ctx, options)
+		if err != nil {
+			return getErrorObject(err)
+		}
+		_, err = streamMailMessages(ctx, d, adapter, result, userID, "")
+		return err
 	}
 
-	options := &users.ItemMessagesRequestBuilderGetRequestConfiguration{
-		QueryParameters: input,
+	folderIDs := []string{folderID}
+	if equalQuals["include_child_folders"].GetBoolValue() {
+		childIDs, err := listChildMailFolderIDs(ctx, client, adapter, userID, folderID)
+		if err != nil {
+			return err
+		}
+		folderIDs = append(folderIDs, childIDs...)
 	}
 
-	userID := d.EqualsQuals["user_id"].GetStringValue()
-	result, err := client.Users().ByUserId(userID).Messages().Get(ctx, options)
-	if err != nil {
-		errObj := getErrorObject(err)
-		return nil, errObj
+	options := &users.ItemMailFoldersItemMessagesRequestBuilderGetRequestConfiguration{
+		QueryParameters: &users.ItemMailFoldersItemMessagesRequestBuilderGetQueryParameters{
+			Top:     input.Top,
+			Select:  input.Select,
+			Expand:  input.Expand,
+			Filter:  input.Filter,
+			Search:  input.Search,
+			Orderby: input.Orderby,
+		},
+	}
+	for _, id := range folderIDs {
+		result, err := client.Users().ByUserId(userID).MailFolders().ByMailFolderId(id).Messages().Get(ctx, options)
+		if err != nil {
+			return getErrorObject(err)
+		}
+		more, err := streamMailMessages(ctx, d, adapter, result, userID, folderID)
+		if err != nil || !more {
+			return err
+		}
 	}
 
+	return nil
+}
+
+// orderMailMessageFilter returns the $filter clauses in an order Exchange
+// accepts with $orderby on receivedDateTime, which it only sorts a filtered
+// listing on if the $filter starts with that property. Clauses on
+// receivedDateTime are moved first, and a filter without any starts with one
+// matching every message.
+// https://learn.microsoft.com/en-us/graph/api/user-list-messages#using-filter-and-orderby-in-the-same-query
+func orderMailMessageFilter(filter []string) []string {
+	if len(filter) == 0 {
+		return filter
+	}
+
+	var received, others []string
+	for _, clause := range filter {
+		if strings.HasPrefix(clause, "receivedDateTime ") {
+			received = append(received, clause)
+		} else {
+			others = append(others, clause)
+		}
+	}
+	if len(received) == 0 {
+		received = []string{"receivedDateTime ge 1900-01-01T00:00:00Z"}
+	}
+	return append(received, others...)
+}
+
+// streamMailMessages streams the messages of the collection, and reports
+// whether more rows are wanted. The rows of a folder-scoped listing carry the
+// folder_id it was scoped to. Search results that reach the cap of Exchange
+// are logged, as they may be incomplete.
+func streamMailMessages(ctx context.Context, d *plugin.QueryData, adapter *msgraphsdkgo.GraphRequestAdapter, result models.MessageCollectionResponseable, userID string, folderID string) (bool, error) {
 	pageIterator, err := msgraphcore.NewPageIterator[models.Messageable](result, adapter, models.CreateMessageCollectionResponseFromDiscriminatorValue)
 	if err != nil {
-		logger.Error("listMicrosoft365MailMessages", "create_iterator_instance_error", err)
-		return nil, err
+		return false, err
 	}
 
+	more := true
+	count := 0
 	err = pageIterator.Iterate(ctx, func(pageItem models.Messageable) bool {
 		message := pageItem
 
-		d.StreamListItem(ctx, &Microsoft365MailMessageInfo{message, userID})
+		d.StreamListItem(ctx, &Microsoft365MailMessageInfo{message, userID, folderID})
+		count++
 
 		// Context can be cancelled due to manual cancellation or the limit has been hit
-		return d.RowsRemaining(ctx) != 0
+		more = d.RowsRemaining(ctx) != 0
+		return more
 	})
 	if err != nil {
-		logger.Error("listMicrosoft365MailMessages", "paging_error", err)
-		return nil, err
+		return false, err
 	}
 
-	return nil, nil
+	if count >= mailSearchResultCap && d.EqualsQualString("query") != "" {
+		plugin.Logger(ctx).Warn("streamMailMessages", "user_id", userID, "folder_id", folderID, "search_results_capped", count)
+	}
+
+	return more, nil
 }
 
 //// HYDRATE FUNCTIONS
@@ -197,10 +331,8 @@ func getMicrosoft365MailMessage(ctx context.Context, d *plugin.QueryData, _ *plu
 	// List operations
 	input := &users.ItemMessagesMessageItemRequestBuilderGetQueryParameters{}
 
-	// Check for query context and requests only for queried columns
-	givenColumns := d.QueryContext.Columns
-	selectColumns := buildMailMessageRequestFields(ctx, givenColumns)
-	input.Select = selectColumns
+	// Request only the properties of the queried columns
+	input.Select, input.Expand = mailMessageSelect.buildGetSelect(d)
 
 	options := &users.ItemMessagesMessageItemRequestBuilderGetRequestConfiguration{
 		QueryParameters: input,
@@ -212,66 +344,5 @@ func getMicrosoft365MailMessage(ctx context.Context, d *plugin.QueryData, _ *plu
 		return nil, errObj
 	}
 
-	return &Microsoft365MailMessageInfo{result, userID}, nil
-}
-
-func buildMailMessageRequestFields(ctx context.Context, queryColumns []string) []string {
-	var selectColumns []string
-
-	for _, columnName := range queryColumns {
-		if columnName == "title" || columnName == "filter" || columnName == "user_id" || columnName == "_ctx" || columnName == "tenant_id" {
-			continue
-		}
-		selectColumns = append(selectColumns, strcase.ToLowerCamel(columnName))
-	}
-
-	return selectColumns
-}
-
-func buildQueryFilter(equalQuals plugin.KeyColumnEqualsQualMap) []string {
-	filters := []string{}
-
-	filterQuals := map[string]string{
-		"subject":         "string",
-		"has_attachments": "bool",
-		"is_read":         "bool",
-		"is_draft":        "bool",
-	}
-
-	for qual, qualType := range filterQuals {
-		switch qualType {
-		case "string":
-			if equalQuals[qual] != nil {
-				filters = append(filters, fmt.Sprintf("%s eq '%s'", strcase.ToCamel(qual), equalQuals[qual].GetStringValue()))
-			}
-		case "bool":
-			if equalQuals[qual] != nil {
-				filters = append(filters, fmt.Sprintf("%s eq %t", strcase.ToCamel(qual), equalQuals[qual].GetBoolValue()))
-			}
-		}
-	}
-	return filters
-}
-
-func buildBoolNEFilter(quals plugin.KeyColumnQualMap) []string {
-	filters := []string{}
-
-	filterQuals := []string{
-		"has_attachments",
-		"is_read",
-		"is_draft",
-	}
-
-	for _, qual := range filterQuals {
-		if quals[qual] != nil {
-			for _, q := range quals[qual].Quals {
-				value := q.Value.GetBoolValue()
-				if q.Operator == "<>" {
-					filters = append(filters, fmt.Sprintf("%s eq %t", strcase.ToCamel(qual), !value))
-					break
-				}
-			}
-		}
-	}
-	return filters
+	return &Microsoft365MailMessageInfo{result, userID, ""}, nil
 }
@@ -0,0 +1,87 @@
+package microsoft365
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/turbot/steampipe-plugin-sdk/v5/grpc/proto"
+	"github.com/turbot/steampipe-plugin-sdk/v5/plugin"
+	"github.com/turbot/steampipe-plugin-sdk/v5/plugin/transform"
+
+	"github.com/microsoftgraph/msgraph-sdk-go/models"
+	"github.com/microsoftgraph/msgraph-sdk-go/users"
+)
+
+//// TABLE DEFINITION
+
+func tableMicrosoft365MailMessageDelta(_ context.Context) *plugin.Table {
+	return &plugin.Table{
+		Name:              "microsoft365_mail_message_delta",
+		Description:       "Messages in a mail folder of the specified user created, updated or deleted since the last query of the table.",
+		GetMatrixItemFunc: tenantMatrix,
+		List: &plugin.ListConfig{
+			Hydrate: listMicrosoft365MailMessageDeltas,
+			KeyColumns: plugin.KeyColumnSlice{
+				{Name: "user_id", Require: plugin.Required},
+				{Name: "folder_id", Require: plugin.Optional},
+			},
+			IgnoreConfig: &plugin.IgnoreConfig{
+				ShouldIgnoreErrorFunc: isIgnorableErrorPredicate([]ErrorCategory{ErrorCategoryNotFound}),
+			},
+		},
+		Columns: append(deltaColumns(mailMessageColumns(), "filter", "query", "folder_id", "include_child_folders"),
+			&plugin.Column{Name: "folder_id", Type: proto.ColumnType_STRING, Description: "The ID or well-known name, e.g. inbox or sentitems, of the mail folder to sync. Defaults to inbox.", Transform: transform.FromField("FolderID")},
+		),
+	}
+}
+
+//// LIST FUNCTION
+
+func listMicrosoft365MailMessageDeltas(ctx context.Context, d *plugin.QueryData, _ *plugin.HydrateData) (interface{}, error) {
+	logger := plugin.Logger(ctx)
+
+	// Create client
+	client, adapter, err := GetGraphClient(ctx, d)
+	if err != nil {
+		logger.Error("microsoft365_mail_message_delta.listMicrosoft365MailMessageDeltas", "connection_error", err)
+		return nil, err
+	}
+
+	userID := d.EqualsQualString("user_id")
+	folderID := d.EqualsQualString("folder_id")
+	if folderID == "" {
+		folderID = "inbox"
+	}
+
+	input := &users.ItemMailFoldersItemMessagesDeltaRequestBuilderGetQueryParameters{}
+
+	// The properties of every column, since the deltaLink keeps those of the first request
+	input.Select, _ = mailMessageSelect.buildDeltaSelect(d.Table)
+
+	info, err := client.Users().ByUserId(userID).MailFolders().ByMailFolderId(folderID).Messages().Delta().ToGetRequestInformation(ctx, &users.ItemMailFoldersItemMessagesDeltaRequestBuilderGetRequestConfiguration{
+		QueryParameters: input,
+	})
+	if err != nil {
+		return nil, err
+	}
+	start, err := info.GetUri()
+	if err != nil {
+		return nil, err
+	}
+
+	err = listDelta(ctx, d, deltaQuery[models.Messageable]{
+		Scope: fmt.Sprintf("users/%s/mailFolders/%s/messages", userID, folderID),
+		Start: start.String(),
+		Get: func(ctx context.Context, url string) (deltaPage[models.Messageable], error) {
+			return users.NewItemMailFoldersItemMessagesDeltaRequestBuilder(url, adapter).GetAsDeltaGetResponse(ctx, nil)
+		},
+	}, func(message models.Messageable, changeType string) {
+		d.StreamListItem(ctx, &Microsoft365MailMessageDeltaInfo{Microsoft365MailMessageInfo{message, userID, folderID}, changeType})
+	})
+	if err != nil {
+		logger.Error("listMicrosoft365MailMessageDeltas", "paging_error", err)
+		return nil, getErrorObject(err)
+	}
+
+	return nil, nil
+}
@@ -13,20 +13,21 @@ import (
 
 func tableMicrosoft365MyCalendar(_ context.Context) *plugin.Table {
 	return &plugin.Table{
-		Name:        "microsoft365_my_calendar",
-		Description: "Metadata of the specified user's calendar.",
+		Name:              "microsoft365_my_calendar",
+		Description:       "Metadata of the specified user's calendar.",
+		GetMatrixItemFunc: tenantMatrix,
 		List: &plugin.ListConfig{
 			ParentHydrate: listMicrosoft365MyCalendarGroups,
 			Hydrate:       listMicrosoft365MyCalendars,
 			IgnoreConfig: &plugin.IgnoreConfig{
-				ShouldIgnoreErrorFunc: isIgnorableErrorPredicate([]string{"ErrorItemNotFound"}),
+				ShouldIgnoreErrorFunc: isIgnorableErrorPredicate([]ErrorCategory{ErrorCategoryNotFound}),
 			},
 		},
 		Get: &plugin.GetConfig{
 			Hydrate:    getMicrosoft365MyCalendar,
 			KeyColumns: plugin.AllColumns([]string{"calendar_group_id", "id"}),
 			IgnoreConfig: &plugin.IgnoreConfig{
-				ShouldIgnoreErrorFunc: isIgnorableErrorPredicate([]string{"ErrorItemNotFound"}),
+				ShouldIgnoreErrorFunc: isIgnorableErrorPredicate([]ErrorCategory{ErrorCategoryNotFound}),
 			},
 		},
 		Columns: calendarColumns(),
@@ -47,8 +48,7 @@ func listMicrosoft365MyCalendars(ctx context.Context, d *plugin.QueryData, h *pl
 		return nil, err
 	}
 
-	getUserIDCached := plugin.HydrateFunc(getUserID).WithCache()
-	userIDCached, err := getUserIDCached(ctx, d, h)
+	userIDCached, err := getUserID(ctx, d, h)
 	if err != nil {
 		return nil, err
 	}
@@ -115,8 +115,7 @@ func getMicrosoft365MyCalendar(ctx context.Context, d *plugin.QueryData, h *plug
 		return nil, err
 	}
 
-	getUserIDCached := plugin.HydrateFunc(getUserID).WithCache()
-	userIDCached, err := getUserIDCached(ctx, d, h)
+	userIDCached, err := getUserID(ctx, d, h)
 	if err != nil {
 		return nil, err
 	}
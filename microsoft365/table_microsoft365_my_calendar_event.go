@@ -15,8 +15,9 @@ import (
 
 func tableMicrosoft365MyCalendarEvent(_ context.Context) *plugin.Table {
 	return &plugin.Table{
-		Name:        "microsoft365_my_calendar_event",
-		Description: "Events scheduled on the specified calendar.",
+		Name:              "microsoft365_my_calendar_event",
+		Description:       "Events scheduled on the specified calendar.",
+		GetMatrixItemFunc: tenantMatrix,
 		List: &plugin.ListConfig{
 			Hydrate: listMicrosoft365MyCalendarEvents,
 			KeyColumns: []*plugin.KeyColumn{
@@ -32,14 +33,14 @@ func tableMicrosoft365MyCalendarEvent(_ context.Context) *plugin.Table {
 				},
 			},
 			IgnoreConfig: &plugin.IgnoreConfig{
-				ShouldIgnoreErrorFunc: isIgnorableErrorPredicate([]string{"ErrorItemNotFound", "UnsupportedQueryOption"}),
+				ShouldIgnoreErrorFunc: isIgnorableErrorPredicate([]ErrorCategory{ErrorCategoryNotFound}, "UnsupportedQueryOption"),
 			},
 		},
 		Get: &plugin.GetConfig{
 			Hydrate:    getMicrosoft365MyCalendarEvent,
 			KeyColumns: plugin.SingleColumn("id"),
 			IgnoreConfig: &plugin.IgnoreConfig{
-				ShouldIgnoreErrorFunc: isIgnorableErrorPredicate([]string{"ErrorItemNotFound"}),
+				ShouldIgnoreErrorFunc: isIgnorableErrorPredicate([]ErrorCategory{ErrorCategoryNotFound}),
 			},
 		},
 		Columns: calendarEventColumns(),
@@ -58,8 +59,7 @@ func listMicrosoft365MyCalendarEvents(ctx context.Context, d *plugin.QueryData,
 		return nil, err
 	}
 
-	getUserIDCached := plugin.HydrateFunc(getUserID).WithCache()
-	userIDCached, err := getUserIDCached(ctx, d, h)
+	userIDCached, err := getUserID(ctx, d, h)
 	if err != nil {
 		return nil, err
 	}
@@ -76,6 +76,9 @@ func listMicrosoft365MyCalendarEvents(ctx context.Context, d *plugin.QueryData,
 	}
 	input.Top = Int32(int32(pageSize))
 
+	// Request only the properties of the queried columns
+	input.Select, input.Expand = calendarEventSelect.buildListSelect(d)
+
 	var result models.EventCollectionResponseable
 
 	// Filter event using timestamp
@@ -144,6 +147,9 @@ func listMicrosoft365MyCalendarEvents(ctx context.Context, d *plugin.QueryData,
 		}
 		input.Top = Int32(int32(pageSize))
 
+		// Request only the properties of the queried columns
+		input.Select, input.Expand = calendarEventSelect.buildListSelect(d)
+
 		options := &users.ItemEventsRequestBuilderGetRequestConfiguration{
 			QueryParameters: input,
 		}
@@ -194,14 +200,22 @@ func getMicrosoft365MyCalendarEvent(ctx context.Context, d *plugin.QueryData, h
 		return nil, err
 	}
 
-	getUserIDCached := plugin.HydrateFunc(getUserID).WithCache()
-	userIDCached, err := getUserIDCached(ctx, d, h)
+	userIDCached, err := getUserID(ctx, d, h)
 	if err != nil {
 		return nil, err
 	}
 	userID := userIDCached.(string)
 
-	result, err := client.Users().ByUserId(userID).Events().ByEventId(eventID).Get(ctx, nil)
+	input := &users.ItemEventsEventItemRequestBuilderGetQueryParameters{}
+
+	// Request only the properties of the queried columns
+	input.Select, input.Expand = calendarEventSelect.buildGetSelect(d)
+
+	options := &users.ItemEventsEventItemRequestBuilderGetRequestConfiguration{
+		QueryParameters: input,
+	}
+
+	result, err := client.Users().ByUserId(userID).Events().ByEventId(eventID).Get(ctx, options)
 	if err != nil {
 		errObj := getErrorObject(err)
 		return nil, errObj
@@ -14,8 +14,9 @@ import (
 
 func tableMicrosoft365MyCalendarGroup(_ context.Context) *plugin.Table {
 	return &plugin.Table{
-		Name:        "microsoft365_my_calendar_group",
-		Description: "List all the calendar groups of specified user.",
+		Name:              "microsoft365_my_calendar_group",
+		Description:       "List all the calendar groups of specified user.",
+		GetMatrixItemFunc: tenantMatrix,
 		List: &plugin.ListConfig{
 			Hydrate: listMicrosoft365MyCalendarGroups,
 		},
@@ -23,7 +24,7 @@ func tableMicrosoft365MyCalendarGroup(_ context.Context) *plugin.Table {
 			Hydrate:    getMicrosoft365MyCalendarGroup,
 			KeyColumns: plugin.SingleColumn("id"),
 			IgnoreConfig: &plugin.IgnoreConfig{
-				ShouldIgnoreErrorFunc: isIgnorableErrorPredicate([]string{"ResourceNotFound"}),
+				ShouldIgnoreErrorFunc: isIgnorableErrorPredicate([]ErrorCategory{ErrorCategoryNotFound}),
 			},
 		},
 		Columns: calendarGroupColumns(),
@@ -42,8 +43,7 @@ func listMicrosoft365MyCalendarGroups(ctx context.Context, d *plugin.QueryData,
 		return nil, err
 	}
 
-	getUserIDCached := plugin.HydrateFunc(getUserID).WithCache()
-	userIDCached, err := getUserIDCached(ctx, d, h)
+	userIDCached, err := getUserID(ctx, d, h)
 	if err != nil {
 		return nil, err
 	}
@@ -109,8 +109,7 @@ func getMicrosoft365MyCalendarGroup(ctx context.Context, d *plugin.QueryData, h
 		return nil, err
 	}
 
-	getUserIDCached := plugin.HydrateFunc(getUserID).WithCache()
-	userIDCached, err := getUserIDCached(ctx, d, h)
+	userIDCached, err := getUserID(ctx, d, h)
 	if err != nil {
 		return nil, err
 	}
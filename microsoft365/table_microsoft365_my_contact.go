@@ -14,19 +14,20 @@ import (
 
 func tableMicrosoft365MyContact(_ context.Context) *plugin.Table {
 	return &plugin.Table{
-		Name:        "microsoft365_my_contact",
-		Description: "Contacts owned by the specified user.",
+		Name:              "microsoft365_my_contact",
+		Description:       "Contacts owned by the specified user.",
+		GetMatrixItemFunc: tenantMatrix,
 		List: &plugin.ListConfig{
 			Hydrate: listMicrosoft365MyContacts,
 			IgnoreConfig: &plugin.IgnoreConfig{
-				ShouldIgnoreErrorFunc: isIgnorableErrorPredicate([]string{"ErrorItemNotFound"}),
+				ShouldIgnoreErrorFunc: isIgnorableErrorPredicate([]ErrorCategory{ErrorCategoryNotFound}),
 			},
 		},
 		Get: &plugin.GetConfig{
 			Hydrate:    getMicrosoft365MyContact,
 			KeyColumns: plugin.SingleColumn("id"),
 			IgnoreConfig: &plugin.IgnoreConfig{
-				ShouldIgnoreErrorFunc: isIgnorableErrorPredicate([]string{"ErrorItemNotFound"}),
+				ShouldIgnoreErrorFunc: isIgnorableErrorPredicate([]ErrorCategory{ErrorCategoryNotFound}),
 			},
 		},
 		Columns: contactColumns(),
@@ -45,8 +46,7 @@ func listMicrosoft365MyContacts(ctx context.Context, d *plugin.QueryData, h *plu
 		return nil, err
 	}
 
-	getUserIDCached := plugin.HydrateFunc(getUserID).WithCache()
-	userIDCached, err := getUserIDCached(ctx, d, h)
+	userIDCached, err := getUserID(ctx, d, h)
 	if err != nil {
 		return nil, err
 	}
@@ -64,6 +64,9 @@ func listMicrosoft365MyContacts(ctx context.Context, d *plugin.QueryData, h *plu
 	}
 	input.Top = Int32(int32(pageSize))
 
+	// Request only the properties of the queried columns
+	input.Select, input.Expand = contactSelect.buildListSelect(d)
+
 	options := &users.ItemContactsRequestBuilderGetRequestConfiguration{
 		QueryParameters: input,
 	}
@@ -113,14 +116,22 @@ func getMicrosoft365MyContact(ctx context.Context, d *plugin.QueryData, h *plugi
 		return nil, err
 	}
 
-	getUserIDCached := plugin.HydrateFunc(getUserID).WithCache()
-	userIDCached, err := getUserIDCached(ctx, d, h)
+	userIDCached, err := getUserID(ctx, d, h)
 	if err != nil {
 		return nil, err
 	}
 	userID := userIDCached.(string)
 
-	result, err := client.Users().ByUserId(userID).Contacts().ByContactId(contactID).Get(ctx, nil)
+	input := &users.ItemContactsContactItemRequestBuilderGetQueryParameters{}
+
+	// Request only the properties of the queried columns
+	input.Select, input.Expand = contactSelect.buildGetSelect(d)
+
+	options := &users.ItemContactsContactItemRequestBuilderGetRequestConfiguration{
+		QueryParameters: input,
+	}
+
+	result, err := client.Users().ByUserId(userID).Contacts().ByContactId(contactID).Get(ctx, options)
 	if err != nil {
 		errObj := getErrorObject(err)
 		return nil, errObj
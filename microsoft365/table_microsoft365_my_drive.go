@@ -15,8 +15,9 @@ import (
 
 func tableMicrosoft365MyDrive(_ context.Context) *plugin.Table {
 	return &plugin.Table{
-		Name:        "microsoft365_my_drive",
-		Description: "Drives defined user's shared drives in the Google Drive.",
+		Name:              "microsoft365_my_drive",
+		Description:       "Drives defined user's shared drives in the Google Drive.",
+		GetMatrixItemFunc: tenantMatrix,
 		List: &plugin.ListConfig{
 			Hydrate: listMicrosoft365MyDrives,
 		},
@@ -24,7 +25,7 @@ func tableMicrosoft365MyDrive(_ context.Context) *plugin.Table {
 			Hydrate:    getMicrosoft365MyDrive,
 			KeyColumns: plugin.SingleColumn("id"),
 			IgnoreConfig: &plugin.IgnoreConfig{
-				ShouldIgnoreErrorFunc: isIgnorableErrorPredicate([]string{"itemNotFound"}),
+				ShouldIgnoreErrorFunc: isIgnorableErrorPredicate([]ErrorCategory{ErrorCategoryNotFound}),
 			},
 		},
 		Columns: driveColumns(),
@@ -43,8 +44,7 @@ func listMicrosoft365MyDrives(ctx context.Context, d *plugin.QueryData, h *plugi
 		return nil, err
 	}
 
-	getUserIDCached := plugin.HydrateFunc(getUserID).WithCache()
-	userIDCached, err := getUserIDCached(ctx, d, h)
+	userIDCached, err := getUserID(ctx, d, h)
 	if err != nil {
 		return nil, err
 	}
@@ -61,9 +61,12 @@ func listMicrosoft365MyDrives(ctx context.Context, d *plugin.QueryData, h *plugi
 	}
 	input.Top = Int32(int32(pageSize))
 
+	// Request only the properties of the queried columns
+	input.Select, input.Expand = driveSelect.buildListSelect(d)
+
 	var queryFilter string
 	equalQuals := d.EqualsQuals
-	filter := buildDriveQueryFilter(equalQuals)
+	filter := driveFilterColumns.buildFilter(d.Quals)
 
 	if equalQuals["filter"] != nil {
 		queryFilter = equalQuals["filter"].GetStringValue()
@@ -127,14 +130,22 @@ func getMicrosoft365MyDrive(ctx context.Context, d *plugin.QueryData, h *plugin.
 		return nil, err
 	}
 
-	getUserIDCached := plugin.HydrateFunc(getUserID).WithCache()
-	userIDCached, err := getUserIDCached(ctx, d, h)
+	userIDCached, err := getUserID(ctx, d, h)
 	if err != nil {
 		return nil, err
 	}
 	userID := userIDCached.(string)
 
-	result, err := client.Users().ByUserId(userID).Drives().ByDriveId(driveID).Get(ctx, nil)
+	input := &users.ItemDrivesDriveItemRequestBuilderGetQueryParameters{}
+
+	// Request only the properties of the queried columns
+	input.Select, input.Expand = driveSelect.buildGetSelect(d)
+
+	options := &users.ItemDrivesDriveItemRequestBuilderGetRequestConfiguration{
+		QueryParameters: input,
+	}
+
+	result, err := client.Users().ByUserId(userID).Drives().ByDriveId(driveID).Get(ctx, options)
 	if err != nil {
 		errObj := getErrorObject(err)
 		return nil, errObj
@@ -14,20 +14,21 @@ import (
 
 func tableMicrosoft365MyDriveFile(_ context.Context) *plugin.Table {
 	return &plugin.Table{
-		Name:        "microsoft365_my_drive_file",
-		Description: "Retrieves file's metadata or content owned by an user.",
+		Name:              "microsoft365_my_drive_file",
+		Description:       "Retrieves file's metadata or content owned by an user.",
+		GetMatrixItemFunc: tenantMatrix,
 		List: &plugin.ListConfig{
 			Hydrate:       listMicrosoft365MyDriveFiles,
 			ParentHydrate: listMicrosoft365MyDrives,
 			IgnoreConfig: &plugin.IgnoreConfig{
-				ShouldIgnoreErrorFunc: isIgnorableErrorPredicate([]string{"itemNotFound"}),
+				ShouldIgnoreErrorFunc: isIgnorableErrorPredicate([]ErrorCategory{ErrorCategoryNotFound}),
 			},
 		},
 		Get: &plugin.GetConfig{
 			Hydrate:    getMicrosoft365MyDriveFile,
 			KeyColumns: plugin.AllColumns([]string{"drive_id", "id"}),
 			IgnoreConfig: &plugin.IgnoreConfig{
-				ShouldIgnoreErrorFunc: isIgnorableErrorPredicate([]string{"itemNotFound"}),
+				ShouldIgnoreErrorFunc: isIgnorableErrorPredicate([]ErrorCategory{ErrorCategoryNotFound}),
 			},
 		},
 		Columns: driveFileColumns(),
@@ -52,8 +53,7 @@ func listMicrosoft365MyDriveFiles(ctx context.Context, d *plugin.QueryData, h *p
 		return nil, err
 	}
 
-	getUserIDCached := plugin.HydrateFunc(getUserID).WithCache()
-	userIDCached, err := getUserIDCached(ctx, d, h)
+	userIDCached, err := getUserID(ctx, d, h)
 	if err != nil {
 		return nil, err
 	}
@@ -157,8 +157,7 @@ func getMicrosoft365MyDriveFile(ctx context.Context, d *plugin.QueryData, h *plu
 		return nil, err
 	}
 
-	getUserIDCached := plugin.HydrateFunc(getUserID).WithCache()
-	userIDCached, err := getUserIDCached(ctx, d, h)
+	userIDCached, err := getUserID(ctx, d, h)
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,84 @@
+package microsoft365
+
+import (
+	"context"
+
+	"github.com/turbot/steampipe-plugin-sdk/v5/plugin"
+)
+
+//// TABLE DEFINITION
+
+func tableMicrosoft365MyMailFolder(_ context.Context) *plugin.Table {
+	return &plugin.Table{
+		Name:              "microsoft365_my_mail_folder",
+		Description:       "Retrieves the mail folders, including hidden and child folders, in the current user's mailbox.",
+		GetMatrixItemFunc: tenantMatrix,
+		List: &plugin.ListConfig{
+			Hydrate: listMicrosoft365MyMailFolders,
+			IgnoreConfig: &plugin.IgnoreConfig{
+				ShouldIgnoreErrorFunc: isIgnorableErrorPredicate([]ErrorCategory{ErrorCategoryNotFound}),
+			},
+		},
+		Get: &plugin.GetConfig{
+			Hydrate:    getMicrosoft365MyMailFolder,
+			KeyColumns: plugin.SingleColumn("id"),
+			IgnoreConfig: &plugin.IgnoreConfig{
+				ShouldIgnoreErrorFunc: isIgnorableErrorPredicate([]ErrorCategory{ErrorCategoryNotFound}),
+			},
+		},
+		Columns: mailFolderColumns(),
+	}
+}
+
+//// LIST FUNCTION
+
+func listMicrosoft365MyMailFolders(ctx context.Context, d *plugin.QueryData, h *plugin.HydrateData) (interface{}, error) {
+	logger := plugin.Logger(ctx)
+
+	// Create client
+	client, adapter, err := GetGraphClient(ctx, d)
+	if err != nil {
+		logger.Error("microsoft365_my_mail_folder.listMicrosoft365MyMailFolders", "connection_error", err)
+		return nil, err
+	}
+
+	userIDCached, err := getUserID(ctx, d, h)
+	if err != nil {
+		return nil, err
+	}
+	userID := userIDCached.(string)
+
+	err = listMailFolders(ctx, d, client, adapter, userID)
+	if err != nil {
+		logger.Error("microsoft365_my_mail_folder.listMicrosoft365MyMailFolders", "api_error", err)
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+//// HYDRATE FUNCTIONS
+
+func getMicrosoft365MyMailFolder(ctx context.Context, d *plugin.QueryData, h *plugin.HydrateData) (interface{}, error) {
+	logger := plugin.Logger(ctx)
+
+	id := d.EqualsQualString("id")
+	if id == "" {
+		return nil, nil
+	}
+
+	// Create client
+	client, _, err := GetGraphClient(ctx, d)
+	if err != nil {
+		logger.Error("microsoft365_my_mail_folder.getMicrosoft365MyMailFolder", "connection_error", err)
+		return nil, err
+	}
+
+	userIDCached, err := getUserID(ctx, d, h)
+	if err != nil {
+		return nil, err
+	}
+	userID := userIDCached.(string)
+
+	return getMailFolder(ctx, d, client, userID, id)
+}
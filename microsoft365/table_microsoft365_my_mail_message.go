@@ -2,10 +2,7 @@ package microsoft365
 
 import (
 	"context"
-	"strings"
 
-	msgraphcore "github.com/microsoftgraph/msgraph-sdk-go-core"
-	"github.com/microsoftgraph/msgraph-sdk-go/models"
 	"github.com/microsoftgraph/msgraph-sdk-go/users"
 
 	"github.com/turbot/steampipe-plugin-sdk/v5/plugin"
@@ -15,29 +12,27 @@ import (
 
 func tableMicrosoft365MyMailMessage(_ context.Context) *plugin.Table {
 	return &plugin.Table{
-		Name:        "microsoft365_my_mail_message",
-		Description: "Retrieves messages in the specified user's mailbox.",
+		Name:              "microsoft365_my_mail_message",
+		Description:       "Retrieves messages in the specified user's mailbox.",
+		GetMatrixItemFunc: tenantMatrix,
 		List: &plugin.ListConfig{
 			Hydrate: listMicrosoft365MyMailMessages,
-			KeyColumns: plugin.KeyColumnSlice{
+			KeyColumns: append(plugin.KeyColumnSlice{
 				// Key fields
+				{Name: "folder_id", Require: plugin.Optional},
+				{Name: "include_child_folders", Require: plugin.Optional},
 				{Name: "filter", Require: plugin.Optional},
-
-				// Other fields for filtering OData
-				{Name: "subject", Require: plugin.Optional},
-				{Name: "has_attachments", Require: plugin.Optional, Operators: []string{"<>", "="}},
-				{Name: "is_read", Require: plugin.Optional, Operators: []string{"<>", "="}},
-				{Name: "is_draft", Require: plugin.Optional, Operators: []string{"<>", "="}},
-			},
+				{Name: "query", Require: plugin.Optional},
+			}, mailMessageFilterColumns.keyColumns()...),
 			IgnoreConfig: &plugin.IgnoreConfig{
-				ShouldIgnoreErrorFunc: isIgnorableErrorPredicate([]string{"ErrorItemNotFound"}),
+				ShouldIgnoreErrorFunc: isIgnorableErrorPredicate([]ErrorCategory{ErrorCategoryNotFound}),
 			},
 		},
 		Get: &plugin.GetConfig{
 			Hydrate:    getMicrosoft365MyMailMessage,
 			KeyColumns: plugin.SingleColumn("id"),
 			IgnoreConfig: &plugin.IgnoreConfig{
-				ShouldIgnoreErrorFunc: isIgnorableErrorPredicate([]string{"ErrorItemNotFound"}),
+				ShouldIgnoreErrorFunc: isIgnorableErrorPredicate([]ErrorCategory{ErrorCategoryNotFound}),
 			},
 		},
 		Columns: mailMessageColumns(),
@@ -56,76 +51,15 @@ func listMicrosoft365MyMailMessages(ctx context.Context, d *plugin.QueryData, h
 		return nil, err
 	}
 
-	getUserIDCached := plugin.HydrateFunc(getUserID).WithCache()
-	userIDCached, err := getUserIDCached(ctx, d, h)
+	userIDCached, err := getUserID(ctx, d, h)
 	if err != nil {
 		return nil, err
 	}
 	userID := userIDCached.(string)
 
-	// List operations
-	input := &users.ItemMessagesRequestBuilderGetQueryParameters{}
-
-	// Minimum value is 1 (this function isn't run if "limit 0" is specified)
-	// Maximum value is unknown (tested up to 9999)
-	pageSize := int64(9999)
-	limit := d.QueryContext.Limit
-	if limit != nil && *limit < pageSize {
-		pageSize = *limit
-	}
-	input.Top = Int32(int32(pageSize))
-
-	// Check for query context and requests only for queried columns
-	givenColumns := d.QueryContext.Columns
-	selectColumns := buildMailMessageRequestFields(ctx, givenColumns)
-	input.Select = selectColumns
-
-	equalQuals := d.EqualsQuals
-	quals := d.Quals
-
-	var queryFilter string
-	filter := buildQueryFilter(equalQuals)
-	filter = append(filter, buildBoolNEFilter(quals)...)
-
-	if equalQuals["filter"] != nil {
-		queryFilter = equalQuals["filter"].GetStringValue()
-	}
-
-	if queryFilter != "" {
-		filter = append(filter, queryFilter)
-	}
-
-	if len(filter) > 0 {
-		joinStr := strings.Join(filter, " and ")
-		input.Filter = &joinStr
-	}
-
-	options := &users.ItemMessagesRequestBuilderGetRequestConfiguration{
-		QueryParameters: input,
-	}
-
-	result, err := client.Users().ByUserId(userID).Messages().Get(ctx, options)
-	if err != nil {
-		errObj := getErrorObject(err)
-		return nil, errObj
-	}
-
-	pageIterator, err := msgraphcore.NewPageIterator[models.Messageable](result, adapter, models.CreateMessageCollectionResponseFromDiscriminatorValue)
-	if err != nil {
-		logger.Error("listMicrosoft365MyMailMessages", "create_iterator_instance_error", err)
-		return nil, err
-	}
-
-	err = pageIterator.Iterate(ctx, func(pageItem models.Messageable) bool {
-		message := pageItem
-
-		d.StreamListItem(ctx, &Microsoft365MailMessageInfo{message, userID})
-
-		// Context can be cancelled due to manual cancellation or the limit has been hit
-		return d.RowsRemaining(ctx) != 0
-	})
+	err = listMailMessages(ctx, d, client, adapter, userID)
 	if err != nil {
-		logger.Error("listMicrosoft365MyMailMessages", "paging_error", err)
+		logger.Error("microsoft365_my_mail_message.listMicrosoft365MyMailMessages", "api_error", err)
 		return nil, err
 	}
 
@@ -149,8 +83,7 @@ func getMicrosoft365MyMailMessage(ctx context.Context, d *plugin.QueryData, h *p
 		return nil, err
 	}
 
-	getUserIDCached := plugin.HydrateFunc(getUserID).WithCache()
-	userIDCached, err := getUserIDCached(ctx, d, h)
+	userIDCached, err := getUserID(ctx, d, h)
 	if err != nil {
 		return nil, err
 	}
@@ -159,10 +92,8 @@ func getMicrosoft365MyMailMessage(ctx context.Context, d *plugin.QueryData, h *p
 	// List operations
 	input := &users.ItemMessagesMessageItemRequestBuilderGetQueryParameters{}
 
-	// Check for query context and requests only for queried columns
-	givenColumns := d.QueryContext.Columns
-	selectColumns := buildMailMessageRequestFields(ctx, givenColumns)
-	input.Select = selectColumns
+	// Request only the properties of the queried columns
+	input.Select, input.Expand = mailMessageSelect.buildGetSelect(d)
 
 	options := &users.ItemMessagesMessageItemRequestBuilderGetRequestConfiguration{
 		QueryParameters: input,
@@ -174,5 +105,5 @@ func getMicrosoft365MyMailMessage(ctx context.Context, d *plugin.QueryData, h *p
 		return nil, errObj
 	}
 
-	return &Microsoft365MailMessageInfo{result, userID}, nil
+	return &Microsoft365MailMessageInfo{result, userID, ""}, nil
 }
@@ -48,12 +48,13 @@ func organizationColumns() []*plugin.Column {
 
 func tableMicrosoft365Organization(_ context.Context) *plugin.Table {
 	return &plugin.Table{
-		Name:        "microsoft365_organization",
-		Description: "Microsoft 365 organization information including SharePoint, authentication, security defaults, and security settings.",
+		Name:              "microsoft365_organization",
+		Description:       "Microsoft 365 organization information including SharePoint, authentication, security defaults, and security settings.",
+		GetMatrixItemFunc: tenantMatrix,
 		List: &plugin.ListConfig{
 			Hydrate: listMicrosoft365Organization,
 			IgnoreConfig: &plugin.IgnoreConfig{
-				ShouldIgnoreErrorFunc: isIgnorableErrorPredicate([]string{"itemNotFound", "accessDenied"}),
+				ShouldIgnoreErrorFunc: isIgnorableErrorPredicate([]ErrorCategory{ErrorCategoryNotFound, ErrorCategoryPermission}),
 			},
 		},
 		Columns: organizationColumns(),
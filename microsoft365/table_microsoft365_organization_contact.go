@@ -2,10 +2,8 @@ package microsoft365
 
 import (
 	"context"
-	"fmt"
 	"strings"
 
-	"github.com/iancoleman/strcase"
 	"github.com/turbot/steampipe-plugin-sdk/v5/grpc/proto"
 	"github.com/turbot/steampipe-plugin-sdk/v5/plugin"
 	"github.com/turbot/steampipe-plugin-sdk/v5/plugin/transform"
@@ -15,6 +13,24 @@ import (
 	"github.com/microsoftgraph/msgraph-sdk-go/models"
 )
 
+// orgContactSelect maps the organizational contact columns to the
+// properties to $select. Only one navigation property is expanded, since
+// directory objects don't support expanding several.
+var orgContactSelect = odataSelect{
+	Properties: map[string][]string{
+		"title":                {"displayName"},
+		"filter":               nil,
+		"search":               nil,
+		"direct_reports":       nil,
+		"member_of":            nil,
+		"transitive_member_of": nil,
+	},
+	Expand: map[string]string{
+		"manager": "manager",
+	},
+	Required: []string{"id"},
+}
+
 func organizationContactColumns() []*plugin.Column {
 	return commonColumns([]*plugin.Column{
 		{Name: "display_name", Type: proto.ColumnType_STRING, Description: "The contact's display name.", Transform: transform.FromMethod("GetDisplayName")},
@@ -44,33 +60,40 @@ func organizationContactColumns() []*plugin.Column {
 		// Standard columns
 		{Name: "title", Type: proto.ColumnType_STRING, Description: ColumnDescriptionTitle, Transform: transform.FromMethod("GetDisplayName")},
 		{Name: "filter", Type: proto.ColumnType_STRING, Transform: transform.FromQual("filter"), Description: "Odata query to search for resources."},
+		{Name: "search", Type: proto.ColumnType_STRING, Transform: transform.FromQual("search"), Description: "Search query for resources, e.g. displayName:wa or \"displayName:wa\" OR \"mail:wa\"."},
 	})
 }
 
 //// TABLE DEFINITION
 
+// orgContactFilterColumns are the columns Graph filters organizational
+// contacts on
+var orgContactFilterColumns = odataFilterColumns{
+	"display_name": {Property: "displayName", Type: filterTypeString, Operators: directoryStringOperators, AdvancedOperators: directoryAdvancedStringOperators},
+	"given_name":   {Property: "givenName", Type: filterTypeString, Operators: directoryStringOperators, AdvancedOperators: directoryAdvancedStringOperators},
+	"surname":      {Property: "surname", Type: filterTypeString, Operators: directoryStringOperators, AdvancedOperators: directoryAdvancedStringOperators},
+}
+
 func tableMicrosoft365OrganizationContact(_ context.Context) *plugin.Table {
 	return &plugin.Table{
-		Name:        "microsoft365_organization_contact",
-		Description: "Retrieve the list of organizational contacts for this organization.",
+		Name:              "microsoft365_organization_contact",
+		Description:       "Retrieve the list of organizational contacts for this organization.",
+		GetMatrixItemFunc: tenantMatrix,
 		List: &plugin.ListConfig{
 			Hydrate: listMicrosoft365OrganizationContacts,
-			KeyColumns: plugin.KeyColumnSlice{
-				// Key fields
-				{Name: "display_name", Require: plugin.Optional},
+			KeyColumns: append(plugin.KeyColumnSlice{
 				{Name: "filter", Require: plugin.Optional},
-				{Name: "given_name", Require: plugin.Optional},
-				{Name: "surname", Require: plugin.Optional},
-			},
+				{Name: "search", Require: plugin.Optional},
+			}, orgContactFilterColumns.keyColumns()...),
 			IgnoreConfig: &plugin.IgnoreConfig{
-				ShouldIgnoreErrorFunc: isIgnorableErrorPredicate([]string{"Request_ResourceNotFound"}),
+				ShouldIgnoreErrorFunc: isIgnorableErrorPredicate([]ErrorCategory{ErrorCategoryNotFound}),
 			},
 		},
 		Get: &plugin.GetConfig{
 			Hydrate:    getMicrosoft365OrganizationContact,
 			KeyColumns: plugin.SingleColumn("id"),
 			IgnoreConfig: &plugin.IgnoreConfig{
-				ShouldIgnoreErrorFunc: isIgnorableErrorPredicate([]string{"Request_ResourceNotFound"}),
+				ShouldIgnoreErrorFunc: isIgnorableErrorPredicate([]ErrorCategory{ErrorCategoryNotFound}),
 			},
 		},
 		Columns: organizationContactColumns(),
@@ -94,7 +117,7 @@ func listMicrosoft365OrganizationContacts(ctx context.Context, d *plugin.QueryDa
 
 	var queryFilter string
 	equalQuals := d.EqualsQuals
-	filter := buildOrgContactQueryFilter(equalQuals)
+	filter, advanced := orgContactFilterColumns.buildAdvancedFilter(d.Quals)
 
 	if equalQuals["filter"] != nil {
 		queryFilter = equalQuals["filter"].GetStringValue()
@@ -102,6 +125,12 @@ func listMicrosoft365OrganizationContacts(ctx context.Context, d *plugin.QueryDa
 
 	if queryFilter != "" {
 		filter = append(filter, queryFilter)
+		advanced = advanced || isAdvancedFilter(queryFilter)
+	}
+
+	if equalQuals["search"] != nil {
+		input.Search = StringPtr(searchQuery(equalQuals["search"].GetStringValue()))
+		advanced = true
 	}
 
 	if len(filter) > 0 {
@@ -118,10 +147,21 @@ func listMicrosoft365OrganizationContacts(ctx context.Context, d *plugin.QueryDa
 	}
 	input.Top = Int32(int32(pageSize))
 
+	// Request only the properties of the queried columns
+	input.Select, input.Expand = orgContactSelect.buildListSelect(d)
+
 	options := &contacts.ContactsRequestBuilderGetRequestConfiguration{
 		QueryParameters: input,
 	}
 
+	// $search, $count and some filters are only supported in advanced queries
+	if advanced {
+		input.Count = BoolPtr(true)
+		options.Headers = advancedQueryHeaders()
+		// $expand isn't supported in advanced queries
+		input.Expand = nil
+	}
+
 	result, err := client.Contacts().Get(ctx, options)
 	if err != nil {
 		errObj := getErrorObject(err)
@@ -133,6 +173,9 @@ func listMicrosoft365OrganizationContacts(ctx context.Context, d *plugin.QueryDa
 		logger.Error("listMicrosoft365Contacts", "create_iterator_instance_error", err)
 		return nil, err
 	}
+	if advanced {
+		pageIterator.SetHeaders(options.Headers)
+	}
 
 	err = pageIterator.Iterate(ctx, func(pageItem models.OrgContactable) bool {
 		contact := pageItem
@@ -167,7 +210,16 @@ func getMicrosoft365OrganizationContact(ctx context.Context, d *plugin.QueryData
 		return nil, err
 	}
 
-	result, err := client.Contacts().ByOrgContactId(contactID).Get(ctx, nil)
+	input := &contacts.OrgContactItemRequestBuilderGetQueryParameters{}
+
+	// Request only the properties of the queried columns
+	input.Select, input.Expand = orgContactSelect.buildGetSelect(d)
+
+	options := &contacts.OrgContactItemRequestBuilderGetRequestConfiguration{
+		QueryParameters: input,
+	}
+
+	result, err := client.Contacts().ByOrgContactId(contactID).Get(ctx, options)
 	if err != nil {
 		errObj := getErrorObject(err)
 		return nil, errObj
@@ -175,23 +227,3 @@ func getMicrosoft365OrganizationContact(ctx context.Context, d *plugin.QueryData
 
 	return &Microsoft365OrgContactInfo{result}, nil
 }
-
-func buildOrgContactQueryFilter(equalQuals plugin.KeyColumnEqualsQualMap) []string {
-	filters := []string{}
-
-	filterQuals := map[string]string{
-		"display_name": "string",
-		"given_name":   "string",
-		"surname":      "string",
-	}
-
-	for qual, qualType := range filterQuals {
-		switch qualType {
-		case "string":
-			if equalQuals[qual] != nil {
-				filters = append(filters, fmt.Sprintf("%s eq '%s'", strcase.ToCamel(qual), equalQuals[qual].GetStringValue()))
-			}
-		}
-	}
-	return filters
-}
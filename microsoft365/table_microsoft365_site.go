@@ -2,10 +2,8 @@ package microsoft365
 
 import (
 	"context"
-	"fmt"
 	"strings"
 
-	"github.com/iancoleman/strcase"
 	"github.com/turbot/steampipe-plugin-sdk/v5/grpc/proto"
 	"github.com/turbot/steampipe-plugin-sdk/v5/plugin"
 	"github.com/turbot/steampipe-plugin-sdk/v5/plugin/transform"
@@ -15,6 +13,26 @@ import (
 	"github.com/microsoftgraph/msgraph-sdk-go/sites"
 )
 
+// siteSelect maps the site columns to the properties to $select. The
+// navigation properties, e.g. drives and lists, can't be expanded when
+// listing sites.
+var siteSelect = odataSelect{
+	Properties: map[string][]string{
+		"title":         {"displayName"},
+		"filter":        nil,
+		"analytics":     nil,
+		"columns":       nil,
+		"content_types": nil,
+		"drives":        nil,
+		"lists":         nil,
+		"operations":    nil,
+		"pages":         nil,
+		"permissions":   nil,
+		"sites":         nil,
+	},
+	Required: []string{"id"},
+}
+
 func siteColumns() []*plugin.Column {
 	return commonColumns([]*plugin.Column{
 		{Name: "name", Type: proto.ColumnType_STRING, Description: "The name of the item.", Transform: transform.FromMethod("GetName")},
@@ -55,27 +73,32 @@ func siteColumns() []*plugin.Column {
 
 //// TABLE DEFINITION
 
+// siteFilterColumns are the columns Graph filters sites on
+var siteFilterColumns = odataFilterColumns{
+	"id":               {Property: "id", Type: filterTypeString, Operators: equalOperators},
+	"display_name":     {Property: "displayName", Type: filterTypeString, Operators: equalOperators},
+	"is_personal_site": {Property: "isPersonalSite", Type: filterTypeBool, Operators: equalOperators},
+}
+
 func tableMicrosoft365Site(_ context.Context) *plugin.Table {
 	return &plugin.Table{
-		Name:        "microsoft365_site",
-		Description: "SharePoint sites in Microsoft 365.",
+		Name:              "microsoft365_site",
+		Description:       "SharePoint sites in Microsoft 365.",
+		GetMatrixItemFunc: tenantMatrix,
 		List: &plugin.ListConfig{
 			Hydrate: listMicrosoft365Sites,
-			KeyColumns: []*plugin.KeyColumn{
+			KeyColumns: append(plugin.KeyColumnSlice{
 				{Name: "filter", Require: plugin.Optional},
-				{Name: "id", Require: plugin.Optional},
-				{Name: "display_name", Require: plugin.Optional},
-				{Name: "is_personal_site", Require: plugin.Optional},
-			},
+			}, siteFilterColumns.keyColumns()...),
 			IgnoreConfig: &plugin.IgnoreConfig{
-				ShouldIgnoreErrorFunc: isIgnorableErrorPredicate([]string{"itemNotFound"}),
+				ShouldIgnoreErrorFunc: isIgnorableErrorPredicate([]ErrorCategory{ErrorCategoryNotFound}),
 			},
 		},
 		Get: &plugin.GetConfig{
 			Hydrate:    getMicrosoft365Site,
 			KeyColumns: plugin.AllColumns([]string{"id"}),
 			IgnoreConfig: &plugin.IgnoreConfig{
-				ShouldIgnoreErrorFunc: isIgnorableErrorPredicate([]string{"itemNotFound"}),
+				ShouldIgnoreErrorFunc: isIgnorableErrorPredicate([]ErrorCategory{ErrorCategoryNotFound}),
 			},
 		},
 		Columns: siteColumns(),
@@ -105,9 +128,12 @@ func listMicrosoft365Sites(ctx context.Context, d *plugin.QueryData, _ *plugin.H
 	}
 	input.Top = Int32(int32(pageSize))
 
+	// Request only the properties of the queried columns
+	input.Select, input.Expand = siteSelect.buildListSelect(d)
+
 	var queryFilter string
 	equalQuals := d.EqualsQuals
-	filter := buildSiteQueryFilter(equalQuals)
+	filter := siteFilterColumns.buildFilter(d.Quals)
 
 	if equalQuals["filter"] != nil {
 		queryFilter = equalQuals["filter"].GetStringValue()
@@ -171,7 +197,16 @@ func getMicrosoft365Site(ctx context.Context, d *plugin.QueryData, h *plugin.Hyd
 		return nil, err
 	}
 
-	result, err := client.Sites().BySiteId(siteID).Get(ctx, nil)
+	input := &sites.SiteItemRequestBuilderGetQueryParameters{}
+
+	// Request only the properties of the queried columns
+	input.Select, input.Expand = siteSelect.buildGetSelect(d)
+
+	options := &sites.SiteItemRequestBuilderGetRequestConfiguration{
+		QueryParameters: input,
+	}
+
+	result, err := client.Sites().BySiteId(siteID).Get(ctx, options)
 	if err != nil {
 		errObj := getErrorObject(err)
 		return nil, errObj
@@ -179,27 +214,3 @@ func getMicrosoft365Site(ctx context.Context, d *plugin.QueryData, h *plugin.Hyd
 
 	return &Microsoft365SiteInfo{result}, nil
 }
-
-func buildSiteQueryFilter(equalQuals plugin.KeyColumnEqualsQualMap) []string {
-	filters := []string{}
-
-	filterQuals := map[string]string{
-		"display_name":     "string",
-		"id":               "string",
-		"is_personal_site": "bool",
-	}
-
-	for qual, qualType := range filterQuals {
-		switch qualType {
-		case "string":
-			if equalQuals[qual] != nil {
-				filters = append(filters, fmt.Sprintf("%s eq '%s'", strcase.ToCamel(qual), equalQuals[qual].GetStringValue()))
-			}
-		case "bool":
-			if equalQuals[qual] != nil {
-				filters = append(filters, fmt.Sprintf("%s eq %t", strcase.ToCamel(qual), equalQuals[qual].GetBoolValue()))
-			}
-		}
-	}
-	return filters
-}
@@ -40,8 +40,9 @@ func teamColumns() []*plugin.Column {
 
 func tableMicrosoft365Team(_ context.Context) *plugin.Table {
 	return &plugin.Table{
-		Name:        "microsoft365_team",
-		Description: "Retrieves all teams in Microsoft Teams for an organization.",
+		Name:              "microsoft365_team",
+		Description:       "Retrieves all teams in Microsoft Teams for an organization.",
+		GetMatrixItemFunc: tenantMatrix,
 		List: &plugin.ListConfig{
 			Hydrate: listMicrosoft365Teams,
 		},
@@ -49,7 +50,7 @@ func tableMicrosoft365Team(_ context.Context) *plugin.Table {
 			Hydrate:    getMicrosoft365Team,
 			KeyColumns: plugin.SingleColumn("id"),
 			IgnoreConfig: &plugin.IgnoreConfig{
-				ShouldIgnoreErrorFunc: isIgnorableErrorPredicate([]string{"NotFound"}),
+				ShouldIgnoreErrorFunc: isIgnorableErrorPredicate([]ErrorCategory{ErrorCategoryNotFound}),
 			},
 		},
 		Columns: teamColumns(),
@@ -138,13 +139,19 @@ func getMicrosoft365Team(ctx context.Context, d *plugin.QueryData, h *plugin.Hyd
 	}
 
 	// Create client
-	client, _, err := GetGraphClient(ctx, d)
+	client, err := getGraphClient(ctx, d)
 	if err != nil {
 		logger.Error("microsoft365_team.getMicrosoft365Team", "connection_error", err)
 		return nil, err
 	}
 
-	result, err := client.Teams().ByTeamId(teamID).Get(ctx, nil)
+	// Batched with the teams of other rows
+	request, err := client.client.Teams().ByTeamId(teamID).ToGetRequestInformation(ctx, nil)
+	if err != nil {
+		logger.Error("microsoft365_team.getMicrosoft365Team", "request_error", err)
+		return nil, err
+	}
+	result, err := batchGet[models.Teamable](ctx, client, request, models.CreateTeamFromDiscriminatorValue)
 	if err != nil {
 		errObj := getErrorObject(err)
 		return nil, errObj
@@ -17,8 +17,9 @@ import (
 
 func tableMicrosoft365TeamMember(_ context.Context) *plugin.Table {
 	return &plugin.Table{
-		Name:        "microsoft365_team_member",
-		Description: "List the members associated with teams.",
+		Name:              "microsoft365_team_member",
+		Description:       "List the members associated with teams.",
+		GetMatrixItemFunc: tenantMatrix,
 		List: &plugin.ListConfig{
 			ParentHydrate: listMicrosoft365Teams,
 			Hydrate:       listMicrosoft365TeamMembers,
@@ -2,10 +2,8 @@ package microsoft365
 
 import (
 	"context"
-	"fmt"
 	"strings"
 
-	"github.com/iancoleman/strcase"
 	"github.com/turbot/steampipe-plugin-sdk/v5/grpc/proto"
 	"github.com/turbot/steampipe-plugin-sdk/v5/plugin"
 	"github.com/turbot/steampipe-plugin-sdk/v5/plugin/transform"
@@ -15,6 +13,24 @@ import (
 	"github.com/microsoftgraph/msgraph-sdk-go/users"
 )
 
+// userSelect maps the user columns to the properties to $select
+var userSelect = odataSelect{
+	Properties: map[string][]string{
+		"title":  {"displayName"},
+		"filter": nil,
+		"search": nil,
+	},
+	Expand: map[string]string{
+		"license_details": "licenseDetails",
+	},
+	Gated: map[string]string{
+		"employee_leave_date_time": "employeeLeaveDateTime",
+		"sign_in_activity":         "signInActivity",
+	},
+	GetOnly:  []string{"aboutMe", "birthday", "hireDate", "interests", "mySite", "pastProjects", "preferredName", "responsibilities", "schools", "skills"},
+	Required: []string{"id"},
+}
+
 func userColumns() []*plugin.Column {
 	return commonColumns([]*plugin.Column{
 		{Name: "id", Type: proto.ColumnType_STRING, Description: "The unique identifier of the user.", Transform: transform.FromMethod("GetId")},
@@ -67,7 +83,7 @@ func userColumns() []*plugin.Column {
 		{Name: "skills", Type: proto.ColumnType_JSON, Description: "A list for the user to enumerate their skills.", Transform: transform.FromMethod("GetSkills")},
 		{Name: "hire_date", Type: proto.ColumnType_TIMESTAMP, Description: "The hire date of the user.", Transform: transform.FromMethod("GetHireDate")},
 		{Name: "employee_hire_date", Type: proto.ColumnType_TIMESTAMP, Description: "The hire date of the user.", Transform: transform.FromMethod("GetEmployeeHireDate")},
-		{Name: "employee_leave_date_time", Type: proto.ColumnType_TIMESTAMP, Description: "The date and time when the user left or will leave the organization.", Transform: transform.FromMethod("GetEmployeeLeaveDateTime")},
+		{Name: "employee_leave_date_time", Type: proto.ColumnType_TIMESTAMP, Description: "The date and time when the user left or will leave the organization. Requires the User-LifeCycleInfo.Read.All permission.", Transform: transform.FromMethod("GetEmployeeLeaveDateTime"), Hydrate: getUserEmployeeLeaveDateTime},
 		{Name: "employee_id", Type: proto.ColumnType_STRING, Description: "The employee identifier assigned to the user by the organization.", Transform: transform.FromMethod("GetEmployeeId")},
 		{Name: "employee_type", Type: proto.ColumnType_STRING, Description: "Captures enterprise worker type.", Transform: transform.FromMethod("GetEmployeeType")},
 		{Name: "birthday", Type: proto.ColumnType_TIMESTAMP, Description: "The birthday of the user.", Transform: transform.FromMethod("GetBirthday")},
@@ -92,7 +108,8 @@ func userColumns() []*plugin.Column {
 		{Name: "service_provisioning_errors", Type: proto.ColumnType_JSON, Description: "Errors published by a federated service describing a non-transient, service-specific error regarding the properties or link from a user object.", Transform: transform.FromMethod("UserServiceProvisioningErrors")},
 		{Name: "identities", Type: proto.ColumnType_JSON, Description: "Represents the identities that can be used to sign in to this user account.", Transform: transform.FromMethod("UserIdentities")},
 		{Name: "license_assignment_states", Type: proto.ColumnType_JSON, Description: "State of license assignments for this user.", Transform: transform.FromMethod("UserLicenseAssignmentStates")},
-		{Name: "license_details", Type: proto.ColumnType_JSON, Description: "A collection of this user's license details.", Transform: transform.FromMethod("UserLicenseDetails")},
+		{Name: "license_details", Type: proto.ColumnType_JSON, Description: "A collection of this user's license details.", Transform: transform.FromMethod("UserLicenseDetails"), Hydrate: getUserLicenseDetails},
+		{Name: "sign_in_activity", Type: proto.ColumnType_JSON, Description: "The last interactive and non-interactive sign-ins of the user. Requires the AuditLog.Read.All permission and a Microsoft Entra ID P1 or P2 license.", Transform: transform.FromMethod("UserSignInActivity"), Hydrate: getUserSignInActivity},
 
 		// Mailbox Settings columns
 		{Name: "user_purpose", Type: proto.ColumnType_STRING, Description: "The purpose of the mailbox.", Transform: transform.FromMethod("GetUserPurpose"), Hydrate: getUserMailboxSettings},
@@ -114,42 +131,49 @@ func userColumns() []*plugin.Column {
 		{Name: "is_sspr_registered", Type: proto.ColumnType_BOOL, Description: "Whether the user is registered for self-service password reset.", Transform: transform.FromMethod("GetIsSsprRegistered"), Hydrate: getUserRegistrationDetails},
 		{Name: "methods_registered", Type: proto.ColumnType_JSON, Description: "List of authentication methods registered by the user.", Transform: transform.FromMethod("GetMethodsRegistered"), Hydrate: getUserRegistrationDetails},
 		{Name: "system_preferred_authentication_methods", Type: proto.ColumnType_JSON, Description: "List of system-preferred authentication methods.", Transform: transform.FromMethod("GetSystemPreferredAuthenticationMethods"), Hydrate: getUserRegistrationDetails},
-		{Name: "user_preferred_method_for_secondary_authentication", Type: proto.ColumnType_STRING, Description: "The user's preferred method for secondary authentication.", Transform: transform.FromMethod("GetUserPreferredMethodForSecondaryAuthentication"), Hydrate: getUserRegistrationDetails},
+		{Name: "user_preferred_method_for_secondary_authentication", Type: proto.ColumnType_STRING, Description: "The user's preferred method for secondary authentication.", Transform: transform.FromMethod("RegistrationUserPreferredMethodForSecondaryAuthentication"), Hydrate: getUserRegistrationDetails},
 		{Name: "is_system_preferred_authentication_method_enabled", Type: proto.ColumnType_BOOL, Description: "Whether system-preferred authentication method is enabled.", Transform: transform.FromMethod("GetIsSystemPreferredAuthenticationMethodEnabled"), Hydrate: getUserRegistrationDetails},
 		{Name: "registration_last_updated_date_time", Type: proto.ColumnType_TIMESTAMP, Description: "The date and time when the user registration details were last updated.", Transform: transform.FromMethod("GetLastUpdatedDateTime"), Hydrate: getUserRegistrationDetails},
 
 		// Standard columns
 		{Name: "title", Type: proto.ColumnType_STRING, Description: ColumnDescriptionTitle, Transform: transform.FromMethod("GetDisplayName")},
 		{Name: "filter", Type: proto.ColumnType_STRING, Transform: transform.FromQual("filter"), Description: "Odata query to search for resources."},
+		{Name: "search", Type: proto.ColumnType_STRING, Transform: transform.FromQual("search"), Description: "Search query for resources, e.g. displayName:wa or \"displayName:wa\" OR \"mail:wa\"."},
 	})
 }
 
 //// TABLE DEFINITION
 
+// userFilterColumns are the columns Graph filters users on
+var userFilterColumns = odataFilterColumns{
+	"id":                  {Property: "id", Type: filterTypeString, Operators: equalOperators},
+	"display_name":        {Property: "displayName", Type: filterTypeString, Operators: directoryStringOperators, AdvancedOperators: directoryAdvancedStringOperators},
+	"user_principal_name": {Property: "userPrincipalName", Type: filterTypeString, Operators: directoryStringOperators, AdvancedOperators: directoryAdvancedStringOperators},
+	"mail":                {Property: "mail", Type: filterTypeString, Operators: directoryStringOperators, AdvancedOperators: directoryAdvancedStringOperators},
+	"account_enabled":     {Property: "accountEnabled", Type: filterTypeBool, Operators: boolOperators},
+	"user_type":           {Property: "userType", Type: filterTypeString, Operators: equalOperators, AdvancedOperators: []string{"<>"}},
+}
+
 func tableMicrosoft365User(_ context.Context) *plugin.Table {
 	return &plugin.Table{
-		Name:        "microsoft365_user",
-		Description: "Users in Microsoft 365.",
+		Name:              "microsoft365_user",
+		Description:       "Users in Microsoft 365.",
+		GetMatrixItemFunc: tenantMatrix,
 		List: &plugin.ListConfig{
 			Hydrate: listMicrosoft365Users,
-			KeyColumns: []*plugin.KeyColumn{
+			KeyColumns: append(plugin.KeyColumnSlice{
 				{Name: "filter", Require: plugin.Optional},
-				{Name: "id", Require: plugin.Optional},
-				{Name: "display_name", Require: plugin.Optional},
-				{Name: "user_principal_name", Require: plugin.Optional},
-				{Name: "mail", Require: plugin.Optional},
-				{Name: "account_enabled", Require: plugin.Optional},
-				{Name: "user_type", Require: plugin.Optional},
-			},
+				{Name: "search", Require: plugin.Optional},
+			}, userFilterColumns.keyColumns()...),
 			IgnoreConfig: &plugin.IgnoreConfig{
-				ShouldIgnoreErrorFunc: isIgnorableErrorPredicate([]string{"itemNotFound"}),
+				ShouldIgnoreErrorFunc: isIgnorableErrorPredicate([]ErrorCategory{ErrorCategoryNotFound}),
 			},
 		},
 		Get: &plugin.GetConfig{
 			Hydrate:    getMicrosoft365User,
 			KeyColumns: plugin.AllColumns([]string{"id"}),
 			IgnoreConfig: &plugin.IgnoreConfig{
-				ShouldIgnoreErrorFunc: isIgnorableErrorPredicate([]string{"itemNotFound"}),
+				ShouldIgnoreErrorFunc: isIgnorableErrorPredicate([]ErrorCategory{ErrorCategoryNotFound}),
 			},
 		},
 		Columns: userColumns(),
@@ -179,9 +203,12 @@ func listMicrosoft365Users(ctx context.Context, d *plugin.QueryData, _ *plugin.H
 	}
 	input.Top = Int32(int32(pageSize))
 
+	// Request only the properties of the queried columns
+	input.Select, input.Expand = userSelect.buildListSelect(d)
+
 	var queryFilter string
 	equalQuals := d.EqualsQuals
-	filter := buildUserQueryFilter(equalQuals)
+	filter, advanced := userFilterColumns.buildAdvancedFilter(d.Quals)
 
 	if equalQuals["filter"] != nil {
 		queryFilter = equalQuals["filter"].GetStringValue()
@@ -189,6 +216,12 @@ func listMicrosoft365Users(ctx context.Context, d *plugin.QueryData, _ *plugin.H
 
 	if queryFilter != "" {
 		filter = append(filter, queryFilter)
+		advanced = advanced || isAdvancedFilter(queryFilter)
+	}
+
+	if equalQuals["search"] != nil {
+		input.Search = StringPtr(searchQuery(equalQuals["search"].GetStringValue()))
+		advanced = true
 	}
 
 	if len(filter) > 0 {
@@ -200,7 +233,26 @@ func listMicrosoft365Users(ctx context.Context, d *plugin.QueryData, _ *plugin.H
 		QueryParameters: input,
 	}
 
+	// $search, $count and some filters are only supported in advanced queries
+	if advanced {
+		input.Count = BoolPtr(true)
+		options.Headers = advancedQueryHeaders()
+		// $expand isn't supported in advanced queries, so getUserLicenseDetails
+		// fetches the license details of each user instead
+		input.Expand = nil
+	}
+
 	result, err := client.Users().Get(ctx, options)
+	// Graph rejects the whole request if the credentials can't read a gated
+	// property, which is then fetched for each user instead
+	gatedRejected := false
+	if err != nil && isGatedPropertyError(err) {
+		if selects, ok := userSelect.withoutGated(input.Select); ok {
+			logger.Warn("microsoft365_user.listMicrosoft365Users", "gated_properties_rejected", getErrorObject(err))
+			input.Select, gatedRejected = selects, true
+			result, err = client.Users().Get(ctx, options)
+		}
+	}
 	if err != nil {
 		errObj := getErrorObject(err)
 		return nil, errObj
@@ -211,11 +263,14 @@ func listMicrosoft365Users(ctx context.Context, d *plugin.QueryData, _ *plugin.H
 		logger.Error("microsoft365_user.listMicrosoft365Users", "create_iterator_instance_error", err)
 		return nil, err
 	}
+	if advanced {
+		pageIterator.SetHeaders(options.Headers)
+	}
 
 	err = pageIterator.Iterate(ctx, func(pageItem models.Userable) bool {
 		user := pageItem
 
-		d.StreamListItem(ctx, &Microsoft365UserInfo{Userable: user, MailboxSettings: nil})
+		d.StreamListItem(ctx, &Microsoft365UserInfo{Userable: user, MailboxSettings: nil, gatedRejected: gatedRejected})
 
 		// Context can be cancelled due to manual cancellation or the limit has been hit
 		return d.RowsRemaining(ctx) != 0
@@ -245,7 +300,24 @@ func getMicrosoft365User(ctx context.Context, d *plugin.QueryData, h *plugin.Hyd
 		return nil, err
 	}
 
-	result, err := client.Users().ByUserId(userID).Get(ctx, nil)
+	input := &users.UserItemRequestBuilderGetQueryParameters{}
+
+	// Request only the properties of the queried columns
+	input.Select, input.Expand = userSelect.buildGetSelect(d)
+
+	options := &users.UserItemRequestBuilderGetRequestConfiguration{
+		QueryParameters: input,
+	}
+
+	result, err := client.Users().ByUserId(userID).Get(ctx, options)
+	gatedRejected := false
+	if err != nil && isGatedPropertyError(err) {
+		if selects, ok := userSelect.withoutGated(input.Select); ok {
+			logger.Warn("microsoft365_user.getMicrosoft365User", "gated_properties_rejected", getErrorObject(err))
+			input.Select, gatedRejected = selects, true
+			result, err = client.Users().ByUserId(userID).Get(ctx, options)
+		}
+	}
 	if err != nil {
 		errObj := getErrorObject(err)
 		return nil, errObj
@@ -255,37 +327,10 @@ func getMicrosoft365User(ctx context.Context, d *plugin.QueryData, h *plugin.Hyd
 	mailboxSettings, err := client.Users().ByUserId(userID).MailboxSettings().Get(ctx, nil)
 	if err != nil {
 		// If we can't get mailbox settings, return user without them
-		return &Microsoft365UserInfo{Userable: result, MailboxSettings: nil}, nil
+		return &Microsoft365UserInfo{Userable: result, MailboxSettings: nil, gatedRejected: gatedRejected}, nil
 	}
 
-	return &Microsoft365UserInfo{Userable: result, MailboxSettings: mailboxSettings}, nil
-}
-
-func buildUserQueryFilter(equalQuals plugin.KeyColumnEqualsQualMap) []string {
-	filters := []string{}
-
-	filterQuals := map[string]string{
-		"display_name":        "string",
-		"id":                  "string",
-		"user_principal_name": "string",
-		"mail":                "string",
-		"account_enabled":     "bool",
-		"user_type":           "string",
-	}
-
-	for qual, qualType := range filterQuals {
-		switch qualType {
-		case "string":
-			if equalQuals[qual] != nil {
-				filters = append(filters, fmt.Sprintf("%s eq '%s'", strcase.ToCamel(qual), equalQuals[qual].GetStringValue()))
-			}
-		case "bool":
-			if equalQuals[qual] != nil {
-				filters = append(filters, fmt.Sprintf("%s eq %t", strcase.ToCamel(qual), equalQuals[qual].GetBoolValue()))
-			}
-		}
-	}
-	return filters
+	return &Microsoft365UserInfo{Userable: result, MailboxSettings: mailboxSettings, gatedRejected: gatedRejected}, nil
 }
 
 //// HYDRATE FUNCTIONS
@@ -297,51 +342,164 @@ func getUserMailboxSettings(ctx context.Context, d *plugin.QueryData, h *plugin.
 	userID := *user.GetId()
 
 	// Create client
-	client, _, err := GetGraphClient(ctx, d)
+	client, err := getGraphClient(ctx, d)
 	if err != nil {
 		logger.Error("microsoft365_user.getUserMailboxSettings", "connection_error", err)
 		return nil, err
 	}
 
-	// Get mailbox settings for this user
-	mailboxSettings, err := client.Users().ByUserId(userID).MailboxSettings().Get(ctx, nil)
+	// Get mailbox settings for this user, batched with those of other users
+	request, err := client.client.Users().ByUserId(userID).MailboxSettings().ToGetRequestInformation(ctx, nil)
 	if err != nil {
-		// Return nil if we can't get mailbox settings (user might not have a mailbox)
-		return nil, nil
+		logger.Error("microsoft365_user.getUserMailboxSettings", "request_error", err)
+		return nil, err
+	}
+	mailboxSettings, err := batchGet[models.MailboxSettingsable](ctx, client, request, models.CreateMailboxSettingsFromDiscriminatorValue)
+	if err != nil {
+		// Users without a mailbox, or one the credentials can't read, have no
+		// mailbox settings
+		errObj := getErrorObject(err)
+		if errObj.Category == ErrorCategoryNotFound || errObj.Category == ErrorCategoryPermission {
+			return nil, nil
+		}
+		logger.Error("microsoft365_user.getUserMailboxSettings", "api_error", errObj)
+		return nil, errObj
 	}
 
 	return mailboxSettings, nil
 }
 
-func getUserRegistrationDetails(ctx context.Context, d *plugin.QueryData, h *plugin.HydrateData) (interface{}, error) {
+// getUserLicenseDetails returns the license details expanded by the list or
+// get request, or fetches them, batched with those of other users, when they
+// couldn't be expanded, e.g. in an advanced query.
+func getUserLicenseDetails(ctx context.Context, d *plugin.QueryData, h *plugin.HydrateData) (interface{}, error) {
 	logger := plugin.Logger(ctx)
 
 	user := h.Item.(*Microsoft365UserInfo)
+	if user.GetLicenseDetails() != nil || user.GetId() == nil {
+		return user, nil
+	}
 
 	// Create client
-	client, _, err := GetGraphClient(ctx, d)
+	client, err := getGraphClient(ctx, d)
+	if err != nil {
+		logger.Error("microsoft365_user.getUserLicenseDetails", "connection_error", err)
+		return nil, err
+	}
+
+	request, err := client.client.Users().ByUserId(*user.GetId()).LicenseDetails().ToGetRequestInformation(ctx, nil)
+	if err != nil {
+		logger.Error("microsoft365_user.getUserLicenseDetails", "request_error", err)
+		return nil, err
+	}
+	result, err := batchGet[models.LicenseDetailsCollectionResponseable](ctx, client, request, models.CreateLicenseDetailsCollectionResponseFromDiscriminatorValue)
 	if err != nil {
-		logger.Error("microsoft365_user.getUserRegistrationDetails", "connection_error", err)
+		// The user may have been deleted since it was listed
+		errObj := getErrorObject(err)
+		if errObj.Category == ErrorCategoryNotFound {
+			return nil, nil
+		}
+		logger.Error("microsoft365_user.getUserLicenseDetails", "api_error", errObj)
+		return nil, errObj
+	}
+
+	details := models.NewUser()
+	details.SetLicenseDetails(result.GetValue())
+	return &Microsoft365UserInfo{Userable: details}, nil
+}
+
+// getUserSignInActivity returns the sign-in activity selected by the list or
+// get request. It needs the AuditLog.Read.All permission and an Entra ID P1 or
+// P2 license.
+func getUserSignInActivity(ctx context.Context, d *plugin.QueryData, h *plugin.HydrateData) (interface{}, error) {
+	return getUserGatedProperty(ctx, d, h, "signInActivity")
+}
+
+// getUserEmployeeLeaveDateTime returns the leave date selected by the list or
+// get request. It needs the User-LifeCycleInfo.Read.All permission.
+func getUserEmployeeLeaveDateTime(ctx context.Context, d *plugin.QueryData, h *plugin.HydrateData) (interface{}, error) {
+	return getUserGatedProperty(ctx, d, h, "employeeLeaveDateTime")
+}
+
+// getUserGatedProperty returns the user of the row, whose list or get request
+// selected the property. If Graph rejected it there, the property is fetched
+// for the user on its own, batched with that of other users, and the column is
+// left empty if the credentials can't read it.
+func getUserGatedProperty(ctx context.Context, d *plugin.QueryData, h *plugin.HydrateData, property string) (interface{}, error) {
+	logger := plugin.Logger(ctx)
+
+	user := h.Item.(*Microsoft365UserInfo)
+	if user.GetId() == nil {
+		return nil, nil
+	}
+	if !user.gatedRejected {
+		return user, nil
+	}
+
+	// Create client
+	client, err := getGraphClient(ctx, d)
+	if err != nil {
+		logger.Error("microsoft365_user.getUserGatedProperty", "connection_error", err)
 		return nil, err
 	}
 
-	// Get user registration details from Reports API
-	// Note: This uses the Reports API endpoint for user registration details
-	userRegistrationDetails, err := client.Reports().AuthenticationMethods().UserRegistrationDetails().Get(ctx, nil)
+	options := &users.UserItemRequestBuilderGetRequestConfiguration{
+		QueryParameters: &users.UserItemRequestBuilderGetQueryParameters{
+			Select: []string{"id", property},
+		},
+	}
+	request, err := client.client.Users().ByUserId(*user.GetId()).ToGetRequestInformation(ctx, options)
 	if err != nil {
-		logger.Error("microsoft365_user.getUserRegistrationDetails", "api_error", err)
+		logger.Error("microsoft365_user.getUserGatedProperty", "request_error", err)
+		return nil, err
+	}
+	result, err := batchGet[models.Userable](ctx, client, request, models.CreateUserFromDiscriminatorValue)
+	if err != nil {
+		errObj := getErrorObject(err)
+		if errObj.Category == ErrorCategoryNotFound || isGatedPropertyError(err) {
+			return nil, nil
+		}
+		logger.Error("microsoft365_user.getUserGatedProperty", "api_error", errObj, "property", property)
+		return nil, errObj
+	}
+
+	return &Microsoft365UserInfo{Userable: result}, nil
+}
+
+// isGatedPropertyError reports whether Graph rejected a request because the
+// credentials can't read a property it selects, for lack of a permission or
+// license.
+func isGatedPropertyError(err error) bool {
+	errObj := getErrorObject(err)
+	return errObj.Category == ErrorCategoryPermission || errObj.Code == nonPremiumTenantErrorCode
+}
+
+func getUserRegistrationDetails(ctx context.Context, d *plugin.QueryData, h *plugin.HydrateData) (interface{}, error) {
+	logger := plugin.Logger(ctx)
+
+	user := h.Item.(*Microsoft365UserInfo)
+	if user.GetId() == nil {
 		return nil, nil
 	}
 
-	// Find the registration details for this specific user
-	if userRegistrationDetails.GetValue() != nil {
-		for _, detail := range userRegistrationDetails.GetValue() {
-			if detail.GetUserPrincipalName() != nil && *detail.GetUserPrincipalName() == *user.GetUserPrincipalName() {
-				return detail, nil
-			}
+	// The report is fetched once and shared by the rows of every user
+	report, err := getUserRegistrationReport(ctx, d, h)
+	if err != nil {
+		// The report needs AuditLog.Read.All and an Entra ID P1 or P2 license;
+		// without them the columns are left empty
+		errObj := getErrorObject(err)
+		if errObj.Category == ErrorCategoryPermission || errObj.Code == nonPremiumTenantErrorCode {
+			logger.Warn("microsoft365_user.getUserRegistrationDetails", "api_error", errObj)
+			return nil, nil
 		}
+		logger.Error("microsoft365_user.getUserRegistrationDetails", "api_error", errObj)
+		return nil, errObj
 	}
 
 	// Return nil if no registration details found for this user
-	return nil, nil
+	detail, ok := report[*user.GetId()]
+	if !ok {
+		return nil, nil
+	}
+	return &Microsoft365UserRegistrationDetailInfo{detail}, nil
 }
@@ -0,0 +1,72 @@
+package microsoft365
+
+import (
+	"context"
+
+	"github.com/turbot/steampipe-plugin-sdk/v5/plugin"
+
+	"github.com/microsoftgraph/msgraph-sdk-go/models"
+	"github.com/microsoftgraph/msgraph-sdk-go/users"
+)
+
+//// TABLE DEFINITION
+
+func tableMicrosoft365UserDelta(_ context.Context) *plugin.Table {
+	return &plugin.Table{
+		Name:              "microsoft365_user_delta",
+		Description:       "Users in Microsoft 365 created, updated or deleted since the last query of the table.",
+		GetMatrixItemFunc: tenantMatrix,
+		List: &plugin.ListConfig{
+			Hydrate: listMicrosoft365UserDeltas,
+		},
+		// Delta queries don't return navigation properties, the properties
+		// Graph only returns for a single user, or signInActivity
+		Columns: deltaColumns(userColumns(), "filter", "search", "license_details", "sign_in_activity",
+			"about_me", "birthday", "hire_date", "interests", "my_site", "past_projects", "preferred_name", "responsibilities", "schools", "skills"),
+	}
+}
+
+//// LIST FUNCTION
+
+func listMicrosoft365UserDeltas(ctx context.Context, d *plugin.QueryData, _ *plugin.HydrateData) (interface{}, error) {
+	logger := plugin.Logger(ctx)
+
+	// Create client
+	client, adapter, err := GetGraphClient(ctx, d)
+	if err != nil {
+		logger.Error("microsoft365_user_delta.listMicrosoft365UserDeltas", "connection_error", err)
+		return nil, err
+	}
+
+	input := &users.DeltaRequestBuilderGetQueryParameters{}
+
+	// The properties of every column, since the deltaLink keeps those of the first request
+	input.Select, _ = userSelect.buildDeltaSelect(d.Table)
+
+	info, err := client.Users().Delta().ToGetRequestInformation(ctx, &users.DeltaRequestBuilderGetRequestConfiguration{
+		QueryParameters: input,
+	})
+	if err != nil {
+		return nil, err
+	}
+	start, err := info.GetUri()
+	if err != nil {
+		return nil, err
+	}
+
+	err = listDelta(ctx, d, deltaQuery[models.Userable]{
+		Scope: "users",
+		Start: start.String(),
+		Get: func(ctx context.Context, url string) (deltaPage[models.Userable], error) {
+			return users.NewDeltaRequestBuilder(url, adapter).GetAsDeltaGetResponse(ctx, nil)
+		},
+	}, func(user models.Userable, changeType string) {
+		d.StreamListItem(ctx, &Microsoft365UserDeltaInfo{&Microsoft365UserInfo{Userable: user}, changeType})
+	})
+	if err != nil {
+		logger.Error("listMicrosoft365UserDeltas", "paging_error", err)
+		return nil, getErrorObject(err)
+	}
+
+	return nil, nil
+}
@@ -0,0 +1,245 @@
+package microsoft365
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/turbot/steampipe-plugin-sdk/v5/grpc/proto"
+	"github.com/turbot/steampipe-plugin-sdk/v5/memoize"
+	"github.com/turbot/steampipe-plugin-sdk/v5/plugin"
+	"github.com/turbot/steampipe-plugin-sdk/v5/plugin/transform"
+
+	msgraphcore "github.com/microsoftgraph/msgraph-sdk-go-core"
+	"github.com/microsoftgraph/msgraph-sdk-go/models"
+	"github.com/microsoftgraph/msgraph-sdk-go/reports"
+)
+
+//// TABLE DEFINITION
+
+// userRegistrationDetailFilterColumns are the columns Graph filters the
+// registration report on
+var userRegistrationDetailFilterColumns = odataFilterColumns{
+	"user_principal_name":     {Property: "userPrincipalName", Type: filterTypeString, Operators: directoryStringOperators},
+	"user_display_name":       {Property: "userDisplayName", Type: filterTypeString, Operators: directoryStringOperators},
+	"user_type":               {Property: "userType", Type: filterTypeString, Operators: equalOperators},
+	"is_admin":                {Property: "isAdmin", Type: filterTypeBool, Operators: boolOperators},
+	"is_mfa_capable":          {Property: "isMfaCapable", Type: filterTypeBool, Operators: boolOperators},
+	"is_mfa_registered":       {Property: "isMfaRegistered", Type: filterTypeBool, Operators: boolOperators},
+	"is_passwordless_capable": {Property: "isPasswordlessCapable", Type: filterTypeBool, Operators: boolOperators},
+	"is_sspr_capable":         {Property: "isSsprCapable", Type: filterTypeBool, Operators: boolOperators},
+	"is_sspr_enabled":         {Property: "isSsprEnabled", Type: filterTypeBool, Operators: boolOperators},
+	"is_sspr_registered":      {Property: "isSsprRegistered", Type: filterTypeBool, Operators: boolOperators},
+}
+
+func tableMicrosoft365UserRegistrationDetail(_ context.Context) *plugin.Table {
+	return &plugin.Table{
+		Name:              "microsoft365_user_registration_detail",
+		Description:       "The authentication methods each user has registered, and whether they are capable of MFA, passwordless sign-in and self-service password reset.",
+		GetMatrixItemFunc: tenantMatrix,
+		List: &plugin.ListConfig{
+			Hydrate: listMicrosoft365UserRegistrationDetails,
+			KeyColumns: append(plugin.KeyColumnSlice{
+				{Name: "filter", Require: plugin.Optional},
+			}, userRegistrationDetailFilterColumns.keyColumns()...),
+		},
+		Get: &plugin.GetConfig{
+			Hydrate:    getMicrosoft365UserRegistrationDetail,
+			KeyColumns: plugin.SingleColumn("id"),
+			IgnoreConfig: &plugin.IgnoreConfig{
+				ShouldIgnoreErrorFunc: isIgnorableErrorPredicate([]ErrorCategory{ErrorCategoryNotFound}),
+			},
+		},
+		Columns: commonColumns([]*plugin.Column{
+			{Name: "id", Type: proto.ColumnType_STRING, Description: "The object ID of the user.", Transform: transform.FromMethod("GetId")},
+			{Name: "user_principal_name", Type: proto.ColumnType_STRING, Description: "The user principal name (UPN) of the user.", Transform: transform.FromMethod("GetUserPrincipalName")},
+			{Name: "user_display_name", Type: proto.ColumnType_STRING, Description: "The display name of the user.", Transform: transform.FromMethod("GetUserDisplayName")},
+			{Name: "user_type", Type: proto.ColumnType_STRING, Description: "The type of the user, member or guest.", Transform: transform.FromMethod("RegistrationUserType")},
+			{Name: "is_admin", Type: proto.ColumnType_BOOL, Description: "Whether the user has an admin role in the tenant.", Transform: transform.FromMethod("GetIsAdmin")},
+			{Name: "is_mfa_capable", Type: proto.ColumnType_BOOL, Description: "Whether the user has registered a strong authentication method for multi-factor authentication, and the method is allowed by the authentication methods policy.", Transform: transform.FromMethod("GetIsMfaCapable")},
+			{Name: "is_mfa_registered", Type: proto.ColumnType_BOOL, Description: "Whether the user has registered a strong authentication method for multi-factor authentication.", Transform: transform.FromMethod("GetIsMfaRegistered")},
+			{Name: "is_passwordless_capable", Type: proto.ColumnType_BOOL, Description: "Whether the user has registered a passwordless strong authentication method allowed by the authentication methods policy.", Transform: transform.FromMethod("GetIsPasswordlessCapable")},
+			{Name: "is_sspr_capable", Type: proto.ColumnType_BOOL, Description: "Whether the user has registered the methods required for self-service password reset, and is allowed to use it.", Transform: transform.FromMethod("GetIsSsprCapable")},
+			{Name: "is_sspr_enabled", Type: proto.ColumnType_BOOL, Description: "Whether self-service password reset is enabled for the user.", Transform: transform.FromMethod("GetIsSsprEnabled")},
+			{Name: "is_sspr_registered", Type: proto.ColumnType_BOOL, Description: "Whether the user has registered the methods required for self-service password reset.", Transform: transform.FromMethod("GetIsSsprRegistered")},
+			{Name: "is_system_preferred_authentication_method_enabled", Type: proto.ColumnType_BOOL, Description: "Whether system-preferred authentication is enabled for the user.", Transform: transform.FromMethod("GetIsSystemPreferredAuthenticationMethodEnabled")},
+			{Name: "last_updated_date_time", Type: proto.ColumnType_TIMESTAMP, Description: "The date and time the report was last updated for the user.", Transform: transform.FromMethod("GetLastUpdatedDateTime")},
+			{Name: "methods_registered", Type: proto.ColumnType_JSON, Description: "The authentication methods the user has registered, e.g. microsoftAuthenticatorPush or fido2.", Transform: transform.FromMethod("GetMethodsRegistered")},
+			{Name: "system_preferred_authentication_methods", Type: proto.ColumnType_JSON, Description: "The authentication methods the system prefers for the user's secondary authentication.", Transform: transform.FromMethod("GetSystemPreferredAuthenticationMethods")},
+			{Name: "user_preferred_method_for_secondary_authentication", Type: proto.ColumnType_STRING, Description: "The method the user chose as their default for secondary authentication.", Transform: transform.FromMethod("RegistrationUserPreferredMethodForSecondaryAuthentication")},
+
+			// Standard columns
+			{Name: "title", Type: proto.ColumnType_STRING, Description: ColumnDescriptionTitle, Transform: transform.FromMethod("GetUserDisplayName")},
+			{Name: "filter", Type: proto.ColumnType_STRING, Transform: transform.FromQual("filter"), Description: "Odata query to search for resources."},
+		}),
+	}
+}
+
+//// LIST FUNCTION
+
+func listMicrosoft365UserRegistrationDetails(ctx context.Context, d *plugin.QueryData, _ *plugin.HydrateData) (interface{}, error) {
+	logger := plugin.Logger(ctx)
+
+	// Create client
+	client, adapter, err := GetGraphClient(ctx, d)
+	if err != nil {
+		logger.Error("microsoft365_user_registration_detail.listMicrosoft365UserRegistrationDetails", "connection_error", err)
+		return nil, err
+	}
+
+	input := &reports.AuthenticationMethodsUserRegistrationDetailsRequestBuilderGetQueryParameters{}
+
+	// Minimum value is 1 (this function isn't run if "limit 0" is specified)
+	// Maximum value is 999 (API limit)
+	pageSize := int64(999)
+	limit := d.QueryContext.Limit
+	if limit != nil && *limit < pageSize {
+		pageSize = *limit
+	}
+	input.Top = Int32(int32(pageSize))
+
+	filter := userRegistrationDetailFilterColumns.buildFilter(d.Quals)
+	if d.EqualsQuals["filter"] != nil {
+		filter = append(filter, d.EqualsQuals["filter"].GetStringValue())
+	}
+	if len(filter) > 0 {
+		joinStr := strings.Join(filter, " and ")
+		input.Filter = &joinStr
+	}
+
+	options := &reports.AuthenticationMethodsUserRegistrationDetailsRequestBuilderGetRequestConfiguration{
+		QueryParameters: input,
+	}
+
+	result, err := client.Reports().AuthenticationMethods().UserRegistrationDetails().Get(ctx, options)
+	if err != nil {
+		errObj := getErrorObject(err)
+		return nil, errObj
+	}
+
+	pageIterator, err := msgraphcore.NewPageIterator[models.UserRegistrationDetailsable](result, adapter, models.CreateUserRegistrationDetailsCollectionResponseFromDiscriminatorValue)
+	if err != nil {
+		logger.Error("microsoft365_user_registration_detail.listMicrosoft365UserRegistrationDetails", "create_iterator_instance_error", err)
+		return nil, err
+	}
+
+	err = pageIterator.Iterate(ctx, func(pageItem models.UserRegistrationDetailsable) bool {
+		d.StreamListItem(ctx, &Microsoft365UserRegistrationDetailInfo{pageItem})
+
+		// Context can be cancelled due to manual cancellation or the limit has been hit
+		return d.RowsRemaining(ctx) != 0
+	})
+	if err != nil {
+		logger.Error("microsoft365_user_registration_detail.listMicrosoft365UserRegistrationDetails", "paging_error", err)
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+//// HYDRATE FUNCTIONS
+
+func getMicrosoft365UserRegistrationDetail(ctx context.Context, d *plugin.QueryData, h *plugin.HydrateData) (interface{}, error) {
+	logger := plugin.Logger(ctx)
+
+	userID := d.EqualsQualString("id")
+	if userID == "" {
+		return nil, nil
+	}
+
+	// Create client
+	client, _, err := GetGraphClient(ctx, d)
+	if err != nil {
+		logger.Error("microsoft365_user_registration_detail.getMicrosoft365UserRegistrationDetail", "connection_error", err)
+		return nil, err
+	}
+
+	result, err := client.Reports().AuthenticationMethods().UserRegistrationDetails().ByUserRegistrationDetailsId(userID).Get(ctx, nil)
+	if err != nil {
+		errObj := getErrorObject(err)
+		return nil, errObj
+	}
+
+	return &Microsoft365UserRegistrationDetailInfo{result}, nil
+}
+
+//// REGISTRATION REPORT
+
+// The registration report is updated by Entra ID every few hours, so it's
+// kept for the duration of a batch of queries rather than refetched
+const userRegistrationReportTTL = 5 * time.Minute
+
+// nonPremiumTenantErrorCode is the error code of the registration report in a
+// tenant without an Entra ID P1 or P2 license
+const nonPremiumTenantErrorCode = "Authentication_RequestFromNonPremiumTenantOrB2CTenant"
+
+// getUserRegistrationReportMemoized fetches the registration report once per
+// connection and tenant, so the user table's hydrate calls share one
+// paginated download instead of fetching the report for every row.
+var getUserRegistrationReportMemoized = plugin.HydrateFunc(getUserRegistrationReportUncached).Memoize(
+	memoize.WithCacheKeyFunction(getUserRegistrationReportCacheKey),
+	memoize.WithTtl(userRegistrationReportTTL),
+)
+
+// Build a cache key for the call to getUserRegistrationReport.
+func getUserRegistrationReportCacheKey(ctx context.Context, d *plugin.QueryData, h *plugin.HydrateData) (interface{}, error) {
+	key := "getUserRegistrationReport"
+	if tenantID := getMatrixTenantID(ctx); tenantID != "" {
+		key = fmt.Sprintf("%s-%s", key, tenantID)
+	}
+	return key, nil
+}
+
+// getUserRegistrationReport returns the registration details of every user in
+// the tenant, keyed by user ID.
+func getUserRegistrationReport(ctx context.Context, d *plugin.QueryData, h *plugin.HydrateData) (map[string]models.UserRegistrationDetailsable, error) {
+	report, err := getUserRegistrationReportMemoized(ctx, d, h)
+	if err != nil {
+		return nil, err
+	}
+	return report.(map[string]models.UserRegistrationDetailsable), nil
+}
+
+func getUserRegistrationReportUncached(ctx context.Context, d *plugin.QueryData, _ *plugin.HydrateData) (interface{}, error) {
+	logger := plugin.Logger(ctx)
+
+	// Create client
+	client, adapter, err := GetGraphClient(ctx, d)
+	if err != nil {
+		logger.Error("getUserRegistrationReport", "connection_error", err)
+		return nil, err
+	}
+
+	options := &reports.AuthenticationMethodsUserRegistrationDetailsRequestBuilderGetRequestConfiguration{
+		QueryParameters: &reports.AuthenticationMethodsUserRegistrationDetailsRequestBuilderGetQueryParameters{
+			Top: Int32(999),
+		},
+	}
+
+	result, err := client.Reports().AuthenticationMethods().UserRegistrationDetails().Get(ctx, options)
+	if err != nil {
+		errObj := getErrorObject(err)
+		return nil, errObj
+	}
+
+	pageIterator, err := msgraphcore.NewPageIterator[models.UserRegistrationDetailsable](result, adapter, models.CreateUserRegistrationDetailsCollectionResponseFromDiscriminatorValue)
+	if err != nil {
+		logger.Error("getUserRegistrationReport", "create_iterator_instance_error", err)
+		return nil, err
+	}
+
+	report := map[string]models.UserRegistrationDetailsable{}
+	err = pageIterator.Iterate(ctx, func(pageItem models.UserRegistrationDetailsable) bool {
+		if pageItem.GetId() != nil {
+			report[*pageItem.GetId()] = pageItem
+		}
+		return true
+	})
+	if err != nil {
+		logger.Error("getUserRegistrationReport", "paging_error", err)
+		return nil, err
+	}
+
+	return report, nil
+}
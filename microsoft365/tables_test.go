@@ -0,0 +1,483 @@
+package microsoft365
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/microsoftgraph/msgraph-sdk-go/models"
+	"github.com/turbot/steampipe-plugin-sdk/v5/grpc/proto"
+	"github.com/turbot/steampipe-plugin-sdk/v5/plugin"
+	"github.com/turbot/steampipe-plugin-sdk/v5/plugin/quals"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func timestampQual(column, operator string, value time.Time) *quals.Qual {
+	return &quals.Qual{Column: column, Operator: operator, Value: &proto.QualValue{Value: &proto.QualValue_TimestampValue{TimestampValue: timestamppb.New(value)}}}
+}
+
+func boolQual(column string, value bool) *quals.Qual {
+	return &quals.Qual{Column: column, Operator: "=", Value: &proto.QualValue{Value: &proto.QualValue_BoolValue{BoolValue: value}}}
+}
+
+// testColumn returns the values of a column of the rows, as strings.
+func testColumn(rows []map[string]*proto.Column, name string) []string {
+	values := []string{}
+	for _, row := range rows {
+		values = append(values, testColumnString(row[name]))
+	}
+	return values
+}
+
+func testColumnString(column *proto.Column) string {
+	switch value := column.GetValue().(type) {
+	case *proto.Column_StringValue:
+		return value.StringValue
+	case *proto.Column_BoolValue:
+		if value.BoolValue {
+			return "true"
+		}
+		return "false"
+	case *proto.Column_IntValue:
+		return strconv.FormatInt(value.IntValue, 10)
+	case *proto.Column_JsonValue:
+		return string(value.JsonValue)
+	case *proto.Column_TimestampValue:
+		return value.TimestampValue.AsTime().Format(time.RFC3339)
+	case *proto.Column_NullValue:
+		return ""
+	default:
+		return column.String()
+	}
+}
+
+func assertColumn(t *testing.T, rows []map[string]*proto.Column, name string, want ...string) {
+	t.Helper()
+	if got := testColumn(rows, name); !slices.Equal(got, want) {
+		t.Errorf("column %s = %q, want %q", name, got, want)
+	}
+}
+
+func TestUserTable(t *testing.T) {
+	f := newFakeGraph(t)
+
+	rows := f.list(t, testQuery{Table: "microsoft365_user"})
+	assertColumn(t, rows, "display_name", "Adele Vance", "Alex Wilber", "Megan Bowen")
+	assertColumn(t, rows, "tenant_id", "00000000-0000-0000-0000-000000000000", "00000000-0000-0000-0000-000000000000", "00000000-0000-0000-0000-000000000000")
+	assertColumn(t, rows, "created_date_time", "2023-03-01T09:15:00Z", "2023-03-02T10:30:00Z", "2023-04-11T16:45:00Z")
+	// Users without a mailbox or registration details have empty columns
+	assertColumn(t, rows, "time_zone", "Pacific Standard Time", "", "")
+	assertColumn(t, rows, "is_mfa_registered", "true", "false", "")
+
+	// Three users are two pages
+	requests := f.requested("/users")
+	if len(requests) != 2 || requests[1].Get("$skiptoken") == "" {
+		t.Errorf("requests = %v, want a request for each page", requests)
+	}
+	if got := requests[0].Get("$select"); !strings.Contains(got, "displayName") {
+		t.Errorf("$select = %s, want displayName", got)
+	}
+	// The mailbox settings of every user, from $batch requests
+	if got := len(f.requested("/users/" + fakeGraphUserID + "/mailboxSettings")); got != 1 {
+		t.Errorf("mailbox settings requests = %d, want 1", got)
+	}
+	// The registration report is fetched once for every row
+	if got := len(f.requested("/reports/authenticationMethods/userRegistrationDetails")); got != 1 {
+		t.Errorf("registration report requests = %d, want 1", got)
+	}
+}
+
+func TestUserTableQuals(t *testing.T) {
+	f := newFakeGraph(t)
+
+	f.list(t, testQuery{
+		Table:   "microsoft365_user",
+		Columns: []string{"id", "display_name"},
+		Quals:   []*quals.Qual{stringQual("display_name", "=", "Adele Vance")},
+		Limit:   1,
+	})
+	requests := f.requested("/users")
+	if len(requests) != 1 {
+		t.Fatalf("requests = %v, want 1 request for the limit", requests)
+	}
+	if got := requests[0].Get("$filter"); got != "displayName eq 'Adele Vance'" {
+		t.Errorf("$filter = %s", got)
+	}
+	if got := requests[0].Get("$top"); got != "1" {
+		t.Errorf("$top = %s, want the limit", got)
+	}
+	if got := requests[0].Get("$select"); got != "displayName,id" && got != "id,displayName" {
+		t.Errorf("$select = %s, want the queried columns", got)
+	}
+}
+
+func TestUserTableGet(t *testing.T) {
+	f := newFakeGraph(t)
+
+	row := f.get(t, testQuery{
+		Table:   "microsoft365_user",
+		Columns: []string{"id", "user_principal_name", "account_enabled"},
+		Quals:   []*quals.Qual{stringQual("id", "=", "48d31887-5fad-4d73-a9f5-3c356e68a038")},
+	})
+	assertColumn(t, []map[string]*proto.Column{row}, "user_principal_name", "MeganB_fabrikam.com#EXT#@contoso.com")
+	assertColumn(t, []map[string]*proto.Column{row}, "account_enabled", "false")
+
+	err := f.getError(t, testQuery{
+		Table: "microsoft365_user",
+		Quals: []*quals.Qual{stringQual("id", "=", "missing")},
+	})
+	var requestErr *RequestError
+	if !errors.As(err, &requestErr) || requestErr.Category != ErrorCategoryNotFound {
+		t.Errorf("get error = %v, want a not found error", err)
+	}
+}
+
+func TestUserTableGatedProperties(t *testing.T) {
+	f := newFakeGraph(t)
+	query := testQuery{
+		Table:   "microsoft365_user",
+		Columns: []string{"id", "display_name", "sign_in_activity", "employee_leave_date_time"},
+	}
+
+	// The list request selects the gated properties
+	rows := f.list(t, query)
+	assertColumn(t, rows, "employee_leave_date_time", "", "2024-06-30T00:00:00Z", "")
+	if got := testColumn(rows, "sign_in_activity"); strings.Contains(got[0], "2024") || !strings.Contains(got[1], "2024-05-20T08:12:00Z") {
+		t.Errorf("column sign_in_activity = %q, want the activity of the second user", got)
+	}
+	if got, want := f.requested("/users")[0].Get("$select"), "displayName,employeeLeaveDateTime,id,signInActivity"; got != want {
+		t.Errorf("$select = %s, want %s", got, want)
+	}
+	if requests := f.requested("/users/" + fakeGraphUserID); len(requests) != 0 {
+		t.Errorf("requests = %v, want none for each user", requests)
+	}
+
+	// Graph rejects a list request that selects them without the permissions,
+	// so it's sent again without them, and they're fetched for each user. The
+	// column is left empty for users the credentials can't read it of.
+	f = newFakeGraph(t)
+	f.failSelect = map[string]int{"signInActivity": http.StatusForbidden}
+	rows = f.list(t, query)
+	assertColumn(t, rows, "employee_leave_date_time", "", "2024-06-30T00:00:00Z", "")
+	assertColumn(t, rows, "sign_in_activity", "", "", "")
+	requests := f.requested("/users")
+	if len(requests) < 2 || requests[1].Get("$select") != "displayName,id" {
+		t.Errorf("requests = %v, want the list sent again without the gated properties", requests)
+	}
+	if requests := f.requested("/users/" + fakeGraphUserID); len(requests) != 2 {
+		t.Errorf("requests = %v, want one for each gated property of the user", requests)
+	}
+}
+
+func TestUserTableRegistrationDetailsErrors(t *testing.T) {
+	user := models.NewUser()
+	user.SetId(StringPtr(fakeGraphUserID))
+	h := &plugin.HydrateData{Item: &Microsoft365UserInfo{Userable: user}}
+
+	// Without the permission or license the columns are left empty, and other
+	// errors fail the query
+	for status, wantErr := range map[int]bool{http.StatusForbidden: false, http.StatusInternalServerError: true} {
+		f := newFakeGraph(t)
+		f.fail = map[string]int{"/reports/authenticationMethods/userRegistrationDetails": status}
+		f.configure = func(config *microsoft365Config) {
+			config.MaxRetries = new(int)
+		}
+
+		d := f.queryData(t, testQuery{Table: "microsoft365_user", Columns: []string{"id", "is_mfa_registered"}})
+		result, err := getUserRegistrationDetails(testContext(), d, h)
+		if (err != nil) != wantErr || result != nil {
+			t.Errorf("status %d: getUserRegistrationDetails() = %v, error = %v, want error %t", status, result, err, wantErr)
+		}
+	}
+}
+
+func TestUserTableLicenseDetailsAdvancedQuery(t *testing.T) {
+	f := newFakeGraph(t)
+
+	rows := f.list(t, testQuery{
+		Table:   "microsoft365_user",
+		Columns: []string{"id", "license_details"},
+		Quals:   []*quals.Qual{stringQual("search", "=", "displayName:a")},
+	})
+	if got := testColumn(rows, "license_details"); !strings.Contains(got[0], "ENTERPRISEPREMIUM") {
+		t.Errorf("column license_details = %q, want the license details of the first user", got)
+	}
+
+	// $expand isn't supported with $search, so they're fetched for each user
+	if got := f.requested("/users")[0].Get("$expand"); got != "" {
+		t.Errorf("$expand = %s, want none in an advanced query", got)
+	}
+	if got := len(f.requested("/users/" + fakeGraphUserID + "/licenseDetails")); got != 1 {
+		t.Errorf("license details requests = %d, want 1", got)
+	}
+}
+
+func TestGroupTable(t *testing.T) {
+	f := newFakeGraph(t)
+
+	rows := f.list(t, testQuery{Table: "microsoft365_group"})
+	assertColumn(t, rows, "display_name", "HR Taskforce", "Digital Initiative Public Relations")
+	assertColumn(t, rows, "group_types", `["Unified"]`, `["Unified"]`)
+	assertColumn(t, rows, "visibility", "Private", "Public")
+}
+
+func TestTeamTables(t *testing.T) {
+	f := newFakeGraph(t)
+
+	rows := f.list(t, testQuery{Table: "microsoft365_team"})
+	assertColumn(t, rows, "display_name", "HR Taskforce", "Digital Initiative Public Relations")
+	assertColumn(t, rows, "is_archived", "false", "true")
+	if got := f.requested("/groups")[0].Get("$filter"); got != "resourceProvisioningOptions/Any(x:x eq 'Team')" {
+		t.Errorf("$filter = %s", got)
+	}
+
+	members := f.list(t, testQuery{Table: "microsoft365_team_member"})
+	assertColumn(t, members, "member_id", "87d349ed-44d7-43e1-9a83-5f2406dee5bd", "6e7b768e-07e2-4810-8459-485f84f8f204", "6e7b768e-07e2-4810-8459-485f84f8f204")
+}
+
+func TestMailMessageTables(t *testing.T) {
+	f := newFakeGraph(t)
+	userQual := stringQual("user_id", "=", fakeGraphUserID)
+
+	rows := f.list(t, testQuery{Table: "microsoft365_mail_message", Quals: []*quals.Qual{userQual}})
+	assertColumn(t, rows, "subject", "Quarterly retail review", "Lunch?", "Draft: store layout")
+	assertColumn(t, rows, "user_id", fakeGraphUserID, fakeGraphUserID, fakeGraphUserID)
+	assertColumn(t, rows, "received_date_time", "2024-04-03T09:00:00Z", "2024-04-02T12:30:00Z", "")
+
+	row := f.get(t, testQuery{Table: "microsoft365_mail_message", Quals: []*quals.Qual{userQual, stringQual("id", "=", "message-2")}})
+	assertColumn(t, []map[string]*proto.Column{row}, "from", `{"emailAddress":{"address":"MeganB@fabrikam.com","name":"Megan Bowen"}}`)
+
+	// The user of the microsoft365_my_* tables is the user_id of the connection
+	rows = f.list(t, testQuery{Table: "microsoft365_my_mail_message", Columns: []string{"id", "is_read"}})
+	assertColumn(t, rows, "is_read", "false", "true", "true")
+
+	// A folder is listed on its own, or with every folder below it
+	folderQual := stringQual("folder_id", "=", "inbox-id")
+	rows = f.list(t, testQuery{Table: "microsoft365_mail_message", Columns: []string{"id", "folder_id"}, Quals: []*quals.Qual{userQual, folderQual}})
+	assertColumn(t, rows, "id", "message-1", "message-2")
+	assertColumn(t, rows, "folder_id", "inbox-id", "inbox-id")
+	rows = f.list(t, testQuery{Table: "microsoft365_my_mail_message", Columns: []string{"id", "folder_id", "parent_folder_id"}, Quals: []*quals.Qual{folderQual, boolQual("include_child_folders", true)}})
+	assertColumn(t, rows, "id", "message-1", "message-2", "message-4")
+	assertColumn(t, rows, "folder_id", "inbox-id", "inbox-id", "inbox-id")
+	assertColumn(t, rows, "parent_folder_id", "inbox-id", "inbox-id", "projects-2024-id")
+	if requests := f.requested("/users/" + fakeGraphUserID + "/mailFolders/projects-id/messages"); len(requests) != 1 {
+		t.Errorf("requests = %v, want the child folder listed", requests)
+	}
+
+	// A search can't be combined with $filter, so the quals of the filter
+	// columns are left to Postgres
+	rows = f.list(t, testQuery{Table: "microsoft365_mail_message", Columns: []string{"id", "query"}, Quals: []*quals.Qual{userQual, stringQual("query", "=", "from:alex AND invoice"), boolQual("is_read", false)}})
+	assertColumn(t, rows, "query", "from:alex AND invoice", "from:alex AND invoice", "from:alex AND invoice")
+	requests := f.requested("/users/" + fakeGraphUserID + "/messages")
+	if last := requests[len(requests)-1]; last.Get("$search") != `"from:alex AND invoice"` || last.Has("$filter") || last.Has("$orderby") || last.Get("$top") != "1000" {
+		t.Errorf("request = %v, want a $search without $filter or $orderby", last)
+	}
+	err := f.listError(t, testQuery{Table: "microsoft365_mail_message", Quals: []*quals.Qual{userQual, stringQual("query", "=", "invoice"), stringQual("filter", "=", "isRead eq false")}})
+	if err == nil || !strings.Contains(err.Error(), "filter can't be combined with query") {
+		t.Errorf("error = %v, want filter rejected with query", err)
+	}
+
+	// Date ranges and the sender are pushed down, and the newest messages
+	// listed first, which Exchange only allows if the filter starts with
+	// receivedDateTime
+	week := time.Date(2024, 3, 28, 0, 0, 0, 0, time.UTC)
+	f.list(t, testQuery{Table: "microsoft365_mail_message", Columns: []string{"id"}, Quals: []*quals.Qual{userQual, stringQual("from_address", "=", "MeganB@fabrikam.com"), timestampQual("received_date_time", ">=", week), timestampQual("sent_date_time", "<", week.AddDate(0, 0, 7)), stringQual("importance", "=", "high")}})
+	requests = f.requested("/users/" + fakeGraphUserID + "/messages")
+	last := requests[len(requests)-1]
+	if got, want := last.Get("$filter"), "receivedDateTime ge 2024-03-28T00:00:00Z and from/emailAddress/address eq 'MeganB@fabrikam.com' and importance eq 'high' and sentDateTime lt 2024-04-04T00:00:00Z"; got != want {
+		t.Errorf("$filter = %s, want %s", got, want)
+	}
+	if got := last.Get("$orderby"); got != "receivedDateTime desc" {
+		t.Errorf("$orderby = %s, want receivedDateTime desc", got)
+	}
+	f.list(t, testQuery{Table: "microsoft365_my_mail_message", Columns: []string{"id"}, Quals: []*quals.Qual{stringQual("conversation_id", "=", "conversation-1")}})
+	requests = f.requested("/users/" + fakeGraphUserID + "/messages")
+	if got, want := requests[len(requests)-1].Get("$filter"), "receivedDateTime ge 1900-01-01T00:00:00Z and conversationId eq 'conversation-1'"; got != want {
+		t.Errorf("$filter = %s, want %s", got, want)
+	}
+
+	// The filter is parenthesized, so its or doesn't escape the other clauses
+	f.list(t, testQuery{Table: "microsoft365_mail_message", Columns: []string{"id"}, Quals: []*quals.Qual{userQual, stringQual("filter", "=", "receivedDateTime ge 2024-03-28T00:00:00Z or isRead eq false"), stringQual("importance", "=", "high")}})
+	requests = f.requested("/users/" + fakeGraphUserID + "/messages")
+	if got, want := requests[len(requests)-1].Get("$filter"), "receivedDateTime ge 1900-01-01T00:00:00Z and importance eq 'high' and (receivedDateTime ge 2024-03-28T00:00:00Z or isRead eq false)"; got != want {
+		t.Errorf("$filter = %s, want %s", got, want)
+	}
+}
+
+func TestMailAttachmentTable(t *testing.T) {
+	f := newFakeGraph(t)
+	keyQuals := []*quals.Qual{stringQual("user_id", "=", fakeGraphUserID), stringQual("message_id", "=", "message-1")}
+
+	rows := f.list(t, testQuery{Table: "microsoft365_mail_attachment", Columns: []string{"id", "name", "type", "size", "content_id", "sha256", "content_base64", "message_id"}, Quals: keyQuals})
+	assertColumn(t, rows, "type", "file", "file", "item", "reference")
+	assertColumn(t, rows, "message_id", "message-1", "message-1", "message-1", "message-1")
+	assertColumn(t, rows, "content_id", "q1-figures@contoso.com", "walkthrough@contoso.com", "", "")
+	assertColumn(t, rows, "sha256", "5ab3f5fe57d66dcd3ff142ae978f25f8bd03b53534f9b0d29552eae6118a4b5b", "", "", "")
+	assertColumn(t, rows, "content_base64", "UmVnaW9uLFExCldlc3QsMTIwMAo=", "", "", "")
+
+	// The list doesn't download the content, and attachments over the size
+	// cap never are
+	if got, want := f.requested("/users/" + fakeGraphUserID + "/messages/message-1/attachments")[0].Get("$select"), "id,microsoft.graph.fileAttachment/contentId,name,size"; got != want {
+		t.Errorf("$select = %s, want %s", got, want)
+	}
+	if requests := f.requested("/users/" + fakeGraphUserID + "/messages/message-1/attachments/attachment-2"); len(requests) != 0 {
+		t.Errorf("requests = %v, want the large attachment skipped", requests)
+	}
+
+	maxSize := 100
+	f.configure = func(config *microsoft365Config) {
+		config.MaxAttachmentContentSize = &maxSize
+	}
+	rows = f.list(t, testQuery{Table: "microsoft365_mail_attachment", Columns: []string{"id", "sha256"}, Quals: keyQuals})
+	assertColumn(t, rows, "sha256", "", "", "", "")
+
+	// An attachment too large for a $batch response is requested on its own
+	maxSize = 64 * 1024 * 1024
+	rows = f.list(t, testQuery{Table: "microsoft365_mail_attachment", Columns: []string{"id", "content_base64"}, Quals: keyQuals})
+	assertColumn(t, rows, "content_base64", "UmVnaW9uLFExCldlc3QsMTIwMAo=", "bGFyZ2U=", "", "")
+	if !f.wasBatched("/users/" + fakeGraphUserID + "/messages/message-1/attachments/attachment-1") {
+		t.Error("the small attachment wasn't requested in a $batch request")
+	}
+	if f.wasBatched("/users/" + fakeGraphUserID + "/messages/message-1/attachments/attachment-2") {
+		t.Error("the large attachment was requested in a $batch request")
+	}
+}
+
+func TestCalendarEventTable(t *testing.T) {
+	f := newFakeGraph(t)
+	userQual := stringQual("user_id", "=", fakeGraphUserID)
+
+	rows := f.list(t, testQuery{Table: "microsoft365_calendar_event", Quals: []*quals.Qual{userQual}})
+	assertColumn(t, rows, "subject", "Store walkthrough", "Planning offsite")
+	assertColumn(t, rows, "is_all_day", "false", "true")
+
+	// A time range queries the calendar view, which expands recurring events
+	start := time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC)
+	end := time.Date(2024, 4, 30, 0, 0, 0, 0, time.UTC)
+	rows = f.list(t, testQuery{
+		Table: "microsoft365_calendar_event",
+		Quals: []*quals.Qual{userQual, timestampQual("start_time", ">=", start), timestampQual("end_time", "<=", end)},
+	})
+	assertColumn(t, rows, "subject", "Store walkthrough")
+	requests := f.requested("/users/" + fakeGraphUserID + "/calendarView")
+	if len(requests) != 1 || requests[0].Get("startDateTime") != "2024-04-01T00:00:00Z" || requests[0].Get("endDateTime") != "2024-04-30T00:00:00Z" {
+		t.Errorf("calendarView requests = %v, want the time range", requests)
+	}
+}
+
+func TestDriveTables(t *testing.T) {
+	f := newFakeGraph(t)
+	userQual := stringQual("user_id", "=", fakeGraphUserID)
+
+	rows := f.list(t, testQuery{Table: "microsoft365_drive", Quals: []*quals.Qual{userQual}})
+	assertColumn(t, rows, "name", "OneDrive")
+	assertColumn(t, rows, "drive_type", "business")
+
+	// Folders are expanded, but their children are only listed once
+	rows = f.list(t, testQuery{Table: "microsoft365_drive_file", Quals: []*quals.Qual{userQual}})
+	assertColumn(t, rows, "name", "Reports", "Q1.docx", "Budget.xlsx")
+	assertColumn(t, rows, "drive_id", "b!adele-drive", "b!adele-drive", "b!adele-drive")
+	assertColumn(t, rows, "size", "25600", "25600", "486400")
+}
+
+func TestOrganizationContactTable(t *testing.T) {
+	f := newFakeGraph(t)
+
+	rows := f.list(t, testQuery{Table: "microsoft365_organization_contact"})
+	assertColumn(t, rows, "display_name", "Lidia Holloway")
+	assertColumn(t, rows, "proxy_addresses", `["SMTP:lidia@fabrikam.com"]`)
+}
+
+func TestUserRegistrationDetailTable(t *testing.T) {
+	f := newFakeGraph(t)
+
+	rows := f.list(t, testQuery{Table: "microsoft365_user_registration_detail"})
+	assertColumn(t, rows, "user_principal_name", "AdeleV@contoso.com", "AlexW@contoso.com")
+	assertColumn(t, rows, "is_admin", "false", "true")
+	assertColumn(t, rows, "methods_registered", `["microsoftAuthenticatorPush","softwareOneTimePasscode"]`, `[]`)
+}
+
+func TestDeltaTables(t *testing.T) {
+	f := newFakeGraph(t)
+
+	rows := f.list(t, testQuery{Table: "microsoft365_user_delta"})
+	assertColumn(t, rows, "display_name", "Adele Vance", "Alex Wilber", "")
+	assertColumn(t, rows, "change_type", "initial", "initial", "deleted")
+
+	// The next query resumes from the saved deltaLink
+	rows = f.list(t, testQuery{Table: "microsoft365_user_delta"})
+	assertColumn(t, rows, "change_type", "updated", "updated", "deleted")
+	requests := f.requested("/users/delta()")
+	if last := requests[len(requests)-1]; last.Get("$deltatoken") == "" {
+		t.Errorf("request = %v, want the deltaLink", last)
+	}
+
+	rows = f.list(t, testQuery{Table: "microsoft365_mail_message_delta", Quals: []*quals.Qual{stringQual("user_id", "=", fakeGraphUserID)}})
+	assertColumn(t, rows, "subject", "Quarterly retail review", "Lunch?", "")
+	assertColumn(t, rows, "folder_id", "inbox", "inbox", "inbox")
+	assertColumn(t, rows, "user_id", fakeGraphUserID, fakeGraphUserID, fakeGraphUserID)
+}
+
+func TestMyTables(t *testing.T) {
+	f := newFakeGraph(t)
+
+	rows := f.list(t, testQuery{Table: "microsoft365_my_drive"})
+	assertColumn(t, rows, "name", "OneDrive")
+	assertColumn(t, rows, "user_id", fakeGraphUserID)
+
+	rows = f.list(t, testQuery{Table: "microsoft365_my_calendar_event", Columns: []string{"id", "subject"}})
+	assertColumn(t, rows, "subject", "Store walkthrough", "Planning offsite")
+}
+
+func TestConnectionDiagnosticTable(t *testing.T) {
+	f := newFakeGraph(t)
+
+	rows := f.list(t, testQuery{Table: "microsoft365_connection_diagnostic"})
+	byTable := map[string]map[string]*proto.Column{}
+	for _, row := range rows {
+		byTable[testColumnString(row["table_name"])] = row
+	}
+	for name, want := range map[string]string{"microsoft365_user": "true", "microsoft365_drive": "true", "microsoft365_site": "false"} {
+		row, ok := byTable[name]
+		if !ok {
+			t.Fatalf("no diagnostic for %s", name)
+		}
+		assertColumn(t, []map[string]*proto.Column{row}, "is_usable", want)
+		assertColumn(t, []map[string]*proto.Column{row}, "auth_method", "msi")
+		assertColumn(t, []map[string]*proto.Column{row}, "token_tenant_id", fakeGraphTenantID)
+	}
+	assertColumn(t, []map[string]*proto.Column{byTable["microsoft365_site"]}, "missing_permissions", `["Sites.Read.All"]`)
+}
+
+func TestMailFolderTables(t *testing.T) {
+	f := newFakeGraph(t)
+	userQual := stringQual("user_id", "=", fakeGraphUserID)
+
+	// Child folders follow their parent, and hidden folders are included
+	rows := f.list(t, testQuery{Table: "microsoft365_mail_folder", Quals: []*quals.Qual{userQual}})
+	assertColumn(t, rows, "path", "Inbox", "Inbox/Projects", "Inbox/Projects/2024", "Sent Items", "Yammer Root")
+	assertColumn(t, rows, "well_known_name", "inbox", "", "", "sentitems", "")
+	assertColumn(t, rows, "is_hidden", "false", "false", "false", "false", "true")
+	assertColumn(t, rows, "unread_item_count", "1", "0", "2", "0", "0")
+	assertColumn(t, rows, "size_in_bytes", "524288", "", "", "2097152", "0")
+	for _, requests := range [][]url.Values{f.requested("/users/" + fakeGraphUserID + "/mailFolders"), f.requested("/users/" + fakeGraphUserID + "/mailFolders/inbox-id/childFolders")} {
+		if len(requests) == 0 || requests[0].Get("includeHiddenFolders") != "true" {
+			t.Errorf("requests = %v, want hidden folders included", requests)
+		}
+	}
+	if got := f.requested("/users/" + fakeGraphUserID + "/mailFolders")[0].Get("$expand"); got != "singleValueExtendedProperties($filter=id eq 'Long 0x0E08')" {
+		t.Errorf("$expand = %s, want the folder size", got)
+	}
+
+	// The path of a single folder is built from its parents
+	row := f.get(t, testQuery{Table: "microsoft365_mail_folder", Columns: []string{"id", "path"}, Quals: []*quals.Qual{userQual, stringQual("id", "=", "projects-2024-id")}})
+	assertColumn(t, []map[string]*proto.Column{row}, "path", "Inbox/Projects/2024")
+
+	rows = f.list(t, testQuery{Table: "microsoft365_my_mail_folder", Columns: []string{"id", "display_name"}, Limit: 2})
+	assertColumn(t, rows, "display_name", "Inbox", "Projects")
+}
@@ -0,0 +1,226 @@
+package microsoft365
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/turbot/steampipe-plugin-sdk/v5/plugin"
+	"github.com/turbot/steampipe-plugin-sdk/v5/plugin/quals"
+)
+
+// matrixKeyTenant is the matrix key, and column, that a multi-tenant
+// connection fans out on
+const matrixKeyTenant = "tenant_id"
+
+// tenantSettings are the connection arguments that can be set per entry of
+// the tenants list. Anything not set in an entry is taken from the
+// connection.
+var tenantSettings = map[string]func(config *microsoft365Config, value string) error{
+	"tenant_id":            func(c *microsoft365Config, v string) error { c.TenantID = &v; return nil },
+	"auth_method":          func(c *microsoft365Config, v string) error { c.AuthMethod = &v; return nil },
+	"client_id":            func(c *microsoft365Config, v string) error { c.ClientID = &v; return nil },
+	"client_secret":        func(c *microsoft365Config, v string) error { c.ClientSecret = &v; return nil },
+	"certificate_path":     func(c *microsoft365Config, v string) error { c.CertificatePath = &v; return nil },
+	"certificate_content":  func(c *microsoft365Config, v string) error { c.CertificateContent = &v; return nil },
+	"certificate_password": func(c *microsoft365Config, v string) error { c.CertificatePassword = &v; return nil },
+	"msi_endpoint":         func(c *microsoft365Config, v string) error { c.MSIEndpoint = &v; return nil },
+	"federated_token_file": func(c *microsoft365Config, v string) error { c.FederatedTokenFile = &v; return nil },
+	"user_id":              func(c *microsoft365Config, v string) error { c.UserID = &v; return nil },
+	"send_certificate_chain": func(c *microsoft365Config, v string) error {
+		send, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("send_certificate_chain must be true or false, got %q", v)
+		}
+		c.SendCertificateChain = &send
+		return nil
+	},
+	"enable_msi": func(c *microsoft365Config, v string) error {
+		enabled, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("enable_msi must be true or false, got %q", v)
+		}
+		c.EnableMSI = &enabled
+		return nil
+	},
+}
+
+// getTenantConfigs returns the config of each entry of the tenants list, keyed
+// by tenant ID, with the entry's settings applied over the connection's.
+func getTenantConfigs(config microsoft365Config) (map[string]microsoft365Config, error) {
+	configs := make(map[string]microsoft365Config, len(config.Tenants))
+	for i, settings := range config.Tenants {
+		tenantConfig := config
+		tenantConfig.Tenants = nil
+
+		// Apply the settings in a stable order, so errors are reported consistently
+		names := make([]string, 0, len(settings))
+		for name := range settings {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			apply, ok := tenantSettings[name]
+			if !ok {
+				return nil, fmt.Errorf("tenants[%d]: unsupported argument %q, valid arguments are %s", i, name, validTenantSettings())
+			}
+			if err := apply(&tenantConfig, settings[name]); err != nil {
+				return nil, fmt.Errorf("tenants[%d]: %v", i, err)
+			}
+		}
+
+		tenantID := settings["tenant_id"]
+		if tenantID == "" {
+			return nil, fmt.Errorf("tenants[%d]: tenant_id must be set", i)
+		}
+		if _, ok := configs[tenantID]; ok {
+			return nil, fmt.Errorf("tenants[%d]: tenant %s is listed more than once", i, tenantID)
+		}
+		configs[tenantID] = tenantConfig
+	}
+
+	return configs, nil
+}
+
+func validTenantSettings() string {
+	names := make([]string, 0, len(tenantSettings))
+	for name := range tenantSettings {
+		names = append(names, fmt.Sprintf("%q", name))
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// tenantMatrix fans every table out across the tenants of a multi-tenant
+// connection, or only those in the tenant_id = or in quals. Connections
+// without a tenants list aren't fanned out.
+func tenantMatrix(ctx context.Context, d *plugin.QueryData) []map[string]interface{} {
+	config := GetConfig(d.Connection)
+	if len(config.Tenants) == 0 {
+		return nil
+	}
+
+	if _, err := getTenantConfigs(config); err != nil {
+		// Fall through to getConnectionConfig, which reports the error
+		plugin.Logger(ctx).Error("tenantMatrix", "config_error", err)
+		return nil
+	}
+
+	tenantIDs := getTenantQualValues(d)
+	matrix := make([]map[string]interface{}, 0, len(config.Tenants))
+	for _, settings := range config.Tenants {
+		if tenantIDs == nil || slices.Contains(tenantIDs, settings["tenant_id"]) {
+			matrix = append(matrix, map[string]interface{}{matrixKeyTenant: settings["tenant_id"]})
+		}
+	}
+
+	// An empty matrix would query the connection without a tenant, so if no
+	// tenant matches, they're all returned for Steampipe to filter out
+	if len(matrix) == 0 {
+		for _, settings := range config.Tenants {
+			matrix = append(matrix, map[string]interface{}{matrixKeyTenant: settings["tenant_id"]})
+		}
+	}
+	return matrix
+}
+
+// getTenantQualValues returns the tenants of the tenant_id = and in quals, or
+// nil if there are none.
+func getTenantQualValues(d *plugin.QueryData) []string {
+	tenantQuals := d.Quals[matrixKeyTenant]
+	if tenantQuals == nil {
+		return nil
+	}
+
+	var tenantIDs []string
+	for _, qual := range tenantQuals.Quals {
+		if qual.Operator != quals.QualOperatorEqual {
+			continue
+		}
+		if list := qual.Value.GetListValue(); list != nil {
+			for _, value := range list.Values {
+				tenantIDs = append(tenantIDs, value.GetStringValue())
+			}
+		} else {
+			tenantIDs = append(tenantIDs, qual.Value.GetStringValue())
+		}
+	}
+	return tenantIDs
+}
+
+// getMatrixTenantID returns the tenant that the current hydrate call runs
+// for, or "" if the connection isn't multi-tenant.
+func getMatrixTenantID(ctx context.Context) string {
+	tenantID, _ := plugin.GetMatrixItem(ctx)[matrixKeyTenant].(string)
+	return tenantID
+}
+
+// getConnectionConfig returns the config for the current hydrate call: the
+// connection config, or for a multi-tenant connection the config of the
+// tenant being queried.
+func getConnectionConfig(ctx context.Context, d *plugin.QueryData) (microsoft365Config, error) {
+	config := GetConfig(d.Connection)
+	if len(config.Tenants) == 0 {
+		return config, nil
+	}
+
+	configs, err := getTenantConfigs(config)
+	if err != nil {
+		return config, err
+	}
+
+	tenantID := getMatrixTenantID(ctx)
+	tenantConfig, ok := configs[tenantID]
+	if !ok {
+		return config, fmt.Errorf("tenant %q is not listed in the tenants of connection %s", tenantID, d.Connection.Name)
+	}
+	return tenantConfig, nil
+}
+
+// tenantCredential reports failures to get a token as a TenantError, so one
+// tenant with invalid credentials doesn't fail queries across all tenants.
+type tenantCredential struct {
+	azcore.TokenCredential
+	tenantID string
+}
+
+func (c *tenantCredential) GetToken(ctx context.Context, opts policy.TokenRequestOptions) (azcore.AccessToken, error) {
+	token, err := c.TokenCredential.GetToken(ctx, opts)
+	if err != nil {
+		return token, &TenantError{TenantID: c.tenantID, Err: err}
+	}
+	return token, nil
+}
+
+// shouldIgnoreTenantError turns tenant-level failures of a multi-tenant
+// connection into warnings, so the other tenants still return rows.
+func shouldIgnoreTenantError(ctx context.Context, err error) bool {
+	tenantID := getMatrixTenantID(ctx)
+	if tenantID == "" || err == nil {
+		return false
+	}
+
+	if !isTenantError(err) {
+		return false
+	}
+
+	plugin.Logger(ctx).Warn("skipping tenant", "tenant_id", tenantID, "error", err)
+	return true
+}
+
+// isTenantError reports whether the tenant can't be queried at all, i.e. its
+// Graph client couldn't be built or its credentials couldn't get a token, e.g.
+// because the application isn't consented to in that tenant. The errors of
+// single requests, e.g. a 403 on one mailbox, are left to the table's ignore
+// config.
+func isTenantError(err error) bool {
+	var tenantErr *TenantError
+	return errors.As(err, &tenantErr)
+}
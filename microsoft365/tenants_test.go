@@ -0,0 +1,158 @@
+package microsoft365
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	msgraphsdkgo "github.com/microsoftgraph/msgraph-sdk-go"
+	"github.com/turbot/steampipe-plugin-sdk/v5/grpc/proto"
+	"github.com/turbot/steampipe-plugin-sdk/v5/plugin/context_key"
+	"github.com/turbot/steampipe-plugin-sdk/v5/plugin/quals"
+)
+
+func TestGetTenantConfigs(t *testing.T) {
+	config := testClientSecretConfig()
+	config.Environment = StringPtr("AZUREUSGOVERNMENTCLOUD")
+	config.Tenants = []map[string]string{
+		{"tenant_id": "tenant-a"},
+		{"tenant_id": "tenant-b", "client_id": "client-b", "client_secret": "secret-b", "enable_msi": "false"},
+	}
+
+	configs, err := getTenantConfigs(config)
+	if err != nil {
+		t.Fatalf("getTenantConfigs() error = %v", err)
+	}
+
+	a := configs["tenant-a"]
+	if *a.TenantID != "tenant-a" || *a.ClientID != *config.ClientID || *a.Environment != "AZUREUSGOVERNMENTCLOUD" {
+		t.Errorf("tenant-a did not inherit the connection settings: %+v", a)
+	}
+	if a.Tenants != nil {
+		t.Error("tenant config still lists the tenants")
+	}
+
+	b := configs["tenant-b"]
+	if *b.ClientID != "client-b" || *b.ClientSecret != "secret-b" || b.EnableMSI == nil || *b.EnableMSI {
+		t.Errorf("tenant-b settings were not applied: %+v", b)
+	}
+
+	for name, tenants := range map[string][]map[string]string{
+		"missing tenant_id":  {{"client_id": "x"}},
+		"duplicate tenant":   {{"tenant_id": "x"}, {"tenant_id": "x"}},
+		"unknown argument":   {{"tenant_id": "x", "environment": "AZURECHINACLOUD"}},
+		"invalid enable_msi": {{"tenant_id": "x", "enable_msi": "maybe"}},
+	} {
+		config.Tenants = tenants
+		if _, err := getTenantConfigs(config); err == nil {
+			t.Errorf("getTenantConfigs() with %s did not fail", name)
+		}
+	}
+}
+
+func TestShouldIgnoreTenantError(t *testing.T) {
+	tenantErr := &TenantError{TenantID: "tenant-a", Err: errors.New("AADSTS7000215: Invalid client secret provided")}
+
+	ctx := testContext()
+	if shouldIgnoreTenantError(ctx, tenantErr) {
+		t.Error("tenant error ignored for a single-tenant connection")
+	}
+
+	ctx = context.WithValue(ctx, context_key.MatrixItem, map[string]interface{}{matrixKeyTenant: "tenant-a"})
+	if !shouldIgnoreTenantError(ctx, tenantErr) {
+		t.Error("TenantError not ignored")
+	}
+	if requestErr := getErrorObject(tenantErr); !shouldIgnoreTenantError(ctx, requestErr) || !strings.Contains(requestErr.Message, "AADSTS7000215") {
+		t.Errorf("getErrorObject() lost the tenant error: %v", requestErr)
+	}
+	if !shouldIgnoreTenantError(ctx, fmt.Errorf("get token: %w", tenantErr)) {
+		t.Error("wrapped TenantError not ignored")
+	}
+	if shouldIgnoreTenantError(ctx, &RequestError{Code: "Authorization_RequestDenied", Category: ErrorCategoryPermission}) {
+		t.Error("Authorization_RequestDenied of a single request ignored as a tenant error")
+	}
+	if shouldIgnoreTenantError(ctx, &RequestError{Code: "InvalidAuthenticationToken", Category: ErrorCategoryAuth}) {
+		t.Error("InvalidAuthenticationToken of a single request ignored as a tenant error")
+	}
+	if shouldIgnoreTenantError(ctx, &RequestError{Code: "ErrorItemNotFound", Category: ErrorCategoryNotFound}) {
+		t.Error("row-level error ignored as a tenant error")
+	}
+}
+
+type failingCredential struct {
+	err error
+}
+
+func (c *failingCredential) GetToken(context.Context, policy.TokenRequestOptions) (azcore.AccessToken, error) {
+	return azcore.AccessToken{}, c.err
+}
+
+func TestTenantCredentialErrorSkipsTenant(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("unexpected request %s %s without a token", r.Method, r.URL.Path)
+	}))
+	t.Cleanup(server.Close)
+
+	cred := &tenantCredential{TokenCredential: &failingCredential{err: errors.New("AADSTS65001: The user or administrator has not consented to use the application")}, tenantID: "tenant-a"}
+	adapter, err := newGraphRequestAdapter(cred, cloudEndpoints{GraphEndpoint: server.URL}, server.Client())
+	if err != nil {
+		t.Fatalf("newGraphRequestAdapter() error = %v", err)
+	}
+
+	// A token failure surfaces from the request that needed the token
+	ctx := context.WithValue(testContext(), context_key.MatrixItem, map[string]interface{}{matrixKeyTenant: "tenant-a"})
+	_, err = msgraphsdkgo.NewGraphServiceClient(adapter).Organization().Get(ctx, nil)
+	if err == nil || !shouldIgnoreTenantError(ctx, getErrorObject(err)) {
+		t.Errorf("error = %v, want the tenant skipped", err)
+	}
+}
+
+func TestTenantMatrixQuals(t *testing.T) {
+	const otherTenantID = "11111111-1111-1111-1111-111111111111"
+	f := newFakeGraph(t)
+	f.configure = func(config *microsoft365Config) {
+		config.Tenants = []map[string]string{{"tenant_id": fakeGraphTenantID}, {"tenant_id": otherTenantID}}
+	}
+
+	// Steampipe skips a connection whose connection key column doesn't match
+	// a qual, which a multi-tenant connection never would
+	p := Plugin(testContext())
+	if len(p.ConnectionKeyColumns) != 0 {
+		t.Errorf("ConnectionKeyColumns = %v, want none", p.ConnectionKeyColumns)
+	}
+	if list := p.TableMap["microsoft365_user"].List; list.KeyColumns.Find(matrixKeyTenant) == nil {
+		t.Errorf("list key columns = %v, want tenant_id", list.KeyColumns)
+	}
+
+	// Each tenant lists the users of the fake
+	tenants := func(q testQuery) map[string]int {
+		counts := map[string]int{}
+		for _, tenantID := range testColumn(f.list(t, q), "tenant_id") {
+			counts[tenantID]++
+		}
+		return counts
+	}
+	query := testQuery{Table: "microsoft365_user", Columns: []string{"id", "tenant_id"}}
+	if got := tenants(query); len(got) != 2 || got[fakeGraphTenantID] == 0 || got[otherTenantID] == 0 {
+		t.Errorf("tenants = %v, want both", got)
+	}
+
+	query.Quals = []*quals.Qual{stringQual("tenant_id", "=", otherTenantID)}
+	if got := tenants(query); len(got) != 1 || got[otherTenantID] == 0 {
+		t.Errorf("tenants = %v, want %s", got, otherTenantID)
+	}
+
+	query.Quals = []*quals.Qual{{Column: "tenant_id", Operator: quals.QualOperatorEqual, Value: &proto.QualValue{Value: &proto.QualValue_ListValue{ListValue: &proto.QualValueList{Values: []*proto.QualValue{
+		{Value: &proto.QualValue_StringValue{StringValue: fakeGraphTenantID}},
+		{Value: &proto.QualValue_StringValue{StringValue: "22222222-2222-2222-2222-222222222222"}},
+	}}}}}}
+	if got := tenants(query); len(got) != 1 || got[fakeGraphTenantID] == 0 {
+		t.Errorf("tenants = %v, want %s", got, fakeGraphTenantID)
+	}
+}
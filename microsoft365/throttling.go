@@ -0,0 +1,466 @@
+package microsoft365
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	khttp "github.com/microsoft/kiota-http-go"
+)
+
+const (
+	defaultMaxRetries     = 5
+	defaultMaxConcurrency = 25
+	defaultMinRetryDelay  = time.Second
+
+	// maxRetryDelay caps the exponential backoff when Graph doesn't send
+	// Retry-After
+	maxRetryDelay = time.Minute
+	// maxRetryAfter is the longest Retry-After that is waited for; a request
+	// asked to wait longer fails with the throttling response instead
+	maxRetryAfter = 3 * time.Minute
+)
+
+// Microsoft Graph workloads, which are throttled independently
+// https://learn.microsoft.com/en-us/graph/throttling-limits
+const (
+	workloadDirectory  = "directory"
+	workloadExchange   = "exchange"
+	workloadSharePoint = "sharepoint"
+	workloadTeams      = "teams"
+)
+
+// workloadConcurrency caps the in-flight requests of each workload below
+// max_concurrency, so a throttled workload can't hold every request slot of
+// the connection
+var workloadConcurrency = map[string]int{
+	workloadDirectory:  20,
+	workloadExchange:   16,
+	workloadSharePoint: 16,
+	workloadTeams:      8,
+}
+
+// Resources below /users/{id}, /me and /groups/{id} that are served by
+// Exchange Online and SharePoint Online
+var (
+	exchangeResources = map[string]bool{
+		"calendar":        true,
+		"calendargroups":  true,
+		"calendars":       true,
+		"calendarview":    true,
+		"contactfolders":  true,
+		"contacts":        true,
+		"conversations":   true,
+		"events":          true,
+		"mailboxsettings": true,
+		"mailfolders":     true,
+		"messages":        true,
+		"threads":         true,
+	}
+	sharePointResources = map[string]bool{
+		"drive":         true,
+		"drives":        true,
+		"followedsites": true,
+		"sites":         true,
+	}
+)
+
+// getWorkload returns the Graph workload that serves the request path, e.g.
+// exchange for /v1.0/users/{id}/messages.
+func getWorkload(path string) string {
+	segments := strings.Split(strings.Trim(strings.ToLower(path), "/"), "/")
+	if len(segments) > 0 && (segments[0] == "v1.0" || segments[0] == "beta") {
+		segments = segments[1:]
+	}
+	if len(segments) == 0 {
+		return workloadDirectory
+	}
+
+	switch segments[0] {
+	case "sites", "drives", "shares":
+		return workloadSharePoint
+	case "teams", "chats", "teamwork":
+		return workloadTeams
+	}
+
+	// e.g. /me/messages, /users/{id}/messages or /groups/{id}/drive
+	resource := ""
+	if segments[0] == "me" && len(segments) > 1 {
+		resource = segments[1]
+	} else if (segments[0] == "users" || segments[0] == "groups") && len(segments) > 2 {
+		resource = segments[2]
+	}
+	switch {
+	case exchangeResources[resource]:
+		return workloadExchange
+	case sharePointResources[resource]:
+		return workloadSharePoint
+	}
+	return workloadDirectory
+}
+
+// workloadContextKey is the context key of the workload a request is
+// throttled as, when its path doesn't tell, e.g. that of the requests of a
+// $batch request
+type workloadContextKey struct{}
+
+// withWorkload returns the context of a request that is throttled as the
+// workload.
+func withWorkload(ctx context.Context, workload string) context.Context {
+	return context.WithValue(ctx, workloadContextKey{}, workload)
+}
+
+// getRequestWorkload returns the workload the request is throttled as: that
+// of its context, if set, or else that of its path.
+func getRequestWorkload(req *http.Request) string {
+	if workload, ok := req.Context().Value(workloadContextKey{}).(string); ok {
+		return workload
+	}
+	return getWorkload(req.URL.Path)
+}
+
+// throttlingOptions holds the max_retries, max_concurrency and
+// min_retry_delay settings of a connection.
+type throttlingOptions struct {
+	MaxRetries     int
+	MaxConcurrency int
+	MinRetryDelay  time.Duration
+	// RequestTimeout, from request_timeout, caps each attempt of a request,
+	// including reading its response, but not the waits between retries. A
+	// request can take (MaxRetries+1) * RequestTimeout plus those waits, and
+	// is only bounded as a whole by the query's context.
+	RequestTimeout time.Duration
+}
+
+func getThrottlingOptions(config microsoft365Config) (throttlingOptions, error) {
+	options := throttlingOptions{
+		MaxRetries:     defaultMaxRetries,
+		MaxConcurrency: defaultMaxConcurrency,
+		MinRetryDelay:  defaultMinRetryDelay,
+	}
+
+	if config.MaxRetries != nil {
+		if *config.MaxRetries < 0 || *config.MaxRetries > 20 {
+			return options, fmt.Errorf("invalid max_retries %d: must be between 0 and 20", *config.MaxRetries)
+		}
+		options.MaxRetries = *config.MaxRetries
+	}
+	if config.MaxConcurrency != nil {
+		if *config.MaxConcurrency < 1 {
+			return options, fmt.Errorf("invalid max_concurrency %d: must be at least 1", *config.MaxConcurrency)
+		}
+		options.MaxConcurrency = *config.MaxConcurrency
+	}
+	if config.MinRetryDelay != nil {
+		if *config.MinRetryDelay < 1 {
+			return options, fmt.Errorf("invalid min_retry_delay %d: must be a number of milliseconds greater than 0", *config.MinRetryDelay)
+		}
+		options.MinRetryDelay = time.Duration(*config.MinRetryDelay) * time.Millisecond
+	}
+
+	return options, nil
+}
+
+// workloadMetrics counts the requests of a workload since the connection's
+// client was created.
+type workloadMetrics struct {
+	Requests    atomic.Int64
+	Throttled   atomic.Int64
+	Retries     atomic.Int64
+	RetryWait   atomic.Int64
+	InFlight    atomic.Int64
+	MaxInFlight atomic.Int64
+}
+
+// throttlingHandler is the Graph middleware that replaces the Kiota retry
+// handler. It caps the in-flight requests of the connection and of each
+// workload, and retries throttled requests, waiting for Retry-After when
+// Graph sends it and backing off exponentially with jitter otherwise. It also
+// retries the requests that failed without a response, e.g. on a reset
+// connection, so max_retries is the only retry limit of a request.
+type throttlingHandler struct {
+	options throttlingOptions
+	logger  hclog.Logger
+
+	connection chan struct{}
+	workloads  map[string]chan struct{}
+	metrics    map[string]*workloadMetrics
+}
+
+func newThrottlingHandler(options throttlingOptions, logger hclog.Logger) *throttlingHandler {
+	h := &throttlingHandler{
+		options:    options,
+		logger:     logger,
+		connection: make(chan struct{}, options.MaxConcurrency),
+		workloads:  map[string]chan struct{}{},
+		metrics:    map[string]*workloadMetrics{},
+	}
+	for workload, limit := range workloadConcurrency {
+		h.workloads[workload] = make(chan struct{}, min(limit, options.MaxConcurrency))
+		h.metrics[workload] = &workloadMetrics{}
+	}
+	return h
+}
+
+func (h *throttlingHandler) Intercept(pipeline khttp.Pipeline, middlewareIndex int, req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	workload := getRequestWorkload(req)
+	metrics := h.metrics[workload]
+
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 {
+			if err := rewindBody(req); err != nil {
+				return nil, err
+			}
+			req.Header.Set("Retry-Attempt", strconv.Itoa(attempt))
+		}
+
+		release, err := h.acquire(ctx, workload)
+		if err != nil {
+			return nil, err
+		}
+		metrics.Requests.Add(1)
+		attemptReq, cancel := h.withAttemptTimeout(req)
+		resp, err := pipeline.Next(attemptReq, middlewareIndex)
+		release()
+		var delay time.Duration
+		if err != nil {
+			cancel()
+			var ok bool
+			delay, ok = h.shouldRetryError(ctx, workload, err, attempt)
+			if !ok || !isRetriableRequest(req) {
+				return resp, err
+			}
+		} else {
+			if !isRetriableResponse(resp) || !isRetriableRequest(req) {
+				resp.Body = &cancelOnClose{ReadCloser: resp.Body, cancel: cancel}
+				return resp, nil
+			}
+			var ok bool
+			delay, ok = h.shouldRetry(workload, resp, attempt)
+			if !ok {
+				resp.Body = &cancelOnClose{ReadCloser: resp.Body, cancel: cancel}
+				return resp, nil
+			}
+
+			// The response is replaced by the retry's
+			_, _ = io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+			cancel()
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// withAttemptTimeout returns the request of one attempt, with
+// request_timeout as its deadline, and the function that releases the
+// deadline once the response is read.
+func (h *throttlingHandler) withAttemptTimeout(req *http.Request) (*http.Request, context.CancelFunc) {
+	if h.options.RequestTimeout <= 0 {
+		return req, func() {}
+	}
+	ctx, cancel := context.WithTimeout(req.Context(), h.options.RequestTimeout)
+	return req.WithContext(ctx), cancel
+}
+
+// cancelOnClose releases the deadline of an attempt when its response body is
+// closed, so the body can still be read after the handler returns.
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (c *cancelOnClose) Close() error {
+	err := c.ReadCloser.Close()
+	c.cancel()
+	return err
+}
+
+// shouldRetry counts a throttled response in the workload's metrics, and
+// reports whether the request is retried after the attempt and how long to
+// wait first.
+func (h *throttlingHandler) shouldRetry(workload string, resp *http.Response, attempt int) (time.Duration, bool) {
+	metrics := h.metrics[workload]
+	if getStatusCategory(resp.StatusCode) == ErrorCategoryThrottled {
+		metrics.Throttled.Add(1)
+	}
+
+	delay, ok := h.getRetryDelay(resp, attempt)
+	if !ok || attempt >= h.options.MaxRetries {
+		return 0, false
+	}
+
+	h.logger.Debug("throttlingHandler", "workload", workload, "status", resp.StatusCode, "attempt", attempt+1, "delay", delay)
+	metrics.Retries.Add(1)
+	metrics.RetryWait.Add(int64(delay))
+	return delay, true
+}
+
+// shouldRetryError reports whether a request that got no response, e.g.
+// because its connection was reset or its attempt timed out, is retried after
+// the attempt and how long to wait first. A cancelled query isn't retried.
+func (h *throttlingHandler) shouldRetryError(ctx context.Context, workload string, err error, attempt int) (time.Duration, bool) {
+	if ctx.Err() != nil || getNetworkErrorCategory(err) != ErrorCategoryTransient || attempt >= h.options.MaxRetries {
+		return 0, false
+	}
+
+	delay := h.getBackoff(attempt)
+	h.logger.Debug("throttlingHandler", "workload", workload, "error", err, "attempt", attempt+1, "delay", delay)
+	metrics := h.metrics[workload]
+	metrics.Retries.Add(1)
+	metrics.RetryWait.Add(int64(delay))
+	return delay, true
+}
+
+// acquire waits for a request slot of the workload and of the connection.
+// Slots are taken in that order by every request, so waiting can't deadlock.
+func (h *throttlingHandler) acquire(ctx context.Context, workload string) (func(), error) {
+	select {
+	case h.workloads[workload] <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	select {
+	case h.connection <- struct{}{}:
+	case <-ctx.Done():
+		<-h.workloads[workload]
+		return nil, ctx.Err()
+	}
+
+	metrics := h.metrics[workload]
+	inFlight := metrics.InFlight.Add(1)
+	for {
+		peak := metrics.MaxInFlight.Load()
+		if inFlight <= peak || metrics.MaxInFlight.CompareAndSwap(peak, inFlight) {
+			break
+		}
+	}
+
+	return func() {
+		metrics.InFlight.Add(-1)
+		<-h.connection
+		<-h.workloads[workload]
+	}, nil
+}
+
+// getRetryDelay returns how long to wait before retrying. Retry-After is
+// honoured, but never below min_retry_delay. Without it, the delay doubles
+// with each attempt and is jittered so parallel hydrates don't retry in
+// lockstep. It returns false if Graph asks to wait longer than maxRetryAfter.
+func (h *throttlingHandler) getRetryDelay(resp *http.Response, attempt int) (time.Duration, bool) {
+	if retryAfter, ok := parseRetryAfter(resp.Header.Get("Retry-After")); ok {
+		if retryAfter > maxRetryAfter {
+			return 0, false
+		}
+		return max(retryAfter, h.options.MinRetryDelay), true
+	}
+	return h.getBackoff(attempt), true
+}
+
+// getBackoff returns min_retry_delay doubled with each attempt, up to
+// maxRetryDelay, and jittered so parallel hydrates don't retry in lockstep.
+func (h *throttlingHandler) getBackoff(attempt int) time.Duration {
+	backoff := h.options.MinRetryDelay << attempt
+	if backoff > maxRetryDelay || backoff <= 0 {
+		backoff = maxRetryDelay
+	}
+	// Equal jitter: between half and all of the backoff
+	half := backoff / 2
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
+
+// parseRetryAfter reads a Retry-After header in seconds or as an HTTP date.
+func parseRetryAfter(value string) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil && seconds >= 0 {
+		return time.Duration(seconds * float64(time.Second)), true
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		return max(time.Until(t), 0), true
+	}
+	return 0, false
+}
+
+// isRetriableResponse reports whether Graph throttled the request or failed
+// with a transient server error.
+func isRetriableResponse(resp *http.Response) bool {
+	switch getStatusCategory(resp.StatusCode) {
+	case ErrorCategoryThrottled, ErrorCategoryTransient:
+		return true
+	}
+	return false
+}
+
+// isRetriableRequest reports whether the request body, if any, can be sent
+// again.
+func isRetriableRequest(req *http.Request) bool {
+	if req.Body == nil || req.Body == http.NoBody {
+		return true
+	}
+	if req.GetBody != nil {
+		return true
+	}
+	_, ok := req.Body.(io.Seeker)
+	return ok
+}
+
+func rewindBody(req *http.Request) error {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil
+	}
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return err
+		}
+		req.Body = body
+		return nil
+	}
+	if seeker, ok := req.Body.(io.Seeker); ok {
+		_, err := seeker.Seek(0, io.SeekStart)
+		return err
+	}
+	return nil
+}
+
+// workloadMetricsSummary is the JSON form of workloadMetrics.
+type workloadMetricsSummary struct {
+	Requests    int64   `json:"requests"`
+	Throttled   int64   `json:"throttled"`
+	Retries     int64   `json:"retries"`
+	RetryWaitS  float64 `json:"retry_wait_seconds"`
+	InFlight    int64   `json:"in_flight"`
+	MaxInFlight int64   `json:"max_in_flight"`
+}
+
+// getMetrics returns the request metrics of each workload.
+func (h *throttlingHandler) getMetrics() map[string]workloadMetricsSummary {
+	summary := make(map[string]workloadMetricsSummary, len(h.metrics))
+	for workload, metrics := range h.metrics {
+		summary[workload] = workloadMetricsSummary{
+			Requests:    metrics.Requests.Load(),
+			Throttled:   metrics.Throttled.Load(),
+			Retries:     metrics.Retries.Load(),
+			RetryWaitS:  time.Duration(metrics.RetryWait.Load()).Seconds(),
+			InFlight:    metrics.InFlight.Load(),
+			MaxInFlight: metrics.MaxInFlight.Load(),
+		}
+	}
+	return summary
+}
@@ -0,0 +1,313 @@
+package microsoft365
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	khttp "github.com/microsoft/kiota-http-go"
+)
+
+// testThrottlingHandler returns a throttling handler with the defaults for any
+// options not set.
+func testThrottlingHandler(options throttlingOptions) *throttlingHandler {
+	if options.MaxConcurrency == 0 {
+		options.MaxConcurrency = defaultMaxConcurrency
+	}
+	if options.MinRetryDelay == 0 {
+		options.MinRetryDelay = time.Millisecond
+	}
+	return newThrottlingHandler(options, hclog.NewNullLogger())
+}
+
+func testThrottlingClient(handler *throttlingHandler) *http.Client {
+	return &http.Client{Transport: khttp.NewCustomTransportWithParentTransport(http.DefaultTransport.(*http.Transport), handler)}
+}
+
+func TestGetWorkload(t *testing.T) {
+	tests := map[string]string{
+		"/v1.0/users":                         workloadDirectory,
+		"/v1.0/users/abc":                     workloadDirectory,
+		"/v1.0/users/abc/messages":            workloadExchange,
+		"/v1.0/users/abc/mailFolders/inbox":   workloadExchange,
+		"/v1.0/me/calendarView":               workloadExchange,
+		"/v1.0/users/abc/mailboxSettings":     workloadExchange,
+		"/v1.0/me/drive/root/children":        workloadSharePoint,
+		"/v1.0/groups/abc/drive":              workloadSharePoint,
+		"/v1.0/sites/root/lists":              workloadSharePoint,
+		"/v1.0/teams/abc/members":             workloadTeams,
+		"/v1.0/groups/abc/members":            workloadDirectory,
+		"/beta/reports/authenticationMethods": workloadDirectory,
+		"/v1.0/organization":                  workloadDirectory,
+		"/":                                   workloadDirectory,
+	}
+	for path, want := range tests {
+		if got := getWorkload(path); got != want {
+			t.Errorf("getWorkload(%q) = %q, want %q", path, got, want)
+		}
+	}
+}
+
+func TestGetThrottlingOptions(t *testing.T) {
+	options, err := getThrottlingOptions(microsoft365Config{})
+	if err != nil {
+		t.Fatalf("getThrottlingOptions() error = %v", err)
+	}
+	if options.MaxRetries != defaultMaxRetries || options.MaxConcurrency != defaultMaxConcurrency || options.MinRetryDelay != defaultMinRetryDelay {
+		t.Errorf("getThrottlingOptions() = %+v, want the defaults", options)
+	}
+
+	retries, concurrency, delay := 2, 4, 250
+	options, err = getThrottlingOptions(microsoft365Config{MaxRetries: &retries, MaxConcurrency: &concurrency, MinRetryDelay: &delay})
+	if err != nil {
+		t.Fatalf("getThrottlingOptions() error = %v", err)
+	}
+	if options.MaxRetries != 2 || options.MaxConcurrency != 4 || options.MinRetryDelay != 250*time.Millisecond {
+		t.Errorf("getThrottlingOptions() = %+v", options)
+	}
+
+	negative, zero, tooMany := -1, 0, 21
+	for name, config := range map[string]microsoft365Config{
+		"max_retries negative": {MaxRetries: &negative},
+		"max_retries too high": {MaxRetries: &tooMany},
+		"max_concurrency zero": {MaxConcurrency: &zero},
+		"min_retry_delay zero": {MinRetryDelay: &zero},
+	} {
+		if _, err := getThrottlingOptions(config); err == nil {
+			t.Errorf("%s: getThrottlingOptions() error = nil, want an error", name)
+		}
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	if got, ok := parseRetryAfter("7"); !ok || got != 7*time.Second {
+		t.Errorf("parseRetryAfter(7) = %v, %v", got, ok)
+	}
+	date := time.Now().Add(30 * time.Second).UTC().Format(http.TimeFormat)
+	if got, ok := parseRetryAfter(date); !ok || got <= 25*time.Second || got > 30*time.Second {
+		t.Errorf("parseRetryAfter(%q) = %v, %v", date, got, ok)
+	}
+	for _, value := range []string{"", "soon", "-1"} {
+		if _, ok := parseRetryAfter(value); ok {
+			t.Errorf("parseRetryAfter(%q) ok = true, want false", value)
+		}
+	}
+}
+
+func TestGetRetryDelay(t *testing.T) {
+	h := testThrottlingHandler(throttlingOptions{MinRetryDelay: 100 * time.Millisecond})
+
+	resp := &http.Response{Header: http.Header{}}
+	for attempt := 0; attempt < 3; attempt++ {
+		backoff := 100 * time.Millisecond << attempt
+		delay, ok := h.getRetryDelay(resp, attempt)
+		if !ok || delay < backoff/2 || delay > backoff {
+			t.Errorf("getRetryDelay(attempt %d) = %v, %v, want between %v and %v", attempt, delay, ok, backoff/2, backoff)
+		}
+	}
+	if delay, _ := h.getRetryDelay(resp, 40); delay > maxRetryDelay {
+		t.Errorf("getRetryDelay(attempt 40) = %v, want at most %v", delay, maxRetryDelay)
+	}
+
+	// Retry-After is honoured, but not below min_retry_delay
+	resp.Header.Set("Retry-After", "0")
+	if delay, ok := h.getRetryDelay(resp, 0); !ok || delay != 100*time.Millisecond {
+		t.Errorf("getRetryDelay(Retry-After 0) = %v, %v, want 100ms", delay, ok)
+	}
+	resp.Header.Set("Retry-After", "2")
+	if delay, ok := h.getRetryDelay(resp, 0); !ok || delay != 2*time.Second {
+		t.Errorf("getRetryDelay(Retry-After 2) = %v, %v, want 2s", delay, ok)
+	}
+	resp.Header.Set("Retry-After", "3600")
+	if _, ok := h.getRetryDelay(resp, 0); ok {
+		t.Error("getRetryDelay(Retry-After 3600) ok = true, want false")
+	}
+}
+
+func TestThrottlingHandlerRetries(t *testing.T) {
+	var calls atomic.Int32
+	var attempts []string
+	var mu sync.Mutex
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		attempts = append(attempts, r.Header.Get("Retry-Attempt"))
+		mu.Unlock()
+		if calls.Add(1) <= 2 {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	h := testThrottlingHandler(throttlingOptions{MaxRetries: 3})
+	resp, err := testThrottlingClient(h).Get(server.URL + "/v1.0/users/abc/messages")
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("StatusCode = %d, want 200", resp.StatusCode)
+	}
+	if got := strings.Join(attempts, ","); got != ",1,2" {
+		t.Errorf("Retry-Attempt headers = %q, want \",1,2\"", got)
+	}
+
+	metrics := h.getMetrics()[workloadExchange]
+	if metrics.Requests != 3 || metrics.Throttled != 2 || metrics.Retries != 2 || metrics.InFlight != 0 {
+		t.Errorf("exchange metrics = %+v", metrics)
+	}
+}
+
+func TestThrottlingHandlerGivesUp(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	h := testThrottlingHandler(throttlingOptions{MaxRetries: 2})
+	resp, err := testThrottlingClient(h).Get(server.URL + "/v1.0/users")
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("StatusCode = %d, want 503", resp.StatusCode)
+	}
+	if got := calls.Load(); got != 3 {
+		t.Errorf("server calls = %d, want 3", got)
+	}
+}
+
+func TestThrottlingHandlerTimeoutPerAttempt(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		_, _ = w.Write([]byte(`{"value":[]}`))
+	}))
+	defer server.Close()
+
+	// The Retry-After is longer than the timeout, which only caps each attempt
+	h := testThrottlingHandler(throttlingOptions{MaxRetries: 1, RequestTimeout: 500 * time.Millisecond})
+	resp, err := testThrottlingClient(h).Get(server.URL + "/v1.0/users")
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil || resp.StatusCode != http.StatusOK || string(body) != `{"value":[]}` {
+		t.Errorf("response = %d %s, %v, want 200 after the retry", resp.StatusCode, body, err)
+	}
+	if got := calls.Load(); got != 2 {
+		t.Errorf("server calls = %d, want 2", got)
+	}
+}
+
+func TestThrottlingHandlerAttemptTimeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(time.Second):
+		}
+	}))
+	defer server.Close()
+
+	h := testThrottlingHandler(throttlingOptions{RequestTimeout: 50 * time.Millisecond})
+	_, err := testThrottlingClient(h).Get(server.URL + "/v1.0/users")
+	if err == nil || !strings.Contains(err.Error(), "deadline exceeded") {
+		t.Errorf("Get() error = %v, want the attempt to time out", err)
+	}
+}
+
+func TestThrottlingHandlerRetriesErrors(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The first attempt times out, and the second is reset
+		switch calls.Add(1) {
+		case 1:
+			<-r.Context().Done()
+		case 2:
+			conn, _, _ := w.(http.Hijacker).Hijack()
+			conn.Close()
+		default:
+			w.WriteHeader(http.StatusOK)
+		}
+	}))
+	defer server.Close()
+
+	h := testThrottlingHandler(throttlingOptions{MaxRetries: 2, RequestTimeout: 50 * time.Millisecond})
+	resp, err := testThrottlingClient(h).Get(server.URL + "/v1.0/users")
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || calls.Load() != 3 {
+		t.Errorf("response = %d after %d calls, want 200 after 3", resp.StatusCode, calls.Load())
+	}
+	if metrics := h.getMetrics()[workloadDirectory]; metrics.Requests != 3 || metrics.Retries != 2 {
+		t.Errorf("directory metrics = %+v", metrics)
+	}
+
+	// max_retries also limits the retries of errors
+	calls.Store(0)
+	h = testThrottlingHandler(throttlingOptions{MaxRetries: 0, RequestTimeout: 50 * time.Millisecond})
+	if _, err := testThrottlingClient(h).Get(server.URL + "/v1.0/users"); err == nil || calls.Load() != 1 {
+		t.Errorf("Get() error = %v after %d calls, want the first error", err, calls.Load())
+	}
+}
+
+func TestThrottlingHandlerConcurrency(t *testing.T) {
+	var inFlight, peak atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		current := inFlight.Add(1)
+		defer inFlight.Add(-1)
+		for {
+			p := peak.Load()
+			if current <= p || peak.CompareAndSwap(p, current) {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+	}))
+	defer server.Close()
+
+	h := testThrottlingHandler(throttlingOptions{MaxConcurrency: 3})
+	client := testThrottlingClient(h)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 12; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := client.Get(server.URL + "/v1.0/users")
+			if err != nil {
+				t.Errorf("Get() error = %v", err)
+				return
+			}
+			resp.Body.Close()
+		}()
+	}
+	wg.Wait()
+
+	if got := peak.Load(); got > 3 {
+		t.Errorf("peak in-flight requests = %d, want at most 3", got)
+	}
+	if got := h.getMetrics()[workloadDirectory].MaxInFlight; got > 3 {
+		t.Errorf("directory max_in_flight = %d, want at most 3", got)
+	}
+}
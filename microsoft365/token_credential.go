@@ -0,0 +1,59 @@
+package microsoft365
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+)
+
+// tokenRefreshWindow is how long before expiry a cached token is refreshed.
+const tokenRefreshWindow = 5 * time.Minute
+
+// cachedTokenCredential wraps a credential and reuses its access tokens until
+// they are about to expire. Some credentials, e.g. the Azure CLI, have no
+// cache of their own and would otherwise fetch a token for every request.
+type cachedTokenCredential struct {
+	cred azcore.TokenCredential
+
+	mu     sync.Mutex
+	tokens map[string]azcore.AccessToken
+}
+
+func newCachedTokenCredential(cred azcore.TokenCredential) *cachedTokenCredential {
+	return &cachedTokenCredential{
+		cred:   cred,
+		tokens: map[string]azcore.AccessToken{},
+	}
+}
+
+// GetToken returns a cached token for the requested scopes, or requests a new
+// one from the wrapped credential.
+func (c *cachedTokenCredential) GetToken(ctx context.Context, opts policy.TokenRequestOptions) (azcore.AccessToken, error) {
+	// Claims challenges (e.g. continuous access evaluation) always need a new token
+	if opts.Claims != "" {
+		return c.cred.GetToken(ctx, opts)
+	}
+
+	key := opts.TenantID + "|" + strings.Join(opts.Scopes, " ")
+
+	// The lock is held while requesting a token so concurrent callers wait for
+	// a single request instead of each starting their own
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if token, ok := c.tokens[key]; ok && time.Until(token.ExpiresOn) > tokenRefreshWindow {
+		return token, nil
+	}
+
+	token, err := c.cred.GetToken(ctx, opts)
+	if err != nil {
+		return azcore.AccessToken{}, err
+	}
+	c.tokens[key] = token
+
+	return token, nil
+}
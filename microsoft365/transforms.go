@@ -1,6 +1,11 @@
 package microsoft365
 
 import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/microsoftgraph/msgraph-sdk-go/models"
@@ -37,9 +42,38 @@ type Microsoft365DriveItemInfo struct {
 	UserID  string
 }
 
+type Microsoft365DriveItemDeltaInfo struct {
+	*Microsoft365DriveItemInfo
+	ChangeType string
+}
+
 type Microsoft365MailMessageInfo struct {
 	models.Messageable
+	UserID   string
+	FolderID string
+}
+
+type Microsoft365MailAttachmentInfo struct {
+	models.Attachmentable
+	UserID    string
+	MessageID string
+}
+
+// Microsoft365MailAttachmentContent is the downloaded content of a file
+// attachment
+type Microsoft365MailAttachmentContent struct {
+	Content []byte
+}
+
+type Microsoft365MailFolderInfo struct {
+	models.MailFolderable
 	UserID string
+	Path   string
+}
+
+type Microsoft365MailMessageDeltaInfo struct {
+	Microsoft365MailMessageInfo
+	ChangeType string
 }
 
 type Microsoft365OrgContactInfo struct {
@@ -50,6 +84,11 @@ type Microsoft365GroupInfo struct {
 	models.Groupable
 }
 
+type Microsoft365GroupDeltaInfo struct {
+	*Microsoft365GroupInfo
+	ChangeType string
+}
+
 type Microsoft365TeamsSettingsInfo struct {
 	TeamsCount int    `json:"teams_count"`
 	Note       string `json:"note"`
@@ -963,6 +1002,72 @@ func (event *Microsoft365CalendarEventInfo) EventStart() map[string]interface{}
 	return startTimeInfo
 }
 
+func (folder *Microsoft365MailFolderInfo) MailFolderSizeInBytes() *int64 {
+	for _, property := range folder.GetSingleValueExtendedProperties() {
+		// Graph returns the ID without the tag's leading zeros, e.g. Long 0xe08
+		if property.GetId() == nil || !sameExtendedPropertyID(*property.GetId(), mailFolderSizeProperty) || property.GetValue() == nil {
+			continue
+		}
+		size, err := strconv.ParseInt(*property.GetValue(), 10, 64)
+		if err != nil {
+			return nil
+		}
+		return &size
+	}
+	return nil
+}
+
+// sameExtendedPropertyID reports whether the IDs of MAPI properties, e.g.
+// Long 0x0E08, are of the same type and tag.
+func sameExtendedPropertyID(a, b string) bool {
+	aType, aTag, _ := strings.Cut(a, " ")
+	bType, bTag, _ := strings.Cut(b, " ")
+	if !strings.EqualFold(aType, bType) {
+		return false
+	}
+	aValue, aErr := strconv.ParseUint(strings.TrimPrefix(strings.ToLower(aTag), "0x"), 16, 32)
+	bValue, bErr := strconv.ParseUint(strings.TrimPrefix(strings.ToLower(bTag), "0x"), 16, 32)
+	if aErr != nil || bErr != nil {
+		return strings.EqualFold(a, b)
+	}
+	return aValue == bValue
+}
+
+// AttachmentType returns the type of the attachment: file, item or reference.
+func (attachment *Microsoft365MailAttachmentInfo) AttachmentType() *string {
+	switch attachment.Attachmentable.(type) {
+	case models.FileAttachmentable:
+		return StringPtr("file")
+	case models.ItemAttachmentable:
+		return StringPtr("item")
+	case models.ReferenceAttachmentable:
+		return StringPtr("reference")
+	}
+	return nil
+}
+
+func (attachment *Microsoft365MailAttachmentInfo) AttachmentContentID() *string {
+	if file, ok := attachment.Attachmentable.(models.FileAttachmentable); ok {
+		return file.GetContentId()
+	}
+	return nil
+}
+
+func (content *Microsoft365MailAttachmentContent) SHA256() *string {
+	if content.Content == nil {
+		return nil
+	}
+	sum := sha256.Sum256(content.Content)
+	return StringPtr(hex.EncodeToString(sum[:]))
+}
+
+func (content *Microsoft365MailAttachmentContent) ContentBase64() *string {
+	if content.Content == nil {
+		return nil
+	}
+	return StringPtr(base64.StdEncoding.EncodeToString(content.Content))
+}
+
 func (message *Microsoft365MailMessageInfo) MessageAttachments() []map[string]interface{} {
 	if message.GetAttachments() == nil {
 		return nil
@@ -1069,6 +1174,13 @@ func (message *Microsoft365MailMessageInfo) MessageFrom() map[string]interface{}
 	return fromInfo
 }
 
+func (message *Microsoft365MailMessageInfo) MessageFromAddress() *string {
+	if message.GetFrom() == nil || message.GetFrom().GetEmailAddress() == nil {
+		return nil
+	}
+	return message.GetFrom().GetEmailAddress().GetAddress()
+}
+
 func (message *Microsoft365MailMessageInfo) MessageImportance() interface{} {
 	if message.GetImportance() == nil {
 		return nil
@@ -1903,6 +2015,20 @@ type Microsoft365SiteInfo struct {
 type Microsoft365UserInfo struct {
 	models.Userable
 	MailboxSettings models.MailboxSettingsable
+
+	// gatedRejected is set when Graph rejected the gated properties in the
+	// $select of the list or get request, so getUserGatedProperty fetches
+	// them for each user
+	gatedRejected bool
+}
+
+type Microsoft365UserDeltaInfo struct {
+	*Microsoft365UserInfo
+	ChangeType string
+}
+
+type Microsoft365UserRegistrationDetailInfo struct {
+	models.UserRegistrationDetailsable
 }
 
 // List transform methods
@@ -3488,6 +3614,33 @@ func (user *Microsoft365UserInfo) UserPasswordProfile() map[string]interface{} {
 	return profile
 }
 
+func (user *Microsoft365UserInfo) UserSignInActivity() map[string]interface{} {
+	if user.GetSignInActivity() == nil {
+		return nil
+	}
+
+	activity := map[string]interface{}{}
+	if user.GetSignInActivity().GetLastSignInDateTime() != nil {
+		activity["last_sign_in_date_time"] = *user.GetSignInActivity().GetLastSignInDateTime()
+	}
+	if user.GetSignInActivity().GetLastSignInRequestId() != nil {
+		activity["last_sign_in_request_id"] = *user.GetSignInActivity().GetLastSignInRequestId()
+	}
+	if user.GetSignInActivity().GetLastNonInteractiveSignInDateTime() != nil {
+		activity["last_non_interactive_sign_in_date_time"] = *user.GetSignInActivity().GetLastNonInteractiveSignInDateTime()
+	}
+	if user.GetSignInActivity().GetLastNonInteractiveSignInRequestId() != nil {
+		activity["last_non_interactive_sign_in_request_id"] = *user.GetSignInActivity().GetLastNonInteractiveSignInRequestId()
+	}
+	if user.GetSignInActivity().GetLastSuccessfulSignInDateTime() != nil {
+		activity["last_successful_sign_in_date_time"] = *user.GetSignInActivity().GetLastSuccessfulSignInDateTime()
+	}
+	if user.GetSignInActivity().GetLastSuccessfulSignInRequestId() != nil {
+		activity["last_successful_sign_in_request_id"] = *user.GetSignInActivity().GetLastSuccessfulSignInRequestId()
+	}
+	return activity
+}
+
 func (user *Microsoft365UserInfo) UserOnPremisesExtensionAttributes() map[string]interface{} {
 	if user.GetOnPremisesExtensionAttributes() == nil {
 		return nil
@@ -4264,3 +4417,38 @@ func (security *Microsoft365SecurityInfo) SecuritySettingsDetails() map[string]i
 
 	return result
 }
+
+// User registration detail transform methods
+func (detail *Microsoft365UserRegistrationDetailInfo) RegistrationUserType() string {
+	if detail.GetUserType() == nil {
+		return ""
+	}
+	return detail.GetUserType().String()
+}
+
+func (detail *Microsoft365UserRegistrationDetailInfo) RegistrationUserPreferredMethodForSecondaryAuthentication() string {
+	if detail.GetUserPreferredMethodForSecondaryAuthentication() == nil {
+		return ""
+	}
+	return detail.GetUserPreferredMethodForSecondaryAuthentication().String()
+}
+
+type Microsoft365ConnectionDiagnosticInfo struct {
+	TableName                  string
+	AuthMethod                 string
+	TokenTenantID              string
+	AppID                      string
+	TokenType                  string
+	UserPrincipalName          string
+	Roles                      []string
+	Scopes                     []string
+	ExpiresOn                  *time.Time
+	RequiredPermissions        []string
+	MissingPermissions         []string
+	OptionalPermissions        []string
+	MissingOptionalPermissions []string
+	IsUsable                   bool
+	RequestMetrics             map[string]workloadMetricsSummary
+	Note                       string
+	Error                      string
+}
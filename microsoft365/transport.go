@@ -0,0 +1,146 @@
+package microsoft365
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	khttp "github.com/microsoft/kiota-http-go"
+	msgraphsdkgo "github.com/microsoftgraph/msgraph-sdk-go"
+	msgraphcore "github.com/microsoftgraph/msgraph-sdk-go-core"
+)
+
+// The Kiota default; a request, including reading the response, that takes
+// longer fails. Graph requests apply it to each attempt, so the waits between
+// retries of a throttled request don't count.
+const defaultRequestTimeout = 100 * time.Second
+
+var tlsVersions = map[string]uint16{
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// httpTransport holds the HTTP settings of a connection, shared by the token
+// requests of its credential and by its Graph request adapter.
+type httpTransport struct {
+	transport *http.Transport
+	timeout   time.Duration
+
+	// replay, if set, records or replays the Graph requests
+	replay *replayTransport
+}
+
+// newHTTPTransport builds the transport from the proxy_url, ca_bundle_path,
+// request_timeout and tls_min_version arguments. Without them, the proxy is
+// taken from the HTTPS_PROXY and NO_PROXY environment variables and the
+// system's certificate authorities are trusted.
+func newHTTPTransport(config microsoft365Config) (*httpTransport, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.ForceAttemptHTTP2 = true
+	transport.TLSClientConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if config.ProxyURL != nil && *config.ProxyURL != "" {
+		proxyURL, err := url.Parse(*config.ProxyURL)
+		if err != nil || proxyURL.Host == "" {
+			return nil, fmt.Errorf("invalid proxy_url %q: must be a URL such as http://proxy.example.com:8080", *config.ProxyURL)
+		}
+		switch proxyURL.Scheme {
+		case "http", "https", "socks5":
+		default:
+			return nil, fmt.Errorf("invalid proxy_url %q: the scheme must be http, https or socks5", *config.ProxyURL)
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+
+	if config.CABundlePath != nil && *config.CABundlePath != "" {
+		pool, err := loadCABundle(*config.CABundlePath)
+		if err != nil {
+			return nil, err
+		}
+		transport.TLSClientConfig.RootCAs = pool
+	}
+
+	if config.TLSMinVersion != nil && *config.TLSMinVersion != "" {
+		version, ok := tlsVersions[*config.TLSMinVersion]
+		if !ok {
+			return nil, fmt.Errorf("invalid tls_min_version %q, valid values are \"1.2\" and \"1.3\"", *config.TLSMinVersion)
+		}
+		transport.TLSClientConfig.MinVersion = version
+	}
+
+	timeout := defaultRequestTimeout
+	if config.RequestTimeout != nil {
+		if *config.RequestTimeout <= 0 {
+			return nil, fmt.Errorf("invalid request_timeout %d: must be a number of seconds greater than 0", *config.RequestTimeout)
+		}
+		timeout = time.Duration(*config.RequestTimeout) * time.Second
+	}
+
+	return &httpTransport{transport: transport, timeout: timeout}, nil
+}
+
+// loadCABundle returns the system's certificate authorities plus those in the
+// PEM file, e.g. the CA of a TLS-inspecting proxy.
+func loadCABundle(path string) (*x509.CertPool, error) {
+	bundle, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading ca_bundle_path %s: %v", path, err)
+	}
+
+	pool, err := x509.SystemCertPool()
+	if err != nil || pool == nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(bundle) {
+		return nil, fmt.Errorf("ca_bundle_path %s contains no PEM encoded certificates", path)
+	}
+	return pool, nil
+}
+
+// tokenClient returns the HTTP client for token requests to the authority.
+func (t *httpTransport) tokenClient() *http.Client {
+	return &http.Client{Transport: t.transport, Timeout: t.timeout}
+}
+
+// localClient returns the HTTP client for token requests to an endpoint on
+// the host itself, e.g. the managed identity endpoint, which must not go
+// through the proxy.
+func (t *httpTransport) localClient() *http.Client {
+	transport := t.transport.Clone()
+	transport.Proxy = nil
+	return &http.Client{Transport: transport, Timeout: t.timeout}
+}
+
+// graphClient returns the HTTP client for the Graph request adapter, with the
+// default Graph middleware in front of the transport. The throttling handler
+// takes the place of the Kiota retry handler.
+func (t *httpTransport) graphClient(throttling *throttlingHandler) *http.Client {
+	options := msgraphsdkgo.GetDefaultClientOptions()
+	middlewares := msgraphcore.GetDefaultMiddlewaresWithOptions(&options)
+	for i, middleware := range middlewares {
+		if _, ok := middleware.(*khttp.RetryHandler); ok {
+			middlewares[i] = throttling
+		}
+	}
+
+	var parent http.RoundTripper = t.transport
+	if t.replay != nil {
+		parent = t.replay
+	}
+
+	// The throttling handler applies the timeout to each attempt, as a
+	// client timeout would also cap the waits between retries
+	throttling.options.RequestTimeout = t.timeout
+
+	return &http.Client{
+		Transport: khttp.NewCustomTransportWithParentTransport(parent, middlewares...),
+		// Kiota handles redirects in its middleware
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
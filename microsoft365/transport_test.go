@@ -0,0 +1,99 @@
+package microsoft365
+
+import (
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestNewHTTPTransportCABundle(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	transport, err := newHTTPTransport(microsoft365Config{})
+	if err != nil {
+		t.Fatalf("newHTTPTransport() error = %v", err)
+	}
+	if _, err := transport.tokenClient().Get(server.URL); err == nil {
+		t.Fatal("request to a server with an untrusted certificate succeeded")
+	}
+
+	bundle := filepath.Join(t.TempDir(), "ca.pem")
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := os.WriteFile(bundle, certPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	transport, err = newHTTPTransport(microsoft365Config{CABundlePath: &bundle})
+	if err != nil {
+		t.Fatalf("newHTTPTransport() error = %v", err)
+	}
+	for name, client := range map[string]*http.Client{"token": transport.tokenClient(), "graph": transport.graphClient(testThrottlingHandler(throttlingOptions{}))} {
+		resp, err := client.Get(server.URL)
+		if err != nil {
+			t.Fatalf("%s client request with ca_bundle_path error = %v", name, err)
+		}
+		resp.Body.Close()
+	}
+}
+
+func TestNewHTTPTransportProxy(t *testing.T) {
+	var proxied atomic.Int32
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied.Add(1)
+	}))
+	defer proxy.Close()
+
+	transport, err := newHTTPTransport(microsoft365Config{ProxyURL: &proxy.URL})
+	if err != nil {
+		t.Fatalf("newHTTPTransport() error = %v", err)
+	}
+
+	resp, err := transport.tokenClient().Get("http://login.example.invalid/token")
+	if err != nil {
+		t.Fatalf("request through proxy_url error = %v", err)
+	}
+	resp.Body.Close()
+	if proxied.Load() != 1 {
+		t.Error("request did not go through proxy_url")
+	}
+
+	if transport.localClient().Transport.(*http.Transport).Proxy != nil {
+		t.Error("local client uses the proxy")
+	}
+}
+
+func TestNewHTTPTransportSettings(t *testing.T) {
+	timeout := 5
+	transport, err := newHTTPTransport(microsoft365Config{RequestTimeout: &timeout, TLSMinVersion: StringPtr("1.3")})
+	if err != nil {
+		t.Fatalf("newHTTPTransport() error = %v", err)
+	}
+	throttling := testThrottlingHandler(throttlingOptions{})
+	if got := transport.graphClient(throttling).Timeout; got != 0 {
+		t.Errorf("graph client timeout = %v, want none", got)
+	}
+	if got := throttling.options.RequestTimeout; got != 5*time.Second {
+		t.Errorf("attempt timeout = %v, want 5s", got)
+	}
+	if got := transport.transport.TLSClientConfig.MinVersion; got != tlsVersions["1.3"] {
+		t.Errorf("TLS min version = %x, want TLS 1.3", got)
+	}
+
+	zero := 0
+	for name, config := range map[string]microsoft365Config{
+		"tls_min_version": {TLSMinVersion: StringPtr("1.0")},
+		"proxy_url":       {ProxyURL: StringPtr("ftp://proxy:21")},
+		"request_timeout": {RequestTimeout: &zero},
+		"ca_bundle_path":  {CABundlePath: StringPtr(filepath.Join(t.TempDir(), "missing.pem"))},
+	} {
+		if _, err := newHTTPTransport(config); err == nil {
+			t.Errorf("newHTTPTransport() with an invalid %s did not fail", name)
+		}
+	}
+}
@@ -2,12 +2,9 @@ package microsoft365
 
 import (
 	"context"
+	"fmt"
 	"os"
 
-	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
-	a "github.com/microsoft/kiota-authentication-azure-go"
-	msgraphsdkgo "github.com/microsoftgraph/msgraph-sdk-go"
-
 	"github.com/turbot/steampipe-plugin-sdk/v5/grpc/proto"
 	"github.com/turbot/steampipe-plugin-sdk/v5/memoize"
 	"github.com/turbot/steampipe-plugin-sdk/v5/plugin"
@@ -44,6 +41,11 @@ func getTenantCacheKey(ctx context.Context, d *plugin.QueryData, h *plugin.Hydra
 }
 
 func getTenant(ctx context.Context, d *plugin.QueryData, h *plugin.HydrateData) (any, error) {
+	// In a multi-tenant connection, rows belong to the tenant being queried
+	if tenantID := getMatrixTenantID(ctx); tenantID != "" {
+		return tenantID, nil
+	}
+
 	projectId, err := getTenantMemoized(ctx, d, h)
 	if err != nil {
 		return nil, err
@@ -85,7 +87,8 @@ func getTenantUncached(ctx context.Context, d *plugin.QueryData, h *plugin.Hydra
 func getUserFromConfig(ctx context.Context, d *plugin.QueryData, _ *plugin.HydrateData) string {
 	var userID string
 
-	microsoft365Config := GetConfig(d.Connection)
+	// A tenant of a multi-tenant connection may set its own user_id
+	microsoft365Config, _ := getConnectionConfig(ctx, d)
 	if microsoft365Config.UserID != nil {
 		userID = *microsoft365Config.UserID
 	}
@@ -93,7 +96,31 @@ func getUserFromConfig(ctx context.Context, d *plugin.QueryData, _ *plugin.Hydra
 	return userID
 }
 
+// getUserIDMemoized caches the resolved user per connection and tenant, like getTenantMemoized
+var getUserIDMemoized = plugin.HydrateFunc(getUserIDUncached).Memoize(memoize.WithCacheKeyFunction(getUserIDCacheKey))
+
+// Build a cache key for the call to getUserID.
+func getUserIDCacheKey(ctx context.Context, d *plugin.QueryData, h *plugin.HydrateData) (interface{}, error) {
+	key := "getUserID"
+	if tenantID := getMatrixTenantID(ctx); tenantID != "" {
+		key = fmt.Sprintf("%s-%s", key, tenantID)
+	}
+	return key, nil
+}
+
 func getUserID(ctx context.Context, d *plugin.QueryData, h *plugin.HydrateData) (interface{}, error) {
+	userID, err := getUserIDMemoized(ctx, d, h)
+	if err != nil {
+		return nil, err
+	}
+
+	return userID, nil
+}
+
+// getUserIDUncached resolves the user for the microsoft365_my_* tables: the
+// user_id from config if set, otherwise the signed-in user of the active
+// credential. App-only tokens have no signed-in user and require user_id.
+func getUserIDUncached(ctx context.Context, d *plugin.QueryData, h *plugin.HydrateData) (interface{}, error) {
 	logger := plugin.Logger(ctx)
 
 	// Get the user from config
@@ -102,39 +129,22 @@ func getUserID(ctx context.Context, d *plugin.QueryData, h *plugin.HydrateData)
 		return userID, nil
 	}
 
-	// If the user is not provided in the config,
-	// get the current authenticated user from CLI
-	getTenantCached := plugin.HydrateFunc(getTenant).WithCache()
-	tenantID, err := getTenantCached(ctx, d, h)
+	c, err := getGraphClient(ctx, d)
 	if err != nil {
+		logger.Error("getUserID", "connection_error", err)
 		return nil, err
 	}
 
-	// Create client
-	cred, err := azidentity.NewAzureCLICredential(
-		&azidentity.AzureCLICredentialOptions{
-			TenantID: tenantID.(string),
-		},
-	)
+	claims, err := c.getAccessTokenClaims(ctx)
 	if err != nil {
-		logger.Error("getUserID", "cli_credential_error", err)
+		logger.Error("getUserID", "access_token_error", err)
 		return nil, err
 	}
-
-	auth, err := a.NewAzureIdentityAuthenticationProvider(cred)
-	if err != nil {
-		logger.Error("getUserID", "identity_authentication_provider_error", err)
-		return nil, err
-	}
-
-	adapter, err := msgraphsdkgo.NewGraphRequestAdapter(auth)
-	if err != nil {
-		logger.Error("getUserID", "graph_request_adaptor_error", err)
-		return nil, err
+	if !claims.IsDelegated() {
+		return nil, &UserIDRequiredError{AuthMethod: c.authMethod, AppID: claims.GetAppID()}
 	}
-	client := msgraphsdkgo.NewGraphServiceClient(adapter)
 
-	result, err := client.Me().Get(ctx, nil)
+	result, err := c.client.Me().Get(ctx, nil)
 	if err != nil {
 		errObj := getErrorObject(err)
 		return nil, errObj
@@ -156,3 +166,16 @@ func Int32(v int32) *int32 {
 func StringPtr(v string) *string {
 	return &v
 }
+
+// BoolPtr returns a pointer to the bool value passed in.
+func BoolPtr(v bool) *bool {
+	return &v
+}
+
+// stringValue returns the string the pointer points to, or "" if it's nil.
+func stringValue(v *string) string {
+	if v == nil {
+		return ""
+	}
+	return *v
+}